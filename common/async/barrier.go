@@ -50,11 +50,16 @@ type StatefulBarrier struct {
 	state atomic.Value
 }
 
+// barrierState wraps the state as atomic.Value can not store nil
+type barrierState struct {
+	value interface{}
+}
+
 func (s *StatefulBarrier) OpenWith(state interface{}) {
 	if s.b.IsOpen() {
 		return
 	}
-	s.state.Store(state)
+	s.state.Store(barrierState{state})
 	s.b.Open()
 }
 
@@ -64,7 +69,7 @@ func (s *StatefulBarrier) Wait() {
 
 func (s *StatefulBarrier) Get() interface{} {
 	s.Wait()
-	return s.state.Load()
+	return s.state.Load().(barrierState).value
 }
 
 func NewStatefulBarrier() *StatefulBarrier {
@@ -9,6 +9,7 @@ func init() {
 	typeStringMap[TypeUDP] = "UDP"
 	typeStringMap[TypeRTC] = "RTC"
 	typeStringMap[TypeHTTP] = "HTTP"
+	typeStringMap[TypeUnix] = "UNIX"
 }
 
 const (
@@ -17,10 +18,11 @@ const (
 	TypeUDP
 	TypeRTC
 	TypeHTTP
+	TypeUnix
 )
 
 func IsAsyncType(connType uint8) bool {
-	return connType < TypeHTTP
+	return connType != TypeHTTP
 }
 
 func TypeString(connType uint8) string {
@@ -1,6 +1,7 @@
 package hub_client
 
 import (
+	gocontext "context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,8 +15,12 @@ import (
 	"whub/hub_client/connections"
 	"whub/hub_client/context"
 	"whub/hub_common/connection"
+	"whub/hub_common/idempotency"
 	"whub/hub_common/messages"
 	"whub/hub_common/roles"
+	"whub/tcp"
+	"whub/unix"
+	ws_connection "whub/websocket/connection"
 	WSClient "whub/websocket/wclient"
 )
 
@@ -36,25 +41,67 @@ type Client struct {
 	lock                        *sync.RWMutex
 	dispatcher                  *ClientMessageDispatcher
 	clientServiceRequestHandler *ClientServiceMessageHandler
+	topicMessageHandler         *TopicMessageHandler
+	clientMessageHandler        *ClientMessageHandler
+	presenceHandler             *PresenceHandler
 	serviceManager              IServiceManager
+	preferredCodecs             []string
+	preferredCompressions       []string
 }
 
+// NewClient creates a client connects to the server via websocket on serverUri:serverPort/wsPath by default. If
+// serverUri is a tcp://host:port address, socket connections will be established over tcp to that address while
+// HTTP requests still go to host:serverPort. If serverUri is a unix:///path/to/socket address, socket connections will
+// be established over the unix domain socket while HTTP requests go to localhost:serverPort.
 func NewClient(connType uint8, serverUri string, serverPort int, wsPath string, clientId string, clientCKey string) *Client {
-	serverFullUri := fmt.Sprintf("%s:%d", serverUri, serverPort)
-	addr := url.URL{Scheme: "ws", Host: serverFullUri, Path: wsPath}
+	httpHost, socketClient, socketConnType, err := newSocketClient(serverUri, serverPort, wsPath, clientId)
+	if err != nil {
+		panic(err)
+	}
+	if socketConnType != connType {
+		context.Ctx.Logger().Printf("connection type %s is overridden by server address %s", base_conn.TypeString(connType), serverUri)
+	}
 	c := &Client{
-		connectionType: connType,
-		serverUri:      serverFullUri,
-		httpClient:     context.Ctx.HTTPClient(),
-		wclient:        WSClient.New(WSClient.NewWClientConfig(addr.String(), nil, nil, nil, nil, nil)),
-		client:         roles.NewClient(clientId, "", roles.ClientTypeAnonymous, clientCKey, 0),
-		logger:         context.Ctx.Logger(),
-		lock:           new(sync.RWMutex),
+		connectionType:       socketConnType,
+		serverUri:            fmt.Sprintf("%s:%d", httpHost, serverPort),
+		httpClient:           context.Ctx.HTTPClient(),
+		wclient:              socketClient,
+		client:               roles.NewClient(clientId, "", roles.ClientTypeAnonymous, clientCKey, 0),
+		logger:               context.Ctx.Logger(),
+		lock:                 new(sync.RWMutex),
+		topicMessageHandler:  NewTopicMessageHandler(),
+		clientMessageHandler: NewClientMessageHandler(),
+		presenceHandler:      NewPresenceHandler(),
 	}
 	c.connPool = connections.NewConnectionPool(c.connect, context.Ctx.MaxActiveServiceConnections()+2)
 	return c
 }
 
+func newSocketClient(serverUri string, serverPort int, wsPath string, clientId string) (httpHost string, client base_conn.IClient, connType uint8, err error) {
+	if strings.HasPrefix(serverUri, "unix://") {
+		socketPath := strings.TrimPrefix(serverUri, "unix://")
+		if socketPath == "" {
+			err = errors.New(fmt.Sprintf("invalid unix server address %s", serverUri))
+			return
+		}
+		return "localhost", unix.NewUnixClient(socketPath), base_conn.TypeUnix, nil
+	}
+	if !strings.HasPrefix(serverUri, "tcp://") {
+		addr := url.URL{Scheme: "ws", Host: fmt.Sprintf("%s:%d", serverUri, serverPort), Path: wsPath}
+		return serverUri, WSClient.New(WSClient.NewWClientConfig(addr.String(), nil, nil, nil, nil, nil)), base_conn.TypeWS, nil
+	}
+	socketAddr, err := url.Parse(serverUri)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(socketAddr.Port())
+	if err != nil {
+		err = errors.New(fmt.Sprintf("invalid tcp server address %s", serverUri))
+		return
+	}
+	return socketAddr.Hostname(), tcp.NewTCPClient(socketAddr.Hostname(), port, clientId), base_conn.TypeTCP, nil
+}
+
 type IWRClient interface {
 	Connect() error
 	Request(message messages.IMessage) (messages.IMessage, error)
@@ -161,6 +208,10 @@ func (c *Client) doConnect(retryCount int, token string, lastErr error) (connect
 	if retryCount <= 0 {
 		return nil, lastErr
 	}
+	if wsClient, ok := c.wclient.(*WSClient.WClient); ok {
+		// websocket connections can pick the codec on upgrade, so that even the first messages use the preferred codec
+		wsClient.SetSubprotocols(c.preferredCodecs)
+	}
 	conn, err := c.wclient.Connect(token)
 	if err != nil {
 		token, err = c.login(2, nil)
@@ -174,15 +225,20 @@ func (c *Client) doConnect(retryCount int, token string, lastErr error) (connect
 
 func (c *Client) initDispatchers() {
 	c.dispatcher = NewClientMessageDispatcher()
+	// client messages can be flushed from the mailbox as soon as the client connects
+	c.dispatcher.RegisterHandler(c.clientMessageHandler)
 }
 
 func (c *Client) initServiceDispatcher() {
 	c.clientServiceRequestHandler = NewClientServiceMessageHandler()
 	c.dispatcher.RegisterHandler(c.clientServiceRequestHandler)
+	c.dispatcher.RegisterHandler(NewClientServiceCancelMessageHandler())
+	c.dispatcher.RegisterHandler(c.topicMessageHandler)
+	c.dispatcher.RegisterHandler(c.presenceHandler)
 }
 
 func (c *Client) handleConnected(rawConn base_conn.IConnection) (connection.IConnection, error) {
-	conn := connection.NewConnection(context.Ctx.Logger().WithPrefix("[ServerConnection]"), rawConn, connection.DefaultTimeout, context.Ctx.MessageParser(), context.Ctx.NotificationEmitter())
+	conn := connection.NewConnection(context.Ctx.Logger().WithPrefix("[ServerConnection]"), rawConn, connection.DefaultTimeout, c.selectMessageParser(rawConn), context.Ctx.NotificationEmitter())
 	c.logger.Println("connection to server has been established: ", conn.Address())
 	c.logger.Println("new client has been instantiated")
 	context.Ctx.AsyncTaskPool().Schedule(conn.ReadingLoop)
@@ -194,16 +250,115 @@ func (c *Client) handleConnected(rawConn base_conn.IConnection) (connection.ICon
 		return nil, err
 	}
 	c.logger.Println("test greeting message result: ", msg)
+	c.negotiateProtocol(conn)
 	conn.OnIncomingMessage(func(msg messages.IMessage) {
 		c.dispatcher.Dispatch(msg, conn)
 	})
 	return conn, nil
 }
 
+// selectMessageParser picks the codec negotiated by websocket sub-protocol, falls back to the default parser
+func (c *Client) selectMessageParser(conn base_conn.IConnection) messages.IMessageParser {
+	wsConn, ok := conn.(ws_connection.IWsConnection)
+	if !ok || wsConn.Subprotocol() == "" {
+		return context.Ctx.MessageParser()
+	}
+	if parser := messages.GetMessageParser(wsConn.Subprotocol()); parser != nil {
+		return parser
+	}
+	return context.Ctx.MessageParser()
+}
+
+// negotiateProtocol is best effort, servers that do not support protocol update will keep the default codec
+func (c *Client) negotiateProtocol(conn connection.IConnection) {
+	selection, err := conn.NegotiateProtocol(c.client.Id(), c.server.Id(), messages.NewProtocolOffer(c.preferredCodecs, c.preferredCompressions))
+	if err != nil {
+		c.logger.Printf("protocol negotiation failed due to %s, will use the default protocol", err.Error())
+		return
+	}
+	c.logger.Printf("protocol negotiated: version %d, codec %s, compression %s", selection.Version, selection.Codec, selection.Compression)
+}
+
+// SetPreferredCodecs sets the codecs offered to the server in preference order, all registered codecs are offered
+// if not set. Websocket connections also request them as sub-protocols, so the codec is picked on upgrade.
+func (c *Client) SetPreferredCodecs(codecs []string) {
+	c.preferredCodecs = codecs
+}
+
+// SetPreferredCompressions sets the payload compressions offered to the server in preference order, payloads are not
+// compressed unless a compression other than messages.CompressionNone is preferred
+func (c *Client) SetPreferredCompressions(compressions []string) {
+	c.preferredCompressions = compressions
+}
+
 func (c *Client) Request(messageType int, uri string, payload []byte) (messages.IMessage, error) {
 	return c.primaryConn.Request(messages.DraftMessage(c.client.Id(), c.server.Id(), uri, messageType, payload))
 }
 
+// RequestWithIdempotencyKey requests with an idempotency key, the request retried with the same key is not executed
+// again, the completed response is replied instead
+func (c *Client) RequestWithIdempotencyKey(messageType int, uri string, key string, payload []byte) (messages.IMessage, error) {
+	msg := messages.DraftMessage(c.client.Id(), c.server.Id(), uri, messageType, payload)
+	msg.SetHeader(idempotency.MessageHeaderIdempotencyKey, key)
+	return c.primaryConn.Request(msg)
+}
+
+// requestService requests a service with payload marshalled as JSON unless it's raw bytes, error responses are
+// returned as errors
+func (c *Client) requestService(messageType int, uri string, payload interface{}) ([]byte, error) {
+	var data []byte
+	var err error
+	switch p := payload.(type) {
+	case nil:
+	case []byte:
+		data = p
+	default:
+		if data, err = json.Marshal(p); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.Request(messageType, uri, data)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsErrorMessage() {
+		return nil, errors.New(string(resp.Payload()))
+	}
+	return resp.Payload(), nil
+}
+
+// RequestWithContext requests a service bounded by ctx, the request is cancelled on the provider side as well once ctx
+// is done. The deadline of ctx is propagated to the provider.
+func (c *Client) RequestWithContext(ctx gocontext.Context, messageType int, uri string, payload []byte) (messages.IMessage, error) {
+	msg := messages.DraftMessage(c.client.Id(), c.server.Id(), uri, messageType, payload)
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.SetDeadline(deadline)
+	}
+	id := msg.Id()
+	type result struct {
+		resp messages.IMessage
+		err  error
+	}
+	// buffered so that the request goroutine never blocks after cancellation
+	results := make(chan result, 1)
+	go func() {
+		resp, err := c.primaryConn.Request(msg)
+		results <- result{resp, err}
+	}()
+	select {
+	case r := <-results:
+		return r.resp, r.err
+	case <-ctx.Done():
+		c.primaryConn.Send(messages.NewServiceCancelMessage(id, c.client.Id(), c.server.Id(), uri))
+		return nil, ctx.Err()
+	}
+}
+
+// RequestStream requests a service that may respond with a stream, the reader is nil if the response is not streamed
+func (c *Client) RequestStream(messageType int, uri string, payload []byte) (messages.IMessage, connection.IStreamReader, error) {
+	return c.primaryConn.RequestWithStream(messages.DraftMessage(c.client.Id(), c.server.Id(), uri, messageType, payload))
+}
+
 func (c *Client) HTTPRequest(token string, message messages.IMessage) (messages.IMessage, error) {
 	r := message.ToHTTPRequest("http", c.serverUri, token)
 	resp := c.httpClient.Request(r)
@@ -256,6 +411,15 @@ func (c *Client) StopService(id string) error {
 	return svc.Stop()
 }
 
+// DrainService stops the service once the server has drained its requests, see IClientService.Drain
+func (c *Client) DrainService(id string) error {
+	svc := c.serviceManager.GetServiceById(id)
+	if svc == nil {
+		return errors.New(fmt.Sprintf("service %s has not been registered yet", id))
+	}
+	return svc.Drain()
+}
+
 func (c *Client) Stop() {
 	c.connPool.Close()
 	c.serviceManager.UnregisterAllServices()
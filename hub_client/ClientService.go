@@ -11,6 +11,7 @@ import (
 	"whub/hub_client/container"
 	"whub/hub_client/context"
 	"whub/hub_client/controllers"
+	"whub/hub_common/connection"
 	"whub/hub_common/health_check"
 	"whub/hub_common/messages"
 	"whub/hub_common/roles"
@@ -32,6 +33,9 @@ type ClientService struct {
 	serviceType   int
 	accessType    int
 	executionType int
+	loadBalancing service.LoadBalancingDescriptor
+	retry         service.RetryDescriptor
+	coProviders   []string
 	cTime         time.Time
 
 	status int
@@ -78,6 +82,17 @@ type IClientService interface {
 	service.IBaseService
 	Init(server roles.ICommonServer) error
 	UpdateDescription(string) error
+	// SetLoadBalancing selects how the server balances requests among connections providing the service, the weight of
+	// the load balancing applies to connections of this client
+	SetLoadBalancing(loadBalancing service.LoadBalancingDescriptor) error
+	LoadBalancing() service.LoadBalancingDescriptor
+	// SetRetry sets how the server retries failed requests on other connections providing the service
+	SetRetry(retry service.RetryDescriptor) error
+	Retry() service.RetryDescriptor
+	// SetCoProviders sets ids of other clients allowed to provide the service along with this client, e.g. the other
+	// deployment of a blue-green pair
+	SetCoProviders(clientIds []string) error
+	CoProviders() []string
 	RegisterRoute(requestType int, shortUri string, handler service.RequestHandler) error // should update service descriptor to the host
 	InitHandlers(handlerMap map[int]map[string]service.RequestHandler) (err error)
 	UnregisterRoute(requestType int, shortUri string) (err error)
@@ -85,6 +100,9 @@ type IClientService interface {
 	NewMessage(to string, uri string, msgType int, payload []byte) messages.IMessage
 
 	Register() error
+	// Drain stops the service after the server has drained requests of this client, requests in flight are still
+	// handled during the drain
+	Drain() error
 
 	HealthCheck() error
 	OnHealthCheckFails(cb func(service IClientService))
@@ -94,6 +112,7 @@ type IClientService interface {
 	ResolveByResponse(request service.IServiceRequest, responseData []byte) error
 	ResolveByError(request service.IServiceRequest, errType int, msg string) error
 	ResolveByInvalidCredential(request service.IServiceRequest) error
+	ResolveByStream(request service.IServiceRequest, headers map[string]string) (connection.IStreamWriter, error)
 
 	Logger() *logger.SimpleLogger
 }
@@ -162,6 +181,51 @@ func (s *ClientService) UpdateDescription(desc string) (err error) {
 	return
 }
 
+func (s *ClientService) SetLoadBalancing(loadBalancing service.LoadBalancingDescriptor) error {
+	if err := loadBalancing.Validate(); err != nil {
+		return err
+	}
+	s.withWrite(func() {
+		s.loadBalancing = loadBalancing
+	})
+	return s.NotifyHostForUpdate()
+}
+
+func (s *ClientService) LoadBalancing() service.LoadBalancingDescriptor {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.loadBalancing
+}
+
+func (s *ClientService) SetRetry(retry service.RetryDescriptor) error {
+	if err := retry.Validate(); err != nil {
+		return err
+	}
+	s.withWrite(func() {
+		s.retry = retry
+	})
+	return s.NotifyHostForUpdate()
+}
+
+func (s *ClientService) Retry() service.RetryDescriptor {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.retry
+}
+
+func (s *ClientService) SetCoProviders(clientIds []string) error {
+	s.withWrite(func() {
+		s.coProviders = clientIds
+	})
+	return s.NotifyHostForUpdate()
+}
+
+func (s *ClientService) CoProviders() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.coProviders
+}
+
 func (s *ClientService) ServiceType() int {
 	return s.serviceType
 }
@@ -273,6 +337,9 @@ func (s *ClientService) Describe() service.ServiceDescriptor {
 		AccessType:    s.AccessType(),
 		ExecutionType: s.ExecutionType(),
 		Status:        s.Status(),
+		LoadBalancing: s.LoadBalancing(),
+		Retry:         s.Retry(),
+		CoProviders:   s.CoProviders(),
 	}
 }
 
@@ -333,6 +400,26 @@ func (s *ClientService) Stop() error {
 	return nil
 }
 
+func (s *ClientService) Drain() error {
+	if !(s.Status() > service.ServiceStatusUnregistered && s.Status() < service.ServiceStatusStopping) {
+		return errors.New("invalid status to drain a service")
+	}
+	err := s.serviceManagerClient.DrainService(s.Describe())
+	if err != nil {
+		return err
+	}
+	s.healthCheckHandler.StopHealthCheck()
+	s.withWrite(func() {
+		s.status = service.ServiceStatusStopping
+	})
+	s.serviceTaskQueue.Stop()
+	// after pool is stopped
+	s.withWrite(func() {
+		s.status = service.ServiceStatusUnregistered
+	})
+	return nil
+}
+
 func (s *ClientService) unregister() error {
 	return s.serviceManagerClient.UnregisterService(s.Describe())
 }
@@ -411,6 +498,10 @@ func (s *ClientService) ResolveByInvalidCredential(request service.IServiceReque
 	return s.ResolveByError(request, messages.MessageTypeSvcUnauthorizedError, "invalid credential")
 }
 
+func (s *ClientService) ResolveByStream(request service.IServiceRequest, headers map[string]string) (connection.IStreamWriter, error) {
+	return request.Stream(s.ProviderInfo().Id, headers)
+}
+
 func (s *ClientService) Init(server roles.ICommonServer) error {
 	return errors.New("current service did not implement Init() interface")
 }
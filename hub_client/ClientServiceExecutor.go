@@ -1,6 +1,8 @@
 package hub_client
 
 import (
+	gocontext "context"
+	"errors"
 	"whub/hub_client/context"
 	"whub/hub_common/messages"
 	"whub/hub_common/service"
@@ -18,7 +20,13 @@ func NewClientServiceExecutor(handler service.IDefaultServiceHandler) *ClientSer
 
 func (e *ClientServiceExecutor) Execute(request service.IServiceRequest) {
 	err := e.handler.Handle(request)
-	if err != nil {
+	if requestErr, ok := service.AsRequestError(err); ok {
+		request.Resolve(messages.NewErrorResponse(request, context.Ctx.Identity().Id(), requestErr.Code(), requestErr.Error()))
+	} else if errors.Is(err, gocontext.DeadlineExceeded) {
+		request.Resolve(messages.NewErrorResponse(request, context.Ctx.Identity().Id(), messages.MessageTypeSvcGatewayTimeoutError, err.Error()))
+	} else if errors.Is(err, gocontext.Canceled) {
+		request.Resolve(messages.NewErrorResponse(request, context.Ctx.Identity().Id(), messages.MessageTypeSvcUnavailableError, "request has been cancelled"))
+	} else if err != nil {
 		request.Resolve(messages.NewInternalErrorMessage(request.Id(), context.Ctx.Identity().Id(), request.From(), request.Uri(), err.Error()))
 	}
 }
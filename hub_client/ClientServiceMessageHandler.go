@@ -1,6 +1,8 @@
 package hub_client
 
 import (
+	"errors"
+	"fmt"
 	"whub/hub_client/container"
 	"whub/hub_client/context"
 	"whub/hub_client/controllers"
@@ -47,15 +49,53 @@ func (h *ClientServiceMessageHandler) Handle(msg messages.IMessage, conn connect
 	}
 	svc := matchContext.Value.(IClientService)
 	request := service.NewServiceRequest(msg)
+	request.BindConnection(conn)
 	request.SetContext("uri_pattern", matchContext.UriPattern)
 	request.SetContext("path_params", matchContext.PathParams)
 	request.SetContext("query_params", matchContext.QueryParams)
 	// at least run the common middleware
 	request = middleware.ConnectionTypeMiddleware(conn, request)
 	resp := svc.Handle(request)
+	released := request.IsCancelled() || request.IsDead()
 
 	// request die here
 	request.Free()
 	h.m.Stop(h.m.GetAssembledTraceId(controllers.TMessagePerformance, msg.Id()))
+	if resp == nil && released {
+		resp = messages.NewErrorResponse(msg, context.Ctx.Identity().Id(), messages.MessageTypeSvcUnavailableError, "request has been cancelled")
+	}
 	return conn.Send(resp)
 }
+
+// ClientServiceCancelMessageHandler cancels service requests on behalf of the requester
+type ClientServiceCancelMessageHandler struct {
+	manager IServiceManager `$inject:""`
+}
+
+func NewClientServiceCancelMessageHandler() *ClientServiceCancelMessageHandler {
+	h := &ClientServiceCancelMessageHandler{}
+	err := container.Container.Fill(h)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func (h *ClientServiceCancelMessageHandler) Type() int {
+	return messages.MessageTypeServiceCancelRequest
+}
+
+func (h *ClientServiceCancelMessageHandler) Types() []int {
+	return nil
+}
+
+func (h *ClientServiceCancelMessageHandler) Handle(msg messages.IMessage, conn connection.IConnection) error {
+	matchContext, err := h.manager.MatchServiceByUri(msg.Uri())
+	if err != nil {
+		return err
+	}
+	if matchContext.Value == nil {
+		return errors.New(fmt.Sprintf("can not find service by uri %s", msg.Uri()))
+	}
+	return matchContext.Value.(IClientService).Cancel(msg.Id())
+}
@@ -9,26 +9,44 @@ import (
 	"whub/hub_common/messages"
 )
 
+// orderedMessageQueueSize is the number of ordered messages buffered before the reading loop is blocked
+const orderedMessageQueueSize = 256
+
 type ClientMessageDispatcher struct {
 	*dispatcher.MessageDispatcher
 	m controllers.IClientMeteringController `$inject:""`
+	// ordered messages(e.g. messages pushed from topics) are handled one by one in arrival order
+	ordered chan func()
 }
 
 func NewClientMessageDispatcher() *ClientMessageDispatcher {
 	md := &ClientMessageDispatcher{
 		MessageDispatcher: dispatcher.NewMessageDispatcher(context.Ctx.Logger().WithPrefix("[MessageDispatcher]")),
+		ordered:           make(chan func(), orderedMessageQueueSize),
 	}
 	err := container.Container.Fill(md)
 	if err != nil {
 		panic(err)
 	}
 	md.init()
+	go md.orderedLoop()
 	return md
 }
 
+func (d *ClientMessageDispatcher) orderedLoop() {
+	for task := range d.ordered {
+		task()
+	}
+}
+
+func isOrderedMessage(message messages.IMessage) bool {
+	return message.MessageType() == messages.MessageTypeServerServiceNotification
+}
+
 func (d *ClientMessageDispatcher) init() {
 	// register common message handlers
 	d.RegisterHandler(dispatcher.NewPingMessageHandler(context.Ctx.Identity()))
+	d.RegisterHandler(dispatcher.NewProtocolUpdateMessageHandler(context.Ctx.Identity()))
 }
 
 func (d *ClientMessageDispatcher) Dispatch(message messages.IMessage, conn connection.IConnection) {
@@ -36,10 +54,16 @@ func (d *ClientMessageDispatcher) Dispatch(message messages.IMessage, conn conne
 		return
 	}
 	d.Logger.Printf("receive message %s from %s", message.String(), conn.Address())
-	d.m.TraceMessagePerformance(message.Id())
-	context.Ctx.AsyncTaskPool().Schedule(func() {
+	traceId := message.Id()
+	d.m.TraceMessagePerformance(traceId)
+	task := func() {
+		// message is disposed by the dispatcher once handled
 		d.MessageDispatcher.Dispatch(message, conn)
-		d.m.Stop(d.m.GetAssembledTraceId(controllers.TMessagePerformance, message.Id()))
-		message.Dispose()
-	})
+		d.m.Stop(d.m.GetAssembledTraceId(controllers.TMessagePerformance, traceId))
+	}
+	if isOrderedMessage(message) {
+		d.ordered <- task
+		return
+	}
+	context.Ctx.AsyncTaskPool().Schedule(task)
 }
@@ -0,0 +1,147 @@
+package hub_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/service"
+)
+
+const MessagingUri = service.ServicePrefix + "/message"
+
+// recentClientMessages is the number of recent client message ids kept to drop the copies of a message the server
+// delivers to every connection of the client
+const recentClientMessages = 256
+
+// ClientMessageListener is called with messages other clients send to this client via the messaging service
+type ClientMessageListener func(message messages.IMessage)
+
+// ClientMessageHandler hands messages delivered by the messaging service to the listener, each message once
+type ClientMessageHandler struct {
+	listener ClientMessageListener
+	// recent ids in a ring, seen is the set of them
+	recent []string
+	next   int
+	seen   map[string]bool
+	lock   *sync.RWMutex
+}
+
+func NewClientMessageHandler() *ClientMessageHandler {
+	return &ClientMessageHandler{
+		recent: make([]string, recentClientMessages),
+		seen:   make(map[string]bool),
+		lock:   new(sync.RWMutex),
+	}
+}
+
+// firstSeen tells if the message id has not been seen recently, and remembers it
+func (h *ClientMessageHandler) firstSeen(id string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.seen[id] {
+		return false
+	}
+	delete(h.seen, h.recent[h.next])
+	h.recent[h.next] = id
+	h.seen[id] = true
+	h.next = (h.next + 1) % len(h.recent)
+	return true
+}
+
+func (h *ClientMessageHandler) Type() int {
+	return messages.MessageTypeClientNotification
+}
+
+func (h *ClientMessageHandler) Types() []int {
+	return nil
+}
+
+func (h *ClientMessageHandler) SetListener(listener ClientMessageListener) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.listener = listener
+}
+
+func (h *ClientMessageHandler) Handle(msg messages.IMessage, conn connection.IConnection) error {
+	h.lock.RLock()
+	listener := h.listener
+	h.lock.RUnlock()
+	if listener == nil {
+		return errors.New(fmt.Sprintf("no listener for client message %s", msg.Id()))
+	}
+	if h.firstSeen(msg.Id()) {
+		listener(msg)
+	}
+	return nil
+}
+
+// OnClientMessage sets the listener of messages sent to this client, set it before Start to receive messages kept in
+// the mailbox of the client while it was offline
+func (c *Client) OnClientMessage(listener ClientMessageListener) {
+	c.clientMessageHandler.SetListener(listener)
+}
+
+func (c *Client) requestMessaging(uri string, to []string, payload []byte) ([]messages.DeliveryResult, error) {
+	msg := messages.DraftMessage(c.client.Id(), c.server.Id(), MessagingUri+uri, messages.MessageTypeServicePostRequest, payload)
+	if len(to) > 0 {
+		msg.SetHeader(messages.MessageHeaderTo, messages.JoinRecipients(to))
+	}
+	resp, err := c.primaryConn.Request(msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsErrorMessage() {
+		return nil, errors.New(string(resp.Payload()))
+	}
+	var results []messages.DeliveryResult
+	if len(resp.Payload()) > 0 {
+		err = json.Unmarshal(resp.Payload(), &results)
+	}
+	return results, err
+}
+
+// SendMessage sends payload to the client
+func (c *Client) SendMessage(to string, payload []byte) (messages.DeliveryResult, error) {
+	results, err := c.requestMessaging("/send", []string{to}, payload)
+	if err != nil {
+		return messages.DeliveryResult{}, err
+	}
+	if len(results) == 0 {
+		return messages.DeliveryResult{}, errors.New("invalid server response")
+	}
+	return results[0], nil
+}
+
+// MulticastMessage sends payload to each of the clients, at most 64 of them
+func (c *Client) MulticastMessage(to []string, payload []byte) ([]messages.DeliveryResult, error) {
+	return c.requestMessaging("/multicast", to, payload)
+}
+
+// BroadcastMessage sends payload to all clients, only managers can do so if the server has mailboxes enabled
+func (c *Client) BroadcastMessage(payload []byte) ([]messages.DeliveryResult, error) {
+	return c.requestMessaging("/broadcast", nil, payload)
+}
+
+// JoinMessageGroup adds the client to the named group until it leaves or all its connections are gone
+func (c *Client) JoinMessageGroup(group string) error {
+	_, err := c.requestMessaging(fmt.Sprintf("/groups/%s/join", group), nil, nil)
+	return err
+}
+
+func (c *Client) LeaveMessageGroup(group string) error {
+	_, err := c.requestMessaging(fmt.Sprintf("/groups/%s/leave", group), nil, nil)
+	return err
+}
+
+// SendGroupMessage sends payload to members of the group, the client should be a member of the group
+func (c *Client) SendGroupMessage(group string, payload []byte) ([]messages.DeliveryResult, error) {
+	return c.requestMessaging(fmt.Sprintf("/groups/%s/send", group), nil, payload)
+}
+
+// SendClientTypeMessage sends payload to all clients of the roles.ClientType*, managers only
+func (c *Client) SendClientTypeMessage(cType int, payload []byte) ([]messages.DeliveryResult, error) {
+	return c.requestMessaging(fmt.Sprintf("/types/%d/send", cType), nil, payload)
+}
@@ -0,0 +1,96 @@
+package hub_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/presence"
+	"whub/hub_common/service"
+)
+
+const PresenceUri = service.ServicePrefix + "/presence"
+
+// PresenceListener is called when a subscribed client goes online or offline
+type PresenceListener func(change presence.Change)
+
+// PresenceHandler hands presence changes pushed by the presence service to the listener
+type PresenceHandler struct {
+	listener PresenceListener
+	lock     *sync.RWMutex
+}
+
+func NewPresenceHandler() *PresenceHandler {
+	return &PresenceHandler{
+		lock: new(sync.RWMutex),
+	}
+}
+
+func (h *PresenceHandler) Type() int {
+	return messages.MessageTypeServerNotification
+}
+
+func (h *PresenceHandler) Types() []int {
+	return nil
+}
+
+func (h *PresenceHandler) SetListener(listener PresenceListener) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.listener = listener
+}
+
+func (h *PresenceHandler) Handle(msg messages.IMessage, conn connection.IConnection) error {
+	h.lock.RLock()
+	listener := h.listener
+	h.lock.RUnlock()
+	if listener == nil {
+		return errors.New(fmt.Sprintf("no listener for presence change %s", msg.Id()))
+	}
+	var change presence.Change
+	if err := json.Unmarshal(msg.Payload(), &change); err != nil {
+		return err
+	}
+	listener(change)
+	return nil
+}
+
+// OnPresenceChange sets the listener of presence changes of clients subscribed by SubscribePresence
+func (c *Client) OnPresenceChange(listener PresenceListener) {
+	c.presenceHandler.SetListener(listener)
+}
+
+func (c *Client) requestPresence(messageType int, uri string, payload interface{}, result interface{}) error {
+	resp, err := c.requestService(messageType, PresenceUri+uri, payload)
+	if err != nil || result == nil {
+		return err
+	}
+	return json.Unmarshal(resp, result)
+}
+
+// GetPresence tells if the clients are online
+func (c *Client) GetPresence(ids []string) (statuses []presence.Status, err error) {
+	err = c.requestPresence(messages.MessageTypeServiceGetRequest, "/status?ids="+strings.Join(ids, ","), nil, &statuses)
+	return
+}
+
+// GetConnections lists connections of this client on the server
+func (c *Client) GetConnections() (infos []presence.ConnectionInfo, err error) {
+	err = c.requestPresence(messages.MessageTypeServiceGetRequest, "/connections", nil, &infos)
+	return
+}
+
+// SubscribePresence subscribes presence changes of the clients until the client goes offline, the current status of
+// the clients is returned
+func (c *Client) SubscribePresence(ids []string) (statuses []presence.Status, err error) {
+	err = c.requestPresence(messages.MessageTypeServicePostRequest, "/subscribe", map[string][]string{"ids": ids}, &statuses)
+	return
+}
+
+// UnsubscribePresence unsubscribes presence changes of the clients, or all clients if ids is empty
+func (c *Client) UnsubscribePresence(ids []string) error {
+	return c.requestPresence(messages.MessageTypeServicePostRequest, "/unsubscribe", map[string][]string{"ids": ids}, nil)
+}
@@ -0,0 +1,147 @@
+package hub_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"whub/hub_common/messages"
+	"whub/hub_common/pubsub_v2/model"
+	"whub/hub_common/service"
+)
+
+const (
+	PubSubTopicsUri      = service.ServicePrefix + "/pubsub/topics"
+	PubSubDeadLettersUri = service.ServicePrefix + "/pubsub/dead-letters"
+)
+
+// pubSubTopicUri escapes hierarchical topic ids and topic filters to fit in one level of the uri
+func pubSubTopicUri(topic string, action string) string {
+	return fmt.Sprintf("%s/%s/%s", PubSubTopicsUri, url.PathEscape(topic), action)
+}
+
+// CreateTopic creates a topic owned by the client
+func (c *Client) CreateTopic(topic string) error {
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, PubSubTopicsUri, map[string]string{"id": topic})
+	return err
+}
+
+// DeleteTopic deletes a topic created by the client
+func (c *Client) DeleteTopic(topic string) error {
+	_, err := c.requestService(messages.MessageTypeServiceDeleteRequest, fmt.Sprintf("%s/%s", PubSubTopicsUri, url.PathEscape(topic)), nil)
+	return err
+}
+
+// PublishTopic publishes payload to the topic, the index of the message in the topic is returned
+func (c *Client) PublishTopic(topic string, payload []byte) (uint32, error) {
+	return c.PublishTopicWithPriority(topic, 0, payload)
+}
+
+// PublishTopicWithPriority publishes payload with the priority, messages of higher priorities are delivered to
+// subscriber groups before the backlog of lower priorities
+func (c *Client) PublishTopicWithPriority(topic string, priority uint8, payload []byte) (uint32, error) {
+	uri := pubSubTopicUri(topic, "publish")
+	if priority > 0 {
+		uri = fmt.Sprintf("%s?priority=%d", uri, priority)
+	}
+	resp, err := c.requestService(messages.MessageTypeServicePostRequest, uri, payload)
+	if err != nil {
+		return 0, err
+	}
+	index, err := strconv.ParseUint(string(resp), 10, 32)
+	return uint32(index), err
+}
+
+// SubscribeTopic joins the subscriber group of the topic, the client has its own group if group is empty. Messages
+// are pushed to listener and acknowledged by its result, or need to be pulled by PullTopic if listener is nil. topic
+// can be a topic filter like sensors/+/temperature or sensors/#, which subscribes all matching topics including the
+// ones created later.
+func (c *Client) SubscribeTopic(topic string, group string, listener TopicMessageListener) (err error) {
+	mode := model.SubscribeMode(model.SubModePull)
+	if listener != nil {
+		mode = model.SubModePush
+		// listen before subscribing so that no pushed message is missed
+		c.topicMessageHandler.On(topic, listener)
+	}
+	_, err = c.requestService(messages.MessageTypeServicePostRequest, pubSubTopicUri(topic, "subscribe"), map[string]string{"group": group, "mode": mode.String()})
+	if err != nil && listener != nil {
+		c.topicMessageHandler.Off(topic)
+	}
+	return
+}
+
+func (c *Client) UnsubscribeTopic(topic string, group string) error {
+	c.topicMessageHandler.Off(topic)
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, pubSubTopicUri(topic, "unsubscribe"), map[string]string{"group": group})
+	return err
+}
+
+// PullTopic takes at most max messages for a pull subscriber group, the messages are acknowledged once they are pulled
+// so they are lost if the client fails to process them, see PullTopicWithAck for at least once delivery
+func (c *Client) PullTopic(topic string, group string, max int) (msgs []model.PubSubMessageDescriptor, err error) {
+	return c.pullTopic(topic, group, max, false)
+}
+
+// PullTopicWithAck takes at most max messages for a pull subscriber group like PullTopic, but the messages are
+// redelivered unless they are acknowledged by AckTopicMessage in time
+func (c *Client) PullTopicWithAck(topic string, group string, max int) (msgs []model.PubSubMessageDescriptor, err error) {
+	return c.pullTopic(topic, group, max, true)
+}
+
+func (c *Client) pullTopic(topic string, group string, max int, manualAck bool) (msgs []model.PubSubMessageDescriptor, err error) {
+	resp, err := c.requestService(messages.MessageTypeServicePostRequest, pubSubTopicUri(topic, "pull"), map[string]interface{}{"group": group, "max": max, "manualAck": manualAck})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(resp, &msgs)
+	return
+}
+
+// AckTopicMessage acknowledges the message at index pulled by PullTopicWithAck, or rejects it so that it's redelivered
+// after a backoff
+func (c *Client) AckTopicMessage(topic string, group string, index uint32, reject bool) error {
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, pubSubTopicUri(topic, "ack"), map[string]interface{}{"group": group, "index": index, "reject": reject})
+	return err
+}
+
+// SeekTopicGroup rewinds the subscriber group so that the next message it receives is the one at index, only the
+// creator of the topic or managers can do so
+func (c *Client) SeekTopicGroup(topic string, group string, index uint32) error {
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, fmt.Sprintf("%s/%s/groups/%s/seek", PubSubTopicsUri, topic, group), map[string]uint32{"index": index})
+	return err
+}
+
+// AddTopicGroupMember allows the client of clientId to join the subscriber group, only the owner of the group, the
+// creator of the topic or managers can do so
+func (c *Client) AddTopicGroupMember(topic string, group string, clientId string) error {
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, fmt.Sprintf("%s/%s/groups/%s/members", PubSubTopicsUri, url.PathEscape(topic), group), map[string]string{"clientId": clientId})
+	return err
+}
+
+// GetTopicMetrics returns the backlog of each priority level of the topic and its subscriber groups
+func (c *Client) GetTopicMetrics(topic string) (metrics model.TopicMetrics, err error) {
+	resp, err := c.requestService(messages.MessageTypeServiceGetRequest, pubSubTopicUri(topic, "metrics"), nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp, &metrics)
+	return
+}
+
+// GetDeadLetters returns at most max dead letters from id from, managers get all dead letters while other clients only
+// get the ones of topics they created
+func (c *Client) GetDeadLetters(from uint32, max int) (deadLetters []model.DeadLetter, err error) {
+	resp, err := c.requestService(messages.MessageTypeServiceGetRequest, fmt.Sprintf("%s?from=%d&max=%d", PubSubDeadLettersUri, from, max), nil)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp, &deadLetters)
+	return
+}
+
+// ReplayDeadLetter redelivers the message of the dead letter to the subscriber group that failed it, only the creator of
+// the topic or managers can do so
+func (c *Client) ReplayDeadLetter(id uint32) error {
+	_, err := c.requestService(messages.MessageTypeServicePostRequest, fmt.Sprintf("%s/%d/replay", PubSubDeadLettersUri, id), nil)
+	return err
+}
@@ -0,0 +1,81 @@
+package hub_client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/pubsub_v2"
+)
+
+// TopicMessageListener is called with messages pushed from a subscribed topic, the message is disposed once the
+// listener returns. The message is acknowledged if the listener returns nil, otherwise it's rejected and will be
+// redelivered later.
+type TopicMessageListener func(message messages.IMessage) error
+
+// TopicMessageHandler routes messages pushed from topics to listeners of the topic, or the topic filter if the message
+// is pushed by a filter subscription
+type TopicMessageHandler struct {
+	listeners map[string]TopicMessageListener
+	lock      *sync.RWMutex
+}
+
+func NewTopicMessageHandler() *TopicMessageHandler {
+	return &TopicMessageHandler{
+		listeners: make(map[string]TopicMessageListener),
+		lock:      new(sync.RWMutex),
+	}
+}
+
+func (h *TopicMessageHandler) withWrite(cb func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	cb()
+}
+
+func (h *TopicMessageHandler) withRead(cb func()) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	cb()
+}
+
+func (h *TopicMessageHandler) Type() int {
+	return messages.MessageTypeServerServiceNotification
+}
+
+func (h *TopicMessageHandler) Types() []int {
+	return nil
+}
+
+func (h *TopicMessageHandler) On(topic string, listener TopicMessageListener) {
+	h.withWrite(func() {
+		h.listeners[topic] = listener
+	})
+}
+
+func (h *TopicMessageHandler) Off(topic string) {
+	h.withWrite(func() {
+		delete(h.listeners, topic)
+	})
+}
+
+func (h *TopicMessageHandler) Handle(msg messages.IMessage, conn connection.IConnection) error {
+	topic := msg.GetHeader(pubsub_v2.MessageHeaderTopic)
+	if filter := msg.GetHeader(pubsub_v2.MessageHeaderTopicFilter); filter != "" {
+		topic = filter
+	}
+	var listener TopicMessageListener
+	h.withRead(func() {
+		listener = h.listeners[topic]
+	})
+	err := errors.New(fmt.Sprintf("no listener for topic %s", topic))
+	if listener != nil {
+		err = listener(msg)
+	}
+	if err != nil {
+		conn.Send(messages.NewErrorMessage(msg.Id(), msg.To(), msg.From(), msg.Uri(), messages.MessageTypeError, err.Error()))
+		return err
+	}
+	return conn.Send(messages.NewACKMessage(msg.Id(), msg.To(), msg.From(), msg.Uri()))
+}
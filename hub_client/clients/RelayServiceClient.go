@@ -19,11 +19,15 @@ const (
 	ServiceManagerUnregisterService = ServerServiceManagerUri + "/unregister" // payload = service descriptor
 	ServiceManagerUpdateService     = ServerServiceManagerUri + "/update"     // payload = service descriptor
 	ServiceManagerUpdateProvider    = ServerServiceManagerUri + "/providers"  // payload = service descriptor
+	ServiceManagerDrainService      = ServerServiceManagerUri + "/drain"      // payload = service descriptor
 )
 
 type IRelayServiceClient interface {
 	RegisterService(descriptor service.ServiceDescriptor) error
 	UnregisterService(descriptor service.ServiceDescriptor) error
+	// DrainService stops the server from routing new requests to this client and returns once the client is removed
+	// from the service providers
+	DrainService(descriptor service.ServiceDescriptor) error
 	UpdateService(descriptor service.ServiceDescriptor) error
 	UpdateServiceProvider(conn connection.IConnection, descriptor service.ServiceDescriptor) error
 	Response(message messages.IMessage) error
@@ -83,6 +87,10 @@ func (c *RelayServiceClient) UnregisterService(descriptor service.ServiceDescrip
 	return c.requestMessage(c.draftDescriptorMessageWith(ServiceManagerUnregisterService, descriptor))
 }
 
+func (c *RelayServiceClient) DrainService(descriptor service.ServiceDescriptor) error {
+	return c.requestMessage(c.draftDescriptorMessageWith(ServiceManagerDrainService, descriptor))
+}
+
 func (c *RelayServiceClient) UpdateService(descriptor service.ServiceDescriptor) error {
 	return c.requestMessage(c.draftDescriptorMessageWith(ServiceManagerUpdateService, descriptor))
 }
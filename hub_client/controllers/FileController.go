@@ -3,6 +3,7 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"time"
 )
@@ -28,6 +29,8 @@ type IFileController interface {
 	Read(path string, sec int) ([]byte, error)
 	List(path string) ([]FileInfo, error)
 	GetFile(path string) ([]byte, error)
+	// Open opens a file for reading, it's up to the caller to close it
+	Open(path string) (io.ReadCloser, error)
 }
 
 type FileController struct {
@@ -151,6 +154,23 @@ func (c *FileController) GetFile(path string) ([]byte, error) {
 	return c.read(file, 0, stat.Size())
 }
 
+func (c *FileController) Open(path string) (io.ReadCloser, error) {
+	file, err := c.open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("path %s is a directory", path))
+	}
+	return file, nil
+}
+
 func GetCurrentPath() (string, error) {
 	return os.Getwd()
 }
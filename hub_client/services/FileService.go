@@ -3,6 +3,7 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"whub/hub_client"
 	"whub/hub_client/controllers"
 	"whub/hub_common/messages"
@@ -38,6 +39,7 @@ func (s *FileService) Init(server roles.ICommonServer) (err error) {
 	}
 	return s.InitHandlers(service.NewRequestHandlerMapBuilder().
 		Get(FileServiceRouteGet, s.Get).
+		Get(FileServiceStreamFile, s.Stream).
 		Get(FileServiceRouteListAll, s.List).Build())
 }
 
@@ -53,6 +55,33 @@ func (s *FileService) Get(request service.IServiceRequest, pathParams map[string
 	return s.ResolveByResponse(request, data)
 }
 
+// Stream responds with the file in chunks, so that files of any size can be transferred without being read into memory
+func (s *FileService) Stream(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	path := pathParams["fileName"]
+	if path == "" {
+		return s.ResolveByError(request, messages.MessageTypeSvcBadRequestError, "invalid path")
+	}
+	file, err := s.fileController.Open(path)
+	if err != nil {
+		return err
+	}
+	writer, err := s.ResolveByStream(request, map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	go func() {
+		defer file.Close()
+		if _, err := io.CopyBuffer(writer, file, make([]byte, FileServiceSectionSize)); err != nil {
+			s.Logger().Printf("stream file %s failed due to %s", path, err.Error())
+			writer.CloseWithError(err.Error())
+			return
+		}
+		writer.Close()
+	}()
+	return nil
+}
+
 func (s *FileService) List(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
 	stats, err := s.fileController.List(".")
 	if err != nil {
@@ -42,7 +42,8 @@ func (s *HTTPClientService) Request(request service.IServiceRequest, pathParams
 		s.Logger().Printf("unable to transfer %s to http request", whr)
 		return err
 	}
-	resp := s.httpClient.Request(httpRequest)
+	// the forwarded request is given up along with the service request
+	resp := s.httpClient.Request(httpRequest.WithContext(request.Context()))
 	s.Logger().Printf("response to %v: %v", whr, resp)
 	marshalled, err := json.Marshal(resp)
 	if err != nil {
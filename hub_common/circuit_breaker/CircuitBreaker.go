@@ -0,0 +1,214 @@
+package circuit_breaker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed   = "closed"    // requests pass through
+	StateOpen     = "open"      // ejected, requests are rejected until the open duration passes
+	StateHalfOpen = "half_open" // one probe request is let through to decide whether to close or open again
+)
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultSlowCallDuration = time.Second * 10
+	DefaultOpenDuration     = time.Second * 10
+	DefaultMaxOpenDuration  = time.Minute * 5
+)
+
+// Options of a circuit breaker, zero values are replaced by defaults
+type Options struct {
+	// FailureThreshold is the number of consecutive failures to open the breaker
+	FailureThreshold int
+	// SlowCallDuration is the latency from which a call is counted as a failure
+	SlowCallDuration time.Duration
+	// OpenDuration is how long the breaker stays open before probing, it's doubled on each failed probe up to
+	// MaxOpenDuration
+	OpenDuration    time.Duration
+	MaxOpenDuration time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = DefaultFailureThreshold
+	}
+	if o.SlowCallDuration <= 0 {
+		o.SlowCallDuration = DefaultSlowCallDuration
+	}
+	if o.OpenDuration <= 0 {
+		o.OpenDuration = DefaultOpenDuration
+	}
+	if o.MaxOpenDuration < o.OpenDuration {
+		o.MaxOpenDuration = DefaultMaxOpenDuration
+		if o.MaxOpenDuration < o.OpenDuration {
+			o.MaxOpenDuration = o.OpenDuration
+		}
+	}
+	return o
+}
+
+// Status is a snapshot of a circuit breaker
+type Status struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	// Ejections is the number of times the breaker has been opened
+	Ejections int `json:"ejections"`
+	// OpenUntil is the unix milli time from which the breaker probes, 0 if it's not open
+	OpenUntil int64 `json:"openUntil,omitempty"`
+}
+
+func (s Status) String() string {
+	marshalled, err := json.Marshal(s)
+	if err != nil {
+		return "{}"
+	}
+	return string(marshalled)
+}
+
+type CircuitBreaker struct {
+	options             Options
+	state               string
+	consecutiveFailures int
+	ejections           int
+	openDuration        time.Duration
+	openUntil           time.Time
+	// probing is true while the half-open probe request is outstanding
+	probing bool
+	// onStateChange is called outside the lock with the previous and the current state
+	onStateChange func(from string, to string)
+	now           func() time.Time
+	lock          *sync.Mutex
+}
+
+func NewCircuitBreaker(options Options) *CircuitBreaker {
+	options = options.withDefaults()
+	return &CircuitBreaker{
+		options:      options,
+		state:        StateClosed,
+		openDuration: options.OpenDuration,
+		now:          time.Now,
+		lock:         new(sync.Mutex),
+	}
+}
+
+func (b *CircuitBreaker) withLock(cb func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	cb()
+}
+
+// OnStateChange sets the callback of state changes
+func (b *CircuitBreaker) OnStateChange(cb func(from string, to string)) {
+	b.withLock(func() {
+		b.onStateChange = cb
+	})
+}
+
+func (b *CircuitBreaker) notify(from string, to string, cb func(from string, to string)) {
+	if from != to && cb != nil {
+		cb(from, to)
+	}
+}
+
+func (b *CircuitBreaker) State() (state string) {
+	b.withLock(func() {
+		state = b.state
+	})
+	return
+}
+
+// Allow tells if a request can be sent. Once the open duration passes, the breaker becomes half-open and only one
+// probe request is allowed until it's recorded.
+func (b *CircuitBreaker) Allow() (allowed bool) {
+	var from, to string
+	var cb func(from string, to string)
+	b.withLock(func() {
+		from, cb = b.state, b.onStateChange
+		switch b.state {
+		case StateClosed:
+			allowed = true
+		case StateOpen:
+			if !b.now().Before(b.openUntil) {
+				b.state = StateHalfOpen
+				b.probing = true
+				allowed = true
+			}
+		case StateHalfOpen:
+			if !b.probing {
+				b.probing = true
+				allowed = true
+			}
+		}
+		to = b.state
+	})
+	b.notify(from, to, cb)
+	return
+}
+
+// Record records the result of an allowed request, slow calls are counted as failures
+func (b *CircuitBreaker) Record(latency time.Duration, failed bool) {
+	failed = failed || latency >= b.options.SlowCallDuration
+	var from, to string
+	var cb func(from string, to string)
+	b.withLock(func() {
+		from, cb = b.state, b.onStateChange
+		switch b.state {
+		case StateClosed:
+			if !failed {
+				b.consecutiveFailures = 0
+			} else if b.consecutiveFailures++; b.consecutiveFailures >= b.options.FailureThreshold {
+				b.open()
+			}
+		case StateHalfOpen:
+			b.probing = false
+			if failed {
+				b.consecutiveFailures++
+				b.openDuration *= 2
+				if b.openDuration > b.options.MaxOpenDuration {
+					b.openDuration = b.options.MaxOpenDuration
+				}
+				b.open()
+			} else {
+				b.consecutiveFailures = 0
+				b.openDuration = b.options.OpenDuration
+				b.state = StateClosed
+			}
+		}
+		// results of requests sent before the breaker opened are ignored
+		to = b.state
+	})
+	b.notify(from, to, cb)
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = StateOpen
+	b.ejections++
+	b.openUntil = b.now().Add(b.openDuration)
+}
+
+// Release gives up an allowed request without a result, e.g. the request is cancelled by the requester
+func (b *CircuitBreaker) Release() {
+	b.withLock(func() {
+		if b.state == StateHalfOpen {
+			b.probing = false
+		}
+	})
+}
+
+func (b *CircuitBreaker) Status() (status Status) {
+	b.withLock(func() {
+		status = Status{
+			State:               b.state,
+			ConsecutiveFailures: b.consecutiveFailures,
+			Ejections:           b.ejections,
+		}
+		if b.state == StateOpen {
+			status.OpenUntil = b.openUntil.UnixNano() / int64(time.Millisecond)
+		}
+	})
+	return
+}
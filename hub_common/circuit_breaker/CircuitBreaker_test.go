@@ -0,0 +1,87 @@
+package circuit_breaker
+
+import (
+	"testing"
+	"time"
+	"whub/common/test_utils"
+)
+
+// newTestBreaker returns a breaker with a clock moved by the returned func
+func newTestBreaker(options Options) (*CircuitBreaker, func(d time.Duration)) {
+	b := NewCircuitBreaker(options)
+	now := time.Now()
+	b.now = func() time.Time {
+		return now
+	}
+	return b, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	tg := test_utils.NewTestGroup("CircuitBreaker", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Open", "breaker should open after consecutive failures only", func() bool {
+			b, _ := newTestBreaker(Options{FailureThreshold: 3})
+			b.Record(0, true)
+			b.Record(0, true)
+			b.Record(0, false)
+			b.Record(0, true)
+			b.Record(0, true)
+			if b.State() != StateClosed || !b.Allow() {
+				return false
+			}
+			b.Record(0, true)
+			status := b.Status()
+			return status.State == StateOpen && status.Ejections == 1 && status.OpenUntil > 0 && !b.Allow()
+		}),
+		test_utils.NewTestCase("Slow calls", "slow calls should be counted as failures", func() bool {
+			b, _ := newTestBreaker(Options{FailureThreshold: 2, SlowCallDuration: time.Second})
+			b.Record(time.Second*2, false)
+			b.Record(time.Second, false)
+			return b.State() == StateOpen
+		}),
+		test_utils.NewTestCase("Half open", "only one probe should be allowed and a successful probe should close the breaker", func() bool {
+			b, advance := newTestBreaker(Options{FailureThreshold: 1, OpenDuration: time.Second})
+			var transitions []string
+			b.OnStateChange(func(from string, to string) {
+				transitions = append(transitions, from+"->"+to)
+			})
+			b.Record(0, true)
+			advance(time.Second)
+			if !b.Allow() || b.Allow() || b.State() != StateHalfOpen {
+				return false
+			}
+			b.Release()
+			if !b.Allow() {
+				return false
+			}
+			b.Record(0, false)
+			return b.State() == StateClosed && b.Allow() && len(transitions) == 3 &&
+				transitions[0] == "closed->open" && transitions[1] == "open->half_open" && transitions[2] == "half_open->closed"
+		}),
+		test_utils.NewTestCase("Failed probe", "a failed probe should open the breaker for a longer duration", func() bool {
+			b, advance := newTestBreaker(Options{FailureThreshold: 1, OpenDuration: time.Second, MaxOpenDuration: time.Second * 3})
+			b.Record(0, true)
+			advance(time.Second)
+			b.Allow()
+			b.Record(0, true)
+			advance(time.Second)
+			if b.Allow() {
+				return false
+			}
+			advance(time.Second)
+			b.Allow()
+			b.Record(0, true)
+			advance(time.Second * 3)
+			// capped by the max open duration
+			return b.Allow() && b.Status().Ejections == 3
+		}),
+		test_utils.NewTestCase("Late results", "results of requests sent before the breaker opened should be ignored", func() bool {
+			b, _ := newTestBreaker(Options{FailureThreshold: 1})
+			b.Record(0, true)
+			b.Record(0, false)
+			return b.State() == StateOpen
+		}),
+	}).Do(t)
+}
@@ -3,6 +3,8 @@ package connection
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 	"whub/common/async"
 	"whub/common/connection"
@@ -16,25 +18,49 @@ const WSConnectionPath = "/ws"
 
 const DefaultTimeout = time.Second * 30
 const DefaultAlivenessTimeout = time.Minute * 5
+const ProtocolNegotiationTimeout = time.Second * 5
 
 type Connection struct {
 	conn                connection.IConnection
 	address             string
 	requestTimeout      time.Duration
-	messageParser       messages.IMessageParser
+	protocol            atomic.Value
+	protocolLock        sync.RWMutex // held for reading by writes, so that codec can't switch in between serializing and writing
 	notificationEmitter notification.IWRNotificationEmitter
 	messageCallback     func(messages.IMessage)
 	logger              *logger.SimpleLogger
 	ttlTimedJob         ctimer.ICTimer
 }
 
+// protocolState is swapped as a whole so that readers and writers never see a half updated protocol
+type protocolState struct {
+	selection messages.ProtocolSelection
+	parser    messages.IMessageParser
+	// messages serialized by the peer before it switched codec may still be in flight, so the previous parser is
+	// kept to decode them
+	previousParser messages.IMessageParser
+}
+
 type IConnection interface {
 	Address() string
 	ReadingLoop()
 	Request(messages.IMessage) (messages.IMessage, error)
 	RequestWithTimeout(messages.IMessage, time.Duration) (messages.IMessage, error)
 	Send(messages.IMessage) error
+	// RequestWithStream returns the stream reader along with the stream header if the peer responds with a stream,
+	// otherwise the reader is nil
+	RequestWithStream(messages.IMessage) (messages.IMessage, IStreamReader, error)
+	// OpenStream creates a writer for a stream that responds to request id, the stream header should be sent after
+	OpenStream(id string, from string, to string, uri string) IStreamWriter
 	OnIncomingMessage(func(message messages.IMessage))
+	MessageParser() messages.IMessageParser
+	SetMessageParser(messages.IMessageParser)
+	Protocol() messages.ProtocolSelection
+	UpdateProtocol(messages.ProtocolSelection) error
+	// AcceptProtocol sends the response to a protocol offer by the current codec and switches to selection right
+	// before the response is written, so that the peer can use the new codec once it reads the response
+	AcceptProtocol(response messages.IMessage, selection messages.ProtocolSelection) error
+	NegotiateProtocol(from string, to string, offer messages.ProtocolOffer) (messages.ProtocolSelection, error)
 	OnceMessage(string, func(messages.IMessage)) (notification.Disposable, error)
 	OnMessage(string, func(messages.IMessage)) (notification.Disposable, error)
 	OffMessage(string, func(messages.IMessage))
@@ -59,16 +85,18 @@ func NewConnection(
 	} else if timeout > time.Second*60 {
 		timeout = time.Second * 60
 	}
-	conn := &Connection{c, "", timeout, messageParser, notifications, nil, logger, nil}
+	conn := &Connection{conn: c, requestTimeout: timeout, notificationEmitter: notifications, logger: logger}
+	conn.SetMessageParser(messageParser)
 	conn.ttlTimedJob = ctimer.New(DefaultAlivenessTimeout, conn.ttlJob)
 	conn.conn.OnError(func(err error) {
 		conn.conn.Close()
 	})
 	conn.conn.OnMessage(func(stream []byte) {
 		conn.ttlTimedJob.Reset()
-		msg, err := conn.messageParser.Deserialize(stream)
+		msg, err := conn.deserialize(stream)
 		if err == nil {
-			if notifications.HasEvent(msg.Id()) {
+			// cancel messages share the id of the request they cancel, but they are never responses
+			if msg.MessageType() != messages.MessageTypeServiceCancelRequest && notifications.HasEvent(msg.Id()) {
 				notifications.Notify(msg.Id(), msg)
 			} else if conn.messageCallback != nil {
 				conn.messageCallback(msg)
@@ -90,7 +118,8 @@ func (conn *Connection) Init(
 	conn.conn = c
 	conn.logger = logger
 	conn.requestTimeout = timeout
-	conn.messageParser = messageParser
+	// connections are pooled, so protocol state of the previous cycle should not be carried over
+	conn.protocol.Store(&protocolState{selection: messages.ProtocolSelection{Version: messages.ProtocolVersion, Compression: messages.CompressionNone}, parser: messageParser})
 	conn.notificationEmitter = notifications
 	conn.ttlTimedJob = ctimer.New(DefaultAlivenessTimeout, conn.ttlJob)
 	conn.conn.OnError(func(err error) {
@@ -98,9 +127,10 @@ func (conn *Connection) Init(
 	})
 	conn.conn.OnMessage(func(stream []byte) {
 		conn.ttlTimedJob.Reset()
-		msg, err := conn.messageParser.Deserialize(stream)
+		msg, err := conn.deserialize(stream)
 		if err == nil {
-			if notifications.HasEvent(msg.Id()) {
+			// cancel messages share the id of the request they cancel, but they are never responses
+			if msg.MessageType() != messages.MessageTypeServiceCancelRequest && notifications.HasEvent(msg.Id()) {
 				notifications.Notify(msg.Id(), msg)
 			} else if conn.messageCallback != nil {
 				conn.messageCallback(msg)
@@ -129,7 +159,25 @@ func (c *Connection) Request(message messages.IMessage) (response messages.IMess
 	return c.RequestWithTimeout(message, c.requestTimeout)
 }
 
+// budget bounds the request by timeout and the message deadline, whichever is earlier. A message without deadline
+// will be given one so that the receiver knows when the requester gives up.
+func (c *Connection) budget(message messages.IMessage, timeout time.Duration) (time.Duration, error) {
+	if message.Deadline().IsZero() {
+		message.SetDeadline(time.Now().Add(timeout))
+		return timeout, nil
+	}
+	if timeout = messages.Budget(message, timeout); timeout <= 0 {
+		err := errors.New(fmt.Sprintf("deadline exceeded for message %s", message.Id()))
+		message.Dispose()
+		return 0, err
+	}
+	return timeout, nil
+}
+
 func (c *Connection) RequestWithTimeout(message messages.IMessage, timeout time.Duration) (response messages.IMessage, err error) {
+	if timeout, err = c.budget(message, timeout); err != nil {
+		return
+	}
 	barrier := async.NewWaitLock()
 	if err = c.Send(message); err != nil {
 		return
@@ -159,17 +207,171 @@ func (c *Connection) Send(message messages.IMessage) (err error) {
 		}
 		message.Dispose()
 	}()
-	if m, e := c.messageParser.Serialize(message); e == nil {
-		return c.conn.Write(m)
-	} else {
-		return e
+	messages.EncodeDeadline(message)
+	c.protocolLock.RLock()
+	defer c.protocolLock.RUnlock()
+	m, err := c.serialize(message, c.protocolState())
+	if err != nil {
+		return
 	}
+	return c.conn.Write(m)
+}
+
+func (c *Connection) serialize(message messages.IMessage, state *protocolState) ([]byte, error) {
+	if err := messages.CompressMessage(message, state.selection.Compression, messages.DefaultCompressionThreshold); err != nil {
+		return nil, err
+	}
+	return state.parser.Serialize(message)
+}
+
+func (c *Connection) RequestWithStream(message messages.IMessage) (messages.IMessage, IStreamReader, error) {
+	timeout, err := c.budget(message, c.requestTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	// listen before sending so that a fast response will not be missed, message will be disposed once sent
+	reader := NewStreamReader(c, message.Id(), message.From(), message.To(), message.Uri(), c.requestTimeout)
+	if err = c.Send(message); err != nil {
+		reader.off()
+		return nil, nil, err
+	}
+	// deadline bounds the time to the response, the stream itself is bounded by the idle timeout of the reader
+	response, err := reader.waitHeader(timeout)
+	if err != nil {
+		reader.off()
+		return nil, nil, err
+	}
+	if !messages.IsStreamHeaderMessage(response) {
+		reader.off()
+		return response, nil, nil
+	}
+	if err = reader.grant(reader.window); err != nil {
+		reader.off()
+		return nil, nil, err
+	}
+	return response, reader, nil
+}
+
+func (c *Connection) OpenStream(id string, from string, to string, uri string) IStreamWriter {
+	return NewStreamWriter(c, id, from, to, uri)
 }
 
 func (c *Connection) OnIncomingMessage(cb func(messages.IMessage)) {
 	c.messageCallback = cb
 }
 
+func (c *Connection) protocolState() *protocolState {
+	return c.protocol.Load().(*protocolState)
+}
+
+func (c *Connection) deserialize(stream []byte) (messages.IMessage, error) {
+	state := c.protocolState()
+	msg, err := state.parser.Deserialize(stream)
+	if err != nil && state.previousParser != nil {
+		msg, err = state.previousParser.Deserialize(stream)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// compression is marked on each message, so messages compressed by any negotiated compression can be decoded
+	if err = messages.DecompressMessage(msg); err != nil {
+		return nil, err
+	}
+	messages.DecodeDeadline(msg)
+	return msg, nil
+}
+
+func (c *Connection) MessageParser() messages.IMessageParser {
+	return c.protocolState().parser
+}
+
+// SetMessageParser swaps the codec of the connection, messages sent after the swap will use the new parser
+func (c *Connection) SetMessageParser(parser messages.IMessageParser) {
+	c.protocolLock.Lock()
+	defer c.protocolLock.Unlock()
+	c.swapProtocol(messages.ProtocolSelection{Version: messages.ProtocolVersion, Compression: messages.CompressionNone}, parser)
+}
+
+func (c *Connection) swapProtocol(selection messages.ProtocolSelection, parser messages.IMessageParser) {
+	state := &protocolState{selection: selection, parser: parser}
+	if current, ok := c.protocol.Load().(*protocolState); ok && current.parser != parser {
+		state.previousParser = current.parser
+	}
+	c.protocol.Store(state)
+}
+
+func (c *Connection) Protocol() messages.ProtocolSelection {
+	return c.protocolState().selection
+}
+
+// UpdateProtocol applies a negotiated protocol selection
+func (c *Connection) UpdateProtocol(selection messages.ProtocolSelection) error {
+	parser := messages.GetMessageParser(selection.Codec)
+	if parser == nil {
+		return errors.New(fmt.Sprintf("unsupported codec %s", selection.Codec))
+	}
+	c.protocolLock.Lock()
+	defer c.protocolLock.Unlock()
+	c.swapProtocol(selection, parser)
+	return nil
+}
+
+// AcceptProtocol switches codec before the response is written, the previous parser is kept to decode messages the
+// peer sent before it reads the response, and no message can be written by the new codec before the response.
+func (c *Connection) AcceptProtocol(response messages.IMessage, selection messages.ProtocolSelection) (err error) {
+	defer func() {
+		if err != nil {
+			c.logger.Println("write error: ", err)
+		}
+		response.Dispose()
+	}()
+	parser := messages.GetMessageParser(selection.Codec)
+	if parser == nil {
+		return errors.New(fmt.Sprintf("unsupported codec %s", selection.Codec))
+	}
+	c.protocolLock.Lock()
+	defer c.protocolLock.Unlock()
+	m, err := c.serialize(response, c.protocolState())
+	if err != nil {
+		return
+	}
+	c.swapProtocol(selection, parser)
+	return c.conn.Write(m)
+}
+
+// NegotiateProtocol initiates a protocol handshake with the peer. The selection is applied on the reading goroutine
+// right when the response is read, so that any message after the response is decoded by the new parser.
+func (c *Connection) NegotiateProtocol(from string, to string, offer messages.ProtocolOffer) (selection messages.ProtocolSelection, err error) {
+	request, err := messages.NewProtocolUpdateMessage(from, to, offer)
+	if err != nil {
+		return
+	}
+	requestId := request.Id()
+	barrier := async.NewWaitLock()
+	timeoutEvt := ctimer.New(ProtocolNegotiationTimeout, func() {
+		c.OffAll(requestId)
+		err = errors.New(fmt.Sprintf("protocol negotiation timeout for message %s", requestId))
+		barrier.Open()
+	})
+	c.OnceMessage(requestId, func(msg messages.IMessage) {
+		timeoutEvt.Cancel()
+		if msg.MessageType() != messages.MessageTypeProtocolUpdate {
+			err = errors.New(fmt.Sprintf("protocol negotiation failed: %s", string(msg.Payload())))
+		} else if selection, err = messages.ParseProtocolSelection(msg); err == nil {
+			err = c.UpdateProtocol(selection)
+		}
+		barrier.Open()
+	})
+	timeoutEvt.Start()
+	if err = c.Send(request); err != nil {
+		timeoutEvt.Cancel()
+		c.OffAll(requestId)
+		return
+	}
+	barrier.Wait()
+	return
+}
+
 func (c *Connection) OnMessage(id string, cb func(messages.IMessage)) (notification.Disposable, error) {
 	return c.notificationEmitter.On(id, cb)
 }
@@ -120,6 +120,19 @@ func (g *ConnectionGroup) Send(message messages.IMessage) error {
 	return next.Send(message)
 }
 
+func (g *ConnectionGroup) RequestWithStream(message messages.IMessage) (messages.IMessage, IStreamReader, error) {
+	next := g.nextConn()
+	if next == nil {
+		return nil, nil, errors.New("no valid connection")
+	}
+	return next.RequestWithStream(message)
+}
+
+func (g *ConnectionGroup) OpenStream(id string, from string, to string, uri string) IStreamWriter {
+	// chunks of a stream need to go through the same connection to keep the order
+	return g.curr.OpenStream(id, from, to, uri)
+}
+
 func (g *ConnectionGroup) withEachConn(cb func(IConnection)) {
 	g.withRead(func() {
 		for _, n := range g.conns {
@@ -134,6 +147,37 @@ func (g *ConnectionGroup) OnIncomingMessage(f func(message messages.IMessage)) {
 	})
 }
 
+func (g *ConnectionGroup) MessageParser() messages.IMessageParser {
+	return g.curr.MessageParser()
+}
+
+func (g *ConnectionGroup) SetMessageParser(parser messages.IMessageParser) {
+	g.withEachConn(func(conn IConnection) {
+		conn.SetMessageParser(parser)
+	})
+}
+
+func (g *ConnectionGroup) Protocol() messages.ProtocolSelection {
+	return g.curr.Protocol()
+}
+
+func (g *ConnectionGroup) UpdateProtocol(selection messages.ProtocolSelection) (err error) {
+	g.withEachConn(func(conn IConnection) {
+		if e := conn.UpdateProtocol(selection); e != nil {
+			err = e
+		}
+	})
+	return
+}
+
+func (g *ConnectionGroup) AcceptProtocol(response messages.IMessage, selection messages.ProtocolSelection) error {
+	return errors.New("protocol should be accepted on each connection of the group")
+}
+
+func (g *ConnectionGroup) NegotiateProtocol(from string, to string, offer messages.ProtocolOffer) (messages.ProtocolSelection, error) {
+	return messages.ProtocolSelection{}, errors.New("protocol should be negotiated on each connection of the group")
+}
+
 func (g *ConnectionGroup) OnceMessage(s string, f func(messages.IMessage)) (notification.Disposable, error) {
 	return nil, nil
 }
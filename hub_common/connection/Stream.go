@@ -0,0 +1,424 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"whub/hub_common/messages"
+	"whub/hub_common/notification"
+)
+
+const (
+	DefaultStreamChunkSize = 1024 * 32
+	// DefaultStreamWindow is the max number of chunks a sender can send before the receiver grants more credits
+	DefaultStreamWindow = 8
+)
+
+var (
+	ErrStreamCancelled = errors.New("stream has been cancelled by receiver")
+	ErrStreamClosed    = errors.New("stream has been closed")
+	ErrStreamOverflow  = errors.New("stream sender exceeded the granted credits")
+)
+
+// IStreamWriter sends ordered chunks of a stream, Write blocks when the receiver has not granted enough credits
+type IStreamWriter interface {
+	io.Writer
+	Id() string
+	// Close sends the end-of-stream marker
+	Close() error
+	// CloseWithError aborts the stream, the receiver will get errMsg as the error of the stream
+	CloseWithError(errMsg string) error
+}
+
+// IStreamReader receives ordered chunks of a stream, credits are granted to the sender as chunks are consumed
+type IStreamReader interface {
+	io.Reader
+	Id() string
+	// Next returns the next chunk of the stream or io.EOF when the stream ends
+	Next() ([]byte, error)
+	// Close cancels the stream if it has not ended yet
+	Close() error
+}
+
+// StreamWriter writes a stream over an IConnection. It listens to the stream id for credit and cancel messages, so
+// it must be created before the stream header is sent.
+type StreamWriter struct {
+	conn      IConnection
+	id        string
+	from      string
+	to        string
+	uri       string
+	chunkSize int
+	timeout   time.Duration
+	seq       int
+	credit    int
+	err       error
+	signal    chan struct{}
+	dispose   notification.Disposable
+	lock      *sync.Mutex
+	writeLock *sync.Mutex
+}
+
+func NewStreamWriter(conn IConnection, id string, from string, to string, uri string) *StreamWriter {
+	w := &StreamWriter{
+		conn:      conn,
+		id:        id,
+		from:      from,
+		to:        to,
+		uri:       uri,
+		chunkSize: DefaultStreamChunkSize,
+		timeout:   DefaultTimeout,
+		signal:    make(chan struct{}, 1),
+		lock:      new(sync.Mutex),
+		writeLock: new(sync.Mutex),
+	}
+	w.dispose, _ = conn.OnMessage(id, w.onMessage)
+	return w
+}
+
+func (w *StreamWriter) withLock(cb func()) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	cb()
+}
+
+func (w *StreamWriter) notify() {
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *StreamWriter) onMessage(msg messages.IMessage) {
+	// chunks of other streams with the same id(e.g. relayed streams) are not for the writer
+	if !messages.IsStreamControlMessage(msg) {
+		return
+	}
+	if msg.GetHeader(messages.MessageStreamHeaderCancel) != "" {
+		w.withLock(func() {
+			if w.err == nil {
+				w.err = ErrStreamCancelled
+			}
+		})
+		w.notify()
+		return
+	}
+	credit, err := strconv.Atoi(msg.GetHeader(messages.MessageStreamHeaderCredit))
+	if err != nil || credit <= 0 {
+		return
+	}
+	w.withLock(func() {
+		w.credit += credit
+	})
+	w.notify()
+}
+
+func (w *StreamWriter) Id() string {
+	return w.id
+}
+
+// acquire takes one credit, it waits until a credit is granted or no credit is granted in timeout
+func (w *StreamWriter) acquire() error {
+	for {
+		var err error
+		acquired := false
+		w.withLock(func() {
+			if w.err != nil {
+				err = w.err
+			} else if w.credit > 0 {
+				w.credit--
+				acquired = true
+			}
+		})
+		if err != nil || acquired {
+			return err
+		}
+		select {
+		case <-w.signal:
+		case <-time.After(w.timeout):
+			return errors.New(fmt.Sprintf("stream %s timeout waiting for credits", w.id))
+		}
+	}
+}
+
+func (w *StreamWriter) Write(p []byte) (n int, err error) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	for n < len(p) {
+		size := len(p) - n
+		if size > w.chunkSize {
+			size = w.chunkSize
+		}
+		if err = w.acquire(); err != nil {
+			return
+		}
+		w.seq++
+		if err = w.conn.Send(messages.NewStreamChunkMessage(w.id, w.from, w.to, w.uri, w.seq, p[n:n+size])); err != nil {
+			w.fail(err)
+			return
+		}
+		n += size
+	}
+	return
+}
+
+func (w *StreamWriter) fail(err error) {
+	w.withLock(func() {
+		if w.err == nil {
+			w.err = err
+		}
+	})
+}
+
+func (w *StreamWriter) Close() error {
+	return w.close("")
+}
+
+func (w *StreamWriter) CloseWithError(errMsg string) error {
+	if errMsg == "" {
+		errMsg = "stream aborted"
+	}
+	return w.close(errMsg)
+}
+
+func (w *StreamWriter) close(errMsg string) (err error) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	w.withLock(func() {
+		err = w.err
+		w.err = ErrStreamClosed
+	})
+	if w.dispose != nil {
+		w.dispose()
+	}
+	if err == ErrStreamClosed {
+		return err
+	}
+	if err == ErrStreamCancelled {
+		// receiver is no longer interested in the stream
+		return nil
+	}
+	w.seq++
+	return w.conn.Send(messages.NewStreamEndMessage(w.id, w.from, w.to, w.uri, w.seq, errMsg))
+}
+
+type streamChunk struct {
+	payload []byte
+	end     bool
+	err     error
+}
+
+// StreamReader reads a stream over an IConnection. The first message of the id is taken as the response of the
+// request, the following chunks are buffered up to the granted credits.
+type StreamReader struct {
+	conn     IConnection
+	id       string
+	from     string
+	to       string
+	uri      string
+	window   int
+	timeout  time.Duration
+	header   chan messages.IMessage
+	chunks   chan streamChunk
+	expected int
+	consumed int
+	// responded is set once the response of the request is received
+	responded bool
+	ended     bool
+	err       error
+	buffer    []byte
+	dispose   notification.Disposable
+	lock      *sync.Mutex
+}
+
+// NewStreamReader creates a reader on the requester side, from is the requester and to is the stream sender
+func NewStreamReader(conn IConnection, id string, from string, to string, uri string, timeout time.Duration) *StreamReader {
+	r := &StreamReader{
+		conn:     conn,
+		id:       id,
+		from:     from,
+		to:       to,
+		uri:      uri,
+		window:   DefaultStreamWindow,
+		timeout:  timeout,
+		header:   make(chan messages.IMessage, 1),
+		chunks:   make(chan streamChunk, DefaultStreamWindow+1),
+		expected: 1,
+		lock:     new(sync.Mutex),
+	}
+	r.dispose, _ = conn.OnMessage(id, r.onMessage)
+	return r
+}
+
+func (r *StreamReader) withLock(cb func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cb()
+}
+
+func (r *StreamReader) onMessage(msg messages.IMessage) {
+	// control messages of other streams with the same id(e.g. relayed streams) are not for the reader
+	if messages.IsStreamControlMessage(msg) {
+		return
+	}
+	first := false
+	r.withLock(func() {
+		first = !r.responded
+		r.responded = true
+	})
+	if first {
+		// the first message is the response, either the stream header or a plain response
+		r.header <- msg
+		return
+	}
+	if msg.MessageType() != messages.MessageTypeStream {
+		return
+	}
+	var chunk streamChunk
+	r.withLock(func() {
+		seq, err := strconv.Atoi(msg.GetHeader(messages.MessageStreamHeaderSeq))
+		if err != nil || seq != r.expected {
+			chunk = streamChunk{end: true, err: errors.New(fmt.Sprintf("stream %s expects chunk %d but got %s", r.id, r.expected, msg.GetHeader(messages.MessageStreamHeaderSeq)))}
+			return
+		}
+		r.expected++
+		chunk = streamChunk{payload: msg.Payload(), end: msg.GetHeader(messages.MessageStreamHeaderEnd) != ""}
+		if errMsg := msg.GetHeader(messages.MessageStreamHeaderError); errMsg != "" {
+			chunk.err = errors.New(errMsg)
+		}
+	})
+	select {
+	case r.chunks <- chunk:
+	default:
+		// do not block the reading loop, a full buffer means the sender does not respect credits
+		r.fail(ErrStreamOverflow)
+	}
+}
+
+func (r *StreamReader) fail(err error) {
+	r.withLock(func() {
+		if r.err == nil {
+			r.err = err
+		}
+	})
+}
+
+// waitHeader waits for the response of the request, it is either the stream header or a plain response
+func (r *StreamReader) waitHeader(timeout time.Duration) (messages.IMessage, error) {
+	select {
+	case msg := <-r.header:
+		return msg, nil
+	case <-time.After(timeout):
+		return nil, errors.New(fmt.Sprintf("request timeout for message %s", r.id))
+	}
+}
+
+func (r *StreamReader) off() {
+	if r.dispose != nil {
+		r.dispose()
+	}
+}
+
+func (r *StreamReader) grant(credit int) error {
+	return r.conn.Send(messages.NewStreamCreditMessage(r.id, r.from, r.to, r.uri, credit))
+}
+
+func (r *StreamReader) Id() string {
+	return r.id
+}
+
+func (r *StreamReader) Next() (payload []byte, err error) {
+	ended := false
+	r.withLock(func() {
+		ended = r.ended
+		if r.err != nil {
+			err = r.err
+		} else if r.ended {
+			err = io.EOF
+		}
+	})
+	if err != nil {
+		if !ended {
+			r.Close()
+		}
+		return nil, err
+	}
+	var chunk streamChunk
+	select {
+	case chunk = <-r.chunks:
+	case <-time.After(r.timeout):
+		r.Close()
+		return nil, errors.New(fmt.Sprintf("stream %s timeout waiting for chunks", r.id))
+	}
+	if chunk.end {
+		r.withLock(func() {
+			r.ended = true
+			if chunk.err != nil && r.err == nil {
+				r.err = chunk.err
+			}
+		})
+		r.off()
+		if chunk.err != nil {
+			return nil, chunk.err
+		}
+		if len(chunk.payload) == 0 {
+			return nil, io.EOF
+		}
+		return chunk.payload, nil
+	}
+	r.consumed++
+	if r.consumed >= r.window/2 {
+		if err = r.grant(r.consumed); err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.consumed = 0
+	}
+	return chunk.payload, nil
+}
+
+func (r *StreamReader) Read(p []byte) (n int, err error) {
+	for len(r.buffer) == 0 {
+		if r.buffer, err = r.Next(); err != nil {
+			return
+		}
+	}
+	n = copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return
+}
+
+func (r *StreamReader) Close() (err error) {
+	ended := false
+	r.withLock(func() {
+		ended = r.ended
+		r.ended = true
+	})
+	if ended {
+		return nil
+	}
+	r.off()
+	return r.conn.Send(messages.NewStreamCancelMessage(r.id, r.from, r.to, r.uri))
+}
+
+// PipeStream relays all chunks from reader to writer, errors on either side are propagated to the other side
+func PipeStream(reader IStreamReader, writer IStreamWriter) error {
+	for {
+		chunk, err := reader.Next()
+		if err == io.EOF {
+			return writer.Close()
+		}
+		if err != nil {
+			writer.CloseWithError(err.Error())
+			return err
+		}
+		if _, err = writer.Write(chunk); err != nil {
+			reader.Close()
+			writer.CloseWithError(err.Error())
+			return err
+		}
+	}
+}
@@ -59,3 +59,37 @@ func (h *InvalidMessageHandler) Type() int {
 func (h *InvalidMessageHandler) Types() []int {
 	return nil
 }
+
+type ProtocolUpdateMessageHandler struct {
+	role roles.IDescribableRole
+}
+
+func NewProtocolUpdateMessageHandler(role roles.IDescribableRole) IMessageHandler {
+	return &ProtocolUpdateMessageHandler{role}
+}
+
+// Handle responds to the protocol offer with the current codec, the connection switches codec before the response is
+// written so that messages the peer sends by the new codec right after reading the response can be decoded
+func (h *ProtocolUpdateMessageHandler) Handle(message messages.IMessage, conn connection.IConnection) error {
+	offer, err := messages.ParseProtocolOffer(message)
+	if err != nil {
+		return conn.Send(messages.NewErrorResponse(message, h.role.Id(), messages.MessageTypeSvcBadRequestError, "invalid protocol offer"))
+	}
+	selection, err := messages.NegotiateProtocol(offer)
+	if err != nil {
+		return conn.Send(messages.NewErrorResponse(message, h.role.Id(), messages.MessageTypeSvcBadRequestError, err.Error()))
+	}
+	resp, err := messages.NewProtocolUpdateResponse(message, h.role.Id(), selection)
+	if err != nil {
+		return err
+	}
+	return conn.AcceptProtocol(resp, selection)
+}
+
+func (h *ProtocolUpdateMessageHandler) Type() int {
+	return messages.MessageTypeProtocolUpdate
+}
+
+func (h *ProtocolUpdateMessageHandler) Types() []int {
+	return nil
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"whub/hub_common/messages"
+)
+
+const contentEncodingIdentity = "identity"
+
+// NegotiateContentEncoding picks the supported compression with the highest quality from an Accept-Encoding header,
+// earlier ones win on equal quality. messages.CompressionNone is returned if nothing is acceptable.
+func NegotiateContentEncoding(acceptEncoding string) string {
+	selected := messages.CompressionNone
+	selectedQuality := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, quality := parseEncodingQuality(part)
+		if coding == "*" {
+			// any compression is acceptable, take the most preferred one
+			for _, name := range messages.CompressionNames() {
+				if name != messages.CompressionNone {
+					coding = name
+					break
+				}
+			}
+		}
+		if quality > selectedQuality && messages.GetCompressor(coding) != nil {
+			selected = coding
+			selectedQuality = quality
+		}
+	}
+	return selected
+}
+
+func parseEncodingQuality(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	coding := strings.ToLower(strings.TrimSpace(params[0]))
+	quality := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				quality = q
+			}
+		}
+	}
+	return coding, quality
+}
+
+// DecodeContent decodes body encoded by the Content-Encoding header, bodies decoded to more than
+// messages.MaxDecompressedSize are rejected by messages.ErrDecompressedTooLarge
+func DecodeContent(contentEncoding string, body []byte) ([]byte, error) {
+	contentEncoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+	if contentEncoding == "" || contentEncoding == contentEncodingIdentity {
+		return body, nil
+	}
+	compressor := messages.GetCompressor(contentEncoding)
+	if compressor == nil {
+		return nil, errors.New(fmt.Sprintf("unsupported content encoding %s", contentEncoding))
+	}
+	return compressor.Decompress(body)
+}
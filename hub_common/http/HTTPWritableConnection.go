@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 	"whub/common/async"
 	base_conn "whub/common/connection"
@@ -21,6 +23,10 @@ type HTTPWritableConnection struct {
 	logger   *logger.SimpleLogger
 	waitLock *async.WaitLock
 	isWhr    bool
+	// contentEncoding is negotiated from the Accept-Encoding header of the request
+	contentEncoding string
+	// stream is set when the response is streamed with chunked transfer encoding
+	stream *httpStreamWriter
 }
 
 func (h *HTTPWritableConnection) Address() string {
@@ -45,6 +51,11 @@ func (h *HTTPWritableConnection) Send(m messages.IMessage) error {
 		h.logger.Println("send to the same HTTP connection more than once")
 		return errors.New("unable to send more than once for HTTP connection")
 	}
+	if h.stream != nil && messages.IsStreamHeaderMessage(m) {
+		// the connection is done when the stream is closed
+		defer m.Dispose()
+		return h.stream.writeHeader(m)
+	}
 	defer h.waitLock.Open()
 	defer m.Dispose()
 	var err error
@@ -69,15 +80,36 @@ func (h *HTTPWritableConnection) writeMessageResponse(m messages.IMessage) (err
 	if m.GetHeader("Content-Type") == "" {
 		h.w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	}
-	h.w.WriteHeader(m.MessageType())
+	// headers set after WriteHeader will not be sent
 	h.writeMessageHeaders(m)
-	_, err = h.w.Write(m.Payload())
+	payload, err := h.encodePayload(m.Payload())
+	if err != nil {
+		return
+	}
+	h.w.WriteHeader(m.MessageType())
+	_, err = h.w.Write(payload)
 	return
 }
 
+// encodePayload compresses the payload by the negotiated content encoding if it's large enough
+func (h *HTTPWritableConnection) encodePayload(payload []byte) ([]byte, error) {
+	h.w.Header().Add("Vary", "Accept-Encoding")
+	if h.contentEncoding == messages.CompressionNone || len(payload) < messages.DefaultCompressionThreshold {
+		return payload, nil
+	}
+	compressed, err := messages.GetCompressor(h.contentEncoding).Compress(payload)
+	if err != nil {
+		return nil, err
+	}
+	h.w.Header().Set("Content-Encoding", h.contentEncoding)
+	return compressed, nil
+}
+
 func (h *HTTPWritableConnection) writeMessageHeaders(m messages.IMessage) {
 	for k, v := range m.Headers() {
-		h.w.Header().Set(k, v)
+		if !messages.IsStreamHeader(k) {
+			h.w.Header().Set(k, v)
+		}
 	}
 }
 
@@ -100,9 +132,51 @@ func (h *HTTPWritableConnection) writeWhrResponse(m messages.IMessage) (err erro
 	return
 }
 
+func (h *HTTPWritableConnection) RequestWithStream(message messages.IMessage) (messages.IMessage, connection.IStreamReader, error) {
+	return nil, nil, errors.New("unable to request stream from HTTP connection")
+}
+
+// OpenStream streams the response body with chunked transfer encoding, flow control is left to the underlying TCP
+// connection
+func (h *HTTPWritableConnection) OpenStream(id string, from string, to string, uri string) connection.IStreamWriter {
+	h.stream = newHTTPStreamWriter(h, id)
+	return h.stream
+}
+
+// Aborted tells if the streamed response is aborted, the response should be interrupted so that the client will not
+// take the partial body as a complete one
+func (h *HTTPWritableConnection) Aborted() bool {
+	return h.stream != nil && h.stream.aborted
+}
+
 func (h *HTTPWritableConnection) OnIncomingMessage(f func(message messages.IMessage)) {
 }
 
+// HTTP connections do not serialize messages, so there is no protocol to negotiate
+
+func (h *HTTPWritableConnection) MessageParser() messages.IMessageParser {
+	return nil
+}
+
+func (h *HTTPWritableConnection) SetMessageParser(parser messages.IMessageParser) {
+}
+
+func (h *HTTPWritableConnection) Protocol() messages.ProtocolSelection {
+	return messages.ProtocolSelection{}
+}
+
+func (h *HTTPWritableConnection) UpdateProtocol(selection messages.ProtocolSelection) error {
+	return errors.New("unable to update protocol for HTTP connection")
+}
+
+func (h *HTTPWritableConnection) AcceptProtocol(response messages.IMessage, selection messages.ProtocolSelection) error {
+	return errors.New("unable to update protocol for HTTP connection")
+}
+
+func (h *HTTPWritableConnection) NegotiateProtocol(from string, to string, offer messages.ProtocolOffer) (messages.ProtocolSelection, error) {
+	return messages.ProtocolSelection{}, errors.New("unable to negotiate protocol for HTTP connection")
+}
+
 func (h *HTTPWritableConnection) OnceMessage(s string, f func(messages.IMessage)) (notification.Disposable, error) {
 	return nil, nil
 }
@@ -131,12 +205,14 @@ func (h *HTTPWritableConnection) String() string {
 	return fmt.Sprintf("{\"type\":\"%s\",\"address\":\"%s\"}", base_conn.TypeString(base_conn.TypeHTTP), h.Address())
 }
 
-func (h *HTTPWritableConnection) Init(w http.ResponseWriter, addr string, logger *logger.SimpleLogger, isWhr bool) {
+func (h *HTTPWritableConnection) Init(w http.ResponseWriter, addr string, logger *logger.SimpleLogger, isWhr bool, acceptEncoding string) {
 	h.w = w
 	h.addr = addr
 	h.logger = logger
 	h.waitLock = async.NewWaitLock()
 	h.isWhr = isWhr
+	h.contentEncoding = NegotiateContentEncoding(acceptEncoding)
+	h.stream = nil
 }
 
 func (h *HTTPWritableConnection) WaitDone() {
@@ -154,3 +230,104 @@ func (h *HTTPWritableConnection) IsLive() bool {
 func NewHTTPWritableConnection() connection.IConnection {
 	return &HTTPWritableConnection{}
 }
+
+type httpStreamWriter struct {
+	conn       *HTTPWritableConnection
+	id         string
+	headerSent chan struct{}
+	closed     bool
+	aborted    bool
+	lock       *sync.Mutex
+}
+
+func newHTTPStreamWriter(conn *HTTPWritableConnection, id string) *httpStreamWriter {
+	return &httpStreamWriter{
+		conn:       conn,
+		id:         id,
+		headerSent: make(chan struct{}),
+		lock:       new(sync.Mutex),
+	}
+}
+
+func (s *httpStreamWriter) withLock(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *httpStreamWriter) writeHeader(m messages.IMessage) (err error) {
+	s.withLock(func() {
+		w := s.conn.w
+		w.Header().Set(messages.MessageHTTPHeaderId, m.Id())
+		w.Header().Set(messages.MessageHTTPHeaderFrom, m.From())
+		w.Header().Set(messages.MessageHTTPHeaderTo, m.To())
+		if m.GetHeader("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		s.conn.writeMessageHeaders(m)
+		status, e := strconv.Atoi(m.GetHeader(messages.MessageStreamHeaderStatus))
+		if e != nil || status < 100 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		s.flush()
+		close(s.headerSent)
+	})
+	return
+}
+
+func (s *httpStreamWriter) flush() {
+	if f, ok := s.conn.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *httpStreamWriter) Id() string {
+	return s.id
+}
+
+func (s *httpStreamWriter) Write(p []byte) (n int, err error) {
+	select {
+	case <-s.headerSent:
+	case <-time.After(connection.DefaultTimeout):
+		return 0, errors.New(fmt.Sprintf("stream %s timeout waiting for stream header to be sent", s.id))
+	}
+	s.withLock(func() {
+		if s.closed {
+			err = connection.ErrStreamClosed
+			return
+		}
+		if n, err = s.conn.w.Write(p); err == nil {
+			s.flush()
+		}
+	})
+	return
+}
+
+func (s *httpStreamWriter) Close() error {
+	return s.close(false)
+}
+
+func (s *httpStreamWriter) CloseWithError(errMsg string) error {
+	s.conn.logger.Printf("stream %s aborted due to %s", s.id, errMsg)
+	return s.close(true)
+}
+
+func (s *httpStreamWriter) close(aborted bool) (err error) {
+	s.withLock(func() {
+		if s.closed {
+			err = connection.ErrStreamClosed
+			return
+		}
+		s.closed = true
+		select {
+		case <-s.headerSent:
+		default:
+			// the response is not streamed, leave it to the regular response
+			return
+		}
+		s.aborted = aborted
+		s.conn.waitLock.Open()
+	})
+	return
+}
@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+	"whub/common/ctimer"
+)
+
+const StoreCleanJobInterval = time.Minute
+
+type IIdempotencyStore interface {
+	// Reserve keeps the record for the key unless the key already has a record, which is returned instead
+	Reserve(key string, record *Record, ttl time.Duration) (existing *Record, err error)
+	Put(key string, record *Record, ttl time.Duration) error
+	Delete(key string) error
+	Close() error
+}
+
+type memoryRecord struct {
+	record *Record
+	expiry time.Time
+}
+
+type MemoryIdempotencyStore struct {
+	records map[string]*memoryRecord
+	lock    *sync.Mutex
+	timer   ctimer.ICTimer
+}
+
+func NewMemoryIdempotencyStore() IIdempotencyStore {
+	store := &MemoryIdempotencyStore{
+		records: make(map[string]*memoryRecord),
+		lock:    new(sync.Mutex),
+	}
+	store.timer = ctimer.New(StoreCleanJobInterval, store.cleanJob)
+	store.timer.Repeat()
+	return store
+}
+
+func (s *MemoryIdempotencyStore) withLock(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *MemoryIdempotencyStore) cleanJob() {
+	now := time.Now()
+	s.withLock(func() {
+		for k, v := range s.records {
+			if now.After(v.expiry) {
+				delete(s.records, k)
+			}
+		}
+	})
+}
+
+func (s *MemoryIdempotencyStore) Reserve(key string, record *Record, ttl time.Duration) (existing *Record, err error) {
+	s.withLock(func() {
+		if r := s.records[key]; r != nil && !time.Now().After(r.expiry) {
+			existing = r.record
+			return
+		}
+		s.records[key] = &memoryRecord{record, time.Now().Add(ttl)}
+	})
+	return
+}
+
+func (s *MemoryIdempotencyStore) Put(key string, record *Record, ttl time.Duration) error {
+	s.withLock(func() {
+		s.records[key] = &memoryRecord{record, time.Now().Add(ttl)}
+	})
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Delete(key string) error {
+	s.withLock(func() {
+		delete(s.records, key)
+	})
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Close() error {
+	s.timer.Cancel()
+	return nil
+}
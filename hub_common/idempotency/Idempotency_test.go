@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/messages"
+)
+
+func newTestRequest(id string) messages.IMessage {
+	return messages.NewMessage(id, "client", "server", "/service/orders/create", messages.MessageTypeServicePostRequest, []byte("order"))
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	tg := test_utils.NewTestGroup("MemoryIdempotencyStore", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Reserve", "only the first reservation of a key should succeed", func() bool {
+			store := NewMemoryIdempotencyStore()
+			defer store.Close()
+			first, err := store.Reserve("a", NewPendingRecord(newTestRequest("1")), time.Minute)
+			if first != nil || err != nil {
+				return false
+			}
+			second, err := store.Reserve("a", NewPendingRecord(newTestRequest("2")), time.Minute)
+			return err == nil && second != nil && second.Pending
+		}),
+		test_utils.NewTestCase("Complete", "completed records should replace pending ones", func() bool {
+			store := NewMemoryIdempotencyStore()
+			defer store.Close()
+			request := newTestRequest("1")
+			store.Reserve("a", NewPendingRecord(request), time.Minute)
+			response := messages.NewMessage("1", "server", "client", request.Uri(), messages.MessageTypeSvcResponseCreated, []byte("created"))
+			response.SetHeader("X-Test", "1")
+			store.Put("a", NewCompletedRecord(request, response), time.Minute)
+			existing, err := store.Reserve("a", NewPendingRecord(request), time.Minute)
+			return err == nil && existing != nil && !existing.Pending && existing.MessageType == messages.MessageTypeSvcResponseCreated &&
+				string(existing.Payload) == "created" && existing.Headers["X-Test"] == "1"
+		}),
+		test_utils.NewTestCase("Expiry", "expired and deleted keys should be reserved again", func() bool {
+			store := NewMemoryIdempotencyStore()
+			defer store.Close()
+			store.Reserve("a", NewPendingRecord(newTestRequest("1")), time.Millisecond)
+			store.Reserve("b", NewPendingRecord(newTestRequest("1")), time.Minute)
+			store.Delete("b")
+			time.Sleep(5 * time.Millisecond)
+			a, errA := store.Reserve("a", NewPendingRecord(newTestRequest("2")), time.Minute)
+			b, errB := store.Reserve("b", NewPendingRecord(newTestRequest("2")), time.Minute)
+			return a == nil && errA == nil && b == nil && errB == nil
+		}),
+	}).Do(t)
+}
+
+func TestRecord(t *testing.T) {
+	tg := test_utils.NewTestGroup("Record", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Replay", "replayed responses should answer the repeated request", func() bool {
+			request := newTestRequest("1")
+			record := NewCompletedRecord(request, messages.NewMessage("1", "server", "client", request.Uri(), messages.MessageTypeSvcResponseOK, []byte("ok")))
+			response := record.Response(newTestRequest("2"), "server")
+			return response.Id() == "2" && response.To() == "client" && response.MessageType() == messages.MessageTypeSvcResponseOK &&
+				string(response.Payload()) == "ok" && response.GetHeader(MessageHeaderIdempotentReplay) == "true"
+		}),
+		test_utils.NewTestCase("Retryable", "only transient failures should be retryable", func() bool {
+			request := newTestRequest("1")
+			return Retryable(nil) &&
+				Retryable(messages.NewErrorResponse(request, "server", messages.MessageTypeSvcGatewayTimeoutError, "timeout")) &&
+				!Retryable(messages.NewErrorResponse(request, "server", messages.MessageTypeSvcBadRequestError, "bad request"))
+		}),
+	}).Do(t)
+}
@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"fmt"
+	"whub/hub_common/messages"
+)
+
+// MessageHeaderIdempotencyKey carries the key of a service request, requests from the same client with the same key
+// are executed once and the completed response is replayed to the repeated requests. Replayed responses carry
+// MessageHeaderIdempotentReplay.
+const (
+	MessageHeaderIdempotencyKey   = "X-Idempotency-Key"
+	MessageHeaderIdempotentReplay = "X-Idempotent-Replay"
+)
+
+// Record is kept for an idempotency key, it's Pending until the request completes with the response
+type Record struct {
+	Uri         string            `json:"uri"`
+	Pending     bool              `json:"pending"`
+	MessageType int               `json:"messageType"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Payload     []byte            `json:"payload"`
+}
+
+// Key scopes the idempotency key to the client so that clients can not replay responses of each other
+func Key(clientId string, key string) string {
+	return fmt.Sprintf("%s/%s", clientId, key)
+}
+
+func NewPendingRecord(request messages.IMessage) *Record {
+	return &Record{
+		Uri:     request.Uri(),
+		Pending: true,
+	}
+}
+
+func NewCompletedRecord(request messages.IMessage, response messages.IMessage) *Record {
+	record := &Record{
+		Uri:         request.Uri(),
+		MessageType: response.MessageType(),
+		Headers:     make(map[string]string),
+		Payload:     response.Payload(),
+	}
+	for k, v := range response.Headers() {
+		record.Headers[k] = v
+	}
+	return record
+}
+
+// Response replays the completed response to the repeated request
+func (r *Record) Response(request messages.IMessage, from string) messages.IMessage {
+	response := messages.NewMessage(request.Id(), from, request.From(), request.Uri(), r.MessageType, r.Payload)
+	for k, v := range r.Headers {
+		response.SetHeader(k, v)
+	}
+	response.SetHeader(MessageHeaderIdempotentReplay, "true")
+	return response
+}
+
+// Retryable tells if the response is a transient failure, keys of such responses are released so that the request
+// can be retried with the same key
+func Retryable(response messages.IMessage) bool {
+	if response == nil {
+		return true
+	}
+	switch response.MessageType() {
+	case messages.MessageTypeSvcInternalError, messages.MessageTypeSvcUnavailableError, messages.MessageTypeSvcGatewayTimeoutError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"whub/common/redis"
+)
+
+const (
+	RedisKeyPrefix = "idempotency-"
+
+	reserveRetries = 3
+)
+
+// RedisIdempotencyStore keeps records in redis so that they survive server restarts and are shared by servers
+type RedisIdempotencyStore struct {
+	redis *redis.RedisClient
+}
+
+func NewRedisIdempotencyStore(serverAddr, passwd string) (IIdempotencyStore, error) {
+	client := redis.NewRedisClient(serverAddr, passwd, 5)
+	if err := client.Ping(); err != nil {
+		return nil, err
+	}
+	return RedisIdempotencyStore{
+		redis: client,
+	}, nil
+}
+
+func (s RedisIdempotencyStore) assembleKey(key string) string {
+	return fmt.Sprintf("%s%s", RedisKeyPrefix, key)
+}
+
+func (s RedisIdempotencyStore) Reserve(key string, record *Record, ttl time.Duration) (*Record, error) {
+	marshalled, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	// the existing record can expire between SetNX and Get, retry the reservation in that case
+	for i := 0; i < reserveRetries; i++ {
+		reserved, err := s.redis.Client().SetNX(s.assembleKey(key), marshalled, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+		value, err := s.redis.Get(s.assembleKey(key))
+		if err != nil {
+			if redisErr, ok := err.(*redis.RedisClientErr); ok && redisErr.Code() == redis.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		existing := new(Record)
+		if err = json.Unmarshal([]byte(value), existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unable to reserve idempotency key %s", key))
+}
+
+func (s RedisIdempotencyStore) Put(key string, record *Record, ttl time.Duration) error {
+	marshalled, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.redis.SetWithExp(s.assembleKey(key), marshalled, ttl)
+}
+
+func (s RedisIdempotencyStore) Delete(key string) error {
+	return s.redis.Delete(s.assembleKey(key))
+}
+
+func (s RedisIdempotencyStore) Close() error {
+	return s.redis.Close()
+}
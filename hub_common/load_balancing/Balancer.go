@@ -0,0 +1,152 @@
+package load_balancing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"whub/hub_common/service"
+)
+
+// Endpoint is a provider connection identified by its address
+type Endpoint struct {
+	Address string
+	Weight  int
+}
+
+// IBalancer orders endpoints for each request, the first endpoint is tried first and the rest are failovers
+type IBalancer interface {
+	Strategy() string
+	Add(endpoint Endpoint)
+	Remove(address string)
+	// Pick returns addresses of all endpoints in the order to try, key is only used by consistent hashing
+	Pick(key string) []string
+	// Begin marks a request to the endpoint as outstanding, the returned func completes it with the result
+	Begin(address string) (done func(err error))
+}
+
+func NewBalancer(strategy string) (IBalancer, error) {
+	switch strategy {
+	case "", service.LoadBalancingRoundRobin:
+		return NewRoundRobinBalancer(), nil
+	case service.LoadBalancingLeastOutstanding:
+		return NewLeastOutstandingBalancer(), nil
+	case service.LoadBalancingWeightedRoundRobin:
+		return NewWeightedRoundRobinBalancer(), nil
+	case service.LoadBalancingEWMA:
+		return NewEWMABalancer(), nil
+	case service.LoadBalancingConsistentHash:
+		return NewConsistentHashBalancer(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown load balancing strategy %s", strategy))
+	}
+}
+
+type endpointStat struct {
+	Endpoint
+	outstanding int
+	// ewma of latencies in nanoseconds, 0 until the first request completes
+	ewma float64
+}
+
+// balancerBase keeps endpoints in the order they are added and tracks outstanding requests and latencies
+type balancerBase struct {
+	strategy  string
+	endpoints []*endpointStat
+	lock      *sync.Mutex
+	// onChange is called with the lock held once endpoints are added or removed
+	onChange func()
+	// onDone is called with the lock held once a request to the endpoint completes
+	onDone func(stat *endpointStat, latency time.Duration, err error)
+}
+
+func newBalancerBase(strategy string) *balancerBase {
+	return &balancerBase{
+		strategy:  strategy,
+		endpoints: make([]*endpointStat, 0),
+		lock:      new(sync.Mutex),
+	}
+}
+
+func (b *balancerBase) withLock(cb func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	cb()
+}
+
+func (b *balancerBase) Strategy() string {
+	return b.strategy
+}
+
+func (b *balancerBase) find(address string) *endpointStat {
+	for _, e := range b.endpoints {
+		if e.Address == address {
+			return e
+		}
+	}
+	return nil
+}
+
+func (b *balancerBase) Add(endpoint Endpoint) {
+	if endpoint.Weight <= 0 {
+		endpoint.Weight = 1
+	}
+	b.withLock(func() {
+		if e := b.find(endpoint.Address); e != nil {
+			e.Weight = endpoint.Weight
+		} else {
+			b.endpoints = append(b.endpoints, &endpointStat{Endpoint: endpoint})
+		}
+		if b.onChange != nil {
+			b.onChange()
+		}
+	})
+}
+
+func (b *balancerBase) Remove(address string) {
+	b.withLock(func() {
+		for i, e := range b.endpoints {
+			if e.Address == address {
+				b.endpoints = append(b.endpoints[:i], b.endpoints[i+1:]...)
+				break
+			}
+		}
+		if b.onChange != nil {
+			b.onChange()
+		}
+	})
+}
+
+func (b *balancerBase) Begin(address string) func(err error) {
+	start := time.Now()
+	b.withLock(func() {
+		if e := b.find(address); e != nil {
+			e.outstanding++
+		}
+	})
+	return func(err error) {
+		b.withLock(func() {
+			// the endpoint may have been removed and added again in between
+			e := b.find(address)
+			if e == nil {
+				return
+			}
+			if e.outstanding > 0 {
+				e.outstanding--
+			}
+			if b.onDone != nil {
+				b.onDone(e, time.Since(start), err)
+			}
+		})
+	}
+}
+
+// rotated returns addresses of endpoints starting from the i-th one
+func (b *balancerBase) rotated(i int) []string {
+	size := len(b.endpoints)
+	addresses := make([]string, size, size)
+	for j := 0; j < size; j++ {
+		addresses[j] = b.endpoints[(i+j)%size].Address
+	}
+	return addresses
+}
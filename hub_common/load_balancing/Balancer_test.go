@@ -0,0 +1,99 @@
+package load_balancing
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/service"
+)
+
+func newTestBalancer(strategy string, endpoints ...Endpoint) IBalancer {
+	b, err := NewBalancer(strategy)
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range endpoints {
+		b.Add(e)
+	}
+	return b
+}
+
+// firstPicks counts how many times each endpoint is picked first in n picks
+func firstPicks(b IBalancer, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[b.Pick("")[0]]++
+	}
+	return counts
+}
+
+func TestBalancers(t *testing.T) {
+	tg := test_utils.NewTestGroup("Balancers", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Round robin", "endpoints should be picked in turn with the rest as failovers", func() bool {
+			b := newTestBalancer("", Endpoint{Address: "a"}, Endpoint{Address: "b"}, Endpoint{Address: "c"})
+			first, second := b.Pick(""), b.Pick("")
+			b.Remove("a")
+			third := b.Pick("")
+			return b.Strategy() == service.LoadBalancingRoundRobin && len(first) == 3 && first[0] == "a" && first[1] == "b" &&
+				second[0] == "b" && len(third) == 2 && len(newTestBalancer("").Pick("")) == 0
+		}),
+		test_utils.NewTestCase("Least outstanding", "endpoints with less outstanding requests should be picked first", func() bool {
+			b := newTestBalancer(service.LoadBalancingLeastOutstanding, Endpoint{Address: "a"}, Endpoint{Address: "b"})
+			doneA := b.Begin("a")
+			b.Begin("a")
+			b.Begin("b")
+			if b.Pick("")[0] != "b" || b.Pick("")[0] != "b" {
+				return false
+			}
+			doneA(nil)
+			doneA(nil)
+			return b.Pick("")[0] == "a"
+		}),
+		test_utils.NewTestCase("Weighted round robin", "endpoints should be picked in proportion to weights", func() bool {
+			b := newTestBalancer(service.LoadBalancingWeightedRoundRobin, Endpoint{Address: "a", Weight: 3}, Endpoint{Address: "b"})
+			counts := firstPicks(b, 8)
+			picks := b.Pick("")
+			return counts["a"] == 6 && counts["b"] == 2 && len(picks) == 2
+		}),
+		test_utils.NewTestCase("EWMA", "endpoints with less latency should be picked first, failures should be penalized", func() bool {
+			b := newTestBalancer(service.LoadBalancingEWMA, Endpoint{Address: "a"}, Endpoint{Address: "b"})
+			slow := b.Begin("a")
+			fast := b.Begin("b")
+			fast(nil)
+			time.Sleep(10 * time.Millisecond)
+			slow(nil)
+			if b.Pick("")[0] != "b" || b.Pick("")[0] != "b" {
+				return false
+			}
+			b.Begin("b")(errors.New("failed"))
+			return b.Pick("")[0] == "a"
+		}),
+		test_utils.NewTestCase("Consistent hash", "keys should stick to endpoints and only keys of removed endpoints should move", func() bool {
+			b := newTestBalancer(service.LoadBalancingConsistentHash, Endpoint{Address: "a"}, Endpoint{Address: "b"}, Endpoint{Address: "c"})
+			before := make(map[string]string)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("session-%d", i)
+				picks := b.Pick(key)
+				if len(picks) != 3 || b.Pick(key)[0] != picks[0] {
+					return false
+				}
+				before[key] = picks[0]
+			}
+			b.Remove("c")
+			for key, address := range before {
+				if picked := b.Pick(key)[0]; address != "c" && picked != address || picked == "c" {
+					return false
+				}
+			}
+			return true
+		}),
+		test_utils.NewTestCase("Unknown strategy", "unknown strategies should be rejected", func() bool {
+			_, err := NewBalancer("random")
+			return err != nil && service.LoadBalancingDescriptor{Strategy: "random"}.Validate() != nil &&
+				service.LoadBalancingDescriptor{Strategy: service.LoadBalancingConsistentHash, HashHeader: "X-Session"}.Validate() == nil
+		}),
+	}).Do(t)
+}
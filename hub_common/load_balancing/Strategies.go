@@ -0,0 +1,208 @@
+package load_balancing
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+	"whub/hub_common/service"
+)
+
+// RoundRobinBalancer picks endpoints in turn
+type RoundRobinBalancer struct {
+	*balancerBase
+	next int
+}
+
+func NewRoundRobinBalancer() IBalancer {
+	return &RoundRobinBalancer{balancerBase: newBalancerBase(service.LoadBalancingRoundRobin)}
+}
+
+func (b *RoundRobinBalancer) Pick(key string) (addresses []string) {
+	b.withLock(func() {
+		if len(b.endpoints) == 0 {
+			return
+		}
+		addresses = b.rotated(b.next % len(b.endpoints))
+		b.next++
+	})
+	return
+}
+
+// LeastOutstandingBalancer picks the endpoint with the least outstanding requests, ties are broken in turn
+type LeastOutstandingBalancer struct {
+	*balancerBase
+	next int
+}
+
+func NewLeastOutstandingBalancer() IBalancer {
+	return &LeastOutstandingBalancer{balancerBase: newBalancerBase(service.LoadBalancingLeastOutstanding)}
+}
+
+func (b *LeastOutstandingBalancer) Pick(key string) (addresses []string) {
+	b.withLock(func() {
+		if len(b.endpoints) == 0 {
+			return
+		}
+		addresses = b.rotated(b.next % len(b.endpoints))
+		b.next++
+		sort.SliceStable(addresses, func(i, j int) bool {
+			return b.find(addresses[i]).outstanding < b.find(addresses[j]).outstanding
+		})
+	})
+	return
+}
+
+// WeightedRoundRobinBalancer picks endpoints in proportion to their weights, picks of an endpoint are spread out
+// (smooth weighted round-robin)
+type WeightedRoundRobinBalancer struct {
+	*balancerBase
+	current map[string]int
+}
+
+func NewWeightedRoundRobinBalancer() IBalancer {
+	b := &WeightedRoundRobinBalancer{
+		balancerBase: newBalancerBase(service.LoadBalancingWeightedRoundRobin),
+		current:      make(map[string]int),
+	}
+	b.onChange = func() {
+		b.current = make(map[string]int)
+	}
+	return b
+}
+
+func (b *WeightedRoundRobinBalancer) Pick(key string) (addresses []string) {
+	b.withLock(func() {
+		if len(b.endpoints) == 0 {
+			return
+		}
+		total := 0
+		var picked *endpointStat
+		for _, e := range b.endpoints {
+			b.current[e.Address] += e.Weight
+			total += e.Weight
+			if picked == nil || b.current[e.Address] > b.current[picked.Address] {
+				picked = e
+			}
+		}
+		b.current[picked.Address] -= total
+		addresses = make([]string, 0, len(b.endpoints))
+		addresses = append(addresses, picked.Address)
+		// failovers in the order of weights
+		rest := make([]*endpointStat, 0, len(b.endpoints)-1)
+		for _, e := range b.endpoints {
+			if e != picked {
+				rest = append(rest, e)
+			}
+		}
+		sort.SliceStable(rest, func(i, j int) bool {
+			return rest[i].Weight > rest[j].Weight
+		})
+		for _, e := range rest {
+			addresses = append(addresses, e.Address)
+		}
+	})
+	return
+}
+
+const (
+	// EWMADecay is the weight of the latest latency in the moving average
+	EWMADecay = 0.3
+	// EWMAFailurePenalty is taken as the latency of failed requests
+	EWMAFailurePenalty = time.Second
+)
+
+// EWMABalancer picks the endpoint with the least expected latency, which is the moving average of latencies scaled by
+// outstanding requests. Endpoints without latencies are tried first.
+type EWMABalancer struct {
+	*balancerBase
+	next int
+}
+
+func NewEWMABalancer() IBalancer {
+	b := &EWMABalancer{balancerBase: newBalancerBase(service.LoadBalancingEWMA)}
+	b.onDone = func(stat *endpointStat, latency time.Duration, err error) {
+		if err != nil && latency < EWMAFailurePenalty {
+			latency = EWMAFailurePenalty
+		}
+		if stat.ewma == 0 {
+			stat.ewma = float64(latency)
+		} else {
+			stat.ewma = stat.ewma*(1-EWMADecay) + float64(latency)*EWMADecay
+		}
+	}
+	return b
+}
+
+func (b *EWMABalancer) Pick(key string) (addresses []string) {
+	b.withLock(func() {
+		if len(b.endpoints) == 0 {
+			return
+		}
+		addresses = b.rotated(b.next % len(b.endpoints))
+		b.next++
+		cost := func(e *endpointStat) float64 {
+			return e.ewma * float64(e.outstanding+1)
+		}
+		sort.SliceStable(addresses, func(i, j int) bool {
+			return cost(b.find(addresses[i])) < cost(b.find(addresses[j]))
+		})
+	})
+	return
+}
+
+// ConsistentHashReplicas is the number of points of an endpoint with weight 1 on the hash ring
+const ConsistentHashReplicas = 64
+
+type ringPoint struct {
+	hash    uint32
+	address string
+}
+
+// ConsistentHashBalancer picks endpoints by the hash of the key on a ring, so that requests with the same key go to the
+// same endpoint as long as it's available, and only keys of an added or removed endpoint move
+type ConsistentHashBalancer struct {
+	*balancerBase
+	ring []ringPoint
+}
+
+func NewConsistentHashBalancer() IBalancer {
+	b := &ConsistentHashBalancer{balancerBase: newBalancerBase(service.LoadBalancingConsistentHash)}
+	b.onChange = b.buildRing
+	return b
+}
+
+func (b *ConsistentHashBalancer) buildRing() {
+	b.ring = make([]ringPoint, 0)
+	for _, e := range b.endpoints {
+		for i := 0; i < ConsistentHashReplicas*e.Weight; i++ {
+			b.ring = append(b.ring, ringPoint{crc32.ChecksumIEEE([]byte(e.Address + "#" + strconv.Itoa(i))), e.Address})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+}
+
+func (b *ConsistentHashBalancer) Pick(key string) (addresses []string) {
+	b.withLock(func() {
+		if len(b.ring) == 0 {
+			return
+		}
+		hash := crc32.ChecksumIEEE([]byte(key))
+		start := sort.Search(len(b.ring), func(i int) bool {
+			return b.ring[i].hash >= hash
+		})
+		// failovers are the following distinct endpoints on the ring
+		seen := make(map[string]bool)
+		addresses = make([]string, 0, len(b.endpoints))
+		for i := 0; i < len(b.ring) && len(addresses) < len(b.endpoints); i++ {
+			point := b.ring[(start+i)%len(b.ring)]
+			if !seen[point.address] {
+				seen[point.address] = true
+				addresses = append(addresses, point.address)
+			}
+		}
+	})
+	return
+}
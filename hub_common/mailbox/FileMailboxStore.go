@@ -0,0 +1,187 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const letterFileExt = ".letters"
+
+// FileMailboxStore keeps letters of each client as JSON lines in dir/<escaped client id>.letters. Letter counts are
+// kept in memory, truncated lines at the end of files(e.g. due to a crash) are dropped.
+type FileMailboxStore struct {
+	dir   string
+	sizes map[string]int
+	lock  *sync.RWMutex
+}
+
+func NewFileMailboxStore(dir string) (IMailboxStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileMailboxStore{
+		dir:   dir,
+		sizes: make(map[string]int),
+		lock:  new(sync.RWMutex),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileMailboxStore) withWrite(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *FileMailboxStore) withRead(cb func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	cb()
+}
+
+func (s *FileMailboxStore) load() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), letterFileExt) {
+			continue
+		}
+		clientId, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), letterFileExt))
+		if err != nil {
+			continue
+		}
+		letters, err := s.read(clientId)
+		if err != nil {
+			return err
+		}
+		if len(letters) > 0 {
+			s.sizes[clientId] = len(letters)
+		}
+	}
+	return nil
+}
+
+func (s *FileMailboxStore) path(clientId string) string {
+	return filepath.Join(s.dir, url.PathEscape(clientId)+letterFileExt)
+}
+
+func (s *FileMailboxStore) read(clientId string) ([]*Letter, error) {
+	data, err := ioutil.ReadFile(s.path(clientId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*Letter, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		letter := new(Letter)
+		if json.Unmarshal(scanner.Bytes(), letter) != nil {
+			break
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// write replaces the letters of the client, the file is removed if there is no letter
+func (s *FileMailboxStore) write(clientId string, letters []*Letter) error {
+	if len(letters) == 0 {
+		delete(s.sizes, clientId)
+		return os.Remove(s.path(clientId))
+	}
+	var buffer bytes.Buffer
+	for _, letter := range letters {
+		marshalled, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		buffer.Write(marshalled)
+		buffer.WriteByte('\n')
+	}
+	tmp := s.path(clientId) + ".tmp"
+	if err := ioutil.WriteFile(tmp, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(clientId)); err != nil {
+		return err
+	}
+	s.sizes[clientId] = len(letters)
+	return nil
+}
+
+func (s *FileMailboxStore) Put(clientId string, letter *Letter) (err error) {
+	marshalled, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	s.withWrite(func() {
+		var file *os.File
+		file, err = os.OpenFile(s.path(clientId), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		if _, err = file.Write(append(marshalled, '\n')); err == nil {
+			s.sizes[clientId]++
+		}
+	})
+	return
+}
+
+func (s *FileMailboxStore) Take(clientId string) (letters []*Letter, err error) {
+	s.withWrite(func() {
+		if s.sizes[clientId] == 0 {
+			return
+		}
+		if letters, err = s.read(clientId); err != nil {
+			return
+		}
+		err = s.write(clientId, nil)
+	})
+	return
+}
+
+func (s *FileMailboxStore) Size(clientId string) (size int, err error) {
+	s.withRead(func() {
+		size = s.sizes[clientId]
+	})
+	return
+}
+
+func (s *FileMailboxStore) Purge(now time.Time) (err error) {
+	s.withWrite(func() {
+		for clientId := range s.sizes {
+			letters, e := s.read(clientId)
+			if e != nil {
+				err = e
+				continue
+			}
+			if kept := unexpired(letters, now); len(kept) < len(letters) {
+				if e = s.write(clientId, kept); e != nil {
+					err = e
+				}
+			}
+		}
+	})
+	return
+}
+
+func (s *FileMailboxStore) Close() error {
+	return nil
+}
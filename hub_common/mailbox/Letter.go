@@ -0,0 +1,49 @@
+package mailbox
+
+import (
+	"time"
+	"whub/hub_common/messages"
+)
+
+// Letter is a message kept in the mailbox of an offline client
+type Letter struct {
+	Id      string            `json:"id"`
+	From    string            `json:"from"`
+	Uri     string            `json:"uri"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload []byte            `json:"payload"`
+	CTime   time.Time         `json:"cTime"`
+	// Expiry is when the letter is dropped, zero time means never
+	Expiry time.Time `json:"expiry"`
+}
+
+func NewLetter(message messages.IMessage, ttl time.Duration) *Letter {
+	letter := &Letter{
+		Id:      message.Id(),
+		From:    message.From(),
+		Uri:     message.Uri(),
+		Headers: make(map[string]string),
+		Payload: message.Payload(),
+		CTime:   time.Now(),
+	}
+	for k, v := range message.Headers() {
+		letter.Headers[k] = v
+	}
+	if ttl > 0 {
+		letter.Expiry = letter.CTime.Add(ttl)
+	}
+	return letter
+}
+
+func (l *Letter) Expired(now time.Time) bool {
+	return !l.Expiry.IsZero() && now.After(l.Expiry)
+}
+
+// Message restores the letter as a client notification to the client
+func (l *Letter) Message(to string) messages.IMessage {
+	message := messages.NewMessage(l.Id, l.From, to, l.Uri, messages.MessageTypeClientNotification, l.Payload)
+	for k, v := range l.Headers {
+		message.SetHeader(k, v)
+	}
+	return message
+}
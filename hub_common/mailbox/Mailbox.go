@@ -0,0 +1,148 @@
+package mailbox
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+	"whub/hub_common/messages"
+)
+
+const (
+	// DefaultPurgeInterval is how often expired letters are dropped
+	DefaultPurgeInterval = time.Minute
+
+	clientLockCount = 64
+)
+
+// Limit bounds the mailbox of a client. MaxDepth is the max number of letters kept, a mailbox with non-positive
+// MaxDepth does not keep any letter. Letters older than MaxAge are dropped, zero MaxAge means no age limit.
+type Limit struct {
+	MaxDepth int
+	MaxAge   time.Duration
+}
+
+var DefaultLimit = Limit{
+	MaxDepth: 256,
+	MaxAge:   time.Hour * 24,
+}
+
+// SendFunc sends the message to the client right away, it fails if the client is not online
+type SendFunc func(message messages.IMessage) error
+
+// Mailbox keeps messages to offline clients and flushes them in order once the clients are back. Messages to a client
+// with pending letters are kept after the letters instead of being sent right away, so that the client receives
+// messages in the order they are sent.
+type Mailbox struct {
+	store IMailboxStore
+	// operations of the same client are serialized by the lock the client id is hashed to
+	clientLocks [clientLockCount]sync.Mutex
+	stop        chan bool
+}
+
+func NewMailbox(store IMailboxStore, purgeInterval time.Duration) *Mailbox {
+	if purgeInterval <= 0 {
+		purgeInterval = DefaultPurgeInterval
+	}
+	m := &Mailbox{
+		store: store,
+		stop:  make(chan bool),
+	}
+	go m.purgeLoop(purgeInterval)
+	return m
+}
+
+func (m *Mailbox) purgeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.store.Purge(time.Now())
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *Mailbox) withClientLock(clientId string, cb func()) {
+	h := fnv.New32a()
+	h.Write([]byte(clientId))
+	lock := &m.clientLocks[h.Sum32()%clientLockCount]
+	lock.Lock()
+	defer lock.Unlock()
+	cb()
+}
+
+func (m *Mailbox) post(clientId string, message messages.IMessage, limit Limit) error {
+	if limit.MaxDepth <= 0 {
+		return errors.New(fmt.Sprintf("mailbox of client %s is disabled", clientId))
+	}
+	size, err := m.store.Size(clientId)
+	if err != nil {
+		return err
+	}
+	if size >= limit.MaxDepth {
+		return errors.New(fmt.Sprintf("mailbox of client %s is full", clientId))
+	}
+	return m.store.Put(clientId, NewLetter(message, limit.MaxAge))
+}
+
+// Send sends the message by send unless the client has pending letters or send fails, in which case the message is
+// kept in the mailbox of the client within the limit. posted tells if the message is kept.
+func (m *Mailbox) Send(clientId string, message messages.IMessage, limit Limit, send SendFunc) (posted bool, err error) {
+	m.withClientLock(clientId, func() {
+		var size int
+		if size, err = m.store.Size(clientId); err != nil {
+			return
+		}
+		if size == 0 {
+			if err = send(message); err == nil {
+				return
+			}
+		}
+		sendErr := err
+		if err = m.post(clientId, message, limit); err != nil {
+			if sendErr != nil {
+				err = errors.New(fmt.Sprintf("%s and %s", sendErr.Error(), err.Error()))
+			}
+			return
+		}
+		posted = true
+	})
+	return
+}
+
+// Flush sends pending letters of the client in order, expired letters are dropped. Once a letter fails to be sent,
+// it and the letters after it are kept for the next flush.
+func (m *Mailbox) Flush(clientId string, send SendFunc) (sent int, err error) {
+	m.withClientLock(clientId, func() {
+		var letters []*Letter
+		if letters, err = m.store.Take(clientId); err != nil {
+			return
+		}
+		letters = unexpired(letters, time.Now())
+		for i, letter := range letters {
+			if err = send(letter.Message(clientId)); err != nil {
+				// no letter can be put in between since the client is locked
+				for _, l := range letters[i:] {
+					m.store.Put(clientId, l)
+				}
+				return
+			}
+			sent++
+		}
+	})
+	return
+}
+
+// Size is the number of pending letters of the client
+func (m *Mailbox) Size(clientId string) (int, error) {
+	return m.store.Size(clientId)
+}
+
+func (m *Mailbox) Close() error {
+	close(m.stop)
+	return m.store.Close()
+}
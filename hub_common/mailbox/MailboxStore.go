@@ -0,0 +1,93 @@
+package mailbox
+
+import (
+	"sync"
+	"time"
+)
+
+// IMailboxStore keeps letters of each client in the order they are put
+type IMailboxStore interface {
+	Put(clientId string, letter *Letter) error
+	// Take removes and returns all letters of the client
+	Take(clientId string) ([]*Letter, error)
+	// Size is the number of letters kept for the client, including expired ones that are not purged yet
+	Size(clientId string) (int, error)
+	// Purge drops expired letters of all clients
+	Purge(now time.Time) error
+	Close() error
+}
+
+// MemoryMailboxStore keeps letters in memory, letters are lost once the server stops
+type MemoryMailboxStore struct {
+	letters map[string][]*Letter
+	lock    *sync.RWMutex
+}
+
+func NewMemoryMailboxStore() IMailboxStore {
+	return &MemoryMailboxStore{
+		letters: make(map[string][]*Letter),
+		lock:    new(sync.RWMutex),
+	}
+}
+
+func (s *MemoryMailboxStore) withWrite(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *MemoryMailboxStore) withRead(cb func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	cb()
+}
+
+func (s *MemoryMailboxStore) Put(clientId string, letter *Letter) error {
+	s.withWrite(func() {
+		s.letters[clientId] = append(s.letters[clientId], letter)
+	})
+	return nil
+}
+
+func (s *MemoryMailboxStore) Take(clientId string) (letters []*Letter, err error) {
+	s.withWrite(func() {
+		letters = s.letters[clientId]
+		delete(s.letters, clientId)
+	})
+	return
+}
+
+func (s *MemoryMailboxStore) Size(clientId string) (size int, err error) {
+	s.withRead(func() {
+		size = len(s.letters[clientId])
+	})
+	return
+}
+
+func (s *MemoryMailboxStore) Purge(now time.Time) error {
+	s.withWrite(func() {
+		for clientId, letters := range s.letters {
+			kept := unexpired(letters, now)
+			if len(kept) == 0 {
+				delete(s.letters, clientId)
+			} else {
+				s.letters[clientId] = kept
+			}
+		}
+	})
+	return nil
+}
+
+func (s *MemoryMailboxStore) Close() error {
+	return nil
+}
+
+func unexpired(letters []*Letter, now time.Time) []*Letter {
+	kept := make([]*Letter, 0, len(letters))
+	for _, letter := range letters {
+		if !letter.Expired(now) {
+			kept = append(kept, letter)
+		}
+	}
+	return kept
+}
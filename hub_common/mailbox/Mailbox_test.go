@@ -0,0 +1,151 @@
+package mailbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/messages"
+)
+
+func newTestMessage(payload string) messages.IMessage {
+	m := messages.NewMessage(payload, "sender", "receiver", "/message/send", messages.MessageTypeServicePostRequest, []byte(payload))
+	m.SetHeader("X-Test", payload)
+	return m
+}
+
+var errOffline = errors.New("offline")
+
+func offline(message messages.IMessage) error {
+	return errOffline
+}
+
+// collector records payloads of sent messages
+type collector struct {
+	payloads []string
+}
+
+func (c *collector) send(message messages.IMessage) error {
+	c.payloads = append(c.payloads, string(message.Payload()))
+	return nil
+}
+
+func (c *collector) equals(payloads ...string) bool {
+	if len(c.payloads) != len(payloads) {
+		return false
+	}
+	for i := range payloads {
+		if c.payloads[i] != payloads[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMailbox(t *testing.T) {
+	tg := test_utils.NewTestGroup("Mailbox", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Online", "messages to online clients should not be kept", func() bool {
+			m := NewMailbox(NewMemoryMailboxStore(), 0)
+			defer m.Close()
+			c := &collector{}
+			posted, err := m.Send("a", newTestMessage("1"), DefaultLimit, c.send)
+			size, _ := m.Size("a")
+			return !posted && err == nil && size == 0 && c.equals("1")
+		}),
+		test_utils.NewTestCase("Order", "letters should be flushed in order, messages sent while letters are pending should follow them", func() bool {
+			m := NewMailbox(NewMemoryMailboxStore(), 0)
+			defer m.Close()
+			m.Send("a", newTestMessage("1"), DefaultLimit, offline)
+			m.Send("a", newTestMessage("2"), DefaultLimit, offline)
+			c := &collector{}
+			if posted, err := m.Send("a", newTestMessage("3"), DefaultLimit, c.send); !posted || err != nil || len(c.payloads) > 0 {
+				return false
+			}
+			var received messages.IMessage
+			sent, err := m.Flush("a", func(message messages.IMessage) error {
+				received = message
+				return c.send(message)
+			})
+			return sent == 3 && err == nil && c.equals("1", "2", "3") && received.To() == "a" &&
+				received.MessageType() == messages.MessageTypeClientNotification && received.GetHeader("X-Test") == "3"
+		}),
+		test_utils.NewTestCase("Depth", "messages should be rejected once the mailbox is full or disabled", func() bool {
+			m := NewMailbox(NewMemoryMailboxStore(), 0)
+			defer m.Close()
+			limit := Limit{MaxDepth: 2}
+			for i := 0; i < 2; i++ {
+				if posted, _ := m.Send("a", newTestMessage("1"), limit, offline); !posted {
+					return false
+				}
+			}
+			posted, err := m.Send("a", newTestMessage("3"), limit, offline)
+			disabledPosted, disabledErr := m.Send("b", newTestMessage("1"), Limit{}, offline)
+			return !posted && err != nil && !disabledPosted && disabledErr != nil
+		}),
+		test_utils.NewTestCase("Age", "expired letters should not be flushed", func() bool {
+			m := NewMailbox(NewMemoryMailboxStore(), 0)
+			defer m.Close()
+			m.Send("a", newTestMessage("1"), Limit{MaxDepth: 8, MaxAge: time.Millisecond}, offline)
+			m.Send("a", newTestMessage("2"), Limit{MaxDepth: 8}, offline)
+			time.Sleep(5 * time.Millisecond)
+			c := &collector{}
+			sent, err := m.Flush("a", c.send)
+			return sent == 1 && err == nil && c.equals("2")
+		}),
+		test_utils.NewTestCase("Flush failure", "letters that fail to be sent should be kept in order", func() bool {
+			m := NewMailbox(NewMemoryMailboxStore(), 0)
+			defer m.Close()
+			for _, p := range []string{"1", "2", "3"} {
+				m.Send("a", newTestMessage(p), DefaultLimit, offline)
+			}
+			c := &collector{}
+			sent, err := m.Flush("a", func(message messages.IMessage) error {
+				if string(message.Payload()) == "2" {
+					return errOffline
+				}
+				return c.send(message)
+			})
+			if sent != 1 || err == nil {
+				return false
+			}
+			sent, err = m.Flush("a", c.send)
+			return sent == 2 && err == nil && c.equals("1", "2", "3")
+		}),
+	}).Do(t)
+}
+
+func TestFileMailboxStore(t *testing.T) {
+	tg := test_utils.NewTestGroup("FileMailboxStore", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Reopen", "letters should survive reopening the store", func() bool {
+			dir, err := ioutil.TempDir("", "mailbox")
+			if err != nil {
+				return false
+			}
+			defer os.RemoveAll(dir)
+			store, err := NewFileMailboxStore(dir)
+			if err != nil {
+				return false
+			}
+			store.Put("a/b", NewLetter(newTestMessage("1"), 0))
+			store.Put("a/b", NewLetter(newTestMessage("2"), 0))
+			store.Put("c", NewLetter(newTestMessage("3"), time.Millisecond))
+			time.Sleep(5 * time.Millisecond)
+			if store.Purge(time.Now()) != nil {
+				return false
+			}
+			store, err = NewFileMailboxStore(dir)
+			if err != nil {
+				return false
+			}
+			sizeOfC, _ := store.Size("c")
+			letters, err := store.Take("a/b")
+			size, _ := store.Size("a/b")
+			return err == nil && sizeOfC == 0 && size == 0 && len(letters) == 2 && string(letters[0].Payload) == "1" &&
+				letters[1].Headers["X-Test"] == "2"
+		}),
+	}).Do(t)
+}
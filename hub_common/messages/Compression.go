@@ -0,0 +1,210 @@
+package messages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Compression names, also used as HTTP content codings by the HTTP bridge
+const (
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+// Compression headers. MessageHeaderCompression asks the connection to compress the message with the given
+// compression(or CompressionNone to not compress it) regardless of the negotiated one, MessageHeaderPayloadEncoding
+// tells the compression that is applied to the payload on the wire.
+const (
+	MessageHeaderCompression     = "X-Compression"
+	MessageHeaderPayloadEncoding = "X-Payload-Encoding"
+)
+
+// DefaultCompressionThreshold is the min payload size to compress, smaller payloads hardly benefit from compression
+const DefaultCompressionThreshold = 1024
+
+// MaxDecompressedSize is the max size of a decompressed payload, which is the max frame size of socket connections, so
+// that a small compressed payload can not blow up in memory
+const MaxDecompressedSize = 16 * 1024 * 1024
+
+var ErrDecompressedTooLarge = errors.New(fmt.Sprintf("decompressed payload exceeds %d bytes", MaxDecompressedSize))
+
+// readDecompressed reads the decompressed payload up to MaxDecompressedSize
+func readDecompressed(reader io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return data, nil
+}
+
+type ICompressor interface {
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails with ErrDecompressedTooLarge if the payload decompresses to more than MaxDecompressedSize
+	Decompress(data []byte) ([]byte, error)
+}
+
+type GzipCompressor struct{}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readDecompressed(reader)
+}
+
+// DeflateCompressor uses the zlib format, which is what deflate means as an HTTP content coding
+type DeflateCompressor struct{}
+
+func (c DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c DeflateCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readDecompressed(reader)
+}
+
+var compressorRegistry *messageCompressorRegistry
+
+func init() {
+	compressorRegistry = &messageCompressorRegistry{
+		compressors: make(map[string]ICompressor),
+		lock:        new(sync.RWMutex),
+	}
+	// none goes first so that compression is only used when peers ask for it
+	compressorRegistry.names = []string{CompressionNone}
+	compressorRegistry.register(CompressionGzip, GzipCompressor{})
+	compressorRegistry.register(CompressionDeflate, DeflateCompressor{})
+}
+
+type messageCompressorRegistry struct {
+	compressors map[string]ICompressor
+	// names in registration order, earlier ones are preferred
+	names []string
+	lock  *sync.RWMutex
+}
+
+func (r *messageCompressorRegistry) register(name string, compressor ICompressor) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if name == CompressionNone || r.compressors[name] != nil {
+		return errors.New(fmt.Sprintf("compressor %s has already been registered", name))
+	}
+	r.compressors[name] = compressor
+	r.names = append(r.names, name)
+	return nil
+}
+
+func (r *messageCompressorRegistry) get(name string) ICompressor {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.compressors[name]
+}
+
+func (r *messageCompressorRegistry) getNames() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	return names
+}
+
+// RegisterCompressor makes a compressor available for negotiation and per message selection under the given name
+func RegisterCompressor(name string, compressor ICompressor) error {
+	return compressorRegistry.register(name, compressor)
+}
+
+// GetCompressor returns the compressor by name, nil for CompressionNone or unknown compressions
+func GetCompressor(name string) ICompressor {
+	return compressorRegistry.get(name)
+}
+
+// CompressionNames returns supported compressions in preference order
+func CompressionNames() []string {
+	return compressorRegistry.getNames()
+}
+
+func isCompressionSupported(compression string) bool {
+	return compression == CompressionNone || GetCompressor(compression) != nil
+}
+
+// CompressMessage compresses the payload with the compression requested by the message header or the given one if
+// the payload is no smaller than threshold
+func CompressMessage(message IMessage, compression string, threshold int) error {
+	if requested := message.GetHeader(MessageHeaderCompression); requested != "" {
+		compression = requested
+	}
+	if compression == "" || compression == CompressionNone || len(message.Payload()) < threshold || message.GetHeader(MessageHeaderPayloadEncoding) != "" {
+		return nil
+	}
+	compressor := GetCompressor(compression)
+	if compressor == nil {
+		return errors.New(fmt.Sprintf("unsupported compression %s", compression))
+	}
+	compressed, err := compressor.Compress(message.Payload())
+	if err != nil {
+		return err
+	}
+	if len(compressed) >= len(message.Payload()) {
+		// incompressible payload
+		return nil
+	}
+	message.SetPayload(compressed)
+	message.SetHeader(MessageHeaderPayloadEncoding, compression)
+	return nil
+}
+
+// DecompressMessage restores the payload compressed by CompressMessage
+func DecompressMessage(message IMessage) error {
+	compression := message.GetHeader(MessageHeaderPayloadEncoding)
+	if compression == "" {
+		return nil
+	}
+	compressor := GetCompressor(compression)
+	if compressor == nil {
+		return errors.New(fmt.Sprintf("unsupported payload encoding %s", compression))
+	}
+	payload, err := compressor.Decompress(message.Payload())
+	if err != nil {
+		return err
+	}
+	message.SetPayload(payload)
+	delete(message.Headers(), MessageHeaderPayloadEncoding)
+	return nil
+}
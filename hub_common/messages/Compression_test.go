@@ -0,0 +1,70 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"whub/common/test_utils"
+)
+
+func TestCompressMessage(t *testing.T) {
+	payload := ([]byte)(strings.Repeat("{\"key\":\"value\"},", 256))
+	tg := test_utils.NewTestGroup("CompressMessage", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Round trip", "all registered compressions should restore the payload", func() bool {
+			for _, compression := range CompressionNames() {
+				m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload)
+				if err := CompressMessage(m, compression, DefaultCompressionThreshold); err != nil {
+					t.Log("compression failed due to ", err)
+					return false
+				}
+				if compression != CompressionNone && (m.GetHeader(MessageHeaderPayloadEncoding) != compression || len(m.Payload()) >= len(payload)) {
+					t.Log("payload is not compressed by ", compression)
+					return false
+				}
+				if err := DecompressMessage(m); err != nil || string(m.Payload()) != string(payload) || m.GetHeader(MessageHeaderPayloadEncoding) != "" {
+					t.Log("decompression failed by ", compression)
+					return false
+				}
+			}
+			return true
+		}),
+		test_utils.NewTestCase("Threshold", "small payloads should not be compressed", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload[:DefaultCompressionThreshold-1])
+			return CompressMessage(m, CompressionGzip, DefaultCompressionThreshold) == nil && m.GetHeader(MessageHeaderPayloadEncoding) == ""
+		}),
+		test_utils.NewTestCase("Header", "compression requested by header should override the negotiated one", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload)
+			m.SetHeader(MessageHeaderCompression, CompressionDeflate)
+			if CompressMessage(m, CompressionGzip, DefaultCompressionThreshold) != nil || m.GetHeader(MessageHeaderPayloadEncoding) != CompressionDeflate {
+				return false
+			}
+			m = NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload)
+			m.SetHeader(MessageHeaderCompression, CompressionNone)
+			return CompressMessage(m, CompressionGzip, DefaultCompressionThreshold) == nil && m.GetHeader(MessageHeaderPayloadEncoding) == ""
+		}),
+		test_utils.NewTestCase("Bomb", "payloads that decompress to more than MaxDecompressedSize should be rejected", func() bool {
+			huge := make([]byte, MaxDecompressedSize+1)
+			for _, compression := range []string{CompressionGzip, CompressionDeflate} {
+				compressor := GetCompressor(compression)
+				compressed, err := compressor.Compress(huge)
+				if err != nil {
+					return false
+				}
+				if _, err = compressor.Decompress(compressed); err != ErrDecompressedTooLarge {
+					t.Log("unexpected error of ", compression, err)
+					return false
+				}
+				exact, _ := compressor.Compress(huge[:MaxDecompressedSize])
+				if restored, err := compressor.Decompress(exact); err != nil || len(restored) != MaxDecompressedSize {
+					return false
+				}
+			}
+			return true
+		}),
+		test_utils.NewTestCase("Unsupported", "", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload)
+			m.SetHeader(MessageHeaderPayloadEncoding, "unknown")
+			return CompressMessage(NewMessage("1", "a", "b", "/x", MessageTypeJSON, payload), "unknown", 0) != nil && DecompressMessage(m) != nil
+		}),
+	}).Do(t)
+}
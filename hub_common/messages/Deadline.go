@@ -0,0 +1,66 @@
+package messages
+
+import (
+	"strconv"
+	"time"
+)
+
+// MessageHeaderTimeout carries the remaining time budget of a message in milliseconds. Deadlines are not sent as is
+// since clocks of peers are not in sync, instead the remaining budget is sent and turned into a local deadline when
+// the message is received. HTTP clients set the same header to bound their requests.
+const MessageHeaderTimeout = "X-Request-Timeout"
+
+// EncodeDeadline puts the remaining budget of the message deadline into the timeout header before it's sent
+func EncodeDeadline(message IMessage) {
+	deadline := message.Deadline()
+	if deadline.IsZero() {
+		return
+	}
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 0 {
+		// zero budget lets the receiver drop it rather than treating it as no deadline
+		remaining = 0
+	}
+	message.SetHeader(MessageHeaderTimeout, strconv.FormatInt(remaining, 10))
+}
+
+// DecodeDeadline turns the timeout header of a received message into its local deadline
+func DecodeDeadline(message IMessage) {
+	timeout := message.GetHeader(MessageHeaderTimeout)
+	if timeout == "" {
+		return
+	}
+	delete(message.Headers(), MessageHeaderTimeout)
+	if deadline, ok := DeadlineFromTimeout(timeout); ok {
+		message.SetDeadline(deadline)
+	}
+}
+
+// DeadlineFromTimeout parses a timeout in milliseconds into a deadline from now, a zero timeout gives a deadline that
+// has already passed
+func DeadlineFromTimeout(timeout string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(timeout, 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(ms) * time.Millisecond), true
+}
+
+// IsDeadlineExceeded tells if the message has a deadline that has passed
+func IsDeadlineExceeded(message IMessage) bool {
+	deadline := message.Deadline()
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// Budget returns the timeout to wait for the response of the message, which is the remaining time before the message
+// deadline if it's earlier than timeout
+func Budget(message IMessage, timeout time.Duration) time.Duration {
+	deadline := message.Deadline()
+	if deadline.IsZero() {
+		return timeout
+	}
+	if remaining := time.Until(deadline); remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
@@ -0,0 +1,47 @@
+package messages
+
+import (
+	"testing"
+	"time"
+	"whub/common/test_utils"
+)
+
+func TestDeadline(t *testing.T) {
+	tg := test_utils.NewTestGroup("Deadline", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Round trip", "remaining budget should be restored as the deadline", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			m.SetDeadline(time.Now().Add(time.Second))
+			EncodeDeadline(m)
+			received := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			received.SetHeader(MessageHeaderTimeout, m.GetHeader(MessageHeaderTimeout))
+			DecodeDeadline(received)
+			remaining := time.Until(received.Deadline())
+			return received.GetHeader(MessageHeaderTimeout) == "" && remaining > 900*time.Millisecond && remaining <= time.Second
+		}),
+		test_utils.NewTestCase("No deadline", "", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			EncodeDeadline(m)
+			return m.GetHeader(MessageHeaderTimeout) == "" && !IsDeadlineExceeded(m) && Budget(m, time.Second) == time.Second
+		}),
+		test_utils.NewTestCase("Exceeded", "exceeded deadlines should stay exceeded on the receiver side", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			m.SetDeadline(time.Now().Add(-time.Millisecond))
+			EncodeDeadline(m)
+			received := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			received.SetHeader(MessageHeaderTimeout, m.GetHeader(MessageHeaderTimeout))
+			DecodeDeadline(received)
+			return IsDeadlineExceeded(m) && Budget(m, time.Second) <= 0 && IsDeadlineExceeded(received)
+		}),
+		test_utils.NewTestCase("Invalid timeout", "", func() bool {
+			_, ok := DeadlineFromTimeout("abc")
+			_, okNegative := DeadlineFromTimeout("-1")
+			return !ok && !okNegative
+		}),
+		test_utils.NewTestCase("Copy", "copies should carry the deadline", func() bool {
+			m := NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil)
+			m.SetDeadline(time.Now().Add(time.Second))
+			return m.Copy().Deadline().Equal(m.Deadline()) && NewMessage("1", "a", "b", "/x", MessageTypeJSON, nil).Deadline().IsZero()
+		}),
+	}).Do(t)
+}
@@ -0,0 +1,141 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JSON payload encodings
+const (
+	JSONPayloadEncodingBase64 = "base64" // payload is a base64 encoded string, lossless for any binary payload
+	JSONPayloadEncodingRaw    = "raw"    // payload is embedded as a json value if it is kept as is, o/w as a json string
+)
+
+type jsonMessage struct {
+	Id              string            `json:"id"`
+	From            string            `json:"from,omitempty"`
+	To              string            `json:"to,omitempty"`
+	Uri             string            `json:"uri,omitempty"`
+	MessageType     int               `json:"messageType"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	PayloadEncoding string            `json:"payloadEncoding,omitempty"`
+	Payload         json.RawMessage   `json:"payload,omitempty"`
+}
+
+// JSONMessageParser implements MessageProtocolSimple. It is meant for clients that can not deal with flatbuffers
+// (browsers, scripts, debugging tools).
+type JSONMessageParser struct {
+	payloadEncoding string
+}
+
+func NewJSONMessageParser(payloadEncoding string) (*JSONMessageParser, error) {
+	if payloadEncoding != JSONPayloadEncodingBase64 && payloadEncoding != JSONPayloadEncodingRaw {
+		return nil, errors.New(fmt.Sprintf("unsupported json payload encoding %s", payloadEncoding))
+	}
+	return &JSONMessageParser{payloadEncoding}, nil
+}
+
+func NewBase64JSONMessageParser() *JSONMessageParser {
+	return &JSONMessageParser{JSONPayloadEncodingBase64}
+}
+
+func NewRawJSONMessageParser() *JSONMessageParser {
+	return &JSONMessageParser{JSONPayloadEncodingRaw}
+}
+
+func (p *JSONMessageParser) PayloadEncoding() string {
+	return p.payloadEncoding
+}
+
+func (p *JSONMessageParser) Serialize(message IMessage) ([]byte, error) {
+	payload, err := p.encodePayload(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMessage{
+		Id:              message.Id(),
+		From:            message.From(),
+		To:              message.To(),
+		Uri:             message.Uri(),
+		MessageType:     message.MessageType(),
+		Headers:         message.Headers(),
+		PayloadEncoding: p.payloadEncoding,
+		Payload:         payload,
+	})
+}
+
+func (p *JSONMessageParser) encodePayload(payload []byte) (json.RawMessage, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	if p.payloadEncoding == JSONPayloadEncodingRaw {
+		if isEmbeddableJSON(payload) {
+			return payload, nil
+		}
+		return json.Marshal((string)(payload))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(payload))
+}
+
+// isEmbeddableJSON tells if payload is kept byte for byte once embedded as a json value. json strings are always
+// quoted so that the deserializer can tell them apart from plain text payloads, null is read as no payload, and
+// json.Marshal compacts embedded values and escapes <, >, & and line separators in them.
+func isEmbeddableJSON(payload []byte) bool {
+	if payload[0] == '"' || bytes.Equal(payload, []byte("null")) || bytes.ContainsAny(payload, "<>&\u2028\u2029") {
+		return false
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, payload); err != nil {
+		return false
+	}
+	return bytes.Equal(compacted.Bytes(), payload)
+}
+
+func (p *JSONMessageParser) Deserialize(buffer []byte) (IMessage, error) {
+	if len(buffer) < 1 {
+		return nil, errors.New("invalid buffer format")
+	}
+	var m jsonMessage
+	if err := json.Unmarshal(buffer, &m); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse the message due to %s", err.Error()))
+	}
+	payload, err := decodeJSONPayload(m.PayloadEncoding, m.Payload)
+	if err != nil {
+		return nil, err
+	}
+	message := NewMessage(m.Id, m.From, m.To, m.Uri, m.MessageType, payload)
+	for k, v := range m.Headers {
+		message.SetHeader(k, v)
+	}
+	return message, nil
+}
+
+// decodeJSONPayload decodes the payload by the encoding the sender declared, so one parser is able to read
+// messages from peers using either encoding
+func decodeJSONPayload(encoding string, raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	switch encoding {
+	case JSONPayloadEncodingBase64:
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, errors.New("base64 payload should be a json string")
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	case JSONPayloadEncodingRaw, "":
+		if raw[0] == '"' {
+			var str string
+			if err := json.Unmarshal(raw, &str); err != nil {
+				return nil, err
+			}
+			return ([]byte)(str), nil
+		}
+		return raw, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported json payload encoding %s", encoding))
+	}
+}
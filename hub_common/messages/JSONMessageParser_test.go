@@ -0,0 +1,87 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"whub/common/test_utils"
+)
+
+func TestJSONMessageParser(t *testing.T) {
+	base64Parser := NewBase64JSONMessageParser()
+	rawParser := NewRawJSONMessageParser()
+	roundTrip := func(p IMessageParser, m0 IMessage) bool {
+		serialized, err := p.Serialize(m0)
+		if err != nil {
+			t.Log("Serialization failed due to ", err)
+			return false
+		}
+		t.Log(string(serialized))
+		m, err := p.Deserialize(serialized)
+		if err != nil {
+			t.Log("Deserialization failed due to ", err)
+			return false
+		}
+		return m.Equals(m0)
+	}
+	tg := test_utils.NewTestGroup("JSONMessageParser", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Base64 round trip", "", func() bool {
+			m0 := NewMessage("1", "a", "t", "x/y/z", MessageTypeACK, []byte{0, 1, 2, 255, 254})
+			m0.SetHeader("Content-Type", "application/octet-stream")
+			m0.SetHeader(MessageHTTPHeaderFrom, "a")
+			return roundTrip(base64Parser, m0)
+		}),
+		test_utils.NewTestCase("Raw round trip with json payload", "", func() bool {
+			m0 := NewMessage("2", "a", "t", "x/y/z", MessageTypeServicePostRequest, ([]byte)(`{"a":1,"b":[true,null]}`))
+			m0.SetHeader("k", "v")
+			serialized, err := rawParser.Serialize(m0)
+			if err != nil {
+				return false
+			}
+			// json payloads are embedded as is
+			if !strings.Contains(string(serialized), `"payload":{"a":1,"b":[true,null]}`) {
+				t.Log("unexpected raw serialization ", string(serialized))
+				return false
+			}
+			return roundTrip(rawParser, m0)
+		}),
+		test_utils.NewTestCase("Raw round trip with text payload", "", func() bool {
+			return roundTrip(rawParser, NewMessage("3", "a", "t", "x", MessageTypeText, ([]byte)("hello \"world\""))) &&
+				roundTrip(rawParser, NewMessage("4", "a", "t", "x", MessageTypeText, ([]byte)(`"quoted"`))) &&
+				roundTrip(rawParser, NewMessage("5", "a", "t", "x", MessageTypeText, ([]byte)("123")))
+		}),
+		test_utils.NewTestCase("Raw round trip with null and whitespace", "payloads should be kept byte for byte", func() bool {
+			return roundTrip(rawParser, NewMessage("7", "a", "t", "x", MessageTypeText, ([]byte)("null"))) &&
+				roundTrip(rawParser, NewMessage("8", "a", "t", "x", MessageTypeText, ([]byte)(" null "))) &&
+				roundTrip(rawParser, NewMessage("9", "a", "t", "x", MessageTypeText, ([]byte)("{\n  \"a\": 1\n}\n"))) &&
+				roundTrip(rawParser, NewMessage("10", "a", "t", "x", MessageTypeText, ([]byte)(" [1, 2] "))) &&
+				roundTrip(rawParser, NewMessage("11", "a", "t", "x", MessageTypeText, ([]byte)(`{"html":"<b>&</b>"}`))) &&
+				roundTrip(base64Parser, NewMessage("12", "a", "t", "x", MessageTypeText, ([]byte)("null")))
+		}),
+		test_utils.NewTestCase("Partial", "", func() bool {
+			return roundTrip(base64Parser, &Message{id: "ppp", uri: "ok"}) && roundTrip(rawParser, &Message{id: "ppp", uri: "ok"})
+		}),
+		test_utils.NewTestCase("Cross encoding", "raw parser should read base64 messages and vice versa", func() bool {
+			m0 := NewMessage("6", "a", "t", "x", MessageTypeText, ([]byte)("hello"))
+			serialized, err := base64Parser.Serialize(m0)
+			if err != nil {
+				return false
+			}
+			m, err := rawParser.Deserialize(serialized)
+			return err == nil && m.Equals(m0)
+		}),
+		test_utils.NewTestCase("Invalid", "", func() bool {
+			_, err0 := base64Parser.Deserialize([]byte("{"))
+			_, err1 := base64Parser.Deserialize([]byte(`{"id":"1","payloadEncoding":"base64","payload":123}`))
+			_, err2 := base64Parser.Deserialize(nil)
+			return err0 != nil && err1 != nil && err2 != nil
+		}),
+		test_utils.NewTestCase("Registry", "", func() bool {
+			return GetMessageParser(MessageParserJSON) != nil &&
+				GetMessageParser(MessageParserFlatBuffer) != nil &&
+				GetMessageParserByProtocol(MessageProtocolSimple) != nil &&
+				GetMessageParserProtocol(MessageParserJSONRaw) == MessageProtocolSimple &&
+				RegisterMessageParser(MessageParserJSON, MessageProtocolSimple, base64Parser) != nil
+		}),
+	}).Do(t)
+}
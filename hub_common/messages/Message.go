@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 	common_http "whub/common/http"
 	"whub/hub_common/utils"
 )
@@ -52,6 +53,8 @@ const (
 	MessageTypeServiceDeleteRequest  = 115
 	MessageTypeServiceOptionsRequest = 116
 	MessageTypeServicePatchRequest   = 117
+	// MessageTypeServiceCancelRequest cancels the service request with the same id, it has no response
+	MessageTypeServiceCancelRequest = 118
 
 	MessageTypeServerNotification        = 11
 	MessageTypeServerServiceNotification = 12
@@ -66,14 +69,17 @@ const (
 	MessageTypeSvcResponseOK            = 200
 	MessageTypeSvcResponseCreated       = 201
 	MessageTypeSvcResponsePartial       = 206
+	MessageTypeSvcNotModified           = 304
 	MessageTypeSvcBadRequestError       = 400
 	MessageTypeSvcUnauthorizedError     = 401
 	MessageTypeSvcForbiddenError        = 403
 	MessageTypeSvcNotFoundError         = 404
 	MessageTypeSvcMethodNotAllowedError = 405
+	MessageTypeSvcConflictError         = 409
 	MessageTypeSvcGoneError             = 410
 	MessageTypeSvcInternalError         = 500
 	MessageTypeSvcUnavailableError      = 503
+	MessageTypeSvcGatewayTimeoutError   = 504
 )
 
 type Message struct {
@@ -84,6 +90,8 @@ type Message struct {
 	messageType int
 	headers     map[string]string
 	payload     []byte
+	// deadline is local to the process, it's carried as the remaining timeout on the wire
+	deadline time.Time
 }
 
 type IMessage interface {
@@ -99,10 +107,14 @@ type IMessage interface {
 	Payload() []byte
 	SetPayload([]byte) IMessage
 	String() string
+	Equals(IMessage) bool
 	Copy() IMessage
 	GetHeader(key string) string
 	SetHeader(key string, value string)
 	Headers() map[string]string
+	// Deadline returns the time by which the message should be handled, zero time means no deadline
+	Deadline() time.Time
+	SetDeadline(time.Time) IMessage
 
 	IsErrorMessage() bool
 	Dispose()
@@ -170,6 +182,15 @@ func (t *Message) Headers() map[string]string {
 	return t.headers
 }
 
+func (t *Message) Deadline() time.Time {
+	return t.deadline
+}
+
+func (t *Message) SetDeadline(deadline time.Time) IMessage {
+	t.deadline = deadline
+	return t
+}
+
 func (t *Message) stringifyHeaders() string {
 	var builder strings.Builder
 	length := len(t.headers)
@@ -199,7 +220,19 @@ func (t *Message) String() string {
 }
 
 func (t *Message) Equals(m IMessage) bool {
-	return t.Id() == m.Id() && t.From() == m.From() && t.To() == m.To() && t.Uri() == m.Uri() && t.MessageType() == m.MessageType() && (string)(t.payload) == (string)(m.Payload())
+	return t.Id() == m.Id() && t.From() == m.From() && t.To() == m.To() && t.Uri() == m.Uri() && t.MessageType() == m.MessageType() && (string)(t.payload) == (string)(m.Payload()) && t.headersEqual(m.Headers())
+}
+
+func (t *Message) headersEqual(headers map[string]string) bool {
+	if len(t.headers) != len(headers) {
+		return false
+	}
+	for k, v := range t.headers {
+		if h, exists := headers[k]; !exists || h != v {
+			return false
+		}
+	}
+	return true
 }
 
 func (t *Message) Copy() IMessage {
@@ -207,11 +240,12 @@ func (t *Message) Copy() IMessage {
 	for k, v := range t.headers {
 		newMsg.SetHeader(k, v)
 	}
+	newMsg.SetDeadline(t.deadline)
 	return newMsg
 }
 
 func (t *Message) IsErrorMessage() bool {
-	return t.MessageType() == MessageTypeError || t.MessageType() >= MessageTypeSvcBadRequestError && t.MessageType() <= MessageTypeSvcInternalError
+	return t.MessageType() == MessageTypeError || t.MessageType() >= MessageTypeSvcBadRequestError && t.MessageType() <= MessageTypeSvcGatewayTimeoutError
 }
 
 func (t *Message) Dispose() {
@@ -243,6 +277,7 @@ func NewMessage(id string, from string, to string, uri string, messageType int,
 	msg.messageType = messageType
 	msg.payload = payload
 	msg.headers = make(map[string]string)
+	msg.deadline = time.Time{}
 	return msg
 }
 
@@ -274,6 +309,11 @@ func NewNotification(id string, message string) IMessage {
 	return NewMessage(id, "", "", "", MessageTypeInternalNotification, ([]byte)(message))
 }
 
+// NewServiceCancelMessage creates the message that cancels service request id on uri
+func NewServiceCancelMessage(id string, from string, to string, uri string) IMessage {
+	return NewMessage(id, from, to, uri, MessageTypeServiceCancelRequest, nil)
+}
+
 func NewErrorResponse(request IMessage, from string, errType int, errMsg string) IMessage {
 	resp := NewMessage(request.Id(), from, request.From(), request.Uri(), errType, ([]byte)(fmt.Sprintf("{\"message\":\"%s\"}", errMsg)))
 	return resp
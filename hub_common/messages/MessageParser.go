@@ -30,19 +30,18 @@ func (p *FBMessageParser) Serialize(message IMessage) ([]byte, error) {
 	// headers
 	headers := message.Headers()
 	lHeaders := len(headers)
+	// keys and values are collected in one pass as map iteration order is not stable
 	var headerKeyOffsets []flatbuffers.UOffsetT
-	for k, _ := range headers {
+	var headerValueOffsets []flatbuffers.UOffsetT
+	for k, v := range headers {
 		headerKeyOffsets = append(headerKeyOffsets, builder.CreateString(k))
+		headerValueOffsets = append(headerValueOffsets, builder.CreateString(v))
 	}
 	Flatbuffer_Message.MessageStartHeaderKeysVector(builder, lHeaders)
 	for _, offset := range headerKeyOffsets {
 		builder.PrependUOffsetT(offset)
 	}
 	headerKeysOffset := builder.EndVector(lHeaders)
-	var headerValueOffsets []flatbuffers.UOffsetT
-	for _, v := range headers {
-		headerValueOffsets = append(headerValueOffsets, builder.CreateString(v))
-	}
 	Flatbuffer_Message.MessageStartHeaderValuesVector(builder, lHeaders)
 	for _, offset := range headerValueOffsets {
 		builder.PrependUOffsetT(offset)
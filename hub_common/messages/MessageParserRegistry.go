@@ -0,0 +1,117 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Message parser names, also used as websocket sub-protocols during codec negotiation
+const (
+	MessageParserFlatBuffer = "whub.fb"
+	MessageParserJSON       = "whub.json"
+	MessageParserJSONRaw    = "whub.json.raw"
+)
+
+type registeredParser struct {
+	name     string
+	protocol int
+	parser   IMessageParser
+}
+
+var parserRegistry *messageParserRegistry
+
+func init() {
+	parserRegistry = &messageParserRegistry{
+		parsers: make(map[string]*registeredParser),
+		lock:    new(sync.RWMutex),
+	}
+	parserRegistry.register(MessageParserFlatBuffer, MessageProtocolFlatBuffer, NewFBMessageParser())
+	parserRegistry.register(MessageParserJSON, MessageProtocolSimple, NewBase64JSONMessageParser())
+	parserRegistry.register(MessageParserJSONRaw, MessageProtocolSimple, NewRawJSONMessageParser())
+}
+
+type messageParserRegistry struct {
+	parsers map[string]*registeredParser
+	// names in registration order, earlier ones are preferred
+	names []string
+	lock  *sync.RWMutex
+}
+
+func (r *messageParserRegistry) withWrite(cb func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cb()
+}
+
+func (r *messageParserRegistry) register(name string, protocol int, parser IMessageParser) (err error) {
+	r.withWrite(func() {
+		if r.parsers[name] != nil {
+			err = errors.New(fmt.Sprintf("message parser %s has already been registered", name))
+			return
+		}
+		r.parsers[name] = &registeredParser{name, protocol, parser}
+		r.names = append(r.names, name)
+	})
+	return
+}
+
+func (r *messageParserRegistry) get(name string) *registeredParser {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.parsers[name]
+}
+
+func (r *messageParserRegistry) getByProtocol(protocol int) *registeredParser {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, name := range r.names {
+		if r.parsers[name].protocol == protocol {
+			return r.parsers[name]
+		}
+	}
+	return nil
+}
+
+func (r *messageParserRegistry) getNames() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	return names
+}
+
+// RegisterMessageParser makes a parser available for codec negotiation under the given name
+func RegisterMessageParser(name string, protocol int, parser IMessageParser) error {
+	return parserRegistry.register(name, protocol, parser)
+}
+
+func GetMessageParser(name string) IMessageParser {
+	p := parserRegistry.get(name)
+	if p == nil {
+		return nil
+	}
+	return p.parser
+}
+
+func GetMessageParserByProtocol(protocol int) IMessageParser {
+	p := parserRegistry.getByProtocol(protocol)
+	if p == nil {
+		return nil
+	}
+	return p.parser
+}
+
+// GetMessageParserProtocol returns the protocol of the parser or -1 if no such parser
+func GetMessageParserProtocol(name string) int {
+	p := parserRegistry.get(name)
+	if p == nil {
+		return -1
+	}
+	return p.protocol
+}
+
+// MessageParserNames returns registered parser names in preference order
+func MessageParserNames() []string {
+	return parserRegistry.getNames()
+}
@@ -40,5 +40,21 @@ func TestFBMessageParser(t *testing.T) {
 			t.Log("Deserialized ", m.String())
 			return m.Equals(m0)
 		}),
+		test_utils.NewTestCase("Headers", "", func() bool {
+			m0 := NewStreamEndMessage("1", "a", "t", "x/y/z", 3, "aborted")
+			m0.SetHeader("Content-Type", "text/plain")
+			s, e := p.Serialize(m0)
+			if e != nil {
+				t.Log("Serialization failed due to ", e)
+				return false
+			}
+			m, e := p.Deserialize(s)
+			if e != nil {
+				t.Log("Deserialization failed due to ", e)
+				return false
+			}
+			t.Log("Deserialized ", m.String())
+			return m.Equals(m0)
+		}),
 	}).Do(t)
 }
@@ -0,0 +1,103 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Wire protocol versions
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// ProtocolOffer is sent by the initiator of a MessageTypeProtocolUpdate handshake, all lists are in preference order
+type ProtocolOffer struct {
+	Version      int      `json:"version"`
+	MinVersion   int      `json:"minVersion"`
+	Codecs       []string `json:"codecs"`
+	Compressions []string `json:"compressions"`
+}
+
+// ProtocolSelection is the responder's pick from a ProtocolOffer
+type ProtocolSelection struct {
+	Version     int    `json:"version"`
+	Codec       string `json:"codec"`
+	Compression string `json:"compression"`
+}
+
+// NewProtocolOffer offers codecs and compressions with the current protocol version, all registered codecs and
+// compressions are offered if not specified
+func NewProtocolOffer(codecs []string, compressions []string) ProtocolOffer {
+	if len(codecs) == 0 {
+		codecs = MessageParserNames()
+	}
+	if len(compressions) == 0 {
+		compressions = CompressionNames()
+	}
+	return ProtocolOffer{
+		Version:      ProtocolVersion,
+		MinVersion:   MinProtocolVersion,
+		Codecs:       codecs,
+		Compressions: compressions,
+	}
+}
+
+func NewProtocolUpdateMessage(from string, to string, offer ProtocolOffer) (IMessage, error) {
+	payload, err := json.Marshal(offer)
+	if err != nil {
+		return nil, err
+	}
+	return DraftMessage(from, to, "", MessageTypeProtocolUpdate, payload), nil
+}
+
+func NewProtocolUpdateResponse(request IMessage, from string, selection ProtocolSelection) (IMessage, error) {
+	payload, err := json.Marshal(selection)
+	if err != nil {
+		return nil, err
+	}
+	return NewMessage(request.Id(), from, request.From(), request.Uri(), MessageTypeProtocolUpdate, payload), nil
+}
+
+func ParseProtocolOffer(message IMessage) (offer ProtocolOffer, err error) {
+	err = json.Unmarshal(message.Payload(), &offer)
+	return
+}
+
+func ParseProtocolSelection(message IMessage) (selection ProtocolSelection, err error) {
+	err = json.Unmarshal(message.Payload(), &selection)
+	return
+}
+
+// NegotiateProtocol picks the highest mutually supported version and the first offered codec and compression this
+// side supports
+func NegotiateProtocol(offer ProtocolOffer) (selection ProtocolSelection, err error) {
+	version := offer.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	if version < MinProtocolVersion || version < offer.MinVersion {
+		err = errors.New(fmt.Sprintf("no mutually supported protocol version(offered %d-%d, supported %d-%d)", offer.MinVersion, offer.Version, MinProtocolVersion, ProtocolVersion))
+		return
+	}
+	selection.Version = version
+	for _, codec := range offer.Codecs {
+		if GetMessageParser(codec) != nil {
+			selection.Codec = codec
+			break
+		}
+	}
+	if selection.Codec == "" {
+		err = errors.New(fmt.Sprintf("none of the offered codecs %v is supported", offer.Codecs))
+		return
+	}
+	selection.Compression = CompressionNone
+	for _, compression := range offer.Compressions {
+		if isCompressionSupported(compression) {
+			selection.Compression = compression
+			break
+		}
+	}
+	return
+}
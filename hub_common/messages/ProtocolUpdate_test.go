@@ -0,0 +1,58 @@
+package messages
+
+import (
+	"testing"
+	"whub/common/test_utils"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	tg := test_utils.NewTestGroup("NegotiateProtocol", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Preferred codec", "first supported offered codec should be picked", func() bool {
+			selection, err := NegotiateProtocol(ProtocolOffer{
+				Version:      ProtocolVersion,
+				MinVersion:   MinProtocolVersion,
+				Codecs:       []string{"unknown", MessageParserJSON, MessageParserFlatBuffer},
+				Compressions: []string{"unknown"},
+			})
+			return err == nil && selection.Codec == MessageParserJSON && selection.Compression == CompressionNone && selection.Version == ProtocolVersion
+		}),
+		test_utils.NewTestCase("Preferred compression", "first supported offered compression should be picked", func() bool {
+			selection, err := NegotiateProtocol(NewProtocolOffer(nil, []string{"unknown", CompressionDeflate, CompressionGzip}))
+			return err == nil && selection.Compression == CompressionDeflate
+		}),
+		test_utils.NewTestCase("Newer peer", "version should be downgraded to the highest supported one", func() bool {
+			selection, err := NegotiateProtocol(ProtocolOffer{Version: ProtocolVersion + 1, MinVersion: MinProtocolVersion, Codecs: []string{MessageParserFlatBuffer}})
+			return err == nil && selection.Version == ProtocolVersion
+		}),
+		test_utils.NewTestCase("Incompatible version", "", func() bool {
+			_, err := NegotiateProtocol(ProtocolOffer{Version: ProtocolVersion + 2, MinVersion: ProtocolVersion + 1, Codecs: []string{MessageParserFlatBuffer}})
+			return err != nil
+		}),
+		test_utils.NewTestCase("No codec", "", func() bool {
+			_, err := NegotiateProtocol(ProtocolOffer{Version: ProtocolVersion, Codecs: []string{"unknown"}})
+			return err != nil
+		}),
+		test_utils.NewTestCase("Message round trip", "", func() bool {
+			offer := NewProtocolOffer(nil, nil)
+			request, err := NewProtocolUpdateMessage("a", "b", offer)
+			if err != nil {
+				return false
+			}
+			parsedOffer, err := ParseProtocolOffer(request)
+			if err != nil || len(parsedOffer.Codecs) != len(MessageParserNames()) {
+				return false
+			}
+			selection, err := NegotiateProtocol(parsedOffer)
+			if err != nil {
+				return false
+			}
+			resp, err := NewProtocolUpdateResponse(request, "b", selection)
+			if err != nil || resp.Id() != request.Id() || resp.To() != "a" {
+				return false
+			}
+			parsedSelection, err := ParseProtocolSelection(resp)
+			return err == nil && parsedSelection == selection && parsedSelection.Codec == MessageParserFlatBuffer && parsedSelection.Compression == CompressionNone
+		}),
+	}).Do(t)
+}
@@ -0,0 +1,39 @@
+package messages
+
+import "strings"
+
+// MessageHeaderTo carries the ids of clients a message is sent to via the messaging service, multiple ids are
+// separated by RecipientSeparator
+const (
+	MessageHeaderTo    = "X-To"
+	RecipientSeparator = ","
+)
+
+// DeliveryResult tells if a message is delivered to the recipient, or Queued in the mailbox of the offline recipient
+// to be delivered once it connects. Error is the reason of the failure.
+type DeliveryResult struct {
+	ClientId  string `json:"clientId"`
+	Delivered bool   `json:"delivered"`
+	Queued    bool   `json:"queued,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ParseRecipients splits the value of MessageHeaderTo into client ids, empty and duplicated ids are dropped
+func ParseRecipients(to string) []string {
+	seen := make(map[string]bool)
+	recipients := make([]string, 0)
+	for _, id := range strings.Split(to, RecipientSeparator) {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		recipients = append(recipients, id)
+	}
+	return recipients
+}
+
+// JoinRecipients assembles client ids into the value of MessageHeaderTo
+func JoinRecipients(ids []string) string {
+	return strings.Join(ids, RecipientSeparator)
+}
@@ -0,0 +1,23 @@
+package messages
+
+import (
+	"testing"
+	"whub/common/test_utils"
+)
+
+func TestRecipients(t *testing.T) {
+	tg := test_utils.NewTestGroup("Recipients", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Parse", "empty and duplicated ids should be dropped", func() bool {
+			recipients := ParseRecipients(" a,b,,a , c")
+			return len(recipients) == 3 && recipients[0] == "a" && recipients[1] == "b" && recipients[2] == "c"
+		}),
+		test_utils.NewTestCase("Empty", "no recipient should be parsed from an empty header", func() bool {
+			return len(ParseRecipients("")) == 0
+		}),
+		test_utils.NewTestCase("Round trip", "joined ids should be parsed back", func() bool {
+			recipients := ParseRecipients(JoinRecipients([]string{"a", "b"}))
+			return len(recipients) == 2 && recipients[0] == "a" && recipients[1] == "b"
+		}),
+	}).Do(t)
+}
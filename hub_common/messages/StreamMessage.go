@@ -0,0 +1,71 @@
+package messages
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Stream headers. A stream shares the id of the request it responds to. The stream header message(seq 0) resolves the
+// request, then data chunks(seq 1..n) follow and the last chunk carries the end marker. Credit and cancel messages
+// flow from the receiver back to the sender.
+const (
+	MessageStreamHeaderPrefix = "X-Stream-"
+	MessageStreamHeaderSeq    = "X-Stream-Seq"
+	MessageStreamHeaderEnd    = "X-Stream-End"
+	MessageStreamHeaderCredit = "X-Stream-Credit"
+	MessageStreamHeaderCancel = "X-Stream-Cancel"
+	MessageStreamHeaderError  = "X-Stream-Error"
+	MessageStreamHeaderStatus = "X-Stream-Status"
+)
+
+// NewStreamHeaderMessage creates the message that opens a stream, headers are carried over as response headers
+func NewStreamHeaderMessage(id string, from string, to string, uri string, headers map[string]string) IMessage {
+	msg := NewMessage(id, from, to, uri, MessageTypeStream, nil)
+	for k, v := range headers {
+		msg.SetHeader(k, v)
+	}
+	msg.SetHeader(MessageStreamHeaderSeq, "0")
+	return msg
+}
+
+func NewStreamChunkMessage(id string, from string, to string, uri string, seq int, payload []byte) IMessage {
+	msg := NewMessage(id, from, to, uri, MessageTypeStream, payload)
+	msg.SetHeader(MessageStreamHeaderSeq, strconv.Itoa(seq))
+	return msg
+}
+
+// NewStreamEndMessage creates the end-of-stream marker, a non-empty errMsg indicates the stream is aborted by sender
+func NewStreamEndMessage(id string, from string, to string, uri string, seq int, errMsg string) IMessage {
+	msg := NewStreamChunkMessage(id, from, to, uri, seq, nil)
+	msg.SetHeader(MessageStreamHeaderEnd, "true")
+	if errMsg != "" {
+		msg.SetHeader(MessageStreamHeaderError, errMsg)
+	}
+	return msg
+}
+
+func NewStreamCreditMessage(id string, from string, to string, uri string, credit int) IMessage {
+	msg := NewMessage(id, from, to, uri, MessageTypeStream, nil)
+	msg.SetHeader(MessageStreamHeaderCredit, strconv.Itoa(credit))
+	return msg
+}
+
+func NewStreamCancelMessage(id string, from string, to string, uri string) IMessage {
+	msg := NewMessage(id, from, to, uri, MessageTypeStream, nil)
+	msg.SetHeader(MessageStreamHeaderCancel, "true")
+	return msg
+}
+
+// IsStreamControlMessage tells if the message is sent from stream receiver to sender
+func IsStreamControlMessage(msg IMessage) bool {
+	return msg.MessageType() == MessageTypeStream && (msg.GetHeader(MessageStreamHeaderCredit) != "" || msg.GetHeader(MessageStreamHeaderCancel) != "")
+}
+
+// IsStreamHeaderMessage tells if the message opens a stream
+func IsStreamHeaderMessage(msg IMessage) bool {
+	return msg.MessageType() == MessageTypeStream && msg.GetHeader(MessageStreamHeaderSeq) == "0"
+}
+
+func IsStreamHeader(key string) bool {
+	return strings.HasPrefix(key, MessageStreamHeaderPrefix)
+}
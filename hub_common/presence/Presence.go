@@ -0,0 +1,124 @@
+package presence
+
+import (
+	"sync"
+	"time"
+)
+
+// Status tells if the client is online and how many connections it has
+type Status struct {
+	ClientId    string `json:"clientId"`
+	Online      bool   `json:"online"`
+	Connections int    `json:"connections"`
+}
+
+// Change is the payload of presence notifications, it's sent when a watched client goes online or offline
+type Change struct {
+	Status
+	Time time.Time `json:"time"`
+}
+
+// ConnectionInfo describes a connection of a client
+type ConnectionInfo struct {
+	Address     string `json:"address"`
+	Type        string `json:"type"`
+	Codec       string `json:"codec"`
+	Compression string `json:"compression"`
+	// Current tells if the connection is the one the request comes from
+	Current bool `json:"current"`
+}
+
+// Watchers keeps which clients subscribe to presence changes of which clients
+type Watchers struct {
+	watchers map[string]map[string]bool // clientId: subscribers
+	watching map[string]map[string]bool // subscriber: clientIds
+	lock     *sync.RWMutex
+}
+
+func NewWatchers() *Watchers {
+	return &Watchers{
+		watchers: make(map[string]map[string]bool),
+		watching: make(map[string]map[string]bool),
+		lock:     new(sync.RWMutex),
+	}
+}
+
+func (w *Watchers) withWrite(cb func()) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	cb()
+}
+
+func (w *Watchers) withRead(cb func()) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	cb()
+}
+
+func addToSetMap(m map[string]map[string]bool, key string, value string) {
+	if m[key] == nil {
+		m[key] = make(map[string]bool)
+	}
+	m[key][value] = true
+}
+
+func deleteFromSetMap(m map[string]map[string]bool, key string, value string) {
+	delete(m[key], value)
+	if len(m[key]) == 0 {
+		delete(m, key)
+	}
+}
+
+func keys(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for k := range set {
+		values = append(values, k)
+	}
+	return values
+}
+
+// Watch subscribes presence changes of clients for the subscriber
+func (w *Watchers) Watch(subscriber string, clientIds []string) {
+	w.withWrite(func() {
+		for _, id := range clientIds {
+			addToSetMap(w.watchers, id, subscriber)
+			addToSetMap(w.watching, subscriber, id)
+		}
+	})
+}
+
+// Unwatch unsubscribes presence changes of clients for the subscriber
+func (w *Watchers) Unwatch(subscriber string, clientIds []string) {
+	w.withWrite(func() {
+		for _, id := range clientIds {
+			deleteFromSetMap(w.watchers, id, subscriber)
+			deleteFromSetMap(w.watching, subscriber, id)
+		}
+	})
+}
+
+// UnwatchAll unsubscribes all presence changes for the subscriber
+func (w *Watchers) UnwatchAll(subscriber string) {
+	w.withWrite(func() {
+		for id := range w.watching[subscriber] {
+			deleteFromSetMap(w.watchers, id, subscriber)
+		}
+		delete(w.watching, subscriber)
+	})
+}
+
+// Subscribers are clients that watch the client
+func (w *Watchers) Subscribers(clientId string) (subscribers []string) {
+	w.withRead(func() {
+		subscribers = keys(w.watchers[clientId])
+	})
+	return
+}
+
+// Watching are clients the subscriber watches
+func (w *Watchers) Watching(subscriber string) (clientIds []string) {
+	w.withRead(func() {
+		clientIds = keys(w.watching[subscriber])
+	})
+	return
+}
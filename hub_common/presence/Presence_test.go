@@ -0,0 +1,53 @@
+package presence
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+)
+
+func sorted(values []string) []string {
+	sort.Strings(values)
+	return values
+}
+
+func TestWatchers(t *testing.T) {
+	tg := test_utils.NewTestGroup("Watchers", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Watch", "subscribers should be found by watched clients", func() bool {
+			w := NewWatchers()
+			w.Watch("s1", []string{"a", "b"})
+			w.Watch("s2", []string{"a"})
+			subscribers := sorted(w.Subscribers("a"))
+			watching := sorted(w.Watching("s1"))
+			return len(subscribers) == 2 && subscribers[0] == "s1" && subscribers[1] == "s2" &&
+				len(watching) == 2 && watching[0] == "a" && watching[1] == "b"
+		}),
+		test_utils.NewTestCase("Unwatch", "unwatched clients should not notify the subscriber", func() bool {
+			w := NewWatchers()
+			w.Watch("s1", []string{"a", "b"})
+			w.Unwatch("s1", []string{"a", "c"})
+			watching := w.Watching("s1")
+			return len(w.Subscribers("a")) == 0 && len(watching) == 1 && watching[0] == "b"
+		}),
+		test_utils.NewTestCase("Unwatch all", "all subscriptions of the subscriber should be removed", func() bool {
+			w := NewWatchers()
+			w.Watch("s1", []string{"a", "b"})
+			w.Watch("s2", []string{"b"})
+			w.UnwatchAll("s1")
+			subscribers := w.Subscribers("b")
+			return len(w.Subscribers("a")) == 0 && len(subscribers) == 1 && subscribers[0] == "s2" &&
+				len(w.Watching("s1")) == 0 && len(w.watchers) == 1 && len(w.watching) == 1
+		}),
+		test_utils.NewTestCase("Change", "changes should be marshalled flat", func() bool {
+			marshalled, err := json.Marshal(Change{Status{"a", true, 2}, time.Now()})
+			var m map[string]interface{}
+			if err != nil || json.Unmarshal(marshalled, &m) != nil {
+				return false
+			}
+			return m["clientId"] == "a" && m["online"] == true && m["connections"] == float64(2) && m["time"] != nil
+		}),
+	}).Do(t)
+}
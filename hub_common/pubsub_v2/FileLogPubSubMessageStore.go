@@ -0,0 +1,511 @@
+package pubsub_v2
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+const (
+	// DefaultLogSegmentSize is the size a log segment grows to before a new segment is started
+	DefaultLogSegmentSize = 16 * 1024 * 1024
+
+	logSegmentExt    = ".log"
+	topicMetaFile    = "meta.json"
+	recordHeaderSize = 8  // body length uint32 | body crc32 uint32
+	recordFixedSize  = 15 // index uint32 | priority uint8 | ctime int64 | publisher length uint16
+)
+
+// logSegment is one file of a topic log, it holds messages from base on. Offsets of the messages are kept in memory.
+type logSegment struct {
+	base      uint32
+	file      *os.File
+	size      int64
+	indexes   []uint32
+	offsets   []int64
+	lastCTime time.Time
+}
+
+func (s *logSegment) search(index uint32) int {
+	return sort.Search(len(s.indexes), func(i int) bool {
+		return s.indexes[i] >= index
+	})
+}
+
+type topicLog struct {
+	dir       string
+	segments  []*logSegment
+	lastIndex uint32
+}
+
+func (l *topicLog) active() *logSegment {
+	return l.segments[len(l.segments)-1]
+}
+
+func (l *topicLog) size() (size int64) {
+	for _, s := range l.segments {
+		size += s.size
+	}
+	return
+}
+
+// FileLogPubSubMessageStore keeps messages of each topic in an append-only log on disk. A topic log is split into
+// segments, retention drops whole segments except the active one, so that the last index is never lost. Segments are
+// kept as dir/<escaped topic id>/<base index>.log along with the topic record in dir/<escaped topic id>/meta.json.
+type FileLogPubSubMessageStore struct {
+	dir         string
+	segmentSize int64
+	logs        map[string]*topicLog
+	topics      map[string]TopicRecord
+	lock        *sync.RWMutex
+}
+
+// NewFileLogPubSubMessageStore opens the logs under dir, truncated records at the end of logs(e.g. due to a crash)
+// are dropped
+func NewFileLogPubSubMessageStore(dir string, segmentSize int64) (IPubSubMessageStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultLogSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileLogPubSubMessageStore{
+		dir:         dir,
+		segmentSize: segmentSize,
+		logs:        make(map[string]*topicLog),
+		topics:      make(map[string]TopicRecord),
+		lock:        new(sync.RWMutex),
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileLogPubSubMessageStore) withWrite(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *FileLogPubSubMessageStore) withRead(cb func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	cb()
+}
+
+func (s *FileLogPubSubMessageStore) load() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		topicId, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		log, record, err := openTopicLog(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to open log of topic %s due to %s", topicId, err.Error()))
+		}
+		s.logs[topicId] = log
+		if record != nil {
+			s.topics[topicId] = *record
+		}
+	}
+	return nil
+}
+
+func openTopicLog(dir string) (*topicLog, *TopicRecord, error) {
+	log := &topicLog{dir: dir}
+	var record *TopicRecord
+	if data, err := ioutil.ReadFile(filepath.Join(dir, topicMetaFile)); err == nil {
+		record = new(TopicRecord)
+		if err = json.Unmarshal(data, record); err != nil {
+			return nil, nil, err
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	// ReadDir sorts by name and names are zero padded, so segments are in index order
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), logSegmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), logSegmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		segment, err := openLogSegment(filepath.Join(dir, entry.Name()), uint32(base))
+		if err != nil {
+			log.close()
+			return nil, nil, err
+		}
+		log.segments = append(log.segments, segment)
+	}
+	if len(log.segments) == 0 {
+		segment, err := openLogSegment(filepath.Join(dir, segmentName(1)), 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.segments = append(log.segments, segment)
+	}
+	active := log.active()
+	log.lastIndex = active.base - 1
+	if len(active.indexes) > 0 {
+		log.lastIndex = active.indexes[len(active.indexes)-1]
+	}
+	return log, record, nil
+}
+
+func segmentName(base uint32) string {
+	return fmt.Sprintf("%010d%s", base, logSegmentExt)
+}
+
+// openLogSegment scans the segment to build the offsets, the segment is truncated at the first invalid record
+func openLogSegment(path string, base uint32) (*logSegment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	segment := &logSegment{base: base, file: file}
+	for {
+		message, size, err := readRecord(file, segment.size, info.Size(), "")
+		if err != nil {
+			break
+		}
+		segment.indexes = append(segment.indexes, message.Index())
+		segment.offsets = append(segment.offsets, segment.size)
+		segment.lastCTime = message.CTime()
+		segment.size += size
+	}
+	if err = file.Truncate(segment.size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return segment, nil
+}
+
+func encodeRecord(message model.IPubSubMessage) []byte {
+	publisher := message.Publisher()
+	if len(publisher) > 0xffff {
+		publisher = publisher[:0xffff]
+	}
+	bodySize := recordFixedSize + len(publisher) + len(message.Payload())
+	record := make([]byte, recordHeaderSize+bodySize)
+	body := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(body[0:4], message.Index())
+	body[4] = message.Priority()
+	binary.BigEndian.PutUint64(body[5:13], uint64(message.CTime().UnixNano()))
+	binary.BigEndian.PutUint16(body[13:15], uint16(len(publisher)))
+	copy(body[recordFixedSize:], publisher)
+	copy(body[recordFixedSize+len(publisher):], message.Payload())
+	binary.BigEndian.PutUint32(record[0:4], uint32(bodySize))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body))
+	return record
+}
+
+// readRecord reads the record at offset that ends before limit, it returns the message and the size of the record
+func readRecord(file *os.File, offset int64, limit int64, topicId string) (model.IPubSubMessage, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	bodySize := binary.BigEndian.Uint32(header[0:4])
+	if bodySize < recordFixedSize || offset+recordHeaderSize+int64(bodySize) > limit {
+		return nil, 0, errors.New("invalid record size")
+	}
+	body := make([]byte, bodySize)
+	if _, err := file.ReadAt(body, offset+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	publisherSize := int(binary.BigEndian.Uint16(body[13:15]))
+	if recordFixedSize+publisherSize > len(body) {
+		return nil, 0, errors.New("invalid publisher size")
+	}
+	message := model.NewPubSubMessage(
+		topicId,
+		binary.BigEndian.Uint32(body[0:4]),
+		body[4],
+		string(body[recordFixedSize:recordFixedSize+publisherSize]),
+		time.Unix(0, int64(binary.BigEndian.Uint64(body[5:13]))),
+		body[recordFixedSize+publisherSize:])
+	return message, int64(recordHeaderSize + bodySize), nil
+}
+
+func (l *topicLog) close() {
+	for _, segment := range l.segments {
+		segment.file.Close()
+	}
+}
+
+func (l *topicLog) append(message model.IPubSubMessage, segmentSize int64) error {
+	if err := checkIndex(l.lastIndex, message); err != nil {
+		return err
+	}
+	active := l.active()
+	if active.size >= segmentSize {
+		segment, err := openLogSegment(filepath.Join(l.dir, segmentName(message.Index())), message.Index())
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, segment)
+		active = segment
+	}
+	record := encodeRecord(message)
+	if _, err := active.file.Write(record); err != nil {
+		// drop the partially written record
+		active.file.Truncate(active.size)
+		return err
+	}
+	active.indexes = append(active.indexes, message.Index())
+	active.offsets = append(active.offsets, active.size)
+	active.lastCTime = message.CTime()
+	active.size += int64(len(record))
+	l.lastIndex = message.Index()
+	return nil
+}
+
+// read reads messages with from <= index <= to, at most max messages if max > 0
+func (l *topicLog) read(topicId string, from uint32, to uint32, max int) ([]model.IPubSubMessage, error) {
+	result := make([]model.IPubSubMessage, 0)
+	// the first segment that may hold from
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].base > from
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	for ; i < len(l.segments); i++ {
+		segment := l.segments[i]
+		for j := segment.search(from); j < len(segment.indexes); j++ {
+			if segment.indexes[j] > to || max > 0 && len(result) >= max {
+				return result, nil
+			}
+			message, _, err := readRecord(segment.file, segment.offsets[j], segment.size, topicId)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+// topicDirName returns the name of the log directory of the topic, escaped topic ids are single path elements except
+// for . and .. which would point to the store directory or its parent
+func topicDirName(topicId string) (string, error) {
+	name := url.PathEscape(topicId)
+	if name == "" || name == "." || name == ".." {
+		return "", errors.New(fmt.Sprintf("invalid topic id %s", topicId))
+	}
+	return name, nil
+}
+
+func (s *FileLogPubSubMessageStore) getOrCreateLog(topicId string) (*topicLog, error) {
+	if log := s.logs[topicId]; log != nil {
+		return log, nil
+	}
+	name, err := topicDirName(topicId)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	log, _, err := openTopicLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.logs[topicId] = log
+	return log, nil
+}
+
+func (s *FileLogPubSubMessageStore) put(message model.IPubSubMessage) error {
+	log, err := s.getOrCreateLog(message.TopicId())
+	if err != nil {
+		return err
+	}
+	return log.append(message, s.segmentSize)
+}
+
+func (s *FileLogPubSubMessageStore) Put(message model.IPubSubMessage) (err error) {
+	s.withWrite(func() {
+		err = s.put(message)
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) BulkPut(msgs []model.IPubSubMessage) (err error) {
+	s.withWrite(func() {
+		for _, m := range msgs {
+			if err = s.put(m); err != nil {
+				return
+			}
+		}
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) readLog(topicId string, from uint32, to uint32, max int) (result []model.IPubSubMessage, err error) {
+	s.withRead(func() {
+		log := s.logs[topicId]
+		if log == nil {
+			result = make([]model.IPubSubMessage, 0)
+			return
+		}
+		result, err = log.read(topicId, from, to, max)
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) Get(topicId string, index uint32) (model.IPubSubMessage, error) {
+	msgs, err := s.readLog(topicId, index, index, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return msgs[0], nil
+}
+
+func (s *FileLogPubSubMessageStore) GetInRange(topicId string, from uint32, to uint32) ([]model.IPubSubMessage, error) {
+	return s.readLog(topicId, from, to, 0)
+}
+
+func (s *FileLogPubSubMessageStore) GetFrom(topicId string, from uint32, max int) ([]model.IPubSubMessage, error) {
+	return s.readLog(topicId, from, ^uint32(0), max)
+}
+
+func (s *FileLogPubSubMessageStore) LastIndex(topicId string) (index uint32, err error) {
+	s.withRead(func() {
+		if log := s.logs[topicId]; log != nil {
+			index = log.lastIndex
+		}
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) ApplyRetention(topicId string, policy RetentionPolicy) (err error) {
+	now := time.Now()
+	s.withWrite(func() {
+		log := s.logs[topicId]
+		if log == nil {
+			return
+		}
+		size := log.size()
+		dropped := 0
+		for ; dropped < len(log.segments)-1; dropped++ {
+			segment := log.segments[dropped]
+			// a segment is out of the age limit once its newest message is
+			if !policy.exceeded(now.Sub(segment.lastCTime), size) {
+				break
+			}
+			name := segment.file.Name()
+			segment.file.Close()
+			if err = os.Remove(name); err != nil {
+				// the segment is kept, so it should still be readable
+				if file, e := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0644); e == nil {
+					segment.file = file
+				}
+				break
+			}
+			size -= segment.size
+		}
+		log.segments = log.segments[dropped:]
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) PutTopic(record TopicRecord) (err error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.withWrite(func() {
+		var log *topicLog
+		if log, err = s.getOrCreateLog(record.Id); err != nil {
+			return
+		}
+		// write to a temp file first so that a crash never leaves a partial meta file
+		tmp := filepath.Join(log.dir, topicMetaFile+".tmp")
+		if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return
+		}
+		if err = os.Rename(tmp, filepath.Join(log.dir, topicMetaFile)); err != nil {
+			return
+		}
+		s.topics[record.Id] = record
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) GetTopics() (records []TopicRecord, err error) {
+	s.withRead(func() {
+		records = make([]TopicRecord, 0, len(s.topics))
+		for _, r := range s.topics {
+			records = append(records, r)
+		}
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) DeleteTopic(topicId string) (err error) {
+	s.withWrite(func() {
+		log := s.logs[topicId]
+		delete(s.logs, topicId)
+		delete(s.topics, topicId)
+		if log != nil {
+			log.close()
+			err = os.RemoveAll(log.dir)
+		}
+	})
+	return
+}
+
+func (s *FileLogPubSubMessageStore) Close() (err error) {
+	s.withWrite(func() {
+		for _, log := range s.logs {
+			for _, segment := range log.segments {
+				if serr := segment.file.Sync(); serr != nil {
+					err = serr
+				}
+			}
+			log.close()
+		}
+		s.logs = make(map[string]*topicLog)
+	})
+	return
+}
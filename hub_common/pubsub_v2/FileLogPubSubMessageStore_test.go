@@ -0,0 +1,97 @@
+package pubsub_v2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+func putN(store IPubSubMessageStore, topic string, from int, n int, ctime time.Time) error {
+	for i := from; i < from+n; i++ {
+		if err := store.Put(model.NewPubSubMessage(topic, uint32(i), 1, "publisher", ctime, ([]byte)(strconv.Itoa(i)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestFileLogPubSubMessageStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tg := test_utils.NewTestGroup("FileLogPubSubMessageStore", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Reopen", "messages and topics should survive reopening", func() bool {
+			store, err := NewFileLogPubSubMessageStore(filepath.Join(dir, "reopen"), 64)
+			if err != nil || putN(store, "a/b", 1, 10, time.Now()) != nil {
+				return false
+			}
+			store.PutTopic(TopicRecord{Id: "a/b", Creator: "c", Groups: []GroupRecord{{Name: "g", Mode: model.SubModePush, LastMsgIndex: 3, Owner: "c", Members: []string{"d"}}}})
+			if store.Put(model.NewPubSubMessage("a/b", 10, 0, "p", time.Now(), nil)) == nil {
+				t.Log("index should be increasing")
+				return false
+			}
+			store.Close()
+			store, err = NewFileLogPubSubMessageStore(filepath.Join(dir, "reopen"), 64)
+			if err != nil {
+				return false
+			}
+			defer store.Close()
+			topics, _ := store.GetTopics()
+			last, _ := store.LastIndex("a/b")
+			msgs, _ := store.GetFrom("a/b", 4, 3)
+			inRange, _ := store.GetInRange("a/b", 9, 20)
+			return len(topics) == 1 && topics[0].Groups[0].LastMsgIndex == 3 && topics[0].Groups[0].Members[0] == "d" && last == 10 &&
+				len(msgs) == 3 && msgs[0].Index() == 4 && string(msgs[2].Payload()) == "6" && msgs[0].Publisher() == "publisher" &&
+				len(inRange) == 2 && inRange[1].Index() == 10
+		}),
+		test_utils.NewTestCase("Truncated", "a partially written record should be dropped", func() bool {
+			path := filepath.Join(dir, "truncated")
+			store, _ := NewFileLogPubSubMessageStore(path, 0)
+			putN(store, "t", 1, 3, time.Now())
+			store.Close()
+			segment := filepath.Join(path, "t", segmentName(1))
+			info, _ := os.Stat(segment)
+			os.Truncate(segment, info.Size()-2)
+			store, err := NewFileLogPubSubMessageStore(path, 0)
+			if err != nil {
+				return false
+			}
+			defer store.Close()
+			last, _ := store.LastIndex("t")
+			return last == 2 && putN(store, "t", 3, 1, time.Now()) == nil
+		}),
+		test_utils.NewTestCase("Relative topic ids", "topics should never be stored out of their own directories", func() bool {
+			path := filepath.Join(dir, "relative")
+			store, _ := NewFileLogPubSubMessageStore(path, 0)
+			defer store.Close()
+			if store.PutTopic(TopicRecord{Id: ".."}) == nil || putN(store, ".", 1, 1, time.Now()) == nil || putN(store, "a/..", 1, 1, time.Now()) != nil {
+				return false
+			}
+			entries, _ := ioutil.ReadDir(path)
+			return len(entries) == 1 && entries[0].Name() == "a%2F.."
+		}),
+		test_utils.NewTestCase("Retention", "old segments should be dropped but the last index is kept", func() bool {
+			store, _ := NewFileLogPubSubMessageStore(filepath.Join(dir, "retention"), 64)
+			defer store.Close()
+			putN(store, "t", 1, 6, time.Now().Add(-time.Hour))
+			store.ApplyRetention("t", RetentionPolicy{MaxAge: time.Minute})
+			msgs, _ := store.GetFrom("t", 1, 0)
+			last, _ := store.LastIndex("t")
+			if len(msgs) == 0 || len(msgs) >= 6 || msgs[len(msgs)-1].Index() != 6 || last != 6 {
+				return false
+			}
+			putN(store, "t", 7, 20, time.Now())
+			store.ApplyRetention("t", RetentionPolicy{MaxBytes: 128})
+			msgs, _ = store.GetFrom("t", 1, 0)
+			return len(msgs) > 0 && msgs[0].Index() > 7 && msgs[len(msgs)-1].Index() == 26
+		}),
+	}).Do(t)
+}
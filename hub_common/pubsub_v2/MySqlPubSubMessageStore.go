@@ -0,0 +1,275 @@
+package pubsub_v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+// relations:
+// tbl d_pub_sub_messages
+// topic_id string | idx uint32 | priority uint8 | publisher string | c_time time | payload blob
+// tbl d_pub_sub_topics
+// id string | creator string | c_time time | last_idx uint32
+// tbl d_pub_sub_groups
+// topic_id string | name string | mode uint8 | last_msg_index uint32 | owner string | members string(json array)
+
+type DPubSubMessage struct {
+	TopicId   string `gorm:"primaryKey;size:255"`
+	Idx       uint32 `gorm:"primaryKey;autoIncrement:false"`
+	Priority  uint8
+	Publisher string
+	CTime     time.Time `gorm:"index"`
+	Payload   []byte
+}
+
+func (d *DPubSubMessage) toMessage() model.IPubSubMessage {
+	return model.NewPubSubMessage(d.TopicId, d.Idx, d.Priority, d.Publisher, d.CTime, d.Payload)
+}
+
+type DPubSubTopic struct {
+	ID      string `gorm:"primaryKey;size:255"`
+	Creator string
+	CTime   time.Time
+	// LastIdx is kept with the topic as messages can be dropped by retention
+	LastIdx uint32
+}
+
+type DPubSubGroup struct {
+	TopicId      string `gorm:"primaryKey;size:255"`
+	Name         string `gorm:"primaryKey;size:255"`
+	Mode         uint8
+	LastMsgIndex uint32
+	Owner        string
+	Members      string
+}
+
+type MySqlPubSubMessageStore struct {
+	db *gorm.DB
+}
+
+func NewMySqlPubSubMessageStore() *MySqlPubSubMessageStore {
+	return &MySqlPubSubMessageStore{}
+}
+
+func (s *MySqlPubSubMessageStore) Init(fullDBUri, username, password, dbname string) error {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", username, password, fullDBUri, dbname)
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return s.db.AutoMigrate(&DPubSubMessage{}, &DPubSubTopic{}, &DPubSubGroup{})
+}
+
+func (s *MySqlPubSubMessageStore) toDMessage(message model.IPubSubMessage) *DPubSubMessage {
+	return &DPubSubMessage{
+		TopicId:   message.TopicId(),
+		Idx:       message.Index(),
+		Priority:  message.Priority(),
+		Publisher: message.Publisher(),
+		CTime:     message.CTime(),
+		Payload:   message.Payload(),
+	}
+}
+
+// advanceLastIndex moves the last index of the topic to index, it fails if index is not greater than the last index
+func (s *MySqlPubSubMessageStore) advanceLastIndex(tx *gorm.DB, topicId string, index uint32) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&DPubSubTopic{ID: topicId}).Error; err != nil {
+		return err
+	}
+	result := tx.Model(&DPubSubTopic{}).Where("id = ? AND last_idx < ?", topicId, index).Update("last_idx", index)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New(fmt.Sprintf("message index %d of topic %s is not greater than the last index", index, topicId))
+	}
+	return nil
+}
+
+func (s *MySqlPubSubMessageStore) Put(message model.IPubSubMessage) error {
+	return s.BulkPut([]model.IPubSubMessage{message})
+}
+
+func (s *MySqlPubSubMessageStore) BulkPut(msgs []model.IPubSubMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		lastIndex := make(map[string]uint32)
+		dMsgs := make([]*DPubSubMessage, len(msgs))
+		for i, m := range msgs {
+			if m.Index() <= lastIndex[m.TopicId()] {
+				return errors.New(fmt.Sprintf("message index %d of topic %s is not increasing", m.Index(), m.TopicId()))
+			}
+			lastIndex[m.TopicId()] = m.Index()
+			dMsgs[i] = s.toDMessage(m)
+		}
+		for topicId, index := range lastIndex {
+			if err := s.advanceLastIndex(tx, topicId, index); err != nil {
+				return err
+			}
+		}
+		return tx.Create(dMsgs).Error
+	})
+}
+
+func (s *MySqlPubSubMessageStore) find(tx *gorm.DB) ([]model.IPubSubMessage, error) {
+	var dMsgs []DPubSubMessage
+	if err := tx.Order("idx").Find(&dMsgs).Error; err != nil {
+		return nil, err
+	}
+	msgs := make([]model.IPubSubMessage, len(dMsgs))
+	for i := range dMsgs {
+		msgs[i] = dMsgs[i].toMessage()
+	}
+	return msgs, nil
+}
+
+func (s *MySqlPubSubMessageStore) Get(topicId string, index uint32) (model.IPubSubMessage, error) {
+	holder := &DPubSubMessage{}
+	err := s.db.Where("topic_id = ? AND idx = ?", topicId, index).First(holder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return holder.toMessage(), nil
+}
+
+func (s *MySqlPubSubMessageStore) GetInRange(topicId string, from uint32, to uint32) ([]model.IPubSubMessage, error) {
+	return s.find(s.db.Where("topic_id = ? AND idx BETWEEN ? AND ?", topicId, from, to))
+}
+
+func (s *MySqlPubSubMessageStore) GetFrom(topicId string, from uint32, max int) ([]model.IPubSubMessage, error) {
+	tx := s.db.Where("topic_id = ? AND idx >= ?", topicId, from)
+	if max > 0 {
+		tx = tx.Limit(max)
+	}
+	return s.find(tx)
+}
+
+func (s *MySqlPubSubMessageStore) LastIndex(topicId string) (uint32, error) {
+	var topics []DPubSubTopic
+	if err := s.db.Where("id = ?", topicId).Limit(1).Find(&topics).Error; err != nil {
+		return 0, err
+	}
+	if len(topics) == 0 {
+		return 0, nil
+	}
+	return topics[0].LastIdx, nil
+}
+
+func (s *MySqlPubSubMessageStore) ApplyRetention(topicId string, policy RetentionPolicy) error {
+	if policy.MaxAge > 0 {
+		if err := s.db.Where("topic_id = ? AND c_time < ?", topicId, time.Now().Add(-policy.MaxAge)).Delete(&DPubSubMessage{}).Error; err != nil {
+			return err
+		}
+	}
+	if policy.MaxBytes <= 0 {
+		return nil
+	}
+	// find the newest message that makes the topic exceed the size limit, it and older messages are dropped
+	rows, err := s.db.Model(&DPubSubMessage{}).Select("idx, LENGTH(payload)").Where("topic_id = ?", topicId).Order("idx DESC").Rows()
+	if err != nil {
+		return err
+	}
+	var size int64
+	var cutoff uint32
+	for rows.Next() {
+		var index uint32
+		var length int64
+		if err = rows.Scan(&index, &length); err != nil {
+			rows.Close()
+			return err
+		}
+		if size += length; size > policy.MaxBytes {
+			cutoff = index
+			break
+		}
+	}
+	rows.Close()
+	if cutoff == 0 {
+		return nil
+	}
+	return s.db.Where("topic_id = ? AND idx <= ?", topicId, cutoff).Delete(&DPubSubMessage{}).Error
+}
+
+func (s *MySqlPubSubMessageStore) PutTopic(record TopicRecord) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		topic := &DPubSubTopic{ID: record.Id, Creator: record.Creator, CTime: record.CTime}
+		// last_idx is only moved by puts
+		if err := tx.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"creator", "c_time"})}).Create(topic).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("topic_id = ?", record.Id).Delete(&DPubSubGroup{}).Error; err != nil {
+			return err
+		}
+		if len(record.Groups) == 0 {
+			return nil
+		}
+		groups := make([]*DPubSubGroup, len(record.Groups))
+		for i, g := range record.Groups {
+			members, err := json.Marshal(g.Members)
+			if err != nil {
+				return err
+			}
+			groups[i] = &DPubSubGroup{TopicId: record.Id, Name: g.Name, Mode: uint8(g.Mode), LastMsgIndex: g.LastMsgIndex, Owner: g.Owner, Members: string(members)}
+		}
+		return tx.Create(groups).Error
+	})
+}
+
+func (s *MySqlPubSubMessageStore) GetTopics() ([]TopicRecord, error) {
+	var topics []DPubSubTopic
+	// rows without creator only track indexes of messages put without a topic record
+	if err := s.db.Where("creator <> ?", "").Find(&topics).Error; err != nil {
+		return nil, err
+	}
+	var groups []DPubSubGroup
+	if err := s.db.Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	topicGroups := make(map[string][]GroupRecord)
+	for _, g := range groups {
+		record := GroupRecord{Name: g.Name, Mode: model.SubscribeMode(g.Mode), LastMsgIndex: g.LastMsgIndex, Owner: g.Owner}
+		if g.Members != "" {
+			if err := json.Unmarshal([]byte(g.Members), &record.Members); err != nil {
+				return nil, err
+			}
+		}
+		topicGroups[g.TopicId] = append(topicGroups[g.TopicId], record)
+	}
+	records := make([]TopicRecord, len(topics))
+	for i, t := range topics {
+		records[i] = TopicRecord{Id: t.ID, Creator: t.Creator, CTime: t.CTime, Groups: topicGroups[t.ID]}
+	}
+	return records, nil
+}
+
+func (s *MySqlPubSubMessageStore) DeleteTopic(topicId string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("topic_id = ?", topicId).Delete(&DPubSubMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("topic_id = ?", topicId).Delete(&DPubSubGroup{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", topicId).Delete(&DPubSubTopic{}).Error
+	})
+}
+
+func (s *MySqlPubSubMessageStore) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
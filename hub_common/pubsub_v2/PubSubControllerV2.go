@@ -1 +1,576 @@
 package pubsub_v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+// DeadLetterPublisher is the creator of the dead-letter topic and the publisher of dead letters
+const DeadLetterPublisher = "pubsub"
+
+// headers of messages pushed to subscribers
+const (
+	MessageHeaderTopic           = "X-Topic"
+	MessageHeaderTopicIndex      = "X-Topic-Index"
+	MessageHeaderSubscriberGroup = "X-Subscriber-Group"
+	// MessageHeaderTopicFilter is the topic filter subscribed if the message is pushed by a filter subscription
+	MessageHeaderTopicFilter = "X-Topic-Filter"
+)
+
+// IPubSubController manages topics and their message queues. Every subscriber group of a topic receives all messages
+// published after the group is created, higher priorities first and in index order within a priority, each message is
+// taken by one consumer of the group. Groups outlive their consumers, a consumer that subscribes to an existing group
+// resumes from the cursor of the group. Messages that are not acknowledged are redelivered, and move to the dead-letter
+// topic after too many failures. The dead-letter topic is reserved, it can't be created, published to or subscribed by
+// clients, dead letters are read by DeadLetters.
+type IPubSubController interface {
+	CreateTopic(id string, creator string) (model.ITopic, error)
+	DeleteTopic(id string) error
+	GetTopic(id string) (model.ITopic, error)
+	Topics() []model.ITopic
+	DescribeTopic(id string) (model.TopicDescriptor, error)
+	// TopicMetrics returns the backlog of each priority level of the topic
+	TopicMetrics(id string) (model.TopicMetrics, error)
+	Publish(topicId string, publisher string, priority uint8, payload []byte) (model.IPubSubMessage, error)
+	// Subscribe adds the consumer to the group, the group is created with the mode of the consumer and owned by the
+	// consumer if it does not exist, other consumers can only join the group once the owner allows them(see
+	// AddGroupMember). topicId can be a topic filter(see TopicTrie), the consumer then joins the group of every
+	// matching topic, including topics created later.
+	Subscribe(topicId string, group string, consumer model.IPubSubConsumer) error
+	// AddGroupMember allows the client to join the group
+	AddGroupMember(topicId string, group string, clientId string) error
+	// Unsubscribe removes the consumer from the group, the group keeps its cursor
+	Unsubscribe(topicId string, group string, consumerId string) error
+	// UnsubscribeAll removes the push consumer from all topics and topic filters, e.g. when it goes offline
+	UnsubscribeAll(consumerId string)
+	DeleteGroup(topicId string, group string) error
+	// SeekGroup rewinds(or forwards) the group so that the next message it receives is the one at index
+	SeekGroup(topicId string, group string, index uint32) error
+	// Pull takes at most max messages for a pull consumer, the messages are acknowledged right away(at most once) unless
+	// manualAck is set(at least once), see IMessageQueue.Pull
+	Pull(topicId string, group string, consumerId string, max int, manualAck bool) ([]model.IPubSubMessage, error)
+	// Ack acknowledges a message delivered to the group
+	Ack(topicId string, group string, index uint32) error
+	// Nack rejects a message delivered to the group, it's redelivered after a backoff
+	Nack(topicId string, group string, index uint32) error
+	// IsDeadLetterTopic tells if the topic is the reserved dead-letter topic
+	IsDeadLetterTopic(id string) bool
+	// DeadLetters returns at most max dead letters from id from that pass filter, all dead letters pass a nil filter
+	DeadLetters(from uint32, max int, filter func(*model.DeadLetter) bool) ([]*model.DeadLetter, error)
+	GetDeadLetter(id uint32) (*model.DeadLetter, error)
+	// ReplayDeadLetter redelivers the message of the dead letter to the group that failed it
+	ReplayDeadLetter(id uint32) error
+	Stop()
+}
+
+type topicEntry struct {
+	topic model.ITopic
+	queue IMessageQueue
+}
+
+// filterSubscription subscribes the consumer to all topics that match the filter
+type filterSubscription struct {
+	filter   string
+	group    string
+	consumer model.IPubSubConsumer
+}
+
+// key tells filter subscriptions of the same filter apart
+func (s *filterSubscription) key() string {
+	return fmt.Sprintf("%s\x00%s", s.group, s.consumer.Id())
+}
+
+func (s *filterSubscription) id() string {
+	return fmt.Sprintf("%s\x00%s", s.filter, s.key())
+}
+
+type PubSubController struct {
+	store     IPubSubMessageStore
+	retention RetentionPolicy
+	delivery  DeliveryPolicy
+	topics    map[string]*topicEntry
+	// filters matches topics with filter subscriptions, subscriptions are also kept in filterSubscriptions by id
+	filters             *TopicTrie
+	filterSubscriptions map[string]*filterSubscription
+	lock                *sync.RWMutex
+}
+
+// NewPubSubController creates a controller with topics and subscriber groups restored from store
+func NewPubSubController(store IPubSubMessageStore, retention RetentionPolicy, delivery DeliveryPolicy) (IPubSubController, error) {
+	c := &PubSubController{
+		store:     store,
+		retention: retention,
+		delivery:  delivery,
+		topics:    make(map[string]*topicEntry),
+		filters:   NewTopicTrie(),
+		lock:      new(sync.RWMutex),
+
+		filterSubscriptions: make(map[string]*filterSubscription),
+	}
+	if err := c.restore(); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+func NewTopicRecord(topic model.ITopic) TopicRecord {
+	groups := topic.Groups()
+	record := TopicRecord{
+		Id:      topic.Id(),
+		Creator: topic.Creator(),
+		CTime:   topic.CTime(),
+		Groups:  make([]GroupRecord, len(groups)),
+	}
+	for i, g := range groups {
+		record.Groups[i] = GroupRecord{Name: g.Name(), Mode: g.Mode(), LastMsgIndex: g.LastMsgIndex(), Owner: g.Owner(), Members: g.Members()}
+	}
+	// keep the record stable so that unchanged records can be told
+	sort.Slice(record.Groups, func(i, j int) bool {
+		return record.Groups[i].Name < record.Groups[j].Name
+	})
+	return record
+}
+
+func (c *PubSubController) restore() error {
+	records, err := c.store.GetTopics()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		topic := model.RestoreTopic(record.Id, record.Creator, record.CTime)
+		for _, g := range record.Groups {
+			owner := g.Owner
+			if owner == "" {
+				// default groups are named after their clients
+				owner = g.Name
+			}
+			group := model.NewSubscriberGroup(g.Name, owner, g.Mode, g.LastMsgIndex)
+			for _, m := range g.Members {
+				group.AddMember(m)
+			}
+			topic.AddGroup(group)
+		}
+		queue, err := NewMessageQueue(topic, c.store, c.retention, c.delivery, c.deadLetter)
+		if err != nil {
+			return err
+		}
+		c.topics[record.Id] = &topicEntry{topic, queue}
+	}
+	return nil
+}
+
+func (c *PubSubController) withWrite(cb func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cb()
+}
+
+func (c *PubSubController) withRead(cb func()) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cb()
+}
+
+func (c *PubSubController) getEntry(id string) (entry *topicEntry, err error) {
+	c.withRead(func() {
+		entry = c.topics[id]
+	})
+	if entry == nil {
+		return nil, errors.New(fmt.Sprintf("topic %s does not exist", id))
+	}
+	return
+}
+
+func (c *PubSubController) IsDeadLetterTopic(id string) bool {
+	return c.delivery.DeadLetterTopic != "" && id == c.delivery.DeadLetterTopic
+}
+
+func (c *PubSubController) CreateTopic(id string, creator string) (topic model.ITopic, err error) {
+	if err = ValidateTopicId(id); err != nil {
+		return nil, err
+	}
+	if c.IsDeadLetterTopic(id) {
+		return nil, errors.New(fmt.Sprintf("topic %s is reserved for dead letters", id))
+	}
+	return c.createTopic(id, creator)
+}
+
+func (c *PubSubController) createTopic(id string, creator string) (topic model.ITopic, err error) {
+	c.withWrite(func() {
+		if c.topics[id] != nil {
+			err = errors.New(fmt.Sprintf("topic %s already exists", id))
+			return
+		}
+		topic = model.NewTopic(id, creator)
+		var queue IMessageQueue
+		if queue, err = NewMessageQueue(topic, c.store, c.retention, c.delivery, c.deadLetter); err != nil {
+			return
+		}
+		if err = queue.Checkpoint(); err != nil {
+			queue.Stop()
+			return
+		}
+		c.topics[id] = &topicEntry{topic, queue}
+	})
+	if err != nil || c.IsDeadLetterTopic(id) {
+		return
+	}
+	var subscriptions []interface{}
+	c.withRead(func() {
+		subscriptions = c.filters.Match(id)
+	})
+	for _, s := range subscriptions {
+		subscription := s.(*filterSubscription)
+		// topics with a group of another mode or a group that does not allow the consumer are skipped
+		c.subscribe(id, subscription.group, subscription.consumer)
+	}
+	return
+}
+
+func (c *PubSubController) DeleteTopic(id string) (err error) {
+	var entry *topicEntry
+	c.withWrite(func() {
+		entry = c.topics[id]
+		delete(c.topics, id)
+	})
+	if entry == nil {
+		return errors.New(fmt.Sprintf("topic %s does not exist", id))
+	}
+	entry.queue.Stop()
+	return c.store.DeleteTopic(id)
+}
+
+func (c *PubSubController) GetTopic(id string) (model.ITopic, error) {
+	entry, err := c.getEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	return entry.topic, nil
+}
+
+func (c *PubSubController) Topics() (topics []model.ITopic) {
+	c.withRead(func() {
+		topics = make([]model.ITopic, 0, len(c.topics))
+		for _, entry := range c.topics {
+			topics = append(topics, entry.topic)
+		}
+	})
+	return
+}
+
+func (c *PubSubController) DescribeTopic(id string) (model.TopicDescriptor, error) {
+	entry, err := c.getEntry(id)
+	if err != nil {
+		return model.TopicDescriptor{}, err
+	}
+	desc := entry.topic.Describe()
+	desc.LastIndex = entry.queue.LastMsgIndex()
+	return desc, nil
+}
+
+func (c *PubSubController) TopicMetrics(id string) (model.TopicMetrics, error) {
+	desc, err := c.DescribeTopic(id)
+	if err != nil {
+		return model.TopicMetrics{}, err
+	}
+	metrics := model.TopicMetrics{
+		Id:        desc.Id,
+		LastIndex: desc.LastIndex,
+		Backlog:   desc.Backlog,
+		Groups:    make(map[string]map[uint8]int, len(desc.Groups)),
+	}
+	for _, g := range desc.Groups {
+		metrics.Groups[g.Name] = g.Backlog
+	}
+	return metrics, nil
+}
+
+func (c *PubSubController) Publish(topicId string, publisher string, priority uint8, payload []byte) (model.IPubSubMessage, error) {
+	if c.IsDeadLetterTopic(topicId) {
+		return nil, errors.New(fmt.Sprintf("topic %s is reserved for dead letters", topicId))
+	}
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return nil, err
+	}
+	return entry.queue.Publish(publisher, priority, payload)
+}
+
+func (c *PubSubController) Subscribe(topicId string, groupName string, consumer model.IPubSubConsumer) error {
+	if groupName == "" {
+		// each consumer has its own group by default
+		groupName = consumer.Id()
+	}
+	if IsTopicFilter(topicId) {
+		return c.subscribeFilter(topicId, groupName, consumer)
+	}
+	return c.subscribe(topicId, groupName, consumer)
+}
+
+func (c *PubSubController) subscribe(topicId string, groupName string, consumer model.IPubSubConsumer) error {
+	if c.IsDeadLetterTopic(topicId) {
+		return errors.New(fmt.Sprintf("topic %s is reserved for dead letters", topicId))
+	}
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	// new groups receive messages published from now on
+	group, err := entry.queue.AddGroup(groupName, consumer.Id(), consumer.Mode())
+	if err != nil {
+		return err
+	}
+	if !group.Allows(consumer.Id()) {
+		return errors.New(fmt.Sprintf("%s is not allowed to join subscriber group %s of topic %s", consumer.Id(), groupName, topicId))
+	}
+	if err = group.AddConsumer(consumer); err != nil {
+		return err
+	}
+	entry.queue.Notify()
+	return entry.queue.Checkpoint()
+}
+
+// matchingTopics returns topics that match the filter, it should be called with the lock held
+func (c *PubSubController) matchingTopics(filter string) []model.ITopic {
+	topics := make([]model.ITopic, 0)
+	for id, entry := range c.topics {
+		if MatchTopicFilter(filter, id) && !c.IsDeadLetterTopic(id) {
+			topics = append(topics, entry.topic)
+		}
+	}
+	return topics
+}
+
+func (c *PubSubController) subscribeFilter(filter string, groupName string, consumer model.IPubSubConsumer) (err error) {
+	subscription := &filterSubscription{filter, groupName, consumer}
+	var topics []model.ITopic
+	// topics created from now on are subscribed by CreateTopic
+	c.withWrite(func() {
+		if err = c.filters.Add(filter, subscription.key(), subscription); err != nil {
+			return
+		}
+		c.filterSubscriptions[subscription.id()] = subscription
+		topics = c.matchingTopics(filter)
+	})
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		// topics with a group of another mode or a group that does not allow the consumer are skipped
+		c.subscribe(topic.Id(), groupName, consumer)
+	}
+	return nil
+}
+
+func (c *PubSubController) Unsubscribe(topicId string, groupName string, consumerId string) error {
+	if groupName == "" {
+		groupName = consumerId
+	}
+	if IsTopicFilter(topicId) {
+		return c.unsubscribeFilter(topicId, groupName, consumerId)
+	}
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	group := entry.topic.GetGroup(groupName)
+	if group == nil || !group.RemoveConsumer(consumerId) {
+		return errors.New(fmt.Sprintf("%s is not subscribed to topic %s in group %s", consumerId, topicId, groupName))
+	}
+	return nil
+}
+
+func (c *PubSubController) unsubscribeFilter(filter string, groupName string, consumerId string) error {
+	var subscription *filterSubscription
+	var topics []model.ITopic
+	c.withWrite(func() {
+		for _, s := range c.filterSubscriptions {
+			if s.filter == filter && s.group == groupName && s.consumer.Id() == consumerId {
+				subscription = s
+				break
+			}
+		}
+		if subscription == nil {
+			return
+		}
+		c.filters.Remove(filter, subscription.key())
+		delete(c.filterSubscriptions, subscription.id())
+		topics = c.matchingTopics(filter)
+	})
+	if subscription == nil {
+		return errors.New(fmt.Sprintf("%s is not subscribed to topic filter %s in group %s", consumerId, filter, groupName))
+	}
+	for _, topic := range topics {
+		if group := topic.GetGroup(groupName); group != nil {
+			group.RemoveConsumer(consumerId)
+		}
+	}
+	return nil
+}
+
+func (c *PubSubController) UnsubscribeAll(consumerId string) {
+	c.withWrite(func() {
+		for k, s := range c.filterSubscriptions {
+			if s.consumer.Id() == consumerId && s.consumer.Mode() == model.SubModePush {
+				c.filters.Remove(s.filter, s.key())
+				delete(c.filterSubscriptions, k)
+			}
+		}
+	})
+	for _, topic := range c.Topics() {
+		for _, group := range topic.Groups() {
+			if group.Mode() == model.SubModePush {
+				group.RemoveConsumer(consumerId)
+			}
+		}
+	}
+}
+
+func (c *PubSubController) DeleteGroup(topicId string, group string) error {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	if !entry.topic.RemoveGroup(group) {
+		return errors.New(fmt.Sprintf("subscriber group %s does not exist in topic %s", group, topicId))
+	}
+	return entry.queue.Checkpoint()
+}
+
+func (c *PubSubController) AddGroupMember(topicId string, group string, clientId string) error {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	g := entry.topic.GetGroup(group)
+	if g == nil {
+		return errors.New(fmt.Sprintf("subscriber group %s does not exist in topic %s", group, topicId))
+	}
+	g.AddMember(clientId)
+	return entry.queue.Checkpoint()
+}
+
+func (c *PubSubController) SeekGroup(topicId string, group string, index uint32) error {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	return entry.queue.Seek(group, index)
+}
+
+func (c *PubSubController) Pull(topicId string, group string, consumerId string, max int, manualAck bool) ([]model.IPubSubMessage, error) {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		group = consumerId
+	}
+	return entry.queue.Pull(group, consumerId, max, manualAck)
+}
+
+// deadLetter publishes the message to the dead-letter topic, the topic is created on demand
+func (c *PubSubController) deadLetter(group string, message model.IPubSubMessage, attempts int) error {
+	deadLetterTopic := c.delivery.DeadLetterTopic
+	if deadLetterTopic == "" || message.TopicId() == deadLetterTopic {
+		// dead letters of dead letters are dropped
+		return nil
+	}
+	entry, err := c.getEntry(deadLetterTopic)
+	if err != nil {
+		// the topic may have been created by dead letters of another topic in the meantime
+		c.createTopic(deadLetterTopic, DeadLetterPublisher)
+		if entry, err = c.getEntry(deadLetterTopic); err != nil {
+			return err
+		}
+	}
+	payload, err := json.Marshal(model.NewDeadLetter(group, message, attempts))
+	if err != nil {
+		return err
+	}
+	_, err = entry.queue.Publish(DeadLetterPublisher, message.Priority(), payload)
+	return err
+}
+
+func (c *PubSubController) Ack(topicId string, group string, index uint32) error {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	return entry.queue.Ack(group, index)
+}
+
+func (c *PubSubController) Nack(topicId string, group string, index uint32) error {
+	entry, err := c.getEntry(topicId)
+	if err != nil {
+		return err
+	}
+	return entry.queue.Nack(group, index)
+}
+
+func (c *PubSubController) DeadLetters(from uint32, max int, filter func(*model.DeadLetter) bool) ([]*model.DeadLetter, error) {
+	if c.delivery.DeadLetterTopic == "" {
+		return nil, errors.New("dead-letter topic is not configured")
+	}
+	deadLetters := make([]*model.DeadLetter, 0, max)
+	// keep reading until there are max dead letters that pass the filter or no more dead letters
+	for len(deadLetters) < max {
+		msgs, err := c.store.GetFrom(c.delivery.DeadLetterTopic, from, max)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, m := range msgs {
+			deadLetter, err := model.ParseDeadLetter(m)
+			if err == nil && (filter == nil || filter(deadLetter)) && len(deadLetters) < max {
+				deadLetters = append(deadLetters, deadLetter)
+			}
+		}
+		from = msgs[len(msgs)-1].Index() + 1
+	}
+	return deadLetters, nil
+}
+
+func (c *PubSubController) GetDeadLetter(id uint32) (*model.DeadLetter, error) {
+	if c.delivery.DeadLetterTopic == "" {
+		return nil, errors.New("dead-letter topic is not configured")
+	}
+	m, err := c.store.Get(c.delivery.DeadLetterTopic, id)
+	if err == ErrMessageNotFound {
+		return nil, errors.New(fmt.Sprintf("dead letter %d does not exist", id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return model.ParseDeadLetter(m)
+}
+
+func (c *PubSubController) ReplayDeadLetter(id uint32) error {
+	deadLetter, err := c.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	entry, err := c.getEntry(deadLetter.Topic)
+	if err != nil {
+		return err
+	}
+	return entry.queue.Replay(deadLetter.Group, deadLetter.Index)
+}
+
+// Stop stops all queues and closes the store
+func (c *PubSubController) Stop() {
+	c.withWrite(func() {
+		for id, entry := range c.topics {
+			entry.queue.Stop()
+			delete(c.topics, id)
+		}
+	})
+	c.store.Close()
+}
@@ -0,0 +1,389 @@
+package pubsub_v2
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+type testConsumer struct {
+	id       string
+	mode     model.SubscribeMode
+	fail     bool
+	received []uint32
+	// ack is called with consumed messages if the consumer acknowledges by itself
+	ack  func(index uint32)
+	lock *sync.Mutex
+}
+
+func newTestConsumer(id string, mode model.SubscribeMode) *testConsumer {
+	return &testConsumer{id: id, mode: mode, lock: new(sync.Mutex)}
+}
+
+func (c *testConsumer) Id() string {
+	return c.id
+}
+
+func (c *testConsumer) Mode() model.SubscribeMode {
+	return c.mode
+}
+
+func (c *testConsumer) Consume(message model.IPubSubMessage) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fail {
+		return errors.New("consumer failure")
+	}
+	c.received = append(c.received, message.Index())
+	if c.ack != nil {
+		go c.ack(message.Index())
+	}
+	return nil
+}
+
+func (c *testConsumer) ManualAck() bool {
+	return c.ack != nil
+}
+
+func (c *testConsumer) Received() []uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]uint32{}, c.received...)
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return false
+}
+
+// waitLong waits for conditions that are met by redelivery
+func waitLong(cond func() bool) bool {
+	return waitFor(cond) || waitFor(cond) || waitFor(cond)
+}
+
+func publishN(c IPubSubController, topic string, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := c.Publish(topic, "publisher", 0, ([]byte)(strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPubSubController(t *testing.T) {
+	tg := test_utils.NewTestGroup("PubSubController", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Push", "each group should get every message in order, by one consumer", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			a1, a2, b := newTestConsumer("a1", model.SubModePush), newTestConsumer("a2", model.SubModePush), newTestConsumer("b", model.SubModePush)
+			if c.Subscribe("t", "a", a1) != nil || c.AddGroupMember("t", "a", "a2") != nil ||
+				c.Subscribe("t", "a", a2) != nil || c.Subscribe("t", "b", b) != nil {
+				return false
+			}
+			if publishN(c, "t", 10) != nil {
+				return false
+			}
+			if !waitFor(func() bool { return len(a1.Received())+len(a2.Received()) == 10 && len(b.Received()) == 10 }) {
+				t.Log("not all messages are received ", a1.Received(), a2.Received(), b.Received())
+				return false
+			}
+			for i, index := range b.Received() {
+				if index != uint32(i+1) {
+					return false
+				}
+			}
+			return len(a1.Received()) > 0 && len(a2.Received()) > 0
+		}),
+		test_utils.NewTestCase("Redelivery", "messages should be retried in order once the group can consume", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			consumer.fail = true
+			c.Subscribe("t", "", consumer)
+			publishN(c, "t", 3)
+			time.Sleep(time.Millisecond * 50)
+			consumer.lock.Lock()
+			consumer.fail = false
+			consumer.lock.Unlock()
+			return waitFor(func() bool {
+				r := consumer.Received()
+				return len(r) == 3 && r[0] == 1 && r[2] == 3
+			})
+		}),
+		test_utils.NewTestCase("Priority", "higher priorities should overtake the backlog of lower ones", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			consumer.fail = true
+			c.Subscribe("t", "", consumer)
+			publishN(c, "t", 5)
+			c.Publish("t", "publisher", 9, nil)
+			c.Publish("t", "publisher", 9, nil)
+			metrics, err := c.TopicMetrics("t")
+			if err != nil || metrics.Backlog[0] != 5 || metrics.Backlog[9] != 2 || metrics.Groups["a"][9] != 2 {
+				t.Log("unexpected metrics ", metrics)
+				return false
+			}
+			consumer.lock.Lock()
+			consumer.fail = false
+			consumer.lock.Unlock()
+			if !waitFor(func() bool { return len(consumer.Received()) == 7 }) {
+				return false
+			}
+			r := consumer.Received()
+			metrics, _ = c.TopicMetrics("t")
+			return r[0] == 6 && r[1] == 7 && r[2] == 1 && r[6] == 5 && len(metrics.Backlog) == 0
+		}),
+		test_utils.NewTestCase("Ack", "messages that are not acknowledged should be redelivered", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DeliveryPolicy{AckTimeout: time.Millisecond * 50})
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			consumer.ack = func(index uint32) {
+				if index != 2 {
+					c.Ack("t", "a", index)
+				}
+			}
+			c.Subscribe("t", "", consumer)
+			publishN(c, "t", 3)
+			if !waitLong(func() bool { return len(consumer.Received()) >= 4 }) {
+				return false
+			}
+			r := consumer.Received()
+			desc, _ := c.DescribeTopic("t")
+			return r[3] == 2 && desc.Groups[0].LastMsgIndex == 1 && desc.Groups[0].InFlight == 1
+		}),
+		test_utils.NewTestCase("DeadLetter", "messages rejected too many times should go to the dead-letter topic", func() bool {
+			policy := DeliveryPolicy{AckTimeout: time.Second, RetryBackoff: time.Millisecond * 10, MaxDeliveries: 2, DeadLetterTopic: "dead"}
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, policy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			reject := true
+			consumer.ack = func(index uint32) {
+				consumer.lock.Lock()
+				rejected := reject
+				consumer.lock.Unlock()
+				if rejected {
+					c.Nack("t", "g", index)
+				} else {
+					c.Ack("t", "g", index)
+				}
+			}
+			c.Subscribe("t", "g", consumer)
+			c.Publish("t", "publisher", 0, ([]byte)("poison"))
+			var deadLetters []*model.DeadLetter
+			if !waitLong(func() bool {
+				deadLetters, _ = c.DeadLetters(0, 10, nil)
+				return len(deadLetters) == 1
+			}) {
+				return false
+			}
+			if _, err := c.CreateTopic("dead", "creator"); err == nil {
+				return false
+			}
+			if c.Subscribe("dead", "", newTestConsumer("b", model.SubModePush)) == nil ||
+				c.Subscribe("#", "", newTestConsumer("b", model.SubModePush)) != nil {
+				return false
+			}
+			if _, err := c.Publish("dead", "publisher", 0, nil); err == nil {
+				return false
+			}
+			if filtered, _ := c.DeadLetters(0, 10, func(d *model.DeadLetter) bool { return d.Topic != "t" }); len(filtered) != 0 {
+				return false
+			}
+			if desc, _ := c.DescribeTopic("dead"); len(desc.Groups) != 0 {
+				return false
+			}
+			d := deadLetters[0]
+			if d.Topic != "t" || d.Group != "g" || d.Index != 1 || d.Attempts != 2 || string(d.Payload) != "poison" || len(consumer.Received()) != 2 {
+				t.Log("unexpected dead letter ", d, consumer.Received())
+				return false
+			}
+			desc, _ := c.DescribeTopic("t")
+			if desc.Groups[0].LastMsgIndex != 1 {
+				return false
+			}
+			consumer.lock.Lock()
+			reject = false
+			consumer.lock.Unlock()
+			if c.ReplayDeadLetter(d.Id) != nil || c.ReplayDeadLetter(d.Id+1) == nil {
+				return false
+			}
+			return waitFor(func() bool {
+				desc, _ := c.DescribeTopic("t")
+				return len(consumer.Received()) == 3 && desc.Groups[0].InFlight == 0
+			})
+		}),
+		test_utils.NewTestCase("Filter", "filter subscriptions should receive messages of all matching topics", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("sensors/1/temperature", "creator")
+			c.CreateTopic("sensors/1/humidity", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			if c.Subscribe("sensors/+/temperature", "", consumer) != nil || c.Subscribe("sensors/#/x", "", consumer) == nil {
+				return false
+			}
+			if _, err := c.CreateTopic("sensors/+", "creator"); err == nil {
+				return false
+			}
+			c.CreateTopic("sensors/2/temperature", "creator")
+			publishN(c, "sensors/1/temperature", 1)
+			publishN(c, "sensors/1/humidity", 1)
+			publishN(c, "sensors/2/temperature", 1)
+			if !waitFor(func() bool { return len(consumer.Received()) == 2 }) {
+				return false
+			}
+			if c.Unsubscribe("sensors/+/temperature", "", "a") != nil || c.Unsubscribe("sensors/+/temperature", "", "a") == nil {
+				return false
+			}
+			c.CreateTopic("sensors/3/temperature", "creator")
+			publishN(c, "sensors/3/temperature", 1)
+			publishN(c, "sensors/1/temperature", 1)
+			time.Sleep(time.Millisecond * 50)
+			return len(consumer.Received()) == 2
+		}),
+		test_utils.NewTestCase("Pull", "pulled messages should not be taken by other consumers of the group", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			c.Subscribe("t", "g", newTestConsumer("p1", model.SubModePull))
+			c.AddGroupMember("t", "g", "p2")
+			c.Subscribe("t", "g", newTestConsumer("p2", model.SubModePull))
+			publishN(c, "t", 5)
+			first, err := c.Pull("t", "g", "p1", 3, false)
+			if err != nil || len(first) != 3 || first[0].Index() != 1 {
+				return false
+			}
+			second, err := c.Pull("t", "g", "p2", 10, false)
+			if err != nil || len(second) != 2 || second[0].Index() != 4 {
+				return false
+			}
+			_, err = c.Pull("t", "g", "p3", 10, false)
+			return err != nil
+		}),
+		test_utils.NewTestCase("PullAck", "messages pulled with manual acknowledgement should be redelivered until acknowledged", func() bool {
+			policy := DeliveryPolicy{AckTimeout: time.Millisecond * 100, RetryBackoff: time.Millisecond * 10, MaxDeliveries: 2, DeadLetterTopic: "dead"}
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, policy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			c.Subscribe("t", "g", newTestConsumer("p", model.SubModePull))
+			publishN(c, "t", 2)
+			msgs, err := c.Pull("t", "g", "p", 10, true)
+			if err != nil || len(msgs) != 2 || c.Ack("t", "g", 1) != nil {
+				return false
+			}
+			if desc, _ := c.DescribeTopic("t"); desc.Groups[0].InFlight != 1 || desc.Groups[0].LastMsgIndex != 1 {
+				return false
+			}
+			// the message that is not acknowledged is pulled again, and then goes to the dead-letter topic
+			if !waitFor(func() bool {
+				msgs, _ = c.Pull("t", "g", "p", 10, true)
+				return len(msgs) == 1 && msgs[0].Index() == 2
+			}) {
+				return false
+			}
+			return waitLong(func() bool {
+				deadLetters, _ := c.DeadLetters(0, 10, nil)
+				return len(deadLetters) == 1 && deadLetters[0].Index == 2 && deadLetters[0].Attempts == 2
+			})
+		}),
+		test_utils.NewTestCase("Mode", "consumers of a group should share the same mode", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			c.Subscribe("t", "g", newTestConsumer("a", model.SubModePull))
+			c.AddGroupMember("t", "g", "b")
+			return c.Subscribe("t", "g", newTestConsumer("b", model.SubModePush)) != nil
+		}),
+		test_utils.NewTestCase("Members", "only the owner and allowed members should join a group", func() bool {
+			store := NewMemoryPubSubMessageStore()
+			c, _ := NewPubSubController(store, RetentionPolicy{}, DefaultDeliveryPolicy)
+			c.CreateTopic("t", "creator")
+			if c.Subscribe("t", "g", newTestConsumer("a", model.SubModePush)) != nil {
+				return false
+			}
+			if c.Subscribe("t", "g", newTestConsumer("b", model.SubModePush)) == nil {
+				return false
+			}
+			if c.AddGroupMember("t", "g", "b") != nil || c.AddGroupMember("t", "x", "b") == nil {
+				return false
+			}
+			c.Stop()
+			c, err := NewPubSubController(store, RetentionPolicy{}, DefaultDeliveryPolicy)
+			if err != nil {
+				return false
+			}
+			defer c.Stop()
+			return c.Subscribe("t", "g", newTestConsumer("b", model.SubModePush)) == nil &&
+				c.Subscribe("t", "g", newTestConsumer("c", model.SubModePush)) != nil
+		}),
+		test_utils.NewTestCase("Resume", "groups should resume from their cursors after restart", func() bool {
+			store := NewMemoryPubSubMessageStore()
+			c, _ := NewPubSubController(store, RetentionPolicy{}, DefaultDeliveryPolicy)
+			c.CreateTopic("t", "creator")
+			c.Subscribe("t", "g", newTestConsumer("p", model.SubModePull))
+			publishN(c, "t", 4)
+			c.Pull("t", "g", "p", 3, false)
+			c.Unsubscribe("t", "g", "p")
+			c.Stop()
+			c, err := NewPubSubController(store, RetentionPolicy{}, DefaultDeliveryPolicy)
+			if err != nil {
+				return false
+			}
+			defer c.Stop()
+			c.Subscribe("t", "g", newTestConsumer("p", model.SubModePull))
+			if msg, _ := c.Publish("t", "publisher", 0, nil); msg == nil || msg.Index() != 5 {
+				return false
+			}
+			msgs, err := c.Pull("t", "g", "p", 10, false)
+			return err == nil && len(msgs) == 2 && msgs[0].Index() == 4
+		}),
+		test_utils.NewTestCase("Seek", "rewound groups should receive messages again", func() bool {
+			c, _ := NewPubSubController(NewMemoryPubSubMessageStore(), RetentionPolicy{}, DefaultDeliveryPolicy)
+			defer c.Stop()
+			c.CreateTopic("t", "creator")
+			consumer := newTestConsumer("a", model.SubModePush)
+			c.Subscribe("t", "g", consumer)
+			publishN(c, "t", 3)
+			if !waitFor(func() bool { return len(consumer.Received()) == 3 }) {
+				return false
+			}
+			if c.SeekGroup("t", "g", 2) != nil || c.SeekGroup("t", "g", 5) == nil {
+				return false
+			}
+			return waitFor(func() bool {
+				r := consumer.Received()
+				return len(r) == 5 && r[3] == 2 && r[4] == 3
+			})
+		}),
+		test_utils.NewTestCase("Retention", "messages out of the retention policy should be dropped", func() bool {
+			store := NewMemoryPubSubMessageStore()
+			for i := 1; i <= 4; i++ {
+				store.Put(model.NewPubSubMessage("t", uint32(i), 0, "p", time.Now().Add(-time.Duration(5-i)*time.Hour), make([]byte, 10)))
+			}
+			store.ApplyRetention("t", RetentionPolicy{MaxAge: time.Hour*3 + time.Minute*30})
+			msgs, _ := store.GetFrom("t", 0, 0)
+			if len(msgs) != 3 || msgs[0].Index() != 2 {
+				return false
+			}
+			store.ApplyRetention("t", RetentionPolicy{MaxBytes: 15})
+			msgs, _ = store.GetFrom("t", 0, 0)
+			last, _ := store.LastIndex("t")
+			return len(msgs) == 1 && msgs[0].Index() == 4 && last == 4
+		}),
+	}).Do(t)
+}
@@ -1,19 +1,234 @@
 package pubsub_v2
 
-// relations:
-// tbl messages
-// index uint32 | topic_id string | payload blob | priority uint8
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"whub/hub_common/pubsub_v2/model"
+)
 
-// tbl subscriber_groups
-//
+// ErrMessageNotFound is returned by Get if the message does not exist, e.g. it has been dropped by retention
+var ErrMessageNotFound = errors.New("message not found")
 
+// TopicRecord is the persisted state of a topic, subscriber groups are persisted with their cursors so that they can
+// resume from where they were
+type TopicRecord struct {
+	Id      string        `json:"id"`
+	Creator string        `json:"creator"`
+	CTime   time.Time     `json:"cTime"`
+	Groups  []GroupRecord `json:"groups"`
+}
+
+type GroupRecord struct {
+	Name         string              `json:"name"`
+	Mode         model.SubscribeMode `json:"mode"`
+	LastMsgIndex uint32              `json:"lastMsgIndex"`
+	// Owner is empty for groups persisted before groups had owners, they are owned by the client named after them
+	Owner   string   `json:"owner"`
+	Members []string `json:"members,omitempty"`
+}
+
+// RetentionPolicy tells how long messages are kept, regardless of whether they have been consumed. Zero values mean
+// no limit.
+type RetentionPolicy struct {
+	MaxAge time.Duration
+	// MaxBytes is the max size of message payloads kept per topic
+	MaxBytes int64
+}
+
+var DefaultRetentionPolicy = RetentionPolicy{
+	MaxAge:   time.Hour * 24,
+	MaxBytes: 64 * 1024 * 1024,
+}
+
+func (p RetentionPolicy) exceeded(age time.Duration, size int64) bool {
+	return p.MaxAge > 0 && age > p.MaxAge || p.MaxBytes > 0 && size > p.MaxBytes
+}
+
+// IPubSubMessageStore stores topics and their messages in index order, indexes of a topic are expected to be put in
+// increasing order
 type IPubSubMessageStore interface {
-	Put(IPubSubMessage) error
-	BulkPut([]IPubSubMessage) error
-	Get(uint32) (IPubSubMessage, error)
-	GetInRange(uint32, uint32) ([]IPubSubMessage, error)
-	GetBySubscriberGroupFrom(string, uint32) ([]IPubSubMessage, error)
+	Put(model.IPubSubMessage) error
+	BulkPut([]model.IPubSubMessage) error
+	Get(topicId string, index uint32) (model.IPubSubMessage, error)
+	// GetInRange gets messages with from <= index <= to
+	GetInRange(topicId string, from uint32, to uint32) ([]model.IPubSubMessage, error)
+	// GetFrom gets at most max messages with index >= from
+	GetFrom(topicId string, from uint32, max int) ([]model.IPubSubMessage, error)
+	// LastIndex is the last index ever put to the topic, even if the message has been dropped by retention
+	LastIndex(topicId string) (uint32, error)
+	// ApplyRetention drops messages of the topic that are out of the policy
+	ApplyRetention(topicId string, policy RetentionPolicy) error
+	PutTopic(TopicRecord) error
+	GetTopics() ([]TopicRecord, error)
+	DeleteTopic(topicId string) error
+	Close() error
+}
+
+// MemoryPubSubMessageStore keeps messages in memory, messages are lost once the server stops
+type MemoryPubSubMessageStore struct {
+	topics    map[string]TopicRecord
+	msgs      map[string][]model.IPubSubMessage
+	lastIndex map[string]uint32
+	lock      *sync.RWMutex
+}
+
+func NewMemoryPubSubMessageStore() IPubSubMessageStore {
+	return &MemoryPubSubMessageStore{
+		topics:    make(map[string]TopicRecord),
+		msgs:      make(map[string][]model.IPubSubMessage),
+		lastIndex: make(map[string]uint32),
+		lock:      new(sync.RWMutex),
+	}
+}
+
+func (s *MemoryPubSubMessageStore) withWrite(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *MemoryPubSubMessageStore) withRead(cb func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	cb()
+}
+
+func checkIndex(lastIndex uint32, message model.IPubSubMessage) error {
+	if message.Index() <= lastIndex {
+		return errors.New(fmt.Sprintf("message index %d of topic %s is not greater than the last index %d", message.Index(), message.TopicId(), lastIndex))
+	}
+	return nil
+}
+
+func (s *MemoryPubSubMessageStore) put(message model.IPubSubMessage) error {
+	if err := checkIndex(s.lastIndex[message.TopicId()], message); err != nil {
+		return err
+	}
+	s.msgs[message.TopicId()] = append(s.msgs[message.TopicId()], message)
+	s.lastIndex[message.TopicId()] = message.Index()
+	return nil
+}
+
+func (s *MemoryPubSubMessageStore) Put(message model.IPubSubMessage) (err error) {
+	s.withWrite(func() {
+		err = s.put(message)
+	})
+	return
+}
+
+func (s *MemoryPubSubMessageStore) BulkPut(msgs []model.IPubSubMessage) (err error) {
+	s.withWrite(func() {
+		for _, m := range msgs {
+			if err = s.put(m); err != nil {
+				return
+			}
+		}
+	})
+	return
+}
+
+// search returns the position of the first message with index >= index
+func search(msgs []model.IPubSubMessage, index uint32) int {
+	return sort.Search(len(msgs), func(i int) bool {
+		return msgs[i].Index() >= index
+	})
+}
+
+func (s *MemoryPubSubMessageStore) Get(topicId string, index uint32) (message model.IPubSubMessage, err error) {
+	s.withRead(func() {
+		msgs := s.msgs[topicId]
+		i := search(msgs, index)
+		if i < len(msgs) && msgs[i].Index() == index {
+			message = msgs[i]
+		}
+	})
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+	return
+}
+
+func (s *MemoryPubSubMessageStore) GetInRange(topicId string, from uint32, to uint32) (result []model.IPubSubMessage, err error) {
+	s.withRead(func() {
+		msgs := s.msgs[topicId]
+		result = make([]model.IPubSubMessage, 0)
+		for i := search(msgs, from); i < len(msgs) && msgs[i].Index() <= to; i++ {
+			result = append(result, msgs[i])
+		}
+	})
+	return
+}
+
+func (s *MemoryPubSubMessageStore) GetFrom(topicId string, from uint32, max int) (result []model.IPubSubMessage, err error) {
+	s.withRead(func() {
+		msgs := s.msgs[topicId]
+		i := search(msgs, from)
+		end := len(msgs)
+		if max > 0 && i+max < end {
+			end = i + max
+		}
+		result = make([]model.IPubSubMessage, end-i)
+		copy(result, msgs[i:end])
+	})
+	return
+}
+
+func (s *MemoryPubSubMessageStore) LastIndex(topicId string) (index uint32, err error) {
+	s.withRead(func() {
+		index = s.lastIndex[topicId]
+	})
+	return
+}
+
+func (s *MemoryPubSubMessageStore) ApplyRetention(topicId string, policy RetentionPolicy) error {
+	now := time.Now()
+	s.withWrite(func() {
+		msgs := s.msgs[topicId]
+		var size int64
+		for _, m := range msgs {
+			size += int64(len(m.Payload()))
+		}
+		i := 0
+		for ; i < len(msgs) && policy.exceeded(now.Sub(msgs[i].CTime()), size); i++ {
+			size -= int64(len(msgs[i].Payload()))
+		}
+		if i > 0 {
+			// copy so that the dropped messages can be collected
+			s.msgs[topicId] = append(make([]model.IPubSubMessage, 0, len(msgs)-i), msgs[i:]...)
+		}
+	})
+	return nil
+}
+
+func (s *MemoryPubSubMessageStore) PutTopic(record TopicRecord) error {
+	s.withWrite(func() {
+		s.topics[record.Id] = record
+	})
+	return nil
+}
+
+func (s *MemoryPubSubMessageStore) GetTopics() (records []TopicRecord, err error) {
+	s.withRead(func() {
+		records = make([]TopicRecord, 0, len(s.topics))
+		for _, r := range s.topics {
+			records = append(records, r)
+		}
+	})
+	return
+}
+
+func (s *MemoryPubSubMessageStore) DeleteTopic(topicId string) error {
+	s.withWrite(func() {
+		delete(s.topics, topicId)
+		delete(s.msgs, topicId)
+		delete(s.lastIndex, topicId)
+	})
+	return nil
 }
 
-type MySqlPubSubMessageStore struct {
+func (s *MemoryPubSubMessageStore) Close() error {
+	return nil
 }
@@ -1,32 +1,462 @@
 package pubsub_v2
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"whub/hub_common/pubsub_v2/model"
+)
+
+const (
+	// DefaultDispatchBatchSize is the max number of messages dispatched to a group before other groups get their turn
+	DefaultDispatchBatchSize = 64
+	// DefaultScanBatchSize is the number of messages read from store at a time to rebuild backlogs
+	DefaultScanBatchSize = 1024
+	// DefaultRedispatchInterval is the interval to retry push groups that failed to consume messages
+	DefaultRedispatchInterval = time.Second
+	// DefaultCheckpointInterval is the interval to persist group cursors and apply the retention policy
+	DefaultCheckpointInterval = time.Second * 10
+)
+
+// DeliveryPolicy tells how messages consumed by consumers that acknowledge by themselves are redelivered
+type DeliveryPolicy struct {
+	// AckTimeout is how long a delivered message waits for acknowledgement before it's redelivered, it doubles on
+	// every attempt up to MaxBackoff
+	AckTimeout time.Duration
+	// RetryBackoff is how long a rejected message waits before it's redelivered, it doubles on every attempt up to
+	// MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// MaxDeliveries is the number of failed deliveries after which the message moves to DeadLetterTopic, 0 for no
+	// limit
+	MaxDeliveries int
+	// DeadLetterTopic receives messages that failed MaxDeliveries times, such messages are dropped if it's empty
+	DeadLetterTopic string
+}
+
+var DefaultDeliveryPolicy = DeliveryPolicy{
+	AckTimeout:      time.Second * 30,
+	RetryBackoff:    time.Second,
+	MaxBackoff:      time.Minute * 5,
+	MaxDeliveries:   5,
+	DeadLetterTopic: "dead-letters",
+}
+
+// backoff doubles base for every attempt after the first one
+func (p DeliveryPolicy) backoff(base time.Duration, attempt int) time.Duration {
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || base < p.MaxBackoff); i++ {
+		base *= 2
+	}
+	if p.MaxBackoff > 0 && base > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return base
+}
+
+// MaxAckTimeout is the longest time a delivered message waits for acknowledgement, i.e. the ack timeout of the last
+// delivery, AckTimeout is taken if deliveries are not limited by either MaxDeliveries or MaxBackoff
+func (p DeliveryPolicy) MaxAckTimeout() time.Duration {
+	if p.MaxDeliveries > 0 {
+		return p.backoff(p.AckTimeout, p.MaxDeliveries)
+	}
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return p.AckTimeout
+}
+
+// DeadLetterHandler takes a message the group failed to consume attempts times
+type DeadLetterHandler func(group string, message model.IPubSubMessage, attempts int) error
 
 // every message will first be put in store and then queue will fetch from store
-// MessageQueue is a single coroutine that handles message dispatching
+// MessageQueue is a single coroutine that handles message dispatching. Each subscriber group has a backlog of
+// messages it has not received, higher priorities are dispatched first while lower ones still get their turns(see
+// model.PriorityBacklog). Messages taken by consumers that acknowledge by themselves stay in flight until they are
+// acknowledged, or go back to the backlog by DeliveryPolicy.
 type IMessageQueue interface {
-	Put(IPubSubMessage)
+	// Publish assigns the next index to the payload and stores the message
+	Publish(publisher string, priority uint8, payload []byte) (model.IPubSubMessage, error)
+	// AddGroup creates a subscriber group owned by owner that receives messages published from now on, the existing
+	// group is returned if there is one with the same name
+	AddGroup(name string, owner string, mode model.SubscribeMode) (model.ISubscriberGroup, error)
+	// Pull takes at most max messages for a pull subscriber group, each message is taken by one consumer only. Messages
+	// are acknowledged right away unless manualAck is set, they are then redelivered like pushed messages until they
+	// are acknowledged by Ack.
+	Pull(group string, consumerId string, max int, manualAck bool) ([]model.IPubSubMessage, error)
+	LastMsgIndex() uint32
+	// Ack acknowledges a message delivered to the group
+	Ack(group string, index uint32) error
+	// Nack rejects a message delivered to the group, the message is redelivered after a backoff
+	Nack(group string, index uint32) error
+	// Replay redelivers a retained message to the group
+	Replay(group string, index uint32) error
+	// Seek moves the cursor of the group so that the next message the group receives is the one at index, it's used
+	// to rewind a group to replay messages that are still retained
+	Seek(group string, index uint32) error
+	// Checkpoint persists the topic and cursors of its subscriber groups
+	Checkpoint() error
+	// Notify wakes up the dispatcher, e.g. when a push consumer joins
+	Notify()
 	Stop()
 }
 
 type MessageQueue struct {
-	// dispatch queue
-	q          chan IPubSubMessage
+	topic     model.ITopic
+	store     IPubSubMessageStore
+	retention RetentionPolicy
+	delivery  DeliveryPolicy
+	// deadLetter takes messages that failed too many times, they are dropped if it's nil
+	deadLetter DeadLetterHandler
+	signal     chan struct{}
+	// lock serializes index assignment and changes of group backlogs
+	lock       *sync.Mutex
 	ctx        context.Context
 	cancelFunc func()
 
 	lastMsgIndex uint32
+	// checkpoint is the last persisted record, used to skip checkpoints that change nothing
+	checkpoint []byte
 }
 
-func (q *MessageQueue) Put(m IPubSubMessage) {
-	q.q <- m
+// NewMessageQueue creates the queue of topic, indexes continue from the last index of the topic in store. Backlogs of
+// existing groups of the topic are rebuilt from their cursors.
+func NewMessageQueue(topic model.ITopic, store IPubSubMessageStore, retention RetentionPolicy, delivery DeliveryPolicy, deadLetter DeadLetterHandler) (IMessageQueue, error) {
+	lastMsgIndex, err := store.LastIndex(topic.Id())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &MessageQueue{
+		topic:        topic,
+		store:        store,
+		retention:    retention,
+		delivery:     delivery,
+		deadLetter:   deadLetter,
+		signal:       make(chan struct{}, 1),
+		lock:         new(sync.Mutex),
+		ctx:          ctx,
+		cancelFunc:   cancel,
+		lastMsgIndex: lastMsgIndex,
+	}
+	for _, group := range topic.Groups() {
+		entries, err := q.scanBacklog(group.LastMsgIndex() + 1)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		group.Reset(group.LastMsgIndex(), entries)
+	}
+	go q.dispatcher()
+	return q, nil
+}
+
+func (q *MessageQueue) withLock(cb func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	cb()
+}
+
+// scanBacklog reads backlog entries of retained messages from index from
+func (q *MessageQueue) scanBacklog(from uint32) ([]model.BacklogEntry, error) {
+	entries := make([]model.BacklogEntry, 0)
+	for {
+		msgs, err := q.store.GetFrom(q.topic.Id(), from, DefaultScanBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			entries = append(entries, model.BacklogEntry{Index: m.Index(), Priority: m.Priority(), CTime: m.CTime()})
+		}
+		if len(msgs) < DefaultScanBatchSize {
+			return entries, nil
+		}
+		from = msgs[len(msgs)-1].Index() + 1
+	}
+}
+
+func (q *MessageQueue) Publish(publisher string, priority uint8, payload []byte) (message model.IPubSubMessage, err error) {
+	if q.ctx.Err() != nil {
+		return nil, errors.New(fmt.Sprintf("topic %s has been closed", q.topic.Id()))
+	}
+	q.withLock(func() {
+		message = model.NewPubSubMessage(q.topic.Id(), q.lastMsgIndex+1, priority, publisher, time.Now(), payload)
+		if err = q.store.Put(message); err != nil {
+			return
+		}
+		q.lastMsgIndex = message.Index()
+		entry := model.BacklogEntry{Index: message.Index(), Priority: priority, CTime: message.CTime()}
+		for _, group := range q.topic.Groups() {
+			group.Enqueue(entry)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.topic.AddPublisher(publisher).SetLastMsgIndex(message.Index())
+	q.Notify()
+	return
+}
+
+func (q *MessageQueue) AddGroup(name string, owner string, mode model.SubscribeMode) (group model.ISubscriberGroup, err error) {
+	q.withLock(func() {
+		if group = q.topic.GetGroup(name); group != nil {
+			return
+		}
+		group = model.NewSubscriberGroup(name, owner, mode, q.lastMsgIndex)
+		err = q.topic.AddGroup(group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, q.Checkpoint()
+}
+
+// read reads messages of entries, messages that have been dropped by retention are skipped
+func (q *MessageQueue) read(entries []model.BacklogEntry) ([]model.IPubSubMessage, error) {
+	msgs := make([]model.IPubSubMessage, 0, len(entries))
+	for _, e := range entries {
+		m, err := q.store.Get(q.topic.Id(), e.Index)
+		if err == ErrMessageNotFound {
+			continue
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func (q *MessageQueue) Pull(groupName string, consumerId string, max int, manualAck bool) ([]model.IPubSubMessage, error) {
+	group, err := q.getGroup(groupName)
+	if err != nil {
+		return nil, err
+	}
+	if group.Mode() != model.SubModePull {
+		return nil, errors.New(fmt.Sprintf("subscriber group %s is not in pull mode", groupName))
+	}
+	if !group.HasConsumer(consumerId) {
+		return nil, errors.New(fmt.Sprintf("%s is not a consumer of subscriber group %s", consumerId, groupName))
+	}
+	if !manualAck {
+		return q.read(group.Take(max))
+	}
+	now := time.Now()
+	entries := group.TakeInFlight(max, func(attempts int) time.Time {
+		return now.Add(q.delivery.backoff(q.delivery.AckTimeout, attempts))
+	})
+	msgs, err := q.read(entries)
+	if err != nil {
+		return msgs, err
+	}
+	if len(msgs) < len(entries) {
+		// messages dropped by retention won't be acknowledged
+		read := make(map[uint32]bool, len(msgs))
+		for _, m := range msgs {
+			read[m.Index()] = true
+		}
+		for _, e := range entries {
+			if !read[e.Index] {
+				group.Ack(e.Index)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func (q *MessageQueue) getGroup(name string) (model.ISubscriberGroup, error) {
+	group := q.topic.GetGroup(name)
+	if group == nil {
+		return nil, errors.New(fmt.Sprintf("subscriber group %s does not exist in topic %s", name, q.topic.Id()))
+	}
+	return group, nil
+}
+
+func (q *MessageQueue) Ack(groupName string, index uint32) error {
+	group, err := q.getGroup(groupName)
+	if err != nil {
+		return err
+	}
+	if !group.Ack(index) {
+		return errors.New(fmt.Sprintf("message %d is not waiting for acknowledgement of subscriber group %s", index, groupName))
+	}
+	return nil
+}
+
+func (q *MessageQueue) Nack(groupName string, index uint32) error {
+	group, err := q.getGroup(groupName)
+	if err != nil {
+		return err
+	}
+	retryAt := time.Now().Add(q.delivery.backoff(q.delivery.RetryBackoff, group.Attempts(index)))
+	if !group.Nack(index, retryAt) {
+		return errors.New(fmt.Sprintf("message %d is not waiting for acknowledgement of subscriber group %s", index, groupName))
+	}
+	return nil
+}
+
+func (q *MessageQueue) Replay(groupName string, index uint32) error {
+	group, err := q.getGroup(groupName)
+	if err != nil {
+		return err
+	}
+	m, err := q.store.Get(q.topic.Id(), index)
+	if err == ErrMessageNotFound {
+		return errors.New(fmt.Sprintf("message %d of topic %s has been dropped", index, q.topic.Id()))
+	}
+	if err != nil {
+		return err
+	}
+	group.Enqueue(model.BacklogEntry{Index: m.Index(), Priority: m.Priority(), CTime: m.CTime()})
+	q.Notify()
+	return nil
+}
+
+func (q *MessageQueue) Seek(groupName string, index uint32) (err error) {
+	group, err := q.getGroup(groupName)
+	if err != nil {
+		return err
+	}
+	if index == 0 {
+		return errors.New("invalid index 0, indexes start from 1")
+	}
+	q.withLock(func() {
+		if index > q.lastMsgIndex+1 {
+			err = errors.New(fmt.Sprintf("index %d is beyond the next index %d of topic %s", index, q.lastMsgIndex+1, q.topic.Id()))
+			return
+		}
+		var entries []model.BacklogEntry
+		if entries, err = q.scanBacklog(index); err != nil {
+			return
+		}
+		group.Reset(index-1, entries)
+	})
+	if err != nil {
+		return
+	}
+	q.Notify()
+	return q.Checkpoint()
+}
+
+func (q *MessageQueue) Checkpoint() error {
+	record := NewTopicRecord(q.topic)
+	marshalled, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if bytes.Equal(marshalled, q.checkpoint) {
+		return nil
+	}
+	if err = q.store.PutTopic(record); err != nil {
+		return err
+	}
+	q.checkpoint = marshalled
+	return nil
+}
+
+func (q *MessageQueue) LastMsgIndex() (index uint32) {
+	q.withLock(func() {
+		index = q.lastMsgIndex
+	})
+	return
+}
+
+func (q *MessageQueue) Notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
 }
 
 func (q *MessageQueue) Stop() {
 	q.cancelFunc()
+	q.Checkpoint()
 }
 
 // main goroutine of MessageQueue
 func (q *MessageQueue) dispatcher() {
+	ticker := time.NewTicker(DefaultRedispatchInterval)
+	defer ticker.Stop()
+	checkpointTicker := time.NewTicker(DefaultCheckpointInterval)
+	defer checkpointTicker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.signal:
+		case <-ticker.C:
+		case <-checkpointTicker.C:
+			q.Checkpoint()
+			q.store.ApplyRetention(q.topic.Id(), q.retention)
+		}
+		for _, group := range q.topic.Groups() {
+			// messages pulled with manual acknowledgement are redelivered as well
+			q.redeliver(group)
+			if group.Mode() == model.SubModePush {
+				q.dispatchGroup(group)
+			}
+		}
+	}
+}
+
+// dispatchGroup sends messages in the backlog of the group, it stops at the first message the group fails to consume
+// so that the message will be retried in the next round
+func (q *MessageQueue) dispatchGroup(group model.ISubscriberGroup) {
+	for i := 0; i < DefaultDispatchBatchSize && q.ctx.Err() == nil; i++ {
+		entries, epoch := group.Peek(1)
+		if len(entries) == 0 {
+			return
+		}
+		entry := entries[0]
+		m, err := q.store.Get(q.topic.Id(), entry.Index)
+		if err == ErrMessageNotFound {
+			group.Done(epoch, entry)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		// the message is in flight before it's sent so that no acknowledgement is missed
+		deadline := time.Now().Add(q.delivery.backoff(q.delivery.AckTimeout, group.Attempts(entry.Index)+1))
+		if !group.Deliver(epoch, entry, deadline) {
+			// a rewound group simply continues with its new backlog
+			continue
+		}
+		consumer, err := group.Dispatch(m)
+		if err != nil {
+			group.Requeue(entry.Index, true)
+			return
+		}
+		if !consumer.ManualAck() {
+			group.Ack(entry.Index)
+		}
+	}
+	// let other groups have their turns before the rest is dispatched
+	q.Notify()
+}
 
+// redeliver puts messages that are not acknowledged in time back to the backlog, messages that failed too many times
+// go to the dead letter handler
+func (q *MessageQueue) redeliver(group model.ISubscriberGroup) {
+	for _, d := range group.Expired(time.Now()) {
+		if q.delivery.MaxDeliveries <= 0 || d.Attempts < q.delivery.MaxDeliveries {
+			group.Requeue(d.Entry.Index, false)
+			continue
+		}
+		m, err := q.store.Get(q.topic.Id(), d.Entry.Index)
+		if err != nil && err != ErrMessageNotFound {
+			// try again in the next round
+			continue
+		}
+		if err == nil && q.deadLetter != nil && q.deadLetter(group.Name(), m, d.Attempts) != nil {
+			continue
+		}
+		group.Ack(d.Entry.Index)
+	}
 }
@@ -0,0 +1,223 @@
+package pubsub_v2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Topic ids are hierarchical, levels are separated by TopicLevelSeparator. A topic filter matches topic ids level by
+// level, TopicWildcard matches exactly one level and TopicMultiWildcard, which can only be the last level, matches any
+// number of remaining levels(including none), e.g. sensors/+/temperature and sensors/# both match
+// sensors/1/temperature.
+const (
+	TopicLevelSeparator = "/"
+	TopicWildcard       = "+"
+	TopicMultiWildcard  = "#"
+)
+
+// IsTopicFilter tells if the id contains wildcards
+func IsTopicFilter(id string) bool {
+	return strings.ContainsAny(id, TopicWildcard+TopicMultiWildcard)
+}
+
+// ValidateTopicId checks the id of a topic to create, wildcards and relative path levels(. and ..) are not allowed
+func ValidateTopicId(id string) error {
+	if id == "" {
+		return errors.New("invalid topic id")
+	}
+	if IsTopicFilter(id) {
+		return errors.New(fmt.Sprintf("invalid topic id %s, %s and %s are reserved for topic filters", id, TopicWildcard, TopicMultiWildcard))
+	}
+	for _, level := range strings.Split(id, TopicLevelSeparator) {
+		if level == "." || level == ".." {
+			return errors.New(fmt.Sprintf("invalid topic id %s, . and .. are not allowed as levels", id))
+		}
+	}
+	return nil
+}
+
+// ValidateTopicFilter checks that wildcards of the filter take whole levels and TopicMultiWildcard is the last level
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("invalid topic filter")
+	}
+	levels := strings.Split(filter, TopicLevelSeparator)
+	for i, level := range levels {
+		if level == TopicWildcard || level == TopicMultiWildcard && i == len(levels)-1 || !IsTopicFilter(level) {
+			continue
+		}
+		return errors.New(fmt.Sprintf("invalid topic filter %s, wildcards should take whole levels and %s should be the last level", filter, TopicMultiWildcard))
+	}
+	return nil
+}
+
+type topicTrieNode struct {
+	parent             *topicTrieNode
+	wildcardChild      *topicTrieNode            // +
+	multiWildcardChild *topicTrieNode            // #
+	constChildren      map[string]*topicTrieNode // const
+	level              string
+	values             map[string]interface{}
+}
+
+func (n *topicTrieNode) child(level string) *topicTrieNode {
+	switch level {
+	case TopicWildcard:
+		return n.wildcardChild
+	case TopicMultiWildcard:
+		return n.multiWildcardChild
+	}
+	return n.constChildren[level]
+}
+
+func (n *topicTrieNode) addChild(level string) *topicTrieNode {
+	if child := n.child(level); child != nil {
+		return child
+	}
+	child := &topicTrieNode{parent: n, level: level}
+	switch level {
+	case TopicWildcard:
+		n.wildcardChild = child
+	case TopicMultiWildcard:
+		n.multiWildcardChild = child
+	default:
+		if n.constChildren == nil {
+			n.constChildren = make(map[string]*topicTrieNode)
+		}
+		n.constChildren[level] = child
+	}
+	return child
+}
+
+func (n *topicTrieNode) isEmpty() bool {
+	return len(n.values) == 0 && n.wildcardChild == nil && n.multiWildcardChild == nil && len(n.constChildren) == 0
+}
+
+// prune removes empty nodes from bottom to up
+func (n *topicTrieNode) prune() {
+	for curr := n; curr.parent != nil && curr.isEmpty(); curr = curr.parent {
+		switch curr.level {
+		case TopicWildcard:
+			curr.parent.wildcardChild = nil
+		case TopicMultiWildcard:
+			curr.parent.multiWildcardChild = nil
+		default:
+			delete(curr.parent.constChildren, curr.level)
+		}
+	}
+}
+
+func (n *topicTrieNode) collect(values []interface{}) []interface{} {
+	for _, v := range n.values {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (n *topicTrieNode) match(levels []string, values []interface{}) []interface{} {
+	if n.multiWildcardChild != nil {
+		values = n.multiWildcardChild.collect(values)
+	}
+	if len(levels) == 0 {
+		return n.collect(values)
+	}
+	if child := n.constChildren[levels[0]]; child != nil {
+		values = child.match(levels[1:], values)
+	}
+	if n.wildcardChild != nil {
+		values = n.wildcardChild.match(levels[1:], values)
+	}
+	return values
+}
+
+// TopicTrie keeps values by topic filters and finds the values of all filters that match a topic id. A filter can hold
+// multiple values told apart by keys. TopicTrie is not thread safe.
+type TopicTrie struct {
+	root *topicTrieNode
+	size int
+}
+
+func NewTopicTrie() *TopicTrie {
+	return &TopicTrie{
+		root: &topicTrieNode{},
+	}
+}
+
+// Size is the number of values in the trie
+func (t *TopicTrie) Size() int {
+	return t.size
+}
+
+// Add adds the value of key to the filter, the value of the same key is replaced
+func (t *TopicTrie) Add(filter string, key string, value interface{}) error {
+	if err := ValidateTopicFilter(filter); err != nil {
+		return err
+	}
+	node := t.root
+	for _, level := range strings.Split(filter, TopicLevelSeparator) {
+		node = node.addChild(level)
+	}
+	if node.values == nil {
+		node.values = make(map[string]interface{})
+	}
+	if _, exists := node.values[key]; !exists {
+		t.size++
+	}
+	node.values[key] = value
+	return nil
+}
+
+func (t *TopicTrie) find(filter string) *topicTrieNode {
+	node := t.root
+	for _, level := range strings.Split(filter, TopicLevelSeparator) {
+		if node = node.child(level); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// Get returns the value of key of the filter
+func (t *TopicTrie) Get(filter string, key string) interface{} {
+	node := t.find(filter)
+	if node == nil {
+		return nil
+	}
+	return node.values[key]
+}
+
+// Remove removes the value of key from the filter
+func (t *TopicTrie) Remove(filter string, key string) bool {
+	node := t.find(filter)
+	if node == nil {
+		return false
+	}
+	if _, exists := node.values[key]; !exists {
+		return false
+	}
+	delete(node.values, key)
+	t.size--
+	node.prune()
+	return true
+}
+
+// Match returns values of all filters that match the topic id
+func (t *TopicTrie) Match(topicId string) []interface{} {
+	return t.root.match(strings.Split(topicId, TopicLevelSeparator), make([]interface{}, 0))
+}
+
+// MatchTopicFilter tells if the topic id matches the filter
+func MatchTopicFilter(filter string, topicId string) bool {
+	filterLevels := strings.Split(filter, TopicLevelSeparator)
+	levels := strings.Split(topicId, TopicLevelSeparator)
+	for i, level := range filterLevels {
+		if level == TopicMultiWildcard {
+			return true
+		}
+		if i >= len(levels) || level != TopicWildcard && level != levels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(levels)
+}
@@ -0,0 +1,64 @@
+package pubsub_v2
+
+import (
+	"sort"
+	"testing"
+	"whub/common/test_utils"
+)
+
+func matchedKeys(trie *TopicTrie, topicId string) []string {
+	keys := make([]string, 0)
+	for _, v := range trie.Match(topicId) {
+		keys = append(keys, v.(string))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestTopicTrie(t *testing.T) {
+	trie := NewTopicTrie()
+	test_utils.NewTestGroup("TopicTrie", "").Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Add", "", func() bool {
+			return trie.Add("sensors/+/temperature", "a", "a") == nil &&
+				trie.Add("sensors/#", "b", "b") == nil &&
+				trie.Add("sensors/1/temperature", "c", "c") == nil &&
+				trie.Add("#", "d", "d") == nil &&
+				trie.Size() == 4
+		}),
+		test_utils.NewTestCase("Invalid filters", "", func() bool {
+			return trie.Add("sensors/#/temperature", "x", "x") != nil && trie.Add("sensors/a+", "x", "x") != nil && trie.Add("", "x", "x") != nil
+		}),
+		test_utils.NewTestCase("Match", "", func() bool {
+			keys := matchedKeys(trie, "sensors/1/temperature")
+			if len(keys) != 4 {
+				return false
+			}
+			keys = matchedKeys(trie, "sensors/2/temperature")
+			if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "d" {
+				return false
+			}
+			// # matches the parent level as well
+			keys = matchedKeys(trie, "sensors")
+			if len(keys) != 2 || keys[0] != "b" {
+				return false
+			}
+			keys = matchedKeys(trie, "devices/1")
+			return len(keys) == 1 && keys[0] == "d"
+		}),
+		test_utils.NewTestCase("Remove", "", func() bool {
+			if !trie.Remove("sensors/+/temperature", "a") || trie.Remove("sensors/+/temperature", "a") || !trie.Remove("#", "d") {
+				return false
+			}
+			keys := matchedKeys(trie, "sensors/2/temperature")
+			return len(keys) == 1 && keys[0] == "b" && trie.Size() == 2 && trie.root.wildcardChild == nil
+		}),
+		test_utils.NewTestCase("ValidateTopicId", "", func() bool {
+			return ValidateTopicId("a/b") == nil && ValidateTopicId("a/..b") == nil &&
+				ValidateTopicId("..") != nil && ValidateTopicId(".") != nil && ValidateTopicId("a/../b") != nil && ValidateTopicId("a/+") != nil
+		}),
+		test_utils.NewTestCase("MatchTopicFilter", "", func() bool {
+			return MatchTopicFilter("a/+/c", "a/b/c") && !MatchTopicFilter("a/+/c", "a/b/c/d") && !MatchTopicFilter("a/+", "a") &&
+				MatchTopicFilter("a/#", "a") && MatchTopicFilter("a/#", "a/b/c") && !MatchTopicFilter("a/b", "a/c")
+		}),
+	}).Do(t)
+}
@@ -0,0 +1,142 @@
+package model
+
+import "time"
+
+// priorityStride is divided by the weight of a priority level to get how far the level moves after being picked
+const priorityStride = 1 << 20
+
+type BacklogEntry struct {
+	Index    uint32
+	Priority uint8
+	CTime    time.Time
+}
+
+// PriorityBacklog holds indexes of messages waiting for delivery in one lane per priority level. Lanes are scheduled
+// by weighted fair queueing with weight priority+1, so higher levels are picked first and proportionally more often
+// while every non-empty level is still picked once in a while. Messages of the same level are picked in index order.
+type PriorityBacklog struct {
+	lanes map[uint8][]BacklogEntry
+	// pass of each level, the non-empty level that finishes its next turn(pass+stride) first is picked next
+	pass map[uint8]uint64
+	// vtime is the pass of the last picked level, levels becoming non-empty start from it
+	vtime uint64
+	// watermark is the index up to which all messages have been delivered
+	watermark uint32
+	// delivered holds indexes beyond watermark that have been delivered out of index order
+	delivered map[uint32]bool
+}
+
+func stride(priority uint8) uint64 {
+	return priorityStride / (uint64(priority) + 1)
+}
+
+func NewPriorityBacklog(watermark uint32) *PriorityBacklog {
+	return &PriorityBacklog{
+		lanes:     make(map[uint8][]BacklogEntry),
+		pass:      make(map[uint8]uint64),
+		watermark: watermark,
+		delivered: make(map[uint32]bool),
+	}
+}
+
+func (b *PriorityBacklog) Watermark() uint32 {
+	return b.watermark
+}
+
+// Push adds an entry to its level, entries that are redelivered are put back in index order
+func (b *PriorityBacklog) Push(entry BacklogEntry) {
+	lane := b.lanes[entry.Priority]
+	if len(lane) == 0 && b.pass[entry.Priority] < b.vtime {
+		// an idle level does not save up turns
+		b.pass[entry.Priority] = b.vtime
+	}
+	i := len(lane)
+	for i > 0 && lane[i-1].Index > entry.Index {
+		i--
+	}
+	lane = append(lane, entry)
+	copy(lane[i+1:], lane[i:])
+	lane[i] = entry
+	b.lanes[entry.Priority] = lane
+}
+
+// pick returns the level to be picked next given the number of entries already taken from each level
+func (b *PriorityBacklog) pick(taken map[uint8]int, pass map[uint8]uint64) (uint8, bool) {
+	picked, found := uint8(0), false
+	for p, lane := range b.lanes {
+		if len(lane) <= taken[p] {
+			continue
+		}
+		finish := pass[p] + stride(p)
+		if !found || finish < pass[picked]+stride(picked) || finish == pass[picked]+stride(picked) && p > picked {
+			picked, found = p, true
+		}
+	}
+	return picked, found
+}
+
+// Peek returns at most max entries in the order they would be taken
+func (b *PriorityBacklog) Peek(max int) []BacklogEntry {
+	taken := make(map[uint8]int)
+	pass := make(map[uint8]uint64, len(b.pass))
+	for p, v := range b.pass {
+		pass[p] = v
+	}
+	entries := make([]BacklogEntry, 0)
+	for len(entries) < max {
+		p, ok := b.pick(taken, pass)
+		if !ok {
+			break
+		}
+		entries = append(entries, b.lanes[p][taken[p]])
+		taken[p]++
+		pass[p] += stride(p)
+	}
+	return entries
+}
+
+// Remove removes an entry that is taken for delivery, it should be the head of its level
+func (b *PriorityBacklog) Remove(entry BacklogEntry) bool {
+	lane := b.lanes[entry.Priority]
+	if len(lane) == 0 || lane[0].Index != entry.Index {
+		return false
+	}
+	if len(lane) == 1 {
+		delete(b.lanes, entry.Priority)
+	} else {
+		b.lanes[entry.Priority] = lane[1:]
+	}
+	b.vtime = b.pass[entry.Priority]
+	b.pass[entry.Priority] += stride(entry.Priority)
+	return true
+}
+
+// Ack marks a removed entry as delivered, the watermark moves over indexes that are all delivered
+func (b *PriorityBacklog) Ack(index uint32) {
+	if index <= b.watermark {
+		return
+	}
+	b.delivered[index] = true
+	for b.delivered[b.watermark+1] {
+		delete(b.delivered, b.watermark+1)
+		b.watermark++
+	}
+}
+
+// Take removes and returns at most max entries in schedule order
+func (b *PriorityBacklog) Take(max int) []BacklogEntry {
+	entries := b.Peek(max)
+	for _, e := range entries {
+		b.Remove(e)
+	}
+	return entries
+}
+
+// Sizes returns the number of waiting messages of each priority level
+func (b *PriorityBacklog) Sizes() map[uint8]int {
+	sizes := make(map[uint8]int, len(b.lanes))
+	for p, lane := range b.lanes {
+		sizes[p] = len(lane)
+	}
+	return sizes
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"testing"
+	"whub/common/test_utils"
+)
+
+func pushAll(b *PriorityBacklog, from uint32, priorities ...uint8) {
+	for i, p := range priorities {
+		b.Push(BacklogEntry{Index: from + uint32(i), Priority: p})
+	}
+}
+
+func TestPriorityBacklog(t *testing.T) {
+	tg := test_utils.NewTestGroup("PriorityBacklog", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Overtake", "higher priorities should be taken first, in index order within a priority", func() bool {
+			b := NewPriorityBacklog(0)
+			pushAll(b, 1, 0, 0, 0, 9, 9)
+			entries := b.Take(3)
+			return len(entries) == 3 && entries[0].Index == 4 && entries[1].Index == 5 && entries[2].Index == 1
+		}),
+		test_utils.NewTestCase("Starvation", "lower priorities should still be taken in a busy backlog", func() bool {
+			b := NewPriorityBacklog(0)
+			priorities := make([]uint8, 0, 100)
+			for i := 0; i < 50; i++ {
+				priorities = append(priorities, 0, 4)
+			}
+			pushAll(b, 1, priorities...)
+			low, last := 0, uint32(0)
+			for _, e := range b.Take(50) {
+				if e.Priority == 0 {
+					if e.Index < last {
+						return false
+					}
+					low, last = low+1, e.Index
+				}
+			}
+			// weights are 1 and 5
+			return low >= 8 && low <= 10
+		}),
+		test_utils.NewTestCase("Watermark", "watermark should only move over continuously acknowledged indexes", func() bool {
+			b := NewPriorityBacklog(0)
+			pushAll(b, 1, 0, 0, 1)
+			b.Take(1)
+			if b.Watermark() != 0 || b.Remove(BacklogEntry{Index: 2, Priority: 0}) {
+				return false
+			}
+			b.Ack(3)
+			b.Take(2)
+			if b.Watermark() != 0 || len(b.Sizes()) != 0 {
+				return false
+			}
+			b.Ack(2)
+			if b.Watermark() != 0 {
+				return false
+			}
+			b.Ack(1)
+			return b.Watermark() == 3
+		}),
+		test_utils.NewTestCase("Requeue", "requeued entries should be put back in index order", func() bool {
+			b := NewPriorityBacklog(0)
+			pushAll(b, 1, 0, 0, 0)
+			taken := b.Take(2)
+			b.Push(taken[1])
+			b.Push(taken[0])
+			entries := b.Peek(3)
+			return len(entries) == 3 && entries[0].Index == 1 && entries[1].Index == 2 && entries[2].Index == 3
+		}),
+	}).Do(t)
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter is the payload of messages in the dead-letter topic, it keeps the message a subscriber group failed to
+// consume and where the message comes from so that it can be replayed to the group
+type DeadLetter struct {
+	// Id is the index of the dead letter in the dead-letter topic
+	Id        uint32    `json:"id"`
+	Topic     string    `json:"topic"`
+	Group     string    `json:"group"`
+	Index     uint32    `json:"index"`
+	Priority  uint8     `json:"priority"`
+	Publisher string    `json:"publisher"`
+	CTime     time.Time `json:"cTime"`
+	Attempts  int       `json:"attempts"`
+	Payload   []byte    `json:"payload"`
+}
+
+func NewDeadLetter(group string, message IPubSubMessage, attempts int) *DeadLetter {
+	return &DeadLetter{
+		Topic:     message.TopicId(),
+		Group:     group,
+		Index:     message.Index(),
+		Priority:  message.Priority(),
+		Publisher: message.Publisher(),
+		CTime:     message.CTime(),
+		Attempts:  attempts,
+		Payload:   message.Payload(),
+	}
+}
+
+// ParseDeadLetter restores the dead letter from a message of the dead-letter topic
+func ParseDeadLetter(message IPubSubMessage) (*DeadLetter, error) {
+	deadLetter := &DeadLetter{}
+	if err := json.Unmarshal(message.Payload(), deadLetter); err != nil {
+		return nil, err
+	}
+	deadLetter.Id = message.Index()
+	return deadLetter, nil
+}
@@ -0,0 +1,78 @@
+package model
+
+import "time"
+
+type IPubSubMessage interface {
+	TopicId() string
+	Index() uint32
+	Priority() uint8
+	Publisher() string
+	CTime() time.Time
+	Payload() []byte
+	Describe() PubSubMessageDescriptor
+}
+
+type PubSubMessage struct {
+	topicId   string
+	index     uint32
+	priority  uint8
+	publisher string
+	ctime     time.Time
+	payload   []byte
+}
+
+// PubSubMessageDescriptor is the json form of a message, payload is base64 encoded
+type PubSubMessageDescriptor struct {
+	TopicId   string    `json:"topicId"`
+	Index     uint32    `json:"index"`
+	Priority  uint8     `json:"priority"`
+	Publisher string    `json:"publisher"`
+	CTime     time.Time `json:"cTime"`
+	Payload   []byte    `json:"payload"`
+}
+
+func NewPubSubMessage(topicId string, index uint32, priority uint8, publisher string, ctime time.Time, payload []byte) IPubSubMessage {
+	return &PubSubMessage{
+		topicId:   topicId,
+		index:     index,
+		priority:  priority,
+		publisher: publisher,
+		ctime:     ctime,
+		payload:   payload,
+	}
+}
+
+func (m *PubSubMessage) TopicId() string {
+	return m.topicId
+}
+
+func (m *PubSubMessage) Index() uint32 {
+	return m.index
+}
+
+func (m *PubSubMessage) Priority() uint8 {
+	return m.priority
+}
+
+func (m *PubSubMessage) Publisher() string {
+	return m.publisher
+}
+
+func (m *PubSubMessage) CTime() time.Time {
+	return m.ctime
+}
+
+func (m *PubSubMessage) Payload() []byte {
+	return m.payload
+}
+
+func (m *PubSubMessage) Describe() PubSubMessageDescriptor {
+	return PubSubMessageDescriptor{
+		TopicId:   m.topicId,
+		Index:     m.index,
+		Priority:  m.priority,
+		Publisher: m.publisher,
+		CTime:     m.ctime,
+		Payload:   m.payload,
+	}
+}
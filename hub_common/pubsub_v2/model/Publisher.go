@@ -1,16 +1,36 @@
 package model
 
-import (
-	"whub/hub_common/pubsub_v2"
-)
+import "sync/atomic"
 
 // Publisher a client that publishes messages
 
 type IPubSubProducer interface {
-	Produce(pubsub_v2.IPubSubMessage) error
+	Produce(IPubSubMessage) error
+}
+
+type IPublisher interface {
+	ClientId() string
+	LastMsgIndex() uint32
+	SetLastMsgIndex(uint32)
 }
 
 type Publisher struct {
 	clientId     string
 	lastMsgIndex uint32
 }
+
+func NewPublisher(clientId string) IPublisher {
+	return &Publisher{clientId: clientId}
+}
+
+func (p *Publisher) ClientId() string {
+	return p.clientId
+}
+
+func (p *Publisher) LastMsgIndex() uint32 {
+	return atomic.LoadUint32(&p.lastMsgIndex)
+}
+
+func (p *Publisher) SetLastMsgIndex(index uint32) {
+	atomic.StoreUint32(&p.lastMsgIndex, index)
+}
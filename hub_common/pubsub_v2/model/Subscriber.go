@@ -1,15 +1,25 @@
 package model
 
 import (
-	"whub/hub_common/pubsub_v2"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // SubscriberGroup a group of msg receivers that receives messages on one topic, each message is sent to one connection in
 // the group. All connection groups in the topic will receive one instance of the message from queue.
 
 type IPubSubConsumer interface {
-	Consume(pubsub_v2.IPubSubMessage) error
+	Id() string
+	// Consume hands the message to the consumer, an error means the message is not delivered
+	Consume(IPubSubMessage) error
 	Mode() SubscribeMode
+	// ManualAck tells if the consumer acknowledges consumed messages by itself, messages consumed by other consumers
+	// are acknowledged once Consume returns
+	ManualAck() bool
 }
 
 type SubscribeMode uint8
@@ -29,13 +39,412 @@ func (m SubscribeMode) String() string {
 	return "unknown"
 }
 
+// ParseSubscribeMode parses the mode name case insensitively
+func ParseSubscribeMode(mode string) (SubscribeMode, error) {
+	switch strings.ToLower(mode) {
+	case "pull":
+		return SubModePull, nil
+	case "push":
+		return SubModePush, nil
+	}
+	return 0, errors.New(fmt.Sprintf("unknown subscribe mode %s", mode))
+}
+
 type ISubscriberGroup interface {
+	Name() string
 	Mode() SubscribeMode
+	// Owner is the client that created the group
+	Owner() string
+	// Members are clients allowed by the owner to join the group
+	Members() []string
+	AddMember(clientId string)
+	// Allows tells if the client can join the group, i.e. it's the owner or a member
+	Allows(clientId string) bool
+	// LastMsgIndex is the index up to which the group has received all messages, messages of higher priorities may
+	// have been received beyond it
 	LastMsgIndex() uint32
+	// Enqueue adds a message to the backlog of the group
+	Enqueue(BacklogEntry)
+	// Peek returns at most max messages to be delivered next and the epoch of the backlog
+	Peek(max int) ([]BacklogEntry, uint64)
+	// Done removes a message consumed without acknowledgement from the backlog, it fails if the backlog has been reset
+	// since epoch
+	Done(epoch uint64, entry BacklogEntry) bool
+	// Deliver moves a message from the backlog to in-flight messages until it's acknowledged or deadline passes, it
+	// fails if the backlog has been reset since epoch
+	Deliver(epoch uint64, entry BacklogEntry, deadline time.Time) bool
+	// Attempts returns the number of times the message has been delivered without acknowledgement
+	Attempts(index uint32) int
+	// Ack acknowledges an in-flight message
+	Ack(index uint32) bool
+	// Nack rejects an in-flight message, it's redelivered after retryAt
+	Nack(index uint32, retryAt time.Time) bool
+	// Expired returns in-flight messages whose deadline passed before now
+	Expired(now time.Time) []Delivery
+	// Requeue puts an in-flight message back to the backlog, undo tells if the delivery attempt did not reach any
+	// consumer and should not be counted
+	Requeue(index uint32, undo bool) bool
+	// Take removes and returns at most max messages to be delivered next, the messages are acknowledged right away
+	Take(max int) []BacklogEntry
+	// TakeInFlight is Take but keeps the messages in flight until they are acknowledged, or redelivered once the
+	// deadline of their delivery attempt passes
+	TakeInFlight(max int, deadline func(attempts int) time.Time) []BacklogEntry
+	// Reset replaces the backlog, e.g. when the group is rewound
+	Reset(lastMsgIndex uint32, entries []BacklogEntry)
+	// Backlog returns the number of messages waiting for the group of each priority level
+	Backlog() map[uint8]int
+	InFlight() int
+	AddConsumer(IPubSubConsumer) error
+	RemoveConsumer(id string) bool
+	HasConsumer(id string) bool
+	Consumers() []IPubSubConsumer
+	Size() int
+	// Dispatch sends the message to exactly one consumer of the group, consumers are taken in turns. The consumer that
+	// took the message is returned.
+	Dispatch(IPubSubMessage) (IPubSubConsumer, error)
+	Describe() SubscriberGroupDescriptor
+}
+
+type SubscriberGroupDescriptor struct {
+	Name         string        `json:"name"`
+	Mode         string        `json:"mode"`
+	Owner        string        `json:"owner"`
+	Members      []string      `json:"members"`
+	Consumers    []string      `json:"consumers"`
+	LastMsgIndex uint32        `json:"lastMsgIndex"`
+	Backlog      map[uint8]int `json:"backlog"`
+	InFlight     int           `json:"inFlight"`
+}
+
+// Delivery is a message that has been delivered to a consumer and waits for acknowledgement
+type Delivery struct {
+	Entry    BacklogEntry
+	Attempts int
+	Deadline time.Time
 }
 
 type SubscriberGroup struct {
-	name         string
-	consumers    []IPubSubConsumer
-	lastMsgIndex uint32
+	name      string
+	mode      SubscribeMode
+	owner     string
+	members   map[string]bool
+	consumers []IPubSubConsumer
+	backlog   *PriorityBacklog
+	inflight  map[uint32]*Delivery
+	// attempts of messages that have been delivered but not acknowledged
+	attempts map[uint32]int
+	epoch    uint64
+	// next is the consumer to take the next message
+	next int
+	lock *sync.RWMutex
+}
+
+func NewSubscriberGroup(name string, owner string, mode SubscribeMode, lastMsgIndex uint32) ISubscriberGroup {
+	return &SubscriberGroup{
+		name:      name,
+		mode:      mode,
+		owner:     owner,
+		members:   make(map[string]bool),
+		consumers: make([]IPubSubConsumer, 0),
+		backlog:   NewPriorityBacklog(lastMsgIndex),
+		inflight:  make(map[uint32]*Delivery),
+		attempts:  make(map[uint32]int),
+		lock:      new(sync.RWMutex),
+	}
+}
+
+func (g *SubscriberGroup) withWrite(cb func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	cb()
+}
+
+func (g *SubscriberGroup) withRead(cb func()) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	cb()
+}
+
+func (g *SubscriberGroup) Name() string {
+	return g.name
+}
+
+func (g *SubscriberGroup) Mode() SubscribeMode {
+	return g.mode
+}
+
+func (g *SubscriberGroup) Owner() string {
+	return g.owner
+}
+
+func (g *SubscriberGroup) Members() (members []string) {
+	g.withRead(func() {
+		members = make([]string, 0, len(g.members))
+		for m := range g.members {
+			members = append(members, m)
+		}
+	})
+	sort.Strings(members)
+	return
+}
+
+func (g *SubscriberGroup) AddMember(clientId string) {
+	g.withWrite(func() {
+		g.members[clientId] = true
+	})
+}
+
+func (g *SubscriberGroup) Allows(clientId string) (allowed bool) {
+	if clientId == g.owner {
+		return true
+	}
+	g.withRead(func() {
+		allowed = g.members[clientId]
+	})
+	return
+}
+
+func (g *SubscriberGroup) LastMsgIndex() (index uint32) {
+	g.withRead(func() {
+		index = g.backlog.Watermark()
+	})
+	return
+}
+
+func (g *SubscriberGroup) Enqueue(entry BacklogEntry) {
+	g.withWrite(func() {
+		g.backlog.Push(entry)
+	})
+}
+
+func (g *SubscriberGroup) Peek(max int) (entries []BacklogEntry, epoch uint64) {
+	g.withRead(func() {
+		entries = g.backlog.Peek(max)
+		epoch = g.epoch
+	})
+	return
+}
+
+func (g *SubscriberGroup) Done(epoch uint64, entry BacklogEntry) (done bool) {
+	g.withWrite(func() {
+		if done = epoch == g.epoch && g.backlog.Remove(entry); done {
+			g.backlog.Ack(entry.Index)
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Deliver(epoch uint64, entry BacklogEntry, deadline time.Time) (delivered bool) {
+	g.withWrite(func() {
+		if delivered = epoch == g.epoch && g.backlog.Remove(entry); delivered {
+			g.attempts[entry.Index]++
+			g.inflight[entry.Index] = &Delivery{Entry: entry, Attempts: g.attempts[entry.Index], Deadline: deadline}
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Attempts(index uint32) (attempts int) {
+	g.withRead(func() {
+		attempts = g.attempts[index]
+	})
+	return
+}
+
+func (g *SubscriberGroup) Ack(index uint32) (acked bool) {
+	g.withWrite(func() {
+		if _, acked = g.inflight[index]; acked {
+			delete(g.inflight, index)
+			delete(g.attempts, index)
+			g.backlog.Ack(index)
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Nack(index uint32, retryAt time.Time) (nacked bool) {
+	g.withWrite(func() {
+		var delivery *Delivery
+		if delivery, nacked = g.inflight[index]; nacked {
+			delivery.Deadline = retryAt
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Expired(now time.Time) (deliveries []Delivery) {
+	g.withRead(func() {
+		for _, d := range g.inflight {
+			if !d.Deadline.After(now) {
+				deliveries = append(deliveries, *d)
+			}
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Requeue(index uint32, undo bool) (requeued bool) {
+	g.withWrite(func() {
+		var delivery *Delivery
+		if delivery, requeued = g.inflight[index]; requeued {
+			delete(g.inflight, index)
+			if undo {
+				g.attempts[index]--
+			}
+			g.backlog.Push(delivery.Entry)
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Take(max int) (entries []BacklogEntry) {
+	g.withWrite(func() {
+		entries = g.backlog.Take(max)
+		for _, e := range entries {
+			g.backlog.Ack(e.Index)
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) TakeInFlight(max int, deadline func(attempts int) time.Time) (entries []BacklogEntry) {
+	g.withWrite(func() {
+		entries = g.backlog.Take(max)
+		for _, e := range entries {
+			g.attempts[e.Index]++
+			g.inflight[e.Index] = &Delivery{Entry: e, Attempts: g.attempts[e.Index], Deadline: deadline(g.attempts[e.Index])}
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Reset(lastMsgIndex uint32, entries []BacklogEntry) {
+	backlog := NewPriorityBacklog(lastMsgIndex)
+	for _, e := range entries {
+		backlog.Push(e)
+	}
+	g.withWrite(func() {
+		g.backlog = backlog
+		g.inflight = make(map[uint32]*Delivery)
+		g.attempts = make(map[uint32]int)
+		g.epoch++
+	})
+}
+
+func (g *SubscriberGroup) Backlog() (sizes map[uint8]int) {
+	g.withRead(func() {
+		sizes = g.backlog.Sizes()
+	})
+	return
+}
+
+func (g *SubscriberGroup) InFlight() (size int) {
+	g.withRead(func() {
+		size = len(g.inflight)
+	})
+	return
+}
+
+func (g *SubscriberGroup) AddConsumer(consumer IPubSubConsumer) (err error) {
+	if consumer.Mode() != g.mode {
+		return errors.New(fmt.Sprintf("can not add %s consumer to %s subscriber group %s", consumer.Mode(), g.mode, g.name))
+	}
+	g.withWrite(func() {
+		for i, c := range g.consumers {
+			if c.Id() == consumer.Id() {
+				// re-subscription replaces the consumer
+				g.consumers[i] = consumer
+				return
+			}
+		}
+		g.consumers = append(g.consumers, consumer)
+	})
+	return nil
+}
+
+func (g *SubscriberGroup) RemoveConsumer(id string) (removed bool) {
+	g.withWrite(func() {
+		for i, c := range g.consumers {
+			if c.Id() == id {
+				g.consumers = append(g.consumers[:i], g.consumers[i+1:]...)
+				removed = true
+				return
+			}
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) HasConsumer(id string) (has bool) {
+	g.withRead(func() {
+		for _, c := range g.consumers {
+			if c.Id() == id {
+				has = true
+				return
+			}
+		}
+	})
+	return
+}
+
+func (g *SubscriberGroup) Consumers() (consumers []IPubSubConsumer) {
+	g.withRead(func() {
+		consumers = make([]IPubSubConsumer, len(g.consumers))
+		copy(consumers, g.consumers)
+	})
+	return
+}
+
+func (g *SubscriberGroup) Size() (size int) {
+	g.withRead(func() {
+		size = len(g.consumers)
+	})
+	return
+}
+
+func (g *SubscriberGroup) Dispatch(message IPubSubMessage) (IPubSubConsumer, error) {
+	var consumers []IPubSubConsumer
+	start := 0
+	g.withWrite(func() {
+		consumers = make([]IPubSubConsumer, len(g.consumers))
+		copy(consumers, g.consumers)
+		if len(consumers) > 0 {
+			start = g.next % len(consumers)
+			g.next = start + 1
+		}
+	})
+	if len(consumers) == 0 {
+		return nil, errors.New(fmt.Sprintf("no consumer in subscriber group %s", g.name))
+	}
+	var errMsg strings.Builder
+	for i := 0; i < len(consumers); i++ {
+		// try the following consumers if the current one fails so that the message is still consumed once
+		consumer := consumers[(start+i)%len(consumers)]
+		err := consumer.Consume(message)
+		if err == nil {
+			return consumer, nil
+		}
+		errMsg.WriteString(err.Error())
+		errMsg.WriteByte('\n')
+	}
+	return nil, errors.New(fmt.Sprintf("subscriber group %s failed to consume message %d: %s", g.name, message.Index(), errMsg.String()))
+}
+
+func (g *SubscriberGroup) Describe() SubscriberGroupDescriptor {
+	desc := SubscriberGroupDescriptor{
+		Name:    g.name,
+		Mode:    g.mode.String(),
+		Owner:   g.owner,
+		Members: g.Members(),
+	}
+	g.withRead(func() {
+		desc.LastMsgIndex = g.backlog.Watermark()
+		desc.Backlog = g.backlog.Sizes()
+		desc.InFlight = len(g.inflight)
+		desc.Consumers = make([]string, len(g.consumers))
+		for i, c := range g.consumers {
+			desc.Consumers[i] = c.Id()
+		}
+	})
+	return desc
 }
@@ -1,25 +1,190 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
 type ITopic interface {
 	Id() string
+	Creator() string
+	CTime() time.Time
 	Publishers() []string
 	Subscribers() []string
+	AddPublisher(clientId string) IPublisher
+	GetPublisher(clientId string) IPublisher
+	AddGroup(ISubscriberGroup) error
+	RemoveGroup(name string) bool
+	GetGroup(name string) ISubscriberGroup
+	Groups() []ISubscriberGroup
+	Describe() TopicDescriptor
+}
+
+type TopicDescriptor struct {
+	Id          string                      `json:"id"`
+	Creator     string                      `json:"creator"`
+	CTime       time.Time                   `json:"cTime"`
+	Publishers  []string                    `json:"publishers"`
+	Groups      []SubscriberGroupDescriptor `json:"groups"`
+	LastIndex   uint32                      `json:"lastIndex"`
+	Subscribers []string                    `json:"subscribers"`
+	// Backlog is the largest backlog among subscriber groups of each priority level
+	Backlog map[uint8]int `json:"backlog"`
+}
+
+// TopicMetrics tells how many messages are waiting for delivery of each priority level
+type TopicMetrics struct {
+	Id        string `json:"id"`
+	LastIndex uint32 `json:"lastIndex"`
+	// Backlog is the largest backlog among subscriber groups of each priority level
+	Backlog map[uint8]int            `json:"backlog"`
+	Groups  map[string]map[uint8]int `json:"groups"`
 }
 
 type Topic struct {
-	id          string
-	publishers  []string
-	subscribers []string
+	id         string
+	creator    string
+	ctime      time.Time
+	publishers map[string]IPublisher
+	groups     map[string]ISubscriberGroup
+	lock       *sync.RWMutex
+}
+
+func NewTopic(id string, creator string) ITopic {
+	return RestoreTopic(id, creator, time.Now())
+}
+
+// RestoreTopic creates a topic that was created at ctime, e.g. a topic loaded from store
+func RestoreTopic(id string, creator string, ctime time.Time) ITopic {
+	return &Topic{
+		id:         id,
+		creator:    creator,
+		ctime:      ctime,
+		publishers: make(map[string]IPublisher),
+		groups:     make(map[string]ISubscriberGroup),
+		lock:       new(sync.RWMutex),
+	}
+}
+
+func (t *Topic) withWrite(cb func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	cb()
+}
+
+func (t *Topic) withRead(cb func()) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	cb()
 }
 
 func (t *Topic) Id() string {
 	return t.id
 }
 
-func (t *Topic) Publishers() []string {
-	return t.publishers
+func (t *Topic) Creator() string {
+	return t.creator
+}
+
+func (t *Topic) CTime() time.Time {
+	return t.ctime
 }
 
+func (t *Topic) Publishers() (publishers []string) {
+	t.withRead(func() {
+		publishers = make([]string, 0, len(t.publishers))
+		for id := range t.publishers {
+			publishers = append(publishers, id)
+		}
+	})
+	return
+}
+
+// Subscribers returns ids of consumers in all subscriber groups
 func (t *Topic) Subscribers() []string {
-	return t.subscribers
+	subscribers := make([]string, 0)
+	for _, g := range t.Groups() {
+		for _, c := range g.Consumers() {
+			subscribers = append(subscribers, c.Id())
+		}
+	}
+	return subscribers
+}
+
+func (t *Topic) AddPublisher(clientId string) (publisher IPublisher) {
+	t.withWrite(func() {
+		publisher = t.publishers[clientId]
+		if publisher == nil {
+			publisher = NewPublisher(clientId)
+			t.publishers[clientId] = publisher
+		}
+	})
+	return
+}
+
+func (t *Topic) GetPublisher(clientId string) (publisher IPublisher) {
+	t.withRead(func() {
+		publisher = t.publishers[clientId]
+	})
+	return
+}
+
+func (t *Topic) AddGroup(group ISubscriberGroup) (err error) {
+	t.withWrite(func() {
+		if t.groups[group.Name()] != nil {
+			err = errors.New(fmt.Sprintf("subscriber group %s already exists in topic %s", group.Name(), t.id))
+			return
+		}
+		t.groups[group.Name()] = group
+	})
+	return
+}
+
+func (t *Topic) RemoveGroup(name string) (removed bool) {
+	t.withWrite(func() {
+		removed = t.groups[name] != nil
+		delete(t.groups, name)
+	})
+	return
+}
+
+func (t *Topic) GetGroup(name string) (group ISubscriberGroup) {
+	t.withRead(func() {
+		group = t.groups[name]
+	})
+	return
+}
+
+func (t *Topic) Groups() (groups []ISubscriberGroup) {
+	t.withRead(func() {
+		groups = make([]ISubscriberGroup, 0, len(t.groups))
+		for _, g := range t.groups {
+			groups = append(groups, g)
+		}
+	})
+	return
+}
+
+func (t *Topic) Describe() TopicDescriptor {
+	groups := t.Groups()
+	desc := TopicDescriptor{
+		Id:          t.id,
+		Creator:     t.creator,
+		CTime:       t.ctime,
+		Publishers:  t.Publishers(),
+		Groups:      make([]SubscriberGroupDescriptor, len(groups)),
+		Subscribers: t.Subscribers(),
+		Backlog:     make(map[uint8]int),
+	}
+	for i, g := range groups {
+		desc.Groups[i] = g.Describe()
+		for p, size := range desc.Groups[i].Backlog {
+			if size > desc.Backlog[p] {
+				desc.Backlog[p] = size
+			}
+		}
+	}
+	return desc
 }
@@ -0,0 +1,105 @@
+package response_cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultMaxEntries = 1024
+
+// Cache keeps at most maxEntries responses, the least recently used entries are evicted first
+type Cache struct {
+	entries    map[string]*list.Element
+	order      *list.List
+	maxEntries int
+	lock       *sync.Mutex
+}
+
+func NewCache(maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Cache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		lock:       new(sync.Mutex),
+	}
+}
+
+func (c *Cache) withLock(cb func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cb()
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*Entry).Key)
+}
+
+// Get returns the unexpired entry of the key, expired entries are dropped
+func (c *Cache) Get(key string) (entry *Entry) {
+	c.withLock(func() {
+		element := c.entries[key]
+		if element == nil {
+			return
+		}
+		if element.Value.(*Entry).Expired(time.Now()) {
+			c.remove(element)
+			return
+		}
+		c.order.MoveToFront(element)
+		entry = element.Value.(*Entry)
+	})
+	return
+}
+
+func (c *Cache) Put(entry *Entry) {
+	c.withLock(func() {
+		if element := c.entries[entry.Key]; element != nil {
+			c.remove(element)
+		}
+		c.entries[entry.Key] = c.order.PushFront(entry)
+		for c.order.Len() > c.maxEntries {
+			c.remove(c.order.Back())
+		}
+	})
+}
+
+// Purge drops entries whose uris start with the prefix, all entries are dropped if the prefix is empty
+func (c *Cache) Purge(prefix string) (purged int) {
+	c.withLock(func() {
+		for _, element := range c.entries {
+			if strings.HasPrefix(element.Value.(*Entry).Uri, prefix) {
+				c.remove(element)
+				purged++
+			}
+		}
+	})
+	return
+}
+
+// PurgePath drops entries of the path and the paths under it, e.g. "/service/files" drops "/service/files?id=1" and
+// "/service/files/list" but not "/service/files2"
+func (c *Cache) PurgePath(path string) (purged int) {
+	c.withLock(func() {
+		for _, element := range c.entries {
+			uri := element.Value.(*Entry).Uri
+			if uri == path || strings.HasPrefix(uri, path+"/") || strings.HasPrefix(uri, path+"?") {
+				c.remove(element)
+				purged++
+			}
+		}
+	})
+	return
+}
+
+func (c *Cache) Size() (size int) {
+	c.withLock(func() {
+		size = c.order.Len()
+	})
+	return
+}
@@ -0,0 +1,96 @@
+package response_cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"whub/hub_common/messages"
+)
+
+const (
+	HeaderCacheControl = "Cache-Control"
+	HeaderETag         = "ETag"
+	HeaderIfNoneMatch  = "If-None-Match"
+	// HeaderCache tells if the response is replied from the cache(HIT) or by the service(MISS)
+	HeaderCache = "X-Cache"
+)
+
+// CacheControl is the parsed Cache-Control header, only directives affecting the hub cache are kept
+type CacheControl struct {
+	NoStore bool
+	NoCache bool
+	Private bool
+	Public  bool
+	// MaxAge is valid if HasMaxAge, s-maxage takes precedence over max-age as the hub cache is shared
+	MaxAge     time.Duration
+	HasMaxAge  bool
+	HasSMaxAge bool
+}
+
+func ParseCacheControl(value string) CacheControl {
+	var cc CacheControl
+	for _, directive := range strings.Split(value, ",") {
+		name, arg := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), "\"")
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store":
+			cc.NoStore = true
+		case "no-cache":
+			cc.NoCache = true
+		case "private":
+			cc.Private = true
+		case "public":
+			cc.Public = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(arg); err == nil && !cc.HasSMaxAge {
+				cc.MaxAge, cc.HasMaxAge = time.Duration(seconds)*time.Second, true
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(arg); err == nil {
+				cc.MaxAge, cc.HasMaxAge, cc.HasSMaxAge = time.Duration(seconds)*time.Second, true, true
+			}
+		}
+	}
+	return cc
+}
+
+// Cacheable tells if the response can be kept in the cache
+func (cc CacheControl) Cacheable() bool {
+	return !cc.NoStore && !cc.NoCache && !cc.Private
+}
+
+// Shared tells if the response is explicitly allowed to be shared among requesters by public or s-maxage, other
+// responses may depend on the requester
+func (cc CacheControl) Shared() bool {
+	return cc.Cacheable() && (cc.Public || cc.HasSMaxAge)
+}
+
+// Header gets the header value regardless of the header name case, HTTP canonicalizes ETag to Etag
+func Header(message messages.IMessage, key string) string {
+	if value := message.GetHeader(key); value != "" {
+		return value
+	}
+	for k, v := range message.Headers() {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// ETagMatches tells if the etag is one of the etags in the If-None-Match header value, weak comparison is used
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
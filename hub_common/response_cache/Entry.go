@@ -0,0 +1,100 @@
+package response_cache
+
+import (
+	"strings"
+	"time"
+	"whub/hub_common/messages"
+)
+
+// Entry is a response kept in the cache
+type Entry struct {
+	Key         string
+	Uri         string
+	MessageType int
+	Headers     map[string]string
+	Payload     []byte
+	ETag        string
+	Expiry      time.Time
+}
+
+// Key identifies the shared cached response by the request uri(with query) and the values of varyHeaders
+func Key(request messages.IMessage, varyHeaders []string) string {
+	var builder strings.Builder
+	builder.WriteString(request.Uri())
+	for _, h := range varyHeaders {
+		builder.WriteString("\n")
+		builder.WriteString(h)
+		builder.WriteString(":")
+		builder.WriteString(Header(request, h))
+	}
+	return builder.String()
+}
+
+// RequesterKey identifies the cached response of the key kept for the requester only
+func RequesterKey(key string, requester string) string {
+	return key + "\nfrom:" + requester
+}
+
+// StoreKey returns the key to keep the response with, responses are only shared among requesters if they are
+// explicitly shared(see CacheControl.Shared), otherwise they are kept for the requester. ok is false if the response
+// should not be kept, e.g. an unshared response of an anonymous requester.
+func StoreKey(key string, requester string, cc CacheControl) (storeKey string, ok bool) {
+	if !cc.Cacheable() {
+		return "", false
+	}
+	if cc.Shared() {
+		return key, true
+	}
+	if requester == "" {
+		return "", false
+	}
+	return RequesterKey(key, requester), true
+}
+
+func NewEntry(key string, uri string, response messages.IMessage, ttl time.Duration) *Entry {
+	entry := &Entry{
+		Key:         key,
+		Uri:         uri,
+		MessageType: response.MessageType(),
+		Headers:     make(map[string]string),
+		Payload:     response.Payload(),
+		ETag:        Header(response, HeaderETag),
+		Expiry:      time.Now().Add(ttl),
+	}
+	for k, v := range response.Headers() {
+		if !messages.IsStreamHeader(k) {
+			entry.Headers[k] = v
+		}
+	}
+	return entry
+}
+
+func (e *Entry) Expired(now time.Time) bool {
+	return now.After(e.Expiry)
+}
+
+// Response replies the request with the cached response, a not modified response is replied if the request is
+// conditional and the cached response has a matching ETag
+func (e *Entry) Response(request messages.IMessage, from string) messages.IMessage {
+	if ETagMatches(Header(request, HeaderIfNoneMatch), e.ETag) {
+		return NotModified(request, from, e.ETag)
+	}
+	response := messages.NewMessage(request.Id(), from, request.From(), request.Uri(), e.MessageType, e.Payload)
+	for k, v := range e.Headers {
+		response.SetHeader(k, v)
+	}
+	response.SetHeader(HeaderCache, "HIT")
+	return response
+}
+
+// NotModified is the response without payload to conditional requests whose cached responses are still valid
+func NotModified(request messages.IMessage, from string, etag string) messages.IMessage {
+	response := messages.NewMessage(request.Id(), from, request.From(), request.Uri(), messages.MessageTypeSvcNotModified, nil)
+	response.SetHeader(HeaderETag, etag)
+	return response
+}
+
+// IsConditionalHit tells if the request is conditional and the response has a matching ETag
+func IsConditionalHit(request messages.IMessage, response messages.IMessage) bool {
+	return ETagMatches(Header(request, HeaderIfNoneMatch), Header(response, HeaderETag))
+}
@@ -0,0 +1,118 @@
+package response_cache
+
+import (
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/messages"
+)
+
+func newTestRequest(uri string) messages.IMessage {
+	return messages.NewMessage("1", "client", "server", uri, messages.MessageTypeServiceGetRequest, nil)
+}
+
+func newTestResponse(payload string, etag string) messages.IMessage {
+	response := messages.NewMessage("1", "provider", "client", "/files", messages.MessageTypeSvcResponseOK, []byte(payload))
+	if etag != "" {
+		response.SetHeader("Etag", etag)
+	}
+	return response
+}
+
+func TestCacheControl(t *testing.T) {
+	tg := test_utils.NewTestGroup("CacheControl", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Max age", "s-maxage should take precedence over max-age", func() bool {
+			cc := ParseCacheControl("public, s-maxage=60, max-age=10")
+			return cc.HasMaxAge && cc.MaxAge == time.Minute && cc.Cacheable() && !ParseCacheControl("").HasMaxAge
+		}),
+		test_utils.NewTestCase("Uncacheable", "no-store, no-cache and private responses should not be cached", func() bool {
+			return !ParseCacheControl("no-store").Cacheable() && !ParseCacheControl("No-Cache").Cacheable() &&
+				!ParseCacheControl("private, max-age=60").Cacheable()
+		}),
+		test_utils.NewTestCase("Shared", "only public and s-maxage responses should be shared", func() bool {
+			return ParseCacheControl("public").Shared() && ParseCacheControl("s-maxage=10").Shared() &&
+				!ParseCacheControl("max-age=10").Shared() && !ParseCacheControl("").Shared() && !ParseCacheControl("public, no-store").Shared()
+		}),
+		test_utils.NewTestCase("ETag", "etags should be matched weakly", func() bool {
+			return ETagMatches(`"a", W/"b"`, `"b"`) && ETagMatches("*", `"a"`) && !ETagMatches(`"a"`, `"b"`) && !ETagMatches("", `"a"`)
+		}),
+	}).Do(t)
+}
+
+func TestCache(t *testing.T) {
+	tg := test_utils.NewTestGroup("Cache", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Hit", "cached responses should reply with the request id", func() bool {
+			c := NewCache(0)
+			request := newTestRequest("/files?dir=a")
+			key := Key(request, []string{"Accept"})
+			c.Put(NewEntry(key, request.Uri(), newTestResponse("list", `"v1"`), time.Minute))
+			other := messages.NewMessage("2", "other", "server", "/files?dir=a", messages.MessageTypeServiceGetRequest, nil)
+			entry := c.Get(Key(other, []string{"Accept"}))
+			if entry == nil || entry.ETag != `"v1"` {
+				return false
+			}
+			response := entry.Response(other, "server")
+			return response.Id() == "2" && response.To() == "other" && string(response.Payload()) == "list" &&
+				response.GetHeader(HeaderCache) == "HIT" && c.Get(Key(newTestRequest("/files?dir=b"), nil)) == nil
+		}),
+		test_utils.NewTestCase("Requesters", "unshared responses should be kept per requester", func() bool {
+			c := NewCache(0)
+			request := newTestRequest("/presence/connections")
+			other := messages.NewMessage("2", "other", "server", "/presence/connections", messages.MessageTypeServiceGetRequest, nil)
+			key := Key(request, nil)
+			storeKey, ok := StoreKey(key, request.From(), ParseCacheControl("max-age=60"))
+			if !ok || storeKey == key || Key(other, nil) != key {
+				return false
+			}
+			c.Put(NewEntry(storeKey, request.Uri(), newTestResponse("mine", ""), time.Minute))
+			_, anonymousOk := StoreKey(key, "", ParseCacheControl(""))
+			sharedKey, sharedOk := StoreKey(key, request.From(), ParseCacheControl("public"))
+			return c.Get(key) == nil && c.Get(RequesterKey(key, other.From())) == nil && c.Get(RequesterKey(key, request.From())) != nil &&
+				!anonymousOk && sharedOk && sharedKey == key
+		}),
+		test_utils.NewTestCase("Vary", "requests with different vary header values should not share responses", func() bool {
+			c := NewCache(0)
+			request := newTestRequest("/files")
+			request.SetHeader("Accept", "text/plain")
+			c.Put(NewEntry(Key(request, []string{"Accept"}), request.Uri(), newTestResponse("list", ""), time.Minute))
+			return c.Get(Key(newTestRequest("/files"), []string{"Accept"})) == nil && c.Get(Key(request, []string{"Accept"})) != nil
+		}),
+		test_utils.NewTestCase("Not modified", "conditional requests with matching etags should get not modified", func() bool {
+			request := newTestRequest("/files")
+			request.SetHeader(HeaderIfNoneMatch, `"v1"`)
+			response := NewEntry("/files", "/files", newTestResponse("list", `"v1"`), time.Minute).Response(request, "server")
+			return response.MessageType() == messages.MessageTypeSvcNotModified && len(response.Payload()) == 0 &&
+				response.GetHeader(HeaderETag) == `"v1"` && IsConditionalHit(request, newTestResponse("list", `W/"v1"`))
+		}),
+		test_utils.NewTestCase("Expiry and eviction", "expired and least recently used entries should be dropped", func() bool {
+			c := NewCache(2)
+			c.Put(NewEntry("a", "/a", newTestResponse("a", ""), time.Minute))
+			c.Put(NewEntry("b", "/b", newTestResponse("b", ""), time.Minute))
+			c.Get("a")
+			c.Put(NewEntry("c", "/c", newTestResponse("c", ""), time.Minute))
+			if c.Get("b") != nil || c.Get("a") == nil || c.Get("c") == nil {
+				return false
+			}
+			expiring := NewCache(0)
+			expiring.Put(NewEntry("d", "/d", newTestResponse("d", ""), time.Millisecond))
+			time.Sleep(5 * time.Millisecond)
+			return expiring.Get("d") == nil && expiring.Size() == 0
+		}),
+		test_utils.NewTestCase("Purge", "entries should be purged by uri prefix", func() bool {
+			c := NewCache(0)
+			for _, uri := range []string{"/files/list", "/files/stat", "/echo/hi"} {
+				c.Put(NewEntry(uri, uri, newTestResponse(uri, ""), time.Minute))
+			}
+			return c.Purge("/files/") == 2 && c.Size() == 1 && c.Purge("") == 1 && c.Size() == 0
+		}),
+		test_utils.NewTestCase("Purge path", "entries of services sharing an id prefix should not be purged", func() bool {
+			c := NewCache(0)
+			for _, uri := range []string{"/service/files", "/service/files?id=1", "/service/files/list", "/service/files2/list", "/service/files2"} {
+				c.Put(NewEntry(uri, uri, newTestResponse(uri, ""), time.Minute))
+			}
+			return c.PurgePath("/service/files") == 3 && c.Size() == 2 && c.Get("/service/files2/list") != nil && c.Get("/service/files2") != nil
+		}),
+	}).Do(t)
+}
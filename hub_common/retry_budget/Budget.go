@@ -0,0 +1,116 @@
+package retry_budget
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRatio               = 0.2
+	DefaultMinRetriesPerSecond = 10
+	DefaultWindow              = time.Second * 10
+)
+
+// Status is a snapshot of the budget within the window
+type Status struct {
+	Requests  int `json:"requests"`
+	Retries   int `json:"retries"`
+	Available int `json:"available"`
+}
+
+type bucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// Budget limits retries to a ratio of requests within a sliding window, so that retries don't amplify an outage. A
+// minimum number of retries per second is always allowed for low traffic.
+type Budget struct {
+	ratio               float64
+	minRetriesPerSecond int
+	// one bucket for each second of the window
+	buckets []bucket
+	lock    *sync.Mutex
+	now     func() time.Time
+}
+
+// NewBudget creates a budget, zero values are replaced by defaults
+func NewBudget(ratio float64, minRetriesPerSecond int, window time.Duration) *Budget {
+	if ratio <= 0 {
+		ratio = DefaultRatio
+	}
+	if minRetriesPerSecond <= 0 {
+		minRetriesPerSecond = DefaultMinRetriesPerSecond
+	}
+	if window < time.Second {
+		window = DefaultWindow
+	}
+	return &Budget{
+		ratio:               ratio,
+		minRetriesPerSecond: minRetriesPerSecond,
+		buckets:             make([]bucket, int(window/time.Second)),
+		lock:                new(sync.Mutex),
+		now:                 time.Now,
+	}
+}
+
+func (b *Budget) withLock(cb func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	cb()
+}
+
+// current returns the bucket of the current second
+func (b *Budget) current() *bucket {
+	second := b.now().Unix()
+	curr := &b.buckets[second%int64(len(b.buckets))]
+	if curr.second != second {
+		*curr = bucket{second: second}
+	}
+	return curr
+}
+
+// status counts buckets within the window
+func (b *Budget) status() (status Status) {
+	oldest := b.now().Unix() - int64(len(b.buckets))
+	for _, bucket := range b.buckets {
+		if bucket.second > oldest {
+			status.Requests += bucket.requests
+			status.Retries += bucket.retries
+		}
+	}
+	status.Available = int(b.ratio * float64(status.Requests))
+	if min := b.minRetriesPerSecond * len(b.buckets); status.Available < min {
+		status.Available = min
+	}
+	status.Available -= status.Retries
+	if status.Available < 0 {
+		status.Available = 0
+	}
+	return
+}
+
+// Deposit records a request
+func (b *Budget) Deposit() {
+	b.withLock(func() {
+		b.current().requests++
+	})
+}
+
+// TryWithdraw records a retry if the budget allows
+func (b *Budget) TryWithdraw() (ok bool) {
+	b.withLock(func() {
+		if ok = b.status().Available > 0; ok {
+			b.current().retries++
+		}
+	})
+	return
+}
+
+func (b *Budget) Status() (status Status) {
+	b.withLock(func() {
+		status = b.status()
+	})
+	return
+}
@@ -0,0 +1,61 @@
+package retry_budget
+
+import (
+	"testing"
+	"time"
+	"whub/common/test_utils"
+)
+
+// newTestBudget returns a budget with a clock moved by the returned func
+func newTestBudget(ratio float64, minRetriesPerSecond int, window time.Duration) (*Budget, func(d time.Duration)) {
+	b := NewBudget(ratio, minRetriesPerSecond, window)
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time {
+		return now
+	}
+	return b, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func withdraw(b *Budget, n int) (withdrawn int) {
+	for i := 0; i < n; i++ {
+		if b.TryWithdraw() {
+			withdrawn++
+		}
+	}
+	return
+}
+
+func TestBudget(t *testing.T) {
+	tg := test_utils.NewTestGroup("Budget", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Min retries", "min retries should be allowed without requests", func() bool {
+			b, _ := newTestBudget(0.1, 2, time.Second*2)
+			return withdraw(b, 10) == 4 && b.Status().Available == 0
+		}),
+		test_utils.NewTestCase("Ratio", "retries should be limited to the ratio of requests", func() bool {
+			b, _ := newTestBudget(0.5, 1, time.Second)
+			for i := 0; i < 10; i++ {
+				b.Deposit()
+			}
+			status := b.Status()
+			return status.Requests == 10 && status.Available == 5 && withdraw(b, 10) == 5 && b.Status().Retries == 5
+		}),
+		test_utils.NewTestCase("Window", "requests and retries out of the window should be dropped", func() bool {
+			b, advance := newTestBudget(0.5, 1, time.Second*2)
+			for i := 0; i < 10; i++ {
+				b.Deposit()
+			}
+			withdraw(b, 5)
+			advance(time.Second)
+			b.Deposit()
+			if status := b.Status(); status.Requests != 11 || status.Retries != 5 {
+				return false
+			}
+			advance(time.Second)
+			status := b.Status()
+			return status.Requests == 1 && status.Retries == 0 && status.Available == 2
+		}),
+	}).Do(t)
+}
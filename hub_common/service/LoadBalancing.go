@@ -0,0 +1,51 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Load balancing strategies of relay services with multiple provider connections
+const (
+	LoadBalancingRoundRobin         = "round_robin" // default
+	LoadBalancingLeastOutstanding   = "least_outstanding"
+	LoadBalancingWeightedRoundRobin = "weighted_round_robin"
+	LoadBalancingEWMA               = "ewma" // latency-aware
+	LoadBalancingConsistentHash     = "consistent_hash"
+)
+
+// LoadBalancingDescriptor selects how requests are balanced among provider connections of a relay service
+type LoadBalancingDescriptor struct {
+	Strategy string `json:"strategy,omitempty"`
+	// Weight of the provider connections registered with the descriptor, 0 means 1
+	Weight int `json:"weight,omitempty"`
+	// HashHeader or HashPathParam is the request header or path param hashed by consistent_hash, requests without the
+	// value are hashed by the requester id
+	HashHeader    string `json:"hashHeader,omitempty"`
+	HashPathParam string `json:"hashPathParam,omitempty"`
+}
+
+func (d LoadBalancingDescriptor) Validate() error {
+	switch d.Strategy {
+	case "", LoadBalancingRoundRobin, LoadBalancingLeastOutstanding, LoadBalancingWeightedRoundRobin, LoadBalancingEWMA:
+	case LoadBalancingConsistentHash:
+		if d.HashHeader != "" && d.HashPathParam != "" {
+			return errors.New("only one of hashHeader and hashPathParam can be set")
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown load balancing strategy %s", d.Strategy))
+	}
+	if d.Weight < 0 {
+		return errors.New(fmt.Sprintf("invalid load balancing weight %d", d.Weight))
+	}
+	return nil
+}
+
+func (d LoadBalancingDescriptor) String() string {
+	marshalled, err := json.Marshal(d)
+	if err != nil {
+		return "{}"
+	}
+	return string(marshalled)
+}
@@ -2,6 +2,8 @@ package service
 
 import "whub/hub_common/messages"
 
+// IRequestHandlerMap builds routes of a service. *Typed methods take TypedRequestHandler(see NewTypedRequestHandler),
+// routes of invalid typed handlers are not added and Err tells the first of them.
 type IRequestHandlerMap interface {
 	Add(requestType int, uri string, handler RequestHandler) IRequestHandlerMap
 	Get(uri string, handler RequestHandler) IRequestHandlerMap
@@ -11,11 +13,20 @@ type IRequestHandlerMap interface {
 	Delete(uri string, handler RequestHandler) IRequestHandlerMap
 	Head(uri string, handler RequestHandler) IRequestHandlerMap
 	Options(uri string, handler RequestHandler) IRequestHandlerMap
+	AddTyped(requestType int, uri string, handler TypedRequestHandler) IRequestHandlerMap
+	GetTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap
+	PostTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap
+	PutTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap
+	PatchTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap
+	DeleteTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap
+	// Err returns the error of the first invalid typed handler, nil if all handlers are added
+	Err() error
 	Build() map[int]map[string]RequestHandler
 }
 
 type RequestHandlerMap struct {
 	handlersMap map[int]map[string]RequestHandler
+	err         error
 }
 
 func NewRequestHandlerMapBuilder() *RequestHandlerMap {
@@ -60,6 +71,41 @@ func (b *RequestHandlerMap) Options(uri string, handler RequestHandler) IRequest
 	return b.Add(messages.MessageTypeServiceOptionsRequest, uri, handler)
 }
 
+func (b *RequestHandlerMap) AddTyped(requestType int, uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	requestHandler, err := NewTypedRequestHandler(handler)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	return b.Add(requestType, uri, requestHandler)
+}
+
+func (b *RequestHandlerMap) GetTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	return b.AddTyped(messages.MessageTypeServiceGetRequest, uri, handler)
+}
+
+func (b *RequestHandlerMap) PostTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	return b.AddTyped(messages.MessageTypeServicePostRequest, uri, handler)
+}
+
+func (b *RequestHandlerMap) PutTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	return b.AddTyped(messages.MessageTypeServicePutRequest, uri, handler)
+}
+
+func (b *RequestHandlerMap) PatchTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	return b.AddTyped(messages.MessageTypeServicePatchRequest, uri, handler)
+}
+
+func (b *RequestHandlerMap) DeleteTyped(uri string, handler TypedRequestHandler) IRequestHandlerMap {
+	return b.AddTyped(messages.MessageTypeServiceDeleteRequest, uri, handler)
+}
+
+func (b *RequestHandlerMap) Err() error {
+	return b.err
+}
+
 func (b *RequestHandlerMap) Build() map[int]map[string]RequestHandler {
 	return b.handlersMap
 }
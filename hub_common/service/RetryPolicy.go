@@ -0,0 +1,166 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"whub/hub_common/messages"
+)
+
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBackoffBase = time.Millisecond * 25
+	DefaultRetryBackoffMax  = time.Millisecond * 250
+)
+
+// request methods of message types, requests of idempotent methods are retried by default
+var retryMethods = map[string]int{
+	http.MethodGet:     messages.MessageTypeServiceGetRequest,
+	http.MethodHead:    messages.MessageTypeServiceHeadRequest,
+	http.MethodOptions: messages.MessageTypeServiceOptionsRequest,
+	http.MethodPost:    messages.MessageTypeServicePostRequest,
+	http.MethodPut:     messages.MessageTypeServicePutRequest,
+	http.MethodPatch:   messages.MessageTypeServicePatchRequest,
+	http.MethodDelete:  messages.MessageTypeServiceDeleteRequest,
+}
+
+var defaultRetryOn = []int{messages.MessageTypeSvcInternalError, messages.MessageTypeSvcUnavailableError, messages.MessageTypeSvcGatewayTimeoutError}
+
+// RetryPolicy selects how a failed request is retried on other provider connections of a relay service. Requests are
+// retried on connection errors and the response types of RetryOn. Zero values use the defaults.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, 1 disables retries
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// RetryOn are the response types to retry, 500, 503 and 504 if empty
+	RetryOn     []int `json:"retryOn,omitempty"`
+	BackoffBase int   `json:"backoffBase,omitempty"` // in milliseconds
+	BackoffMax  int   `json:"backoffMax,omitempty"`  // in milliseconds
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return errors.New(fmt.Sprintf("invalid retry max attempts %d", p.MaxAttempts))
+	}
+	if p.BackoffBase < 0 || p.BackoffMax < 0 {
+		return errors.New("invalid retry backoff")
+	}
+	for _, t := range p.RetryOn {
+		if t < messages.MessageTypeSvcBadRequestError || t > messages.MessageTypeSvcGatewayTimeoutError {
+			return errors.New(fmt.Sprintf("invalid retry response type %d", t))
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = defaultRetryOn
+	}
+	if p.BackoffBase == 0 {
+		p.BackoffBase = int(DefaultRetryBackoffBase / time.Millisecond)
+	}
+	if p.BackoffMax == 0 {
+		p.BackoffMax = int(DefaultRetryBackoffMax / time.Millisecond)
+	}
+	if p.BackoffMax < p.BackoffBase {
+		p.BackoffMax = p.BackoffBase
+	}
+	return p
+}
+
+// inherit fills zero values from the parent policy
+func (p RetryPolicy) inherit(parent RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = parent.MaxAttempts
+	}
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = parent.RetryOn
+	}
+	if p.BackoffBase == 0 {
+		p.BackoffBase = parent.BackoffBase
+	}
+	if p.BackoffMax == 0 {
+		p.BackoffMax = parent.BackoffMax
+	}
+	return p
+}
+
+// ShouldRetryOn tells if the response should be retried, nil response means a connection error
+func (p RetryPolicy) ShouldRetryOn(response messages.IMessage) bool {
+	if response == nil {
+		return true
+	}
+	for _, t := range p.RetryOn {
+		if response.MessageType() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the max delay before the n-th retry(starting from 1), the delay doubles on each retry up to BackoffMax
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	backoff := time.Duration(p.BackoffBase) * time.Millisecond
+	max := time.Duration(p.BackoffMax) * time.Millisecond
+	for i := 1; i < n && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// RetryDescriptor is the retry policy of a relay service
+type RetryDescriptor struct {
+	RetryPolicy
+	// Methods are the policies of request methods(GET, POST, ...) overriding the service policy, zero values are
+	// inherited from the service policy. Only GET, HEAD and OPTIONS requests are retried by the service policy,
+	// requests of other methods are retried only if they have a policy here.
+	Methods map[string]RetryPolicy `json:"methods,omitempty"`
+}
+
+func (d RetryDescriptor) Validate() error {
+	if err := d.RetryPolicy.Validate(); err != nil {
+		return err
+	}
+	for method, policy := range d.Methods {
+		if _, ok := retryMethods[method]; !ok {
+			return errors.New(fmt.Sprintf("unknown retry method %s", method))
+		}
+		if err := policy.Validate(); err != nil {
+			return errors.New(fmt.Sprintf("invalid retry policy of %s: %s", method, err.Error()))
+		}
+	}
+	return nil
+}
+
+// PolicyOf returns the policy with defaults of the request message type, ok is false if the request is not retried
+func (d RetryDescriptor) PolicyOf(messageType int) (policy RetryPolicy, ok bool) {
+	for method, methodPolicy := range d.Methods {
+		if retryMethods[method] == messageType {
+			policy = methodPolicy.inherit(d.RetryPolicy).withDefaults()
+			return policy, policy.MaxAttempts > 1
+		}
+	}
+	switch messageType {
+	case messages.MessageTypeServiceGetRequest, messages.MessageTypeServiceHeadRequest, messages.MessageTypeServiceOptionsRequest:
+		policy = d.RetryPolicy.withDefaults()
+		return policy, policy.MaxAttempts > 1
+	default:
+		return RetryPolicy{MaxAttempts: 1}, false
+	}
+}
+
+func (d RetryDescriptor) String() string {
+	marshalled, err := json.Marshal(d)
+	if err != nil {
+		return "{}"
+	}
+	return string(marshalled)
+}
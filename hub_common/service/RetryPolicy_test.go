@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/messages"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tg := test_utils.NewTestGroup("RetryPolicy", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Default methods", "only GET, HEAD and OPTIONS requests should be retried by default", func() bool {
+			d := RetryDescriptor{}
+			policy, ok := d.PolicyOf(messages.MessageTypeServiceGetRequest)
+			_, headOk := d.PolicyOf(messages.MessageTypeServiceHeadRequest)
+			_, postOk := d.PolicyOf(messages.MessageTypeServicePostRequest)
+			return ok && headOk && !postOk && policy.MaxAttempts == DefaultRetryMaxAttempts &&
+				policy.ShouldRetryOn(nil) && policy.ShouldRetryOn(messages.NewMessage("", "", "", "", messages.MessageTypeSvcUnavailableError, nil)) &&
+				!policy.ShouldRetryOn(messages.NewMessage("", "", "", "", messages.MessageTypeSvcNotFoundError, nil))
+		}),
+		test_utils.NewTestCase("Method policies", "method policies should override and inherit the service policy", func() bool {
+			var d RetryDescriptor
+			if err := json.Unmarshal([]byte(`{"maxAttempts":4,"backoffBase":10,"methods":{"POST":{"retryOn":[503]},"GET":{"maxAttempts":1}}}`), &d); err != nil || d.Validate() != nil {
+				return false
+			}
+			post, postOk := d.PolicyOf(messages.MessageTypeServicePostRequest)
+			_, getOk := d.PolicyOf(messages.MessageTypeServiceGetRequest)
+			head, _ := d.PolicyOf(messages.MessageTypeServiceHeadRequest)
+			return postOk && post.MaxAttempts == 4 && post.BackoffBase == 10 && len(post.RetryOn) == 1 &&
+				!post.ShouldRetryOn(messages.NewMessage("", "", "", "", messages.MessageTypeSvcInternalError, nil)) &&
+				!getOk && head.MaxAttempts == 4
+		}),
+		test_utils.NewTestCase("Validate", "invalid policies should be rejected", func() bool {
+			return RetryDescriptor{Methods: map[string]RetryPolicy{"FETCH": {}}}.Validate() != nil &&
+				RetryDescriptor{RetryPolicy: RetryPolicy{MaxAttempts: -1}}.Validate() != nil &&
+				RetryDescriptor{RetryPolicy: RetryPolicy{RetryOn: []int{200}}}.Validate() != nil
+		}),
+		test_utils.NewTestCase("Backoff", "backoff should double up to the max", func() bool {
+			policy := RetryPolicy{BackoffBase: 10, BackoffMax: 30}.withDefaults()
+			return policy.Backoff(1) == time.Millisecond*10 && policy.Backoff(2) == time.Millisecond*20 && policy.Backoff(3) == time.Millisecond*30
+		}),
+	}).Do(t)
+}
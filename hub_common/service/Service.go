@@ -37,6 +37,13 @@ type ServiceDescriptor struct {
 	AccessType    int                  `json:"accessType"`
 	ExecutionType int                  `json:"executionType"`
 	Status        int                  `json:"status"`
+	// LoadBalancing only applies to relay services
+	LoadBalancing LoadBalancingDescriptor `json:"loadBalancing"`
+	// Retry only applies to relay services
+	Retry RetryDescriptor `json:"retry"`
+	// CoProviders are ids of other clients allowed to provide the relay service along with the provider, only the ones
+	// of the primary provider apply
+	CoProviders []string `json:"coProviders"`
 }
 
 func (sd ServiceDescriptor) marshallStringField(key string, value string) string {
@@ -68,7 +75,7 @@ func (sd ServiceDescriptor) marshallNumberField(key string, value int) string {
 }
 
 func (sd ServiceDescriptor) String() string {
-	return fmt.Sprintf("{%s,%s,%s,%s,%s,%s,%s,%s,%s,%s}",
+	return fmt.Sprintf("{%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s}",
 		sd.marshallStringField("id", sd.Id),
 		sd.marshallStringField("description", sd.Description),
 		sd.marshallObjField("hostInfo", sd.HostInfo.String()),
@@ -79,6 +86,9 @@ func (sd ServiceDescriptor) String() string {
 		sd.marshallNumberField("accessType", sd.AccessType),
 		sd.marshallNumberField("executionType", sd.ExecutionType),
 		sd.marshallNumberField("status", sd.Status),
+		sd.marshallObjField("loadBalancing", sd.LoadBalancing.String()),
+		sd.marshallObjField("retry", sd.Retry.String()),
+		sd.marshallArrStringField("coProviders", sd.CoProviders),
 	)
 }
 
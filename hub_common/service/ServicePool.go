@@ -16,6 +16,8 @@ type IServiceTaskQueue interface {
 	Has(id string) bool
 	KillAll() error
 	Cancel(id string) error
+	// CancelIf cancels the request only if the predicate accepts it, e.g. the cancellation is from its requester
+	CancelIf(id string, predicate func(request IServiceRequest) bool) error
 	CancelAll() error
 	Size() int
 }
@@ -46,7 +48,7 @@ func (p *ServiceTaskQueue) withAll(operation func(request IServiceRequest) error
 			err := operation(v)
 			if err != nil {
 				hasError = true
-				fmt.Sprintf("%s%s", errorMessage, err)
+				errorMessage = fmt.Sprintf("%s%s", errorMessage, err)
 			}
 		}
 	})
@@ -84,22 +86,30 @@ func (p *ServiceTaskQueue) Remove(id string) bool {
 }
 
 func (p *ServiceTaskQueue) Schedule(request IServiceRequest) *async.WaitLock {
-	if p.Has(request.Id()) {
+	id := request.Id()
+	if p.Has(id) {
 		return nil
 	}
 	p.withWrite(func() {
-		p.requestSet[request.Id()] = request
+		p.requestSet[id] = request
 	})
 	return p.pool.Schedule(func() {
+		request.TransitStatus(ServiceRequestStatusProcessing)
 		// check if message_dispatcher is processable
-		if UnProcessableServiceRequestMap[request.Status()] {
-			request.Resolve(messages.NewErrorResponse(request, p.hostId, 503, "request has been cancelled or target server is dead"))
+		if request.Status() != ServiceRequestStatusProcessing {
+			// the request has been released by Cancel or Kill, no response is expected from the queue
+			p.Remove(id)
+			return
+		}
+		// the requester has given up on the request, executing it is a waste
+		if messages.IsDeadlineExceeded(request.Message()) {
+			request.Resolve(messages.NewErrorResponse(request, p.hostId, messages.MessageTypeSvcGatewayTimeoutError, "request deadline exceeded before execution"))
+			p.Remove(id)
 			return
 		}
-		request.TransitStatus(ServiceRequestStatusProcessing)
 		// execute should take care of the execution logic
 		p.executor.Execute(request)
-		p.Remove(request.Id())
+		p.Remove(id)
 	})
 }
 
@@ -117,6 +127,17 @@ func (p *ServiceTaskQueue) Cancel(id string) error {
 	return msg.Cancel()
 }
 
+func (p *ServiceTaskQueue) CancelIf(id string, predicate func(request IServiceRequest) bool) error {
+	msg := p.Get(id)
+	if msg == nil {
+		return errors.New("Can not find message_dispatcher " + id + " from the set")
+	}
+	if !predicate(msg) {
+		return errors.New(fmt.Sprintf("request %s can not be cancelled by the requester", id))
+	}
+	return msg.Cancel()
+}
+
 func (p *ServiceTaskQueue) CancelAll() error {
 	return p.withAll(func(message IServiceRequest) error {
 		return message.Cancel()
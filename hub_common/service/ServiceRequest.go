@@ -1,11 +1,13 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
 	"whub/common/async"
+	"whub/hub_common/connection"
 	"whub/hub_common/messages"
 )
 
@@ -71,6 +73,11 @@ type ServiceRequest struct {
 	status  int
 	messages.IMessage
 	requestContext map[string]interface{}
+	// conn is where the request comes from, responses and streams go back through it
+	conn connection.IConnection
+	// ctx is done when the request deadline is exceeded or the request is cancelled, killed or freed
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewServiceRequest(m messages.IMessage) IServiceRequest {
@@ -79,6 +86,12 @@ func NewServiceRequest(m messages.IMessage) IServiceRequest {
 	request.barrier = async.NewStatefulBarrier()
 	request.status = ServiceRequestStatusQueued
 	request.IMessage = m
+	request.conn = nil
+	if deadline := m.Deadline(); !deadline.IsZero() {
+		request.ctx, request.cancel = context.WithDeadline(context.Background(), deadline)
+	} else {
+		request.ctx, request.cancel = context.WithCancel(context.Background())
+	}
 	return request
 }
 
@@ -92,6 +105,13 @@ type IServiceRequest interface {
 	IsCancelled() bool
 	IsFinished() bool
 	Resolve(messages.IMessage) error
+	// Stream resolves the request with a stream header carrying headers, the response body is then written to the
+	// returned writer chunk by chunk
+	Stream(from string, headers map[string]string) (connection.IStreamWriter, error)
+	BindConnection(connection.IConnection)
+	Connection() connection.IConnection
+	// Context carries the request deadline, handlers should give up once it's done
+	Context() context.Context
 	Wait() error // wait for the state to transit to final (dead/finished/cancelled)
 	Response() messages.IMessage
 	TransitStatus(int)
@@ -101,8 +121,8 @@ type IServiceRequest interface {
 }
 
 func (t *ServiceRequest) setStatus(status int) {
-	if t.Status() == ServiceRequestStatusFinished {
-		// can not set status of a finished service message_dispatcher
+	if t.Status() == ServiceRequestStatusFinished || UnProcessableServiceRequestMap[t.Status()] {
+		// can not set status of a finished, dead or cancelled service message_dispatcher
 		return
 	}
 	t.status = status
@@ -117,28 +137,66 @@ func (t *ServiceRequest) Kill() error {
 		return errors.New("unable to kill a " + statusCodeStringMap[t.Status()] + " ServiceRequest")
 	}
 	t.status = ServiceRequestStatusDead
+	t.cancel()
 	t.barrier.OpenWith(nil)
 	return nil
 }
 
+// Cancel cancels the request context. A queued request will not be executed, while a processing request is still
+// resolved by its executor, usually with the error its handler gives up on the cancelled context with.
 func (t *ServiceRequest) Cancel() error {
-	if t.Status() > 1 {
-		return errors.New("unable to cancel a " + statusCodeStringMap[t.Status()] + " ServiceRequest")
+	status := t.Status()
+	if status > 1 {
+		return errors.New("unable to cancel a " + statusCodeStringMap[status] + " ServiceRequest")
 	}
 	t.status = ServiceRequestStatusCancelled
-	t.barrier.OpenWith(nil)
+	t.cancel()
+	if status == ServiceRequestStatusQueued {
+		t.barrier.OpenWith(nil)
+	}
 	return nil
 }
 
 func (t *ServiceRequest) Resolve(m messages.IMessage) error {
-	if t.Status() != ServiceRequestStatusProcessing {
+	status := t.Status()
+	if status != ServiceRequestStatusProcessing && status != ServiceRequestStatusCancelled {
 		return errors.New("can not Resolve a non-processing ServiceRequest")
 	}
-	t.status = ServiceRequestStatusFinished
+	if status == ServiceRequestStatusProcessing {
+		t.status = ServiceRequestStatusFinished
+	}
 	t.barrier.OpenWith(m)
 	return nil
 }
 
+func (t *ServiceRequest) Stream(from string, headers map[string]string) (connection.IStreamWriter, error) {
+	if t.conn == nil {
+		return nil, errors.New("unable to stream a ServiceRequest without connection")
+	}
+	if t.Status() != ServiceRequestStatusProcessing {
+		return nil, errors.New("can not stream a non-processing ServiceRequest")
+	}
+	// writer needs to listen for credits before the stream header is sent
+	writer := t.conn.OpenStream(t.Id(), from, t.From(), t.Uri())
+	if err := t.Resolve(messages.NewStreamHeaderMessage(t.Id(), from, t.From(), t.Uri(), headers)); err != nil {
+		writer.CloseWithError(err.Error())
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (t *ServiceRequest) BindConnection(conn connection.IConnection) {
+	t.conn = conn
+}
+
+func (t *ServiceRequest) Connection() connection.IConnection {
+	return t.conn
+}
+
+func (t *ServiceRequest) Context() context.Context {
+	return t.ctx
+}
+
 func (t *ServiceRequest) IsDead() bool {
 	return t.Status() == ServiceRequestStatusDead
 }
@@ -159,8 +217,10 @@ func (t *ServiceRequest) Wait() error {
 	return nil
 }
 
+// Response waits for the response, nil if the request is cancelled before execution or killed
 func (t *ServiceRequest) Response() messages.IMessage {
-	return t.barrier.Get().(messages.IMessage)
+	resp, _ := t.barrier.Get().(messages.IMessage)
+	return resp
 }
 
 func (t *ServiceRequest) TransitStatus(status int) {
@@ -190,5 +250,7 @@ func (t *ServiceRequest) String() string {
 func (t *ServiceRequest) Free() {
 	t.requestContext = nil
 	t.barrier = nil
+	t.conn = nil
+	t.cancel()
 	requestPool.Put(t)
 }
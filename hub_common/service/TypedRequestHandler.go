@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"whub/hub_common/messages"
+)
+
+// TypedRequestHandler is the context-aware handler style, it's a func of the form
+//   func(ctx context.Context, request IServiceRequest, body *T) error
+// where ctx is the request context carrying the deadline and cancellation of the request, and body is decoded from
+// the JSON payload. body is validated before the handler is called if *T implements IRequestValidator.
+type TypedRequestHandler interface{}
+
+// IRequestValidator is implemented by typed request bodies that need validation
+type IRequestValidator interface {
+	Validate() error
+}
+
+// IRequestError is returned by request handlers to resolve the request with the error type instead of an internal
+// error
+type IRequestError interface {
+	Error() string
+	Code() int
+}
+
+type RequestError struct {
+	code int
+	msg  string
+}
+
+func (e *RequestError) Error() string {
+	return e.msg
+}
+
+func (e *RequestError) Code() int {
+	return e.code
+}
+
+func NewRequestError(code int, msg string) IRequestError {
+	return &RequestError{code, msg}
+}
+
+func NewBadRequestError(msg string) IRequestError {
+	return NewRequestError(messages.MessageTypeSvcBadRequestError, msg)
+}
+
+// AsRequestError tells if err is(or wraps) an IRequestError
+func AsRequestError(err error) (IRequestError, bool) {
+	var requestErr IRequestError
+	if errors.As(err, &requestErr) {
+		return requestErr, true
+	}
+	return nil, false
+}
+
+var (
+	contextType        = reflect.TypeOf((*context.Context)(nil)).Elem()
+	serviceRequestType = reflect.TypeOf((*IServiceRequest)(nil)).Elem()
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewTypedRequestHandler adapts a TypedRequestHandler to RequestHandler. Payloads that can not be decoded or
+// validated are rejected with a bad request error before the handler is called.
+func NewTypedRequestHandler(handler TypedRequestHandler) (RequestHandler, error) {
+	if handler == nil {
+		return nil, errors.New("invalid typed request handler: nil")
+	}
+	fn := reflect.ValueOf(handler)
+	fnType := fn.Type()
+	if fn.Kind() != reflect.Func || fnType.NumIn() != 3 || fnType.NumOut() != 1 ||
+		fnType.In(0) != contextType || fnType.In(1) != serviceRequestType ||
+		fnType.In(2).Kind() != reflect.Ptr || fnType.Out(0) != errorType {
+		return nil, errors.New(fmt.Sprintf("invalid typed request handler %s, expecting func(context.Context, IServiceRequest, *T) error", fnType))
+	}
+	bodyType := fnType.In(2).Elem()
+	return func(request IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+		body := reflect.New(bodyType)
+		if len(request.Payload()) > 0 {
+			if err := json.Unmarshal(request.Payload(), body.Interface()); err != nil {
+				return NewBadRequestError(fmt.Sprintf("invalid request body: %s", err.Error()))
+			}
+		}
+		if validator, ok := body.Interface().(IRequestValidator); ok {
+			if err := validator.Validate(); err != nil {
+				return NewBadRequestError(err.Error())
+			}
+		}
+		ctx := request.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		results := fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(request), body})
+		if err, _ := results[0].Interface().(error); err != nil {
+			return err
+		}
+		return nil
+	}, nil
+}
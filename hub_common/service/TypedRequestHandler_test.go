@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"whub/common/test_utils"
+	"whub/hub_common/messages"
+)
+
+type typedTestBody struct {
+	Name string `json:"name"`
+}
+
+func (b *typedTestBody) Validate() error {
+	if b.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type typedTestError struct{}
+
+func (e typedTestError) Error() string {
+	return "forbidden"
+}
+
+func (e typedTestError) Code() int {
+	return messages.MessageTypeSvcForbiddenError
+}
+
+func newTypedTestRequest(payload string) IServiceRequest {
+	return NewServiceRequest(messages.NewMessage("1", "a", "b", "/x", messages.MessageTypeServicePostRequest, ([]byte)(payload)))
+}
+
+func TestTypedRequestHandler(t *testing.T) {
+	var received *typedTestBody
+	typed := func(ctx context.Context, request IServiceRequest, body *typedTestBody) error {
+		if ctx == nil {
+			return errors.New("missing context")
+		}
+		received = body
+		return nil
+	}
+	tg := test_utils.NewTestGroup("TypedRequestHandler", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Decode", "body should be decoded from the payload", func() bool {
+			handler, err := NewTypedRequestHandler(typed)
+			if err != nil {
+				t.Log("unable to adapt handler due to ", err)
+				return false
+			}
+			received = nil
+			return handler(newTypedTestRequest(`{"name":"whub"}`), nil, nil) == nil && received != nil && received.Name == "whub"
+		}),
+		test_utils.NewTestCase("Invalid body", "invalid payloads should be rejected as bad requests", func() bool {
+			handler, _ := NewTypedRequestHandler(typed)
+			received = nil
+			requestErr, ok := AsRequestError(handler(newTypedTestRequest(`{"name":`), nil, nil))
+			return ok && requestErr.Code() == messages.MessageTypeSvcBadRequestError && received == nil
+		}),
+		test_utils.NewTestCase("Validate", "bodies should be validated before the handler is called", func() bool {
+			handler, _ := NewTypedRequestHandler(typed)
+			received = nil
+			requestErr, ok := AsRequestError(handler(newTypedTestRequest(`{}`), nil, nil))
+			return ok && requestErr.Error() == "name is required" && received == nil
+		}),
+		test_utils.NewTestCase("Custom request error", "any wrapped IRequestError should be recognized", func() bool {
+			requestErr, ok := AsRequestError(fmt.Errorf("wrapped: %w", typedTestError{}))
+			_, okPlain := AsRequestError(errors.New("plain"))
+			return ok && requestErr.Code() == messages.MessageTypeSvcForbiddenError && !okPlain
+		}),
+		test_utils.NewTestCase("Invalid handler", "", func() bool {
+			_, errNil := NewTypedRequestHandler(nil)
+			_, errBody := NewTypedRequestHandler(func(ctx context.Context, request IServiceRequest, body typedTestBody) error { return nil })
+			_, errArgs := NewTypedRequestHandler(func(request IServiceRequest) error { return nil })
+			return errNil != nil && errBody != nil && errArgs != nil
+		}),
+		test_utils.NewTestCase("Both styles", "builder should accept both handler styles", func() bool {
+			builder := NewRequestHandlerMapBuilder().
+				Get("/legacy", func(request IServiceRequest, pathParams map[string]string, queryParams map[string]string) error { return nil }).
+				PostTyped("/typed", typed)
+			handlers := builder.Build()
+			return builder.Err() == nil && handlers[messages.MessageTypeServiceGetRequest]["/legacy"] != nil && handlers[messages.MessageTypeServicePostRequest]["/typed"] != nil
+		}),
+		test_utils.NewTestCase("Invalid typed route", "invalid typed handlers should be reported by Err instead of added", func() bool {
+			builder := NewRequestHandlerMapBuilder().
+				PostTyped("/invalid", func(request IServiceRequest) error { return nil }).
+				PostTyped("/typed", typed)
+			handlers := builder.Build()
+			return builder.Err() != nil && handlers[messages.MessageTypeServicePostRequest]["/invalid"] == nil && handlers[messages.MessageTypeServicePostRequest]["/typed"] != nil
+		}),
+	}).Do(t)
+}
@@ -8,6 +8,7 @@ import (
 	common_connection "whub/hub_common/connection"
 	"whub/hub_common/dispatcher"
 	"whub/hub_common/roles"
+	"whub/hub_server/config"
 	"whub/hub_server/context"
 	"whub/hub_server/events"
 	server_http "whub/hub_server/http"
@@ -15,12 +16,11 @@ import (
 	"whub/hub_server/modules"
 	"whub/hub_server/services"
 	"whub/hub_server/socket"
-	"whub/websocket/wserver"
 )
 
 type Server struct {
-	*wserver.WServer
 	roles.ICommonServer
+	listeners               []socket.IListener
 	messageDispatcher       dispatcher.IMessageDispatcher
 	clientConnectionHandler socket.ISocketConnectionHandler
 	httpRequestHandler      server_http.IHTTPRequestHandler
@@ -32,6 +32,7 @@ type IServer interface {
 	Stop() error
 }
 
+// Start starts all listeners and blocks until any of them stops
 func (s *Server) Start() (err error) {
 	err = services.InitNativeServices()
 	if err != nil {
@@ -41,23 +42,54 @@ func (s *Server) Start() (err error) {
 	s.logger.Println("all native services have been initialized")
 
 	s.logger.Println("message dispatcher and http request handler has been initialized")
-	return s.WServer.Start()
+	errChan := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		listener := l
+		s.logger.Printf("starting %s listener on %s", listener.Type(), listener.Address())
+		go func() {
+			errChan <- listener.Start()
+		}()
+	}
+	return <-errChan
 }
 
 func (s *Server) Stop() (closeError error) {
 	events.EmitEvent(events.EventServerClosed, "")
-	s.WServer.Stop()
+	for _, l := range s.listeners {
+		if err := l.Stop(); err != nil {
+			s.logger.Printf("unable to stop %s listener on %s due to %s", l.Type(), l.Address(), err.Error())
+			closeError = err
+		}
+	}
 	return
 }
 
-func (s *Server) handleSocketConnection(conn connection.IConnection, r *http.Request) {
-	s.clientConnectionHandler.HandleConnectionEstablished(conn, r)
+func (s *Server) Listeners() []socket.IListener {
+	return s.listeners
+}
+
+func (s *Server) handleSocketConnection(conn connection.IConnection, token string) {
+	s.clientConnectionHandler.HandleConnectionEstablished(conn, token)
 }
 
 func (s *Server) handleHTTPRequests(w http.ResponseWriter, r *http.Request) {
 	s.httpRequestHandler.Handle(w, r)
 }
 
+func (s *Server) addListener(listener socket.IListener) {
+	listener.OnConnectionEstablished(s.handleSocketConnection)
+	s.listeners = append(s.listeners, listener)
+}
+
+// LoadConfig loads config.Config from the command line flags and recreates the context by it, server entrypoints
+// should call it before NewServer
+func LoadConfig() {
+	config.Load()
+	context.Reset()
+}
+
+// NewServer creates a server with a primary websocket listener(also serves HTTP requests) on the identity address
+// and extra listeners from config.Config.Listeners
 func NewServer(identity roles.ICommonServer, websocketPath string) *Server {
 	if websocketPath == "" {
 		websocketPath = common_connection.WSConnectionPath
@@ -65,8 +97,6 @@ func NewServer(identity roles.ICommonServer, websocketPath string) *Server {
 	logger := context.Ctx.Logger()
 	logger.SetPrefix(fmt.Sprintf("[Server-%s]", identity.Id()))
 	context.Ctx.Start(identity)
-	wServer := wserver.NewWServer(wserver.NewServerConfig(identity.Id(), identity.Url(), identity.Port(), websocketPath, wserver.DefaultWsConnHandler()))
-	wServer.SetLogger(logger)
 	err := modules.InitCoreComponents()
 	if err != nil {
 		logger.Fatalln("unable to load modules components due to ", err.Error())
@@ -74,19 +104,21 @@ func NewServer(identity roles.ICommonServer, websocketPath string) *Server {
 	}
 	messageDispatcher := message_dispatcher.NewServerMessageDispatcher()
 	server := &Server{
-		WServer:            wServer,
 		ICommonServer:      identity,
 		messageDispatcher:  messageDispatcher,
 		httpRequestHandler: server_http.NewHTTPRequestHandler(messageDispatcher),
 		logger:             logger,
 	}
-	server.OnClientConnected(server.handleSocketConnection)
-	server.OnNonUpgradableRequest(server.handleHTTPRequests)
-	server.SetBeforeUpgradeChecker(server_http.NewWebsocketUpgradeChecker().ShouldUpgradeProtocol)
 	server.clientConnectionHandler = socket.NewSocketConnectionHandler(server.messageDispatcher)
-	/*
-		onHttpRequest func(u func(w http.ResponseWriter, r *http.Handle) error, w http.ResponseWriter, r *http.Handle),
-	*/
+	server.addListener(socket.NewWSListener(identity.Id(), identity.Url(), identity.Port(), websocketPath, logger, server.handleHTTPRequests))
+	for _, listenerConfig := range config.Config.Listeners {
+		listener, err := socket.NewListener(identity.Id(), listenerConfig, logger, server.handleHTTPRequests)
+		if err != nil {
+			logger.Fatalln("unable to create listener due to ", err.Error())
+			panic(err)
+		}
+		server.addListener(listener)
+	}
 	context.Ctx.Logger().Printf("server has been initiated on %s:%d with websocket path %s", identity.Url(), identity.Port(), websocketPath)
 	return server
 }
@@ -10,11 +10,21 @@ import (
 
 var Config ServerConfig
 
+var configPath string
+
 type ServerConfig struct {
 	CommonConfig     `json:"commonConfig"`
 	DomainConfigs    `json:"domainConfig"`
 	ThrottleConfigs  `json:"throttleConfigs"`
-	DisabledServices []string `json:"disabledServices"`
+	DisabledServices []string             `json:"disabledServices"`
+	Listeners        []ListenerConfig     `json:"listeners"`
+	PubSub           PubSubConfig         `json:"pubsub"`
+	Mailbox          MailboxConfig        `json:"mailbox"`
+	Idempotency      IdempotencyConfig    `json:"idempotency"`
+	ResponseCache    ResponseCacheConfig  `json:"responseCache"`
+	CircuitBreaker   CircuitBreakerConfig `json:"circuitBreaker"`
+	Retry            RetryConfig          `json:"retry"`
+	Drain            DrainConfig          `json:"drain"`
 }
 
 type CommonConfig struct {
@@ -58,6 +68,98 @@ type RedisConfig struct {
 	Password string `json:"password"`
 }
 
+// Listener types
+const (
+	ListenerTypeWS   = "ws"
+	ListenerTypeTCP  = "tcp"
+	ListenerTypeUnix = "unix"
+)
+
+// ListenerConfig configures an extra socket listener besides the primary websocket listener of the server
+type ListenerConfig struct {
+	Type         string `json:"type"`
+	Address      string `json:"address"`
+	Port         int    `json:"port"`
+	Path         string `json:"path"`         // websocket upgrade path for ws listeners, socket file path for unix listeners
+	MaxFrameSize int    `json:"maxFrameSize"` // tcp/unix only, 0 to use the default max frame size
+	Checksum     bool   `json:"checksum"`     // tcp/unix only, whether outgoing frames carry a checksum
+	Permission   string `json:"permission"`   // unix only, octal file mode of the socket file(e.g. "0660")
+}
+
+// PubSubConfig configures the message store and delivery of the pubsub service. Messages are kept in the mysql
+// database of domainConfig.pubsub.persistent if configured, otherwise in the on-disk log under Dir if configured,
+// otherwise in memory.
+type PubSubConfig struct {
+	Dir           string `json:"dir"`
+	SegmentSize   int64  `json:"segmentSize"`   // max size in bytes of a log segment, 0 to use the default size
+	RetentionAge  int    `json:"retentionAge"`  // in seconds, 0 to use the default age, negative for no age limit
+	RetentionSize int64  `json:"retentionSize"` // in bytes per topic, 0 to use the default size, negative for no size limit
+	AckTimeout    int    `json:"ackTimeout"`    // in seconds, 0 to use the default timeout
+	MaxDeliveries int    `json:"maxDeliveries"` // 0 to use the default limit, negative for no limit
+	// DeadLetterTopic receives messages that failed maxDeliveries times, the default topic is used if empty
+	DeadLetterTopic string `json:"deadLetterTopic"`
+}
+
+// MailboxConfig configures store-and-forward delivery of the messaging service. Messages to offline clients are kept
+// in their mailboxes and flushed once they connect. Letters are kept on disk under Dir if configured, otherwise in
+// memory.
+type MailboxConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir"`
+	// Limits are keyed by roles.ClientType*, client types not configured use the default limit
+	Limits map[int]MailboxLimitConfig `json:"limits"`
+}
+
+type MailboxLimitConfig struct {
+	MaxDepth int `json:"maxDepth"` // 0 to use the default depth, negative to disable mailboxes of the client type
+	MaxAge   int `json:"maxAge"`   // in seconds, 0 to use the default age, negative for no age limit
+}
+
+// IdempotencyConfig configures how long completed responses of service requests with idempotency keys are kept.
+// Responses are kept in the redis server of domainConfig.idempotency.redis if configured, otherwise in memory.
+type IdempotencyConfig struct {
+	Window int `json:"window"` // in seconds, 0 to use the default window
+}
+
+// ResponseCacheConfig configures the shared cache of GET service responses. Responses are kept for the max-age(or
+// s-maxage) of their Cache-Control headers, or DefaultTtl if they don't have one. Responses marked no-store, no-cache
+// or private are not kept.
+type ResponseCacheConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxEntries int  `json:"maxEntries"` // 0 to use the default max entries
+	DefaultTtl int  `json:"defaultTtl"` // in seconds, 0 to not cache responses without max-age
+	MaxTtl     int  `json:"maxTtl"`     // in seconds, 0 for no limit
+	// VaryHeaders are request headers whose values are part of the cache key besides the uri(with query)
+	VaryHeaders []string `json:"varyHeaders"`
+}
+
+// CircuitBreakerConfig configures the circuit breaker of each provider connection of relay services. A connection is
+// ejected once it fails(errors, 5xx responses or slow calls) FailureThreshold times in a row, and a probe request is
+// sent to it after OpenDuration to decide whether to readmit it. OpenDuration is doubled on each failed probe up to
+// MaxOpenDuration. Zero values use the defaults.
+type CircuitBreakerConfig struct {
+	Disabled         bool `json:"disabled"`
+	FailureThreshold int  `json:"failureThreshold"`
+	SlowCallDuration int  `json:"slowCallDuration"` // in milliseconds
+	OpenDuration     int  `json:"openDuration"`     // in milliseconds
+	MaxOpenDuration  int  `json:"maxOpenDuration"`  // in milliseconds
+}
+
+// RetryConfig configures the retry budget shared by all relay services. Retries are limited to BudgetRatio of the
+// requests to relay services within BudgetWindow, with MinRetriesPerSecond always allowed. Zero values use the defaults.
+type RetryConfig struct {
+	BudgetRatio         float64 `json:"budgetRatio"`
+	MinRetriesPerSecond int     `json:"minRetriesPerSecond"`
+	BudgetWindow        int     `json:"budgetWindow"` // in seconds
+}
+
+// DrainConfig configures how long the hub waits for in-flight requests of a draining provider before removing it. As
+// the drain request is answered once the provider is removed, the timeout is capped below the request timeout of
+// providers(25 seconds).
+type DrainConfig struct {
+	Timeout int `json:"timeout"` // in seconds, 0 to use the default timeout
+}
+
 type ThrottleConfigs map[string]ThrottleConfig
 
 type ThrottleConfig struct {
@@ -66,7 +168,6 @@ type ThrottleConfig struct {
 }
 
 func init() {
-	var configPath string
 	Config.CommonConfig = CommonConfig{
 		MaxListenerCount:             defaultMaxListenerCount,
 		MaxAsyncPoolSize:             defaultAsyncPoolSize,
@@ -77,6 +178,11 @@ func init() {
 		SignKey:                      defaultSignKey,
 	}
 	flag.StringVar(&configPath, "config", "", "path to the server config json file")
+}
+
+// Load parses the command line flags and loads the config file given by -config, the default config is kept if no
+// config file is given or it can't be loaded. Only server entrypoints should call it, tests can set Config directly.
+func Load() {
 	flag.Parse()
 	if configPath == "" {
 		fmt.Println("no config path is specified, will use default config")
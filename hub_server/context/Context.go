@@ -66,6 +66,11 @@ func NewContext() *Context {
 	}
 }
 
+// Reset recreates the context by the current config, server entrypoints call it once the config is loaded
+func Reset() {
+	Ctx = NewContext()
+}
+
 func (c *Context) withLock(cb func()) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -5,8 +5,10 @@ import (
 	"net/http"
 	"sync"
 	"whub/common/logger"
+	"whub/hub_common/connection"
 	"whub/hub_common/dispatcher"
 	whttp "whub/hub_common/http"
+	"whub/hub_common/messages"
 	"whub/hub_server/context"
 )
 
@@ -36,12 +38,41 @@ func (h *HTTPRequestHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	msg, err := TransformRequest(r)
 	if err != nil {
 		logger.LogError(h.logger, "Handle", err)
+		status := http.StatusBadRequest
+		if err == messages.ErrDecompressedTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
 	}
 	conn := h.pool.Get().(*whttp.HTTPWritableConnection)
-	conn.Init(w, r.RemoteAddr, h.logger.WithPrefix(fmt.Sprintf("[HTTP-%s-%s]", r.RemoteAddr, msg.Id())), isWhrRequest(r))
+	conn.Init(w, r.RemoteAddr, h.logger.WithPrefix(fmt.Sprintf("[HTTP-%s-%s]", r.RemoteAddr, msg.Id())), isWhrRequest(r), r.Header.Get("Accept-Encoding"))
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go h.cancelOnDisconnect(r, messages.NewServiceCancelMessage(msg.Id(), msg.From(), msg.To(), msg.Uri()), conn, done, stopped)
 	// Do not do this on another goroutine. It will cause issue with ResponseWriter.
 	h.serviceMessageDispatcher.Dispatch(msg, conn)
 	conn.WaitDone()
+	close(done)
+	// the cancel message may still be dispatched with conn
+	<-stopped
+	aborted := conn.Aborted()
 	// recycle after conn is used
 	h.pool.Put(conn)
+	if aborted {
+		// interrupt the chunked response so that the partial body will not be taken as a complete one
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// cancelOnDisconnect dispatches the cancel message if the HTTP client goes away before the request is done, stopped is
+// closed once conn is no longer used
+func (h *HTTPRequestHandler) cancelOnDisconnect(r *http.Request, cancel messages.IMessage, conn connection.IConnection, done chan struct{}, stopped chan struct{}) {
+	defer close(stopped)
+	select {
+	case <-done:
+		cancel.Dispose()
+	case <-r.Context().Done():
+		h.logger.Printf("client %s disconnected, cancel request %s", r.RemoteAddr, cancel.Id())
+		h.serviceMessageDispatcher.Dispatch(cancel, conn)
+	}
 }
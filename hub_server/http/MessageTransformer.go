@@ -23,8 +23,10 @@ func init() {
 	reservedHeaders["User-Agent"] = true
 	reservedHeaders["Accept"] = true
 	reservedHeaders["Accept-Encoding"] = true
+	reservedHeaders["Content-Encoding"] = true
 	reservedHeaders["Connection"] = true
 	reservedHeaders["R-Token"] = true
+	reservedHeaders[messages.MessageHeaderTimeout] = true
 }
 
 func isWhrRequest(r *http.Request) bool {
@@ -42,7 +44,7 @@ func TransformRequest(r *http.Request) (messages.IMessage, error) {
 		if err != nil {
 			return nil, err
 		}
-		return messages.DraftMessage(r.RemoteAddr, "", r.URL.String(), messages.MessageTypeServiceRequest, encoded), nil
+		return withDeadline(messages.DraftMessage(r.RemoteAddr, "", r.URL.String(), messages.MessageTypeServiceRequest, encoded), r.Header), nil
 	}
 	var from, to, url string
 	// from should only be the auth token represents a client
@@ -55,9 +57,22 @@ func TransformRequest(r *http.Request) (messages.IMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	// messages always carry decoded payloads, compression between hub and peers is up to the connections
+	body, err = whttp.DecodeContent(r.Header.Get("Content-Encoding"), body)
+	if err != nil {
+		return nil, err
+	}
 	message := messages.DraftMessage(from, to, url, msgType, body)
 	message = transformHeaderFields(message, r.Header)
-	return message, nil
+	return withDeadline(message, r.Header), nil
+}
+
+// withDeadline bounds the message by the timeout(in milliseconds) the HTTP client is willing to wait
+func withDeadline(message messages.IMessage, httpHeaders http.Header) messages.IMessage {
+	if deadline, ok := messages.DeadlineFromTimeout(httpHeaders.Get(messages.MessageHeaderTimeout)); ok {
+		message.SetDeadline(deadline)
+	}
+	return message
 }
 
 func transformHeaderFields(message messages.IMessage, httpHeaders map[string][]string) messages.IMessage {
@@ -38,8 +38,10 @@ func (d *ServerMessageDispatcher) logger() *logger.SimpleLogger {
 func (d *ServerMessageDispatcher) init() {
 	// register common message handlers
 	d.registerHandler(dispatcher.NewPingMessageHandler(context.Ctx.Server()))
+	d.registerHandler(dispatcher.NewProtocolUpdateMessageHandler(context.Ctx.Server()))
 	d.registerHandler(dispatcher.NewInvalidMessageHandler(context.Ctx.Server()))
 	d.registerHandler(NewServiceRequestMessageHandler())
+	d.registerHandler(NewServiceCancelMessageHandler())
 }
 
 func (d *ServerMessageDispatcher) Dispatch(message messages.IMessage, conn connection.IConnection) {
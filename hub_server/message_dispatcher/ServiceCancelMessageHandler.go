@@ -0,0 +1,48 @@
+package message_dispatcher
+
+import (
+	"whub/hub_common/connection"
+	"whub/hub_common/dispatcher"
+	"whub/hub_common/messages"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/auth"
+	"whub/hub_server/modules/service_manager"
+	"whub/hub_server/service_base"
+)
+
+// ServiceCancelMessageHandler cancels service requests on behalf of the requester, relay services forward the
+// cancellation to their providers. The source of the cancellation is validated the same way as requests, so that
+// clients can only cancel their own requests.
+type ServiceCancelMessageHandler struct {
+	serviceManager service_manager.IServiceManagerModule `module:""`
+	authController auth.IAuthModule                      `module:""`
+}
+
+func NewServiceCancelMessageHandler() dispatcher.IMessageHandler {
+	handler := &ServiceCancelMessageHandler{}
+	err := module_base.Manager.AutoFill(handler)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func (h *ServiceCancelMessageHandler) Type() int {
+	return messages.MessageTypeServiceCancelRequest
+}
+
+func (h *ServiceCancelMessageHandler) Types() []int {
+	return nil
+}
+
+func (h *ServiceCancelMessageHandler) Handle(message messages.IMessage, conn connection.IConnection) error {
+	matchContext := h.serviceManager.MatchServiceByUri(message.Uri())
+	if matchContext == nil {
+		return service_base.NewCanNotFindServiceError(message.Uri())
+	}
+	requester, err := h.authController.ValidateRequestSource(conn, message)
+	if err != nil {
+		requester = ""
+	}
+	return matchContext.Value.(service_base.IService).CancelFrom(message.Id(), requester, conn.Address())
+}
@@ -11,6 +11,7 @@ import (
 	"whub/hub_server/context"
 	"whub/hub_server/errors"
 	"whub/hub_server/module_base"
+	"whub/hub_server/modules/idempotency"
 	"whub/hub_server/modules/metering"
 	"whub/hub_server/modules/middleware_manager"
 	"whub/hub_server/modules/service_manager"
@@ -21,6 +22,7 @@ type ServiceRequestMessageHandler struct {
 	serviceManager    service_manager.IServiceManagerModule       `module:""`
 	middlewareManager middleware_manager.IMiddlewareManagerModule `module:""`
 	metering          metering.IMeteringModule                    `module:""`
+	idempotency       idempotency.IIdempotencyModule              `module:""`
 }
 
 func NewServiceRequestMessageHandler() dispatcher.IMessageHandler {
@@ -68,14 +70,22 @@ func (h *ServiceRequestMessageHandler) Handle(message messages.IMessage, conn co
 	if request.Status() > service.ServiceRequestStatusProcessing {
 		// request is resolved in middleware
 		response = request.Response()
+	} else if key, replayed := h.idempotency.Begin(request); replayed != nil {
+		// request with the idempotency key was handled before
+		response = replayed
 	} else {
 		// continue the request with service
-		response = svc.Handle(request)
+		response = h.middlewareManager.RunResponseMiddlewares(conn, request, svc.Handle(request))
+		h.idempotency.Complete(key, request, response)
 	}
+	released := request.IsCancelled() || request.IsDead()
 	// request die here
 	request.Free()
 
-	if response == nil && !base_conn.IsAsyncType(conn.ConnectionType()) {
+	if response == nil && released {
+		err = conn.Send(messages.NewErrorResponse(message, context.Ctx.Server().Id(),
+			messages.MessageTypeSvcUnavailableError, "request has been cancelled or target server is dead"))
+	} else if response == nil && !base_conn.IsAsyncType(conn.ConnectionType()) {
 		err = conn.Send(messages.NewErrorResponse(request, context.Ctx.Server().Id(),
 			messages.MessageTypeSvcForbiddenError,
 			errors.NewJsonMessageError("service does not support sync requests")))
@@ -96,6 +106,7 @@ func (h *ServiceRequestMessageHandler) processIncomingMessage(message messages.I
 
 func (h *ServiceRequestMessageHandler) createRequest(message messages.IMessage, matchContext *uri_trie.MatchContext, conn connection.IConnection) service.IServiceRequest {
 	request := service.NewServiceRequest(message)
+	request.BindConnection(conn)
 	request = h.registerRequestMetaContext(request, matchContext)
 	return h.middlewareManager.RunMiddlewares(conn, request)
 }
@@ -5,6 +5,7 @@ import (
 	"whub/common/data_structures"
 	"whub/common/logger"
 	"whub/hub_common/connection"
+	"whub/hub_common/messages"
 	"whub/hub_common/service"
 	"whub/hub_server/context"
 )
@@ -18,6 +19,11 @@ type IServerMiddleware interface {
 	Compare(comparable data_structures.IComparable) int
 }
 
+// IServerResponseMiddleware is implemented by middlewares that also process responses of requests handled by services
+type IServerResponseMiddleware interface {
+	OnResponse(conn connection.IConnection, request service.IServiceRequest, response messages.IMessage) messages.IMessage
+}
+
 type ServerMiddleware struct {
 	id       string
 	logger   *logger.SimpleLogger
@@ -84,8 +84,22 @@ func (s *InMemoryStore) Delete(id string) (err error) {
 	return
 }
 
-func (s *InMemoryStore) Find(query *DClientQuery) ([]*client.Client, error) {
-	panic("implement me")
+// Find supports queries by type and limit, clients in memory do not keep their creation time
+func (s *InMemoryStore) Find(query *DClientQuery) (clients []*client.Client, e error) {
+	if !query.createdAfter.IsZero() || !query.createdBefore.IsZero() {
+		return nil, errors.New("in-memory store does not support queries by creation time")
+	}
+	s.withRead(func() {
+		for _, v := range s.clients {
+			if query.limit > 0 && len(clients) >= query.limit {
+				return
+			}
+			if query.cType < 0 || v.CType() == query.cType {
+				clients = append(clients, v)
+			}
+		}
+	})
+	return
 }
 
 func (s *InMemoryStore) Close() error {
@@ -0,0 +1,122 @@
+package connection_manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"whub/common/data_structures"
+)
+
+// groupId: []clientId
+type IClientGroupStore interface {
+	Add(groupId string, clientId string) error
+	Delete(groupId string, clientId string) error
+	// DeleteClient removes the client from all groups it has joined
+	DeleteClient(clientId string) error
+	Get(groupId string) ([]string, error)
+	GroupsOf(clientId string) ([]string, error)
+}
+
+type InMemoryClientGroupStore struct {
+	groups  map[string]data_structures.ISet // groupId: clientIds
+	clients map[string]data_structures.ISet // clientId: groupIds
+	lock    *sync.RWMutex
+}
+
+func NewInMemoryClientGroupStore() IClientGroupStore {
+	return &InMemoryClientGroupStore{
+		groups:  make(map[string]data_structures.ISet),
+		clients: make(map[string]data_structures.ISet),
+		lock:    new(sync.RWMutex),
+	}
+}
+
+func (s *InMemoryClientGroupStore) withWrite(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+func (s *InMemoryClientGroupStore) withRead(cb func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	cb()
+}
+
+func addToSetMap(m map[string]data_structures.ISet, key string, value string) bool {
+	set := m[key]
+	if set == nil {
+		set = data_structures.NewSet()
+		m[key] = set
+	}
+	return set.Add(value)
+}
+
+func deleteFromSetMap(m map[string]data_structures.ISet, key string, value string) bool {
+	set := m[key]
+	if set == nil || !set.Delete(value) {
+		return false
+	}
+	if set.Size() == 0 {
+		delete(m, key)
+	}
+	return true
+}
+
+func setToStrings(set data_structures.ISet) []string {
+	if set == nil {
+		return []string{}
+	}
+	allData := set.GetAll()
+	values := make([]string, len(allData), len(allData))
+	for i := range allData {
+		values[i] = allData[i].(string)
+	}
+	return values
+}
+
+func (s *InMemoryClientGroupStore) Add(groupId string, clientId string) (err error) {
+	s.withWrite(func() {
+		if !addToSetMap(s.groups, groupId, clientId) {
+			err = errors.New(fmt.Sprintf("client %s has already joined group %s", clientId, groupId))
+			return
+		}
+		addToSetMap(s.clients, clientId, groupId)
+	})
+	return
+}
+
+func (s *InMemoryClientGroupStore) Delete(groupId string, clientId string) (err error) {
+	s.withWrite(func() {
+		if !deleteFromSetMap(s.groups, groupId, clientId) {
+			err = errors.New(fmt.Sprintf("client %s is not in group %s", clientId, groupId))
+			return
+		}
+		deleteFromSetMap(s.clients, clientId, groupId)
+	})
+	return
+}
+
+func (s *InMemoryClientGroupStore) DeleteClient(clientId string) error {
+	s.withWrite(func() {
+		for _, groupId := range setToStrings(s.clients[clientId]) {
+			deleteFromSetMap(s.groups, groupId, clientId)
+		}
+		delete(s.clients, clientId)
+	})
+	return nil
+}
+
+func (s *InMemoryClientGroupStore) Get(groupId string) (clientIds []string, err error) {
+	s.withRead(func() {
+		clientIds = setToStrings(s.groups[groupId])
+	})
+	return
+}
+
+func (s *InMemoryClientGroupStore) GroupsOf(clientId string) (groupIds []string, err error) {
+	s.withRead(func() {
+		groupIds = setToStrings(s.clients[clientId])
+	})
+	return
+}
@@ -18,6 +18,7 @@ type ConnectionManagerModule struct {
 	logger            *logger.SimpleLogger
 	connStore         IConnectionStore             // all connection management
 	activeClientStore IActiveClientConnectionStore // connected client management
+	clientGroupStore  IClientGroupStore            // named groups of connected clients
 }
 
 type IConnectionManagerModule interface {
@@ -29,7 +30,11 @@ type IConnectionManagerModule interface {
 	RegisterClientToConnection(clientId string, addr string) error
 	WithAllConnections(func(connection.IConnection)) error
 
-	// AddToConnectionGroup(clientId string, conn connection.IConnection, groupId string) error
+	// client groups are named sets of connected clients, a client leaves all its groups once its connections are gone
+	JoinGroup(clientId string, groupId string) error
+	LeaveGroup(clientId string, groupId string) error
+	GetGroupMembers(groupId string) ([]string, error)
+	GetGroupsOfClient(clientId string) ([]string, error)
 }
 
 func (m *ConnectionManagerModule) Init() error {
@@ -40,6 +45,7 @@ func (m *ConnectionManagerModule) Init() error {
 	m.logger = m.Logger()
 	m.connStore = NewInMemoryConnectionStore()
 	m.activeClientStore = NewInMemoryActiveClientConnectionStore()
+	m.clientGroupStore = NewInMemoryClientGroupStore()
 	m.initNotifications()
 	return nil
 }
@@ -101,6 +107,7 @@ func (m *ConnectionManagerModule) handleClientConnectionClosed(clientId string)
 	}
 	if len(conns) == 0 {
 		m.logger.Printf("all connections from client %s is gone", clientId)
+		m.clientGroupStore.DeleteClient(clientId)
 		events.EmitEvent(events.EventClientConnectionGone, clientId)
 	}
 }
@@ -195,41 +202,25 @@ func (m *ConnectionManagerModule) WithAllConnections(cb func(iConnection connect
 	return nil
 }
 
-/*
-func (m *ConnectionManagerModule) assembleConnectionGroupId(id string) string {
-	return fmt.Sprintf("conn-group-%s", id)
-}
-
-func (m *ConnectionManagerModule) isConnGroupId(id string) bool {
-	return strings.HasPrefix(id, "conn-group-")
-}
-
-func (m *ConnectionManagerModule) AddToConnectionGroup(clientId string, conn connection.IConnection, groupId string) error {
-	if m.isConnGroupId(conn.Address()) {
-		return errors.New("connection group can not form another connection group")
-	}
-	groupId = m.assembleConnectionGroupId(groupId)
-	group, err := m.connStore.Get(groupId)
+func (m *ConnectionManagerModule) JoinGroup(clientId string, groupId string) error {
+	conns, err := m.activeClientStore.Get(clientId)
 	if err != nil {
 		return err
 	}
-	// remove from active client store as we will manage it from connection group
-	m.activeClientStore.Delete(clientId, conn.Address())
-	if group != nil {
-		// group already exist
-		group.(connection.IConnectionGroup).Add(conn)
-	} else {
-		// new group
-		group = connection.NewConnectionGroup(groupId, conn)
-		m.activeClientStore.Add(clientId, groupId)
+	if len(conns) == 0 {
+		return errors.New(fmt.Sprintf("client %s is not connected", clientId))
 	}
-	conn.OnClose(func(err error) {
-		m.handleConnectionClosed(conn, err)
-		// delete the clientId-connection record from conn-group
-		group.(connection.IConnectionGroup).Remove(conn.Address())
-		events.EmitEvent(events.EventClientConnectionClosed, clientId)
-		m.handleClientConnectionClosed(clientId)
-	})
-	return nil
+	return m.clientGroupStore.Add(groupId, clientId)
+}
+
+func (m *ConnectionManagerModule) LeaveGroup(clientId string, groupId string) error {
+	return m.clientGroupStore.Delete(groupId, clientId)
+}
+
+func (m *ConnectionManagerModule) GetGroupMembers(groupId string) ([]string, error) {
+	return m.clientGroupStore.Get(groupId)
+}
+
+func (m *ConnectionManagerModule) GetGroupsOfClient(clientId string) ([]string, error) {
+	return m.clientGroupStore.GroupsOf(clientId)
 }
-*/
@@ -0,0 +1,107 @@
+package idempotency
+
+import (
+	"fmt"
+	"time"
+	"whub/common/logger"
+	"whub/hub_common/idempotency"
+	"whub/hub_common/messages"
+	"whub/hub_server/config"
+	"whub/hub_server/context"
+	"whub/hub_server/module_base"
+)
+
+/*
+ * Service requests carrying idempotency.MessageHeaderIdempotencyKey are executed once per client and key. The key is
+ * reserved before the request is handled and the completed response is kept for the idempotency window, so that the
+ * request retried with the same key gets the kept response without being executed again. Keys of requests that fail
+ * with transient errors are released to allow retries.
+ */
+
+const (
+	ID            = "Idempotency"
+	DefaultWindow = 10 * time.Minute
+)
+
+type IIdempotencyModule interface {
+	// Begin reserves the idempotency key of the request, returns the key to complete the request with, or the
+	// response to reply with if the key was used before. Both are empty if the request has no idempotency key.
+	Begin(request messages.IMessage) (key string, response messages.IMessage)
+	Complete(key string, request messages.IMessage, response messages.IMessage)
+}
+
+type IdempotencyModule struct {
+	*module_base.ModuleBase
+	store  idempotency.IIdempotencyStore
+	window time.Duration
+	logger *logger.SimpleLogger
+}
+
+func (m *IdempotencyModule) Init() error {
+	m.ModuleBase = module_base.NewModuleBase(ID, func() error {
+		return m.store.Close()
+	})
+	m.logger = m.Logger()
+	m.store = createIdempotencyStore(m.logger)
+	m.window = DefaultWindow
+	if window := config.Config.Idempotency.Window; window > 0 {
+		m.window = time.Duration(window) * time.Second
+	}
+	return nil
+}
+
+func createIdempotencyStore(logger *logger.SimpleLogger) idempotency.IIdempotencyStore {
+	redisConfig := config.Config.DomainConfigs["idempotency"].Redis
+	if redisConfig.Server == "" {
+		logger.Println("init in memory store")
+		return idempotency.NewMemoryIdempotencyStore()
+	}
+	store, err := idempotency.NewRedisIdempotencyStore(redisConfig.Server, redisConfig.Password)
+	logger.Printf("init redis store with redis server %s", redisConfig.Server)
+	if err != nil {
+		logger.Printf("unable to create redis idempotency store due to %s, will use in memory store", err.Error())
+		store = idempotency.NewMemoryIdempotencyStore()
+	}
+	return store
+}
+
+func (m *IdempotencyModule) Begin(request messages.IMessage) (string, messages.IMessage) {
+	key := request.GetHeader(idempotency.MessageHeaderIdempotencyKey)
+	if key == "" {
+		return "", nil
+	}
+	key = idempotency.Key(request.From(), key)
+	existing, err := m.store.Reserve(key, idempotency.NewPendingRecord(request), m.window)
+	if err != nil {
+		// the request is still served when the store is not available
+		m.logger.Printf("unable to reserve idempotency key %s due to %s", key, err.Error())
+		return "", nil
+	}
+	if existing == nil {
+		return key, nil
+	}
+	if existing.Uri != request.Uri() {
+		return "", messages.NewErrorResponse(request, context.Ctx.Server().Id(), messages.MessageTypeSvcBadRequestError,
+			fmt.Sprintf("idempotency key is used by request to %s", existing.Uri))
+	}
+	if existing.Pending {
+		return "", messages.NewErrorResponse(request, context.Ctx.Server().Id(), messages.MessageTypeSvcConflictError,
+			"request with the same idempotency key is in progress")
+	}
+	return "", existing.Response(request, context.Ctx.Server().Id())
+}
+
+func (m *IdempotencyModule) Complete(key string, request messages.IMessage, response messages.IMessage) {
+	if key == "" {
+		return
+	}
+	var err error
+	if idempotency.Retryable(response) {
+		err = m.store.Delete(key)
+	} else {
+		err = m.store.Put(key, idempotency.NewCompletedRecord(request, response), m.window)
+	}
+	if err != nil {
+		m.logger.Printf("unable to complete idempotency key %s due to %s", key, err.Error())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"whub/common/data_structures"
 	"whub/common/logger"
 	"whub/hub_common/connection"
+	"whub/hub_common/messages"
 	"whub/hub_common/service"
 	"whub/hub_server/middleware"
 	"whub/hub_server/module_base"
@@ -15,6 +16,8 @@ const ID = "MiddlewareManager"
 type IMiddlewareManagerModule interface {
 	RegisterMiddleware(middleware middleware.IServerMiddleware) error
 	RunMiddlewares(conn connection.IConnection, request service.IServiceRequest) service.IServiceRequest
+	// RunResponseMiddlewares passes the response of the request handled by the service through response middlewares
+	RunResponseMiddlewares(conn connection.IConnection, request service.IServiceRequest, response messages.IMessage) messages.IMessage
 	Clear()
 }
 
@@ -42,6 +45,16 @@ func (m *MiddlewareManagerModule) RunMiddlewares(conn connection.IConnection, re
 	return request
 }
 
+func (m *MiddlewareManagerModule) RunResponseMiddlewares(conn connection.IConnection, request service.IServiceRequest, response messages.IMessage) messages.IMessage {
+	m.middlewares.ForEach(func(md interface{}) bool {
+		if responseMiddleware, ok := md.(middleware.IServerResponseMiddleware); ok {
+			response = responseMiddleware.OnResponse(conn, request, response)
+		}
+		return true
+	})
+	return response
+}
+
 func (m *MiddlewareManagerModule) Clear() {
 	m.middlewares.Clear()
 }
@@ -7,8 +7,11 @@ import (
 	"whub/hub_server/modules/blocklist"
 	"whub/hub_server/modules/client_manager"
 	"whub/hub_server/modules/connection_manager"
+	"whub/hub_server/modules/idempotency"
 	"whub/hub_server/modules/metering"
 	"whub/hub_server/modules/middleware_manager"
+	"whub/hub_server/modules/response_cache"
+	"whub/hub_server/modules/retry_budget"
 	"whub/hub_server/modules/service_manager"
 	"whub/hub_server/modules/status"
 	"whub/hub_server/modules/throttle"
@@ -28,6 +31,9 @@ func initModuleInstances() {
 		new(status.ServerStatusModule),
 		new(throttle.RequestThrottleModule),
 		new(blocklist.BlockListModule),
+		new(idempotency.IdempotencyModule),
+		new(response_cache.ResponseCacheModule),
+		new(retry_budget.RetryBudgetModule),
 	}
 }
 
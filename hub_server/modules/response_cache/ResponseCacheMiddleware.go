@@ -0,0 +1,43 @@
+package response_cache
+
+import (
+	"whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/service"
+	"whub/hub_server/middleware"
+	"whub/hub_server/module_base"
+)
+
+const (
+	ResponseCacheMiddlewareId       = "response_cache"
+	ResponseCacheMiddlewarePriority = 4
+
+	cacheKeyContextKey = "response_cache_key"
+)
+
+type ResponseCacheMiddleware struct {
+	*middleware.ServerMiddleware
+	IResponseCacheModule `module:""`
+}
+
+func (m *ResponseCacheMiddleware) Init() error {
+	m.ServerMiddleware = middleware.NewServerMiddleware(ResponseCacheMiddlewareId, ResponseCacheMiddlewarePriority)
+	return module_base.Manager.AutoFill(m)
+}
+
+func (m *ResponseCacheMiddleware) Run(conn connection.IConnection, request service.IServiceRequest) service.IServiceRequest {
+	key, cached := m.Lookup(request)
+	if cached != nil {
+		// requests are only resolvable while processing
+		request.TransitStatus(service.ServiceRequestStatusProcessing)
+		request.Resolve(cached)
+	} else if key != "" {
+		request.SetContext(cacheKeyContextKey, key)
+	}
+	return request
+}
+
+func (m *ResponseCacheMiddleware) OnResponse(conn connection.IConnection, request service.IServiceRequest, response messages.IMessage) messages.IMessage {
+	key, _ := request.GetContext(cacheKeyContextKey).(string)
+	return m.Store(key, request, response)
+}
@@ -0,0 +1,137 @@
+package response_cache
+
+import (
+	"fmt"
+	"time"
+	"whub/common/logger"
+	"whub/hub_common/messages"
+	"whub/hub_common/response_cache"
+	"whub/hub_common/service"
+	"whub/hub_server/config"
+	"whub/hub_server/context"
+	"whub/hub_server/events"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/middleware_manager"
+)
+
+/*
+ * GET service requests are answered from the response cache when possible. The cache key is the request uri(with
+ * query) and the values of the configured vary headers. Successful responses are kept as long as their Cache-Control
+ * headers allow, responses are only shared among requesters if they are public or have s-maxage, other responses are
+ * kept for their requesters only as they may depend on the requester. Conditional requests(If-None-Match) matching the ETag of the response are answered with
+ * MessageTypeSvcNotModified. Cached responses of a service are purged once it's registered, updated or unregistered.
+ */
+
+const ID = "ResponseCache"
+
+type IResponseCacheModule interface {
+	// Lookup returns the key to store the response of the request with, or the cached response to reply with. Both
+	// are empty if the request should not go through the cache.
+	Lookup(request messages.IMessage) (key string, response messages.IMessage)
+	// Store keeps the response of the request if it's cacheable, returns the response to reply with
+	Store(key string, request messages.IMessage, response messages.IMessage) messages.IMessage
+	// Purge drops cached responses whose uris start with the prefix, all responses are dropped if the prefix is empty
+	Purge(prefix string) int
+}
+
+type ResponseCacheModule struct {
+	*module_base.ModuleBase
+	cache       *response_cache.Cache
+	enabled     bool
+	defaultTtl  time.Duration
+	maxTtl      time.Duration
+	varyHeaders []string
+	logger      *logger.SimpleLogger
+}
+
+func (m *ResponseCacheModule) Init() error {
+	m.ModuleBase = module_base.NewModuleBase(ID, func() error {
+		events.OffEvent(events.EventServiceRegistered, m.handleServiceChange)
+		events.OffEvent(events.EventServiceUpdated, m.handleServiceChange)
+		events.OffEvent(events.EventServiceUnregistered, m.handleServiceChange)
+		return nil
+	})
+	m.logger = m.Logger()
+	cacheConfig := config.Config.ResponseCache
+	m.enabled = cacheConfig.Enabled
+	m.cache = response_cache.NewCache(cacheConfig.MaxEntries)
+	m.defaultTtl = time.Duration(cacheConfig.DefaultTtl) * time.Second
+	m.maxTtl = time.Duration(cacheConfig.MaxTtl) * time.Second
+	m.varyHeaders = cacheConfig.VaryHeaders
+	events.OnEvent(events.EventServiceRegistered, m.handleServiceChange)
+	events.OnEvent(events.EventServiceUpdated, m.handleServiceChange)
+	events.OnEvent(events.EventServiceUnregistered, m.handleServiceChange)
+	return nil
+}
+
+func (m *ResponseCacheModule) OnLoad() {
+	if m.enabled {
+		if err := middleware_manager.RegisterMiddleware(new(ResponseCacheMiddleware)); err != nil {
+			m.logger.Printf("unable to register response cache middleware due to %s", err.Error())
+		}
+	}
+	m.ModuleBase.OnLoad()
+}
+
+func (m *ResponseCacheModule) handleServiceChange(message messages.IMessage) {
+	serviceId := string(message.Payload())
+	if purged := m.cache.PurgePath(fmt.Sprintf("%s/%s", service.ServicePrefix, serviceId)); purged > 0 {
+		m.logger.Printf("%d cached responses of service %s purged", purged, serviceId)
+	}
+}
+
+func (m *ResponseCacheModule) Lookup(request messages.IMessage) (string, messages.IMessage) {
+	if request.MessageType() != messages.MessageTypeServiceGetRequest {
+		return "", nil
+	}
+	cc := response_cache.ParseCacheControl(response_cache.Header(request, response_cache.HeaderCacheControl))
+	if cc.NoStore {
+		return "", nil
+	}
+	key := response_cache.Key(request, m.varyHeaders)
+	if cc.NoCache {
+		// the requester wants a fresh response, which is still kept for others
+		return key, nil
+	}
+	if entry := m.cache.Get(key); entry != nil {
+		return "", entry.Response(request, context.Ctx.Server().Id())
+	}
+	if request.From() != "" {
+		if entry := m.cache.Get(response_cache.RequesterKey(key, request.From())); entry != nil {
+			return "", entry.Response(request, context.Ctx.Server().Id())
+		}
+	}
+	return key, nil
+}
+
+func (m *ResponseCacheModule) ttl(cc response_cache.CacheControl) time.Duration {
+	ttl := m.defaultTtl
+	if cc.HasMaxAge {
+		ttl = cc.MaxAge
+	}
+	if m.maxTtl > 0 && ttl > m.maxTtl {
+		ttl = m.maxTtl
+	}
+	return ttl
+}
+
+func (m *ResponseCacheModule) Store(key string, request messages.IMessage, response messages.IMessage) messages.IMessage {
+	if key == "" || response == nil || response.MessageType() != messages.MessageTypeSvcResponseOK || messages.IsStreamHeaderMessage(response) {
+		return response
+	}
+	cc := response_cache.ParseCacheControl(response_cache.Header(response, response_cache.HeaderCacheControl))
+	if storeKey, ok := response_cache.StoreKey(key, request.From(), cc); ok && m.ttl(cc) > 0 {
+		m.cache.Put(response_cache.NewEntry(storeKey, request.Uri(), response, m.ttl(cc)))
+	}
+	if response_cache.IsConditionalHit(request, response) {
+		notModified := response_cache.NotModified(request, response.From(), response_cache.Header(response, response_cache.HeaderETag))
+		response.Dispose()
+		return notModified
+	}
+	response.SetHeader(response_cache.HeaderCache, "MISS")
+	return response
+}
+
+func (m *ResponseCacheModule) Purge(prefix string) int {
+	return m.cache.Purge(prefix)
+}
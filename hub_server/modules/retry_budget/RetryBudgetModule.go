@@ -0,0 +1,48 @@
+package retry_budget
+
+import (
+	"time"
+	"whub/hub_common/retry_budget"
+	"whub/hub_server/config"
+	"whub/hub_server/module_base"
+)
+
+/*
+ * Retries of all relay services share one budget, each request to a relay service deposits to the budget and each
+ * retry withdraws from it. Once the budget is used up, failed requests are no longer retried so that retries don't
+ * amplify an outage of providers.
+ */
+
+const ID = "RetryBudget"
+
+type IRetryBudgetModule interface {
+	// Deposit records a request to a relay service
+	Deposit()
+	// TryWithdraw records a retry, returns false if the budget is used up
+	TryWithdraw() bool
+	Status() retry_budget.Status
+}
+
+type RetryBudgetModule struct {
+	*module_base.ModuleBase
+	budget *retry_budget.Budget
+}
+
+func (m *RetryBudgetModule) Init() error {
+	m.ModuleBase = module_base.NewModuleBase(ID, nil)
+	retryConfig := config.Config.Retry
+	m.budget = retry_budget.NewBudget(retryConfig.BudgetRatio, retryConfig.MinRetriesPerSecond, time.Duration(retryConfig.BudgetWindow)*time.Second)
+	return nil
+}
+
+func (m *RetryBudgetModule) Deposit() {
+	m.budget.Deposit()
+}
+
+func (m *RetryBudgetModule) TryWithdraw() bool {
+	return m.budget.TryWithdraw()
+}
+
+func (m *RetryBudgetModule) Status() retry_budget.Status {
+	return m.budget.Status()
+}
@@ -34,6 +34,9 @@ type IServiceManagerModule interface {
 	GetServicesByClientId(clientId string) []server_service.IService
 	RegisterService(string, server_service.IService) error
 	UnregisterService(string) error
+	// RemoveServiceProvider removes the provider from the relay service, the service is unregistered once it has no
+	// provider left
+	RemoveServiceProvider(serviceId string, providerId string) (unregistered bool, err error)
 
 	UnregisterAllServices() error
 	UnregisterAllServicesFromClientId(string) error
@@ -111,7 +114,7 @@ func (s *ServiceManagerModule) UnregisterAllServicesFromClientId(clientId string
 	return s.WithServicesFromClientId(clientId, func(services []server_service.IService) {
 		for i, _ := range services {
 			if services[i] != nil {
-				s.UnregisterService(services[i].Id())
+				s.RemoveServiceProvider(services[i].Id(), clientId)
 			}
 		}
 	})
@@ -127,7 +130,7 @@ func (s *ServiceManagerModule) getServicesByClientId(id string) []server_service
 	defer s.lock.RUnlock()
 	var services []server_service.IService
 	for _, v := range s.serviceMap {
-		if v.ProviderInfo().Id == id {
+		if relayService, ok := v.(server_service.IRelayService); ok && relayService.HasProvider(id) || v.ProviderInfo().Id == id {
 			services = append(services, v)
 		}
 	}
@@ -172,6 +175,7 @@ func (s *ServiceManagerModule) registerService(clientId string, svc server_servi
 		*/
 	})
 	err = nil
+	events.EmitEvent(events.EventServiceRegistered, svc.Id())
 	return
 }
 
@@ -197,9 +201,39 @@ func (s *ServiceManagerModule) unregisterService(serviceId string) error {
 		}
 	})
 	s.logger.Println("unregister service ", serviceId, " succeeded")
+	events.EmitEvent(events.EventServiceUnregistered, serviceId)
 	return nil
 }
 
+func (s *ServiceManagerModule) RemoveServiceProvider(serviceId string, providerId string) (unregistered bool, err error) {
+	svc := s.GetService(serviceId)
+	if svc == nil {
+		return false, server_errors.NewNoSuchServiceError(serviceId)
+	}
+	relayService, ok := svc.(server_service.IRelayService)
+	if !ok {
+		if svc.ProviderInfo().Id != providerId {
+			return false, errors.New(fmt.Sprintf("client %s is not the provider of service %s", providerId, serviceId))
+		}
+		return true, s.unregisterService(serviceId)
+	}
+	promoted, remaining, err := relayService.RemoveProvider(providerId)
+	if err != nil {
+		return false, err
+	}
+	if remaining == 0 {
+		return true, s.unregisterService(serviceId)
+	}
+	if promoted != nil {
+		// routes and settings of the service follow the promoted provider
+		promoted.Status = svc.Status()
+		if err = s.UpdateService(*promoted); err != nil {
+			s.logger.Printf("update service %s with promoted provider %s failed due to %s", serviceId, promoted.Provider.Id, err.Error())
+		}
+	}
+	return false, nil
+}
+
 func (s *ServiceManagerModule) FindServiceByUri(uri string) server_service.IService {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -242,8 +276,19 @@ func (s *ServiceManagerModule) UpdateService(descriptor service.ServiceDescripto
 	if tService == nil {
 		return errors.New(fmt.Sprintf("tService %s can not be found", descriptor.Id))
 	}
+	relayService, ok := tService.(*server_service.RelayService)
+	if !ok {
+		// only relay services are provided by clients
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, fmt.Sprintf("service %s can not be updated by clients", descriptor.Id))
+	}
+	if relayService.ProviderInfo().Id != descriptor.Provider.Id {
+		// only the primary provider updates the service
+		return relayService.UpdateProvider(descriptor)
+	}
+	// cached responses of the service may be stale once it's updated, even partially
+	defer events.EmitEvent(events.EventServiceUpdated, descriptor.Id)
 	return utils.ProcessWithErrors(func() error {
-		return tService.(*server_service.RelayService).Update(descriptor)
+		return relayService.Update(descriptor)
 	}, func() error {
 		// TODO how to update uris here???
 		fullUris := tService.FullServiceUris()
@@ -52,10 +52,6 @@ func initGlobalVariables() {
 	}
 }
 
-func init() {
-	initGlobalVariables()
-}
-
 type ThrottleGroup struct {
 	ThrottleLevel  uint8
 	WindowDuration time.Duration
@@ -75,6 +71,8 @@ type RequestThrottleModule struct {
 
 func (m *RequestThrottleModule) Init() error {
 	m.ModuleBase = module_base.NewModuleBase(ID, nil)
+	// throttle groups follow the loaded config
+	initGlobalVariables()
 	m.controller = throttling.NewThrottleController(m.Logger())
 	m.logger = m.Logger()
 	return nil
@@ -3,15 +3,23 @@ package request
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+	base_conn "whub/common/connection"
 	"whub/common/logger"
+	"whub/hub_common/circuit_breaker"
 	"whub/hub_common/connection"
+	"whub/hub_common/load_balancing"
 	"whub/hub_common/messages"
 	"whub/hub_common/service"
+	"whub/hub_server/config"
 	"whub/hub_server/context"
 	server_errors "whub/hub_server/errors"
 	"whub/hub_server/events"
 	"whub/hub_server/module_base"
 	"whub/hub_server/modules/connection_manager"
+	"whub/hub_server/modules/retry_budget"
 )
 
 type InternalServiceRequestExecutor struct {
@@ -25,40 +33,141 @@ func NewInternalServiceRequestExecutor(handler service.IDefaultServiceHandler) s
 func (e *InternalServiceRequestExecutor) Execute(request service.IServiceRequest) {
 	// internal service will resolve the request if no error is present
 	err := e.handler.Handle(request)
-	if err != nil {
+	if requestErr, ok := service.AsRequestError(err); ok {
+		request.Resolve(messages.NewErrorResponse(request, context.Ctx.Server().Id(), requestErr.Code(), requestErr.Error()))
+	} else if err != nil {
 		request.Resolve(messages.NewInternalErrorMessage(request.Id(), context.Ctx.Server().Id(), request.From(), request.Uri(), server_errors.NewJsonMessageError(err.Error())))
 	}
 }
 
+var errAllConnectionsEjected = errors.New("all service connections are ejected by circuit breakers")
+var errAllConnectionsDraining = errors.New("all service connections are draining")
+
+// ProviderConnectionStatus describes a provider connection of a relay service
+type ProviderConnectionStatus struct {
+	Type     string `json:"type"`
+	Address  string `json:"address"`
+	Provider string `json:"provider"`
+	Weight   int    `json:"weight"`
+	// CircuitBreaker is nil if circuit breakers are disabled
+	CircuitBreaker *circuit_breaker.Status `json:"circuitBreaker,omitempty"`
+}
+
 type RelayServiceRequestExecutor struct {
-	serviceId         string
-	providerId        string
-	hostId            string
-	connections       []connection.IConnection
-	lastSucceededConn int
+	serviceId   string
+	hostId      string
+	connections []connection.IConnection
+	// provider ids and weights of provider connections by address
+	owners  map[string]string
+	weights map[string]int
+	// in-flight request counts by connection address
+	inFlight map[string]int
+	// provider ids of draining connections by address, connections added during the drain are not draining. The drained
+	// channel of a provider is closed and removed once its draining connections have no in-flight request
+	draining map[string]string
+	drained  map[string]chan struct{}
+	// circuit breakers of provider connections by address, empty if circuit breakers are disabled
+	breakers          map[string]*circuit_breaker.CircuitBreaker
+	loadBalancing     service.LoadBalancingDescriptor
+	balancer          load_balancing.IBalancer
+	retry             service.RetryDescriptor
+	lock              *sync.RWMutex
 	connectionManager connection_manager.IConnectionManagerModule `module:""`
+	retryBudget       retry_budget.IRetryBudgetModule             `module:""`
 	logger            *logger.SimpleLogger
 }
 
-func NewRelayServiceRequestExecutor(serviceId string, providerId string) *RelayServiceRequestExecutor {
+func NewRelayServiceRequestExecutor(serviceId string, loadBalancing service.LoadBalancingDescriptor, retry service.RetryDescriptor) *RelayServiceRequestExecutor {
 	e := &RelayServiceRequestExecutor{
-		hostId:     context.Ctx.Server().Id(),
-		serviceId:  serviceId,
-		providerId: providerId,
-		logger:     context.Ctx.Logger().WithPrefix(fmt.Sprintf("[RelayServiceRequestExecutor-%s]", serviceId)),
+		hostId:      context.Ctx.Server().Id(),
+		serviceId:   serviceId,
+		connections: []connection.IConnection{},
+		owners:      make(map[string]string),
+		weights:     make(map[string]int),
+		inFlight:    make(map[string]int),
+		draining:    make(map[string]string),
+		drained:     make(map[string]chan struct{}),
+		breakers:    make(map[string]*circuit_breaker.CircuitBreaker),
+		lock:        new(sync.RWMutex),
+		logger:      context.Ctx.Logger().WithPrefix(fmt.Sprintf("[RelayServiceRequestExecutor-%s]", serviceId)),
 	}
 	err := module_base.Manager.AutoFill(e)
 	if err != nil {
 		panic(err)
 	}
-	err = e.updateConnections()
-	if err != nil {
-		e.connections = []connection.IConnection{}
+	if err = e.SetLoadBalancing(loadBalancing); err != nil {
+		e.logger.Printf("unable to set load balancing due to %s, will use round robin", err.Error())
+		e.SetLoadBalancing(service.LoadBalancingDescriptor{})
+	}
+	if err = e.SetRetry(retry); err != nil {
+		e.logger.Printf("unable to set retry due to %s, will use the default retry", err.Error())
 	}
 	e.initNotifications()
 	return e
 }
 
+func (e *RelayServiceRequestExecutor) withWrite(cb func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	cb()
+}
+
+func (e *RelayServiceRequestExecutor) withRead(cb func()) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	cb()
+}
+
+// SetLoadBalancing switches the load balancing strategy, provider connections are kept with their weights
+func (e *RelayServiceRequestExecutor) SetLoadBalancing(loadBalancing service.LoadBalancingDescriptor) (err error) {
+	if err = loadBalancing.Validate(); err != nil {
+		return err
+	}
+	e.withWrite(func() {
+		var balancer load_balancing.IBalancer
+		if e.balancer != nil && e.balancer.Strategy() == loadBalancing.Strategy {
+			balancer = e.balancer
+		} else if balancer, err = load_balancing.NewBalancer(loadBalancing.Strategy); err != nil {
+			return
+		}
+		for _, conn := range e.connections {
+			balancer.Add(load_balancing.Endpoint{Address: conn.Address(), Weight: e.weights[conn.Address()]})
+		}
+		e.balancer = balancer
+		e.loadBalancing = loadBalancing
+	})
+	if err == nil {
+		e.logger.Printf("load balancing: %s", loadBalancing.String())
+	}
+	return
+}
+
+func (e *RelayServiceRequestExecutor) LoadBalancing() (loadBalancing service.LoadBalancingDescriptor) {
+	e.withRead(func() {
+		loadBalancing = e.loadBalancing
+	})
+	return
+}
+
+// SetRetry sets the retry policies of requests to the service
+func (e *RelayServiceRequestExecutor) SetRetry(retry service.RetryDescriptor) error {
+	if err := retry.Validate(); err != nil {
+		return err
+	}
+	e.withWrite(func() {
+		e.retry = retry
+	})
+	e.logger.Printf("retry: %s", retry.String())
+	return nil
+}
+
+func (e *RelayServiceRequestExecutor) Retry() (retry service.RetryDescriptor) {
+	e.withRead(func() {
+		retry = e.retry
+	})
+	return
+}
+
 func (e *RelayServiceRequestExecutor) initNotifications() {
 	// do not on ClientConnectionEstablished event because new client connection doesn't mean the client is ready for
 	// service requests
@@ -68,60 +177,428 @@ func (e *RelayServiceRequestExecutor) initNotifications() {
 }
 
 func (e *RelayServiceRequestExecutor) handleClientConnectionChangeEvent(event messages.IMessage) {
-	if (string)(event.Payload()) == e.providerId {
+	if e.HasProviderConnections((string)(event.Payload())) {
 		e.updateConnections()
 	}
 }
 
 func (e *RelayServiceRequestExecutor) updateConnections() error {
-	for i := 0; i < len(e.connections); i++ {
-		conn := e.connections[i]
-		if conn == nil || !conn.IsLive() {
-			// remove this conn
-			e.connections = append(e.connections[:i], e.connections[i+1:]...)
-			i--
+	e.removeConnections(func(conn connection.IConnection) bool {
+		return conn == nil || !conn.IsLive()
+	})
+	return nil
+}
+
+// removeConnections removes connections that match the predicate
+func (e *RelayServiceRequestExecutor) removeConnections(predicate func(conn connection.IConnection) bool) {
+	e.withWrite(func() {
+		for i := 0; i < len(e.connections); i++ {
+			conn := e.connections[i]
+			if predicate(conn) {
+				// remove this conn
+				e.connections = append(e.connections[:i], e.connections[i+1:]...)
+				i--
+				if conn != nil {
+					e.balancer.Remove(conn.Address())
+					delete(e.owners, conn.Address())
+					delete(e.weights, conn.Address())
+					delete(e.breakers, conn.Address())
+					if providerId := e.draining[conn.Address()]; providerId != "" {
+						// removed connections no longer hold the drain
+						delete(e.draining, conn.Address())
+						e.checkDrained(providerId)
+					}
+				}
+			}
+		}
+		e.logger.Println("service connections:", e.connections)
+	})
+}
+
+// HasProviderConnections tells if the provider has any connection to the executor
+func (e *RelayServiceRequestExecutor) HasProviderConnections(providerId string) (has bool) {
+	e.withRead(func() {
+		for _, owner := range e.owners {
+			if owner == providerId {
+				has = true
+				return
+			}
+		}
+	})
+	return
+}
+
+// RemoveProviderConnections removes all connections of the provider
+func (e *RelayServiceRequestExecutor) RemoveProviderConnections(providerId string) {
+	e.logger.Printf("remove connections of provider %s", providerId)
+	e.removeConnections(func(conn connection.IConnection) bool {
+		return conn == nil || e.owners[conn.Address()] == providerId
+	})
+}
+
+// RemoveDrainedConnections removes the draining connections of the provider, returns the number of its remaining
+// connections, which are added during the drain
+func (e *RelayServiceRequestExecutor) RemoveDrainedConnections(providerId string) (remaining int) {
+	e.logger.Printf("remove drained connections of provider %s", providerId)
+	e.removeConnections(func(conn connection.IConnection) bool {
+		return conn == nil || e.draining[conn.Address()] == providerId
+	})
+	e.withRead(func() {
+		for _, owner := range e.owners {
+			if owner == providerId {
+				remaining++
+			}
+		}
+	})
+	return
+}
+
+// DrainProvider stops routing new requests to the current connections of the provider, the returned channel is closed
+// once these connections have no in-flight request
+func (e *RelayServiceRequestExecutor) DrainProvider(providerId string) (drained <-chan struct{}) {
+	e.logger.Printf("drain provider %s", providerId)
+	e.withWrite(func() {
+		ch := e.drained[providerId]
+		if ch == nil {
+			ch = make(chan struct{})
+			e.drained[providerId] = ch
+		}
+		for addr, owner := range e.owners {
+			if owner == providerId {
+				e.draining[addr] = providerId
+			}
+		}
+		e.checkDrained(providerId)
+		drained = ch
+	})
+	return
+}
+
+// checkDrained closes the drained channel of the provider if its draining connections have no in-flight request, the
+// caller should hold the write lock
+func (e *RelayServiceRequestExecutor) checkDrained(providerId string) {
+	ch := e.drained[providerId]
+	if ch == nil {
+		return
+	}
+	for addr, owner := range e.draining {
+		if owner == providerId && e.inFlight[addr] > 0 {
+			return
 		}
 	}
-	e.logger.Println("service connections:", e.connections)
-	return nil
+	close(ch)
+	delete(e.drained, providerId)
+}
+
+// WaitDrained waits for the channel returned by DrainProvider, returns false if the provider isn't drained in timeout
+func WaitDrained(drained <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// IsProviderDraining tells if all connections of the provider are draining
+func (e *RelayServiceRequestExecutor) IsProviderDraining(providerId string) (draining bool) {
+	e.withRead(func() {
+		for addr, owner := range e.owners {
+			if owner != providerId {
+				continue
+			}
+			if e.draining[addr] == "" {
+				draining = false
+				return
+			}
+			draining = true
+		}
+	})
+	return
+}
+
+func (e *RelayServiceRequestExecutor) ProviderInFlight(providerId string) (count int) {
+	e.withRead(func() {
+		for addr, owner := range e.owners {
+			if owner == providerId {
+				count += e.inFlight[addr]
+			}
+		}
+	})
+	return
+}
+
+// beginInFlight counts the request to the connection as in-flight until the returned func is called, returns nil if
+// the connection is draining
+func (e *RelayServiceRequestExecutor) beginInFlight(addr string) (done func()) {
+	draining := false
+	e.withWrite(func() {
+		if draining = e.draining[addr] != ""; !draining {
+			e.inFlight[addr]++
+		}
+	})
+	if draining {
+		return nil
+	}
+	return func() {
+		e.withWrite(func() {
+			if e.inFlight[addr]--; e.inFlight[addr] > 0 {
+				return
+			}
+			delete(e.inFlight, addr)
+			if providerId := e.draining[addr]; providerId != "" {
+				e.checkDrained(providerId)
+			}
+		})
+	}
+}
+
+// SetProviderWeight updates the weight of all connections of the provider
+func (e *RelayServiceRequestExecutor) SetProviderWeight(providerId string, weight int) {
+	e.withWrite(func() {
+		for addr, owner := range e.owners {
+			if owner == providerId && e.weights[addr] != weight {
+				e.weights[addr] = weight
+				e.balancer.Add(load_balancing.Endpoint{Address: addr, Weight: weight})
+			}
+		}
+	})
 }
 
 func (e *RelayServiceRequestExecutor) Execute(request service.IServiceRequest) {
-	response, err := e.doRequest(request)
+	response, reader, err := e.doRequest(request)
 	if request.Status() == service.ServiceRequestStatusDead {
 		// last check on if message_dispatcher is killed
+		if reader != nil {
+			reader.Close()
+		}
 		request.Resolve(messages.NewInternalErrorMessage(request.Id(), e.hostId, request.From(), request.Uri(), "request has been cancelled or target server is dead"))
+	} else if err == errAllConnectionsEjected || err == errAllConnectionsDraining {
+		request.Resolve(messages.NewErrorResponse(request, e.hostId, messages.MessageTypeSvcUnavailableError, err.Error()))
+	} else if err != nil && messages.IsDeadlineExceeded(request.Message()) {
+		request.Resolve(messages.NewErrorResponse(request, e.hostId, messages.MessageTypeSvcGatewayTimeoutError, err.Error()))
 	} else if err != nil {
 		request.Resolve(messages.NewInternalErrorMessage(request.Id(), e.hostId, request.From(), request.Uri(), err.Error()))
+	} else if reader != nil {
+		e.relayStream(request, response, reader)
 	} else {
 		request.Resolve(response)
 	}
 }
 
-// try all connections from lastSucceededConn until one succeeded
-func (e *RelayServiceRequestExecutor) doRequest(request service.IServiceRequest) (msg messages.IMessage, err error) {
-	if len(e.connections) == 0 {
-		return nil, errors.New("all service connection is down")
+// relayStream resolves the request with the stream header from the provider and pipes the stream to the requester
+func (e *RelayServiceRequestExecutor) relayStream(request service.IServiceRequest, header messages.IMessage, reader connection.IStreamReader) {
+	writer, err := request.Stream(e.hostId, header.Headers())
+	if err != nil {
+		e.logger.Printf("unable to relay stream %s due to %s", reader.Id(), err.Error())
+		reader.Close()
+		return
+	}
+	go func() {
+		if err := connection.PipeStream(reader, writer); err != nil {
+			e.logger.Printf("stream %s relay failed due to %s", reader.Id(), err.Error())
+		}
+	}()
+}
+
+// hashKey is the key of the request for consistent hashing
+func (e *RelayServiceRequestExecutor) hashKey(request service.IServiceRequest, loadBalancing service.LoadBalancingDescriptor) string {
+	if loadBalancing.HashHeader != "" {
+		if value := request.GetHeader(loadBalancing.HashHeader); value != "" {
+			return value
+		}
 	}
-	size := len(e.connections)
-	for i := 0; i < size; i++ {
-		e.lastSucceededConn++
-		if msg, err = e.connections[(e.lastSucceededConn % len(e.connections))].Request(request.Message()); err == nil {
-			// once the first connection successfully handles the request, return
+	if loadBalancing.HashPathParam != "" {
+		if pathParams, ok := request.GetContext(service.ServiceRequestContextPathParams).(map[string]string); ok && pathParams[loadBalancing.HashPathParam] != "" {
+			return pathParams[loadBalancing.HashPathParam]
+		}
+	}
+	return request.From()
+}
+
+func (e *RelayServiceRequestExecutor) connectionByAddress(addr string) (conn connection.IConnection) {
+	e.withRead(func() {
+		for _, c := range e.connections {
+			if c.Address() == addr {
+				conn = c
+				return
+			}
+		}
+	})
+	return
+}
+
+// try connections in the order picked by the balancer, failed requests are retried on the following connections by
+// the retry policy of the request
+func (e *RelayServiceRequestExecutor) doRequest(request service.IServiceRequest) (msg messages.IMessage, reader connection.IStreamReader, err error) {
+	var balancer load_balancing.IBalancer
+	var loadBalancing service.LoadBalancingDescriptor
+	var retry service.RetryDescriptor
+	e.withRead(func() {
+		balancer, loadBalancing, retry = e.balancer, e.loadBalancing, e.retry
+	})
+	policy, retryable := retry.PolicyOf(request.Message().MessageType())
+	e.retryBudget.Deposit()
+	addresses := balancer.Pick(e.hashKey(request, loadBalancing))
+	err = errors.New("all service connection is down")
+	attempts := 0
+	ejected, draining := false, false
+	for _, addr := range addresses {
+		if attempts > 0 && (request.IsCancelled() || messages.IsDeadlineExceeded(request.Message())) {
+			// the requester has given up or no budget left for other connections
+			return
+		}
+		conn, breaker, providerId := e.connectionByAddress(addr), e.breakerByAddress(addr), e.ownerByAddress(addr)
+		if conn == nil {
+			continue
+		}
+		if breaker != nil && !breaker.Allow() {
+			ejected = true
+			continue
+		}
+		doneInFlight := e.beginInFlight(addr)
+		if doneInFlight == nil {
+			// no new request to draining connections
+			if breaker != nil {
+				breaker.Release()
+			}
+			draining = true
+			continue
+		}
+		if attempts > 0 && !e.beforeRetry(request, policy, attempts) {
+			doneInFlight()
+			if breaker != nil {
+				breaker.Release()
+			}
+			return
+		}
+		if msg != nil {
+			// only the response of the last attempt is returned
+			msg.Dispose()
+			msg = nil
+		}
+		attempts++
+		done := balancer.Begin(addr)
+		start := time.Now()
+		// messages are disposed once sent, send a copy as the request message is still in use, the copy carries the
+		// deadline so that the provider gets the remaining budget
+		stopForwarding := e.forwardCancel(request, conn, providerId)
+		msg, reader, err = conn.RequestWithStream(request.Message().Copy())
+		stopForwarding()
+		doneInFlight()
+		done(err)
+		e.recordResult(request, breaker, time.Since(start), msg, err)
+		if !retryable || attempts >= policy.MaxAttempts || reader != nil {
 			return
 		}
+		if err == nil && !policy.ShouldRetryOn(msg) {
+			// once a connection successfully handles the request, return
+			return
+		}
+	}
+	if attempts == 0 && draining {
+		err = errAllConnectionsDraining
+	} else if attempts == 0 && ejected {
+		err = errAllConnectionsEjected
+	}
+	return
+}
+
+// beforeRetry withdraws the retry from the retry budget and waits for the backoff with jitter, returns false if the
+// request shouldn't be retried
+func (e *RelayServiceRequestExecutor) beforeRetry(request service.IServiceRequest, policy service.RetryPolicy, n int) bool {
+	if !e.retryBudget.TryWithdraw() {
+		e.logger.Printf("retry budget is used up, request %s won't be retried", request.Id())
+		return false
+	}
+	backoff := policy.Backoff(n)
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		e.logger.Printf("retry request %s(attempt %d)", request.Id(), n+1)
+		return true
+	case <-request.Context().Done():
+		return false
 	}
+}
+
+// recordResult records the result of a request to the circuit breaker of the connection, errors and 5xx responses are
+// failures while requests given up by the requester are not counted
+func (e *RelayServiceRequestExecutor) recordResult(request service.IServiceRequest, breaker *circuit_breaker.CircuitBreaker, latency time.Duration, msg messages.IMessage, err error) {
+	if breaker == nil {
+		return
+	}
+	if err != nil && (request.IsCancelled() || messages.IsDeadlineExceeded(request.Message())) {
+		breaker.Release()
+		return
+	}
+	failed := err != nil || msg.MessageType() >= messages.MessageTypeSvcInternalError && msg.MessageType() <= messages.MessageTypeSvcGatewayTimeoutError
+	breaker.Record(latency, failed)
+}
+
+func (e *RelayServiceRequestExecutor) ownerByAddress(addr string) (providerId string) {
+	e.withRead(func() {
+		providerId = e.owners[addr]
+	})
+	return
+}
+
+func (e *RelayServiceRequestExecutor) breakerByAddress(addr string) (breaker *circuit_breaker.CircuitBreaker) {
+	e.withRead(func() {
+		breaker = e.breakers[addr]
+	})
 	return
 }
 
-func (e *RelayServiceRequestExecutor) UpdateProviderConnection(connAddr string) (err error) {
-	e.logger.Printf("update provider connection: %s", connAddr)
-	for _, c := range e.connections {
-		if c.Address() == connAddr {
-			err = errors.New(fmt.Sprintf("connection address %s has already been added to the executor", connAddr))
-			e.logger.Printf(err.Error())
-			return err
+func (e *RelayServiceRequestExecutor) newBreaker(addr string) *circuit_breaker.CircuitBreaker {
+	breakerConfig := config.Config.CircuitBreaker
+	if breakerConfig.Disabled {
+		return nil
+	}
+	breaker := circuit_breaker.NewCircuitBreaker(circuit_breaker.Options{
+		FailureThreshold: breakerConfig.FailureThreshold,
+		SlowCallDuration: time.Duration(breakerConfig.SlowCallDuration) * time.Millisecond,
+		OpenDuration:     time.Duration(breakerConfig.OpenDuration) * time.Millisecond,
+		MaxOpenDuration:  time.Duration(breakerConfig.MaxOpenDuration) * time.Millisecond,
+	})
+	breaker.OnStateChange(func(from string, to string) {
+		e.logger.Printf("circuit breaker of provider connection %s: %s -> %s", addr, from, to)
+	})
+	return breaker
+}
+
+// forwardCancel sends a cancel message to the provider connection once the request is cancelled until it's stopped,
+// the provider then responds to the pending request early
+func (e *RelayServiceRequestExecutor) forwardCancel(request service.IServiceRequest, conn connection.IConnection, providerId string) (stop func()) {
+	done := make(chan struct{})
+	ctx := request.Context()
+	id, uri := request.Id(), request.Uri()
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if request.IsCancelled() {
+				e.logger.Printf("forward cancellation of request %s to %s", id, conn.Address())
+				conn.Send(messages.NewServiceCancelMessage(id, e.hostId, providerId, uri))
+			}
 		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// UpdateProviderConnection adds the connection of the provider with its weight for weighted balancing
+func (e *RelayServiceRequestExecutor) UpdateProviderConnection(providerId string, connAddr string, weight int) (err error) {
+	e.logger.Printf("update provider connection: %s from %s(weight %d)", connAddr, providerId, weight)
+	if e.connectionByAddress(connAddr) != nil {
+		err = errors.New(fmt.Sprintf("connection address %s has already been added to the executor", connAddr))
+		e.logger.Printf(err.Error())
+		return err
 	}
 	conn, err := e.connectionManager.GetConnectionByAddress(connAddr)
 	if err != nil {
@@ -129,11 +606,47 @@ func (e *RelayServiceRequestExecutor) UpdateProviderConnection(connAddr string)
 		e.logger.Printf(err.Error())
 		return err
 	}
-	e.connections = append(e.connections, conn)
+	breaker := e.newBreaker(connAddr)
+	e.withWrite(func() {
+		e.connections = append(e.connections, conn)
+		e.owners[connAddr] = providerId
+		e.weights[connAddr] = weight
+		if breaker != nil {
+			e.breakers[connAddr] = breaker
+		}
+		e.balancer.Add(load_balancing.Endpoint{Address: connAddr, Weight: weight})
+	})
 	e.logger.Printf("update provider connection %s succeeded", connAddr)
 	return nil
 }
 
-func (e *RelayServiceRequestExecutor) GetProviderConnections() []connection.IConnection {
-	return e.connections
+func (e *RelayServiceRequestExecutor) GetProviderConnections() (conns []connection.IConnection) {
+	e.withRead(func() {
+		conns = make([]connection.IConnection, len(e.connections))
+		copy(conns, e.connections)
+	})
+	return
+}
+
+// GetProviderConnectionStatuses returns provider connections along with their weights and circuit breaker states
+func (e *RelayServiceRequestExecutor) GetProviderConnectionStatuses() (statuses []ProviderConnectionStatus) {
+	e.withRead(func() {
+		statuses = make([]ProviderConnectionStatus, len(e.connections))
+		for i, conn := range e.connections {
+			statuses[i] = ProviderConnectionStatus{
+				Type:     base_conn.TypeString(conn.ConnectionType()),
+				Address:  conn.Address(),
+				Provider: e.owners[conn.Address()],
+				Weight:   e.weights[conn.Address()],
+			}
+			if statuses[i].Weight <= 0 {
+				statuses[i].Weight = 1
+			}
+			if breaker := e.breakers[conn.Address()]; breaker != nil {
+				status := breaker.Status()
+				statuses[i].CircuitBreaker = &status
+			}
+		}
+	})
+	return
 }
@@ -0,0 +1,270 @@
+package request
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"whub/common/logger"
+	"whub/common/test_utils"
+	"whub/hub_common/circuit_breaker"
+	"whub/hub_common/connection"
+	"whub/hub_common/load_balancing"
+	"whub/hub_common/messages"
+	"whub/hub_common/retry_budget"
+	"whub/hub_common/service"
+)
+
+type testRetryBudget struct{}
+
+func (b testRetryBudget) Deposit() {}
+
+func (b testRetryBudget) TryWithdraw() bool {
+	return true
+}
+
+func (b testRetryBudget) Status() retry_budget.Status {
+	return retry_budget.Status{}
+}
+
+// testResponse records if it's disposed
+type testResponse struct {
+	messages.IMessage
+	disposed bool
+}
+
+func (r *testResponse) Dispose() {
+	r.disposed = true
+}
+
+// testProviderConnection responds with its address, requests are held until release is closed if it's set. Connections
+// with failure set respond with 503 and keep the responses.
+type testProviderConnection struct {
+	connection.IConnection
+	address   string
+	release   chan struct{}
+	failure   bool
+	responses []*testResponse
+}
+
+func (c *testProviderConnection) Address() string {
+	return c.address
+}
+
+func (c *testProviderConnection) IsLive() bool {
+	return true
+}
+
+func (c *testProviderConnection) Send(message messages.IMessage) error {
+	return nil
+}
+
+func (c *testProviderConnection) RequestWithStream(message messages.IMessage) (messages.IMessage, connection.IStreamReader, error) {
+	if c.release != nil {
+		<-c.release
+	}
+	if c.failure {
+		response := &testResponse{IMessage: messages.NewMessage(message.Id(), c.address, message.From(), message.Uri(), messages.MessageTypeSvcUnavailableError, ([]byte)(c.address))}
+		c.responses = append(c.responses, response)
+		return response, nil, nil
+	}
+	return messages.NewMessage(message.Id(), c.address, message.From(), message.Uri(), messages.MessageTypeSvcResponseOK, ([]byte)(c.address)), nil, nil
+}
+
+// newTestRelayExecutor creates an executor without modules, circuit breakers and connection manager
+func newTestRelayExecutor() *RelayServiceRequestExecutor {
+	e := &RelayServiceRequestExecutor{
+		hostId:      "server",
+		serviceId:   "test",
+		connections: []connection.IConnection{},
+		owners:      make(map[string]string),
+		weights:     make(map[string]int),
+		inFlight:    make(map[string]int),
+		draining:    make(map[string]string),
+		drained:     make(map[string]chan struct{}),
+		breakers:    make(map[string]*circuit_breaker.CircuitBreaker),
+		lock:        new(sync.RWMutex),
+		retryBudget: testRetryBudget{},
+		logger:      logger.New(os.Stdout, "[RelayServiceRequestExecutorTest]", false),
+	}
+	e.SetLoadBalancing(service.LoadBalancingDescriptor{})
+	return e
+}
+
+func (e *RelayServiceRequestExecutor) addTestConnection(providerId string, conn *testProviderConnection) {
+	e.withWrite(func() {
+		e.connections = append(e.connections, conn)
+		e.owners[conn.address] = providerId
+		e.balancer.Add(load_balancing.Endpoint{Address: conn.address})
+	})
+}
+
+func newTestServiceRequest() service.IServiceRequest {
+	return service.NewServiceRequest(messages.NewMessage("1", "client", "server", "/service/test/x", messages.MessageTypeServiceGetRequest, nil))
+}
+
+// waitInFlight waits until the provider has n in-flight requests
+func waitInFlight(e *RelayServiceRequestExecutor, providerId string, n int) bool {
+	for i := 0; i < 100; i++ {
+		if e.ProviderInFlight(providerId) == n {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRelayServiceRequestExecutorRetry(t *testing.T) {
+	tg := test_utils.NewTestGroup("RelayServiceRequestExecutor retry", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Dispose", "responses of retried attempts should be disposed", func() bool {
+			e := newTestRelayExecutor()
+			e.SetRetry(service.RetryDescriptor{RetryPolicy: service.RetryPolicy{MaxAttempts: 3, BackoffBase: 1, BackoffMax: 1}})
+			conns := []*testProviderConnection{{address: "a:1", failure: true}, {address: "a:2", failure: true}, {address: "a:3", failure: true}}
+			for _, conn := range conns {
+				e.addTestConnection("a", conn)
+			}
+			msg, _, err := e.doRequest(newTestServiceRequest())
+			if err != nil || msg == nil || msg.MessageType() != messages.MessageTypeSvcUnavailableError {
+				t.Log("unexpected response: ", msg, err)
+				return false
+			}
+			disposed := 0
+			for _, conn := range conns {
+				for _, response := range conn.responses {
+					if response.disposed {
+						disposed++
+					} else if response != msg {
+						t.Log("response of a retried attempt is not disposed: ", response)
+						return false
+					}
+				}
+			}
+			return disposed == 2 && !msg.(*testResponse).disposed
+		}),
+	}).Do(t)
+}
+
+func TestRelayServiceRequestExecutorDrain(t *testing.T) {
+	tg := test_utils.NewTestGroup("RelayServiceRequestExecutor drain", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Idle", "provider without in-flight request should be drained at once", func() bool {
+			e := newTestRelayExecutor()
+			e.addTestConnection("a", &testProviderConnection{address: "a:1"})
+			drained := e.DrainProvider("a")
+			return isClosed(drained) && e.IsProviderDraining("a")
+		}),
+		test_utils.NewTestCase("In flight", "provider should be drained once its in-flight requests are done", func() bool {
+			e := newTestRelayExecutor()
+			conn := &testProviderConnection{address: "a:1", release: make(chan struct{})}
+			e.addTestConnection("a", conn)
+			responded := make(chan messages.IMessage, 1)
+			go func() {
+				msg, _, _ := e.doRequest(newTestServiceRequest())
+				responded <- msg
+			}()
+			if !waitInFlight(e, "a", 1) {
+				t.Log("request is not in flight")
+				return false
+			}
+			drained := e.DrainProvider("a")
+			if isClosed(drained) {
+				t.Log("provider is drained with a request in flight")
+				return false
+			}
+			close(conn.release)
+			msg := <-responded
+			return WaitDrained(drained, time.Second) && msg != nil && string(msg.Payload()) == "a:1" && e.ProviderInFlight("a") == 0
+		}),
+		test_utils.NewTestCase("Timeout", "waiting should time out while requests are in flight", func() bool {
+			e := newTestRelayExecutor()
+			conn := &testProviderConnection{address: "a:1", release: make(chan struct{})}
+			e.addTestConnection("a", conn)
+			go e.doRequest(newTestServiceRequest())
+			if !waitInFlight(e, "a", 1) {
+				return false
+			}
+			drained := e.DrainProvider("a")
+			timedOut := !WaitDrained(drained, time.Millisecond*50)
+			close(conn.release)
+			return timedOut && WaitDrained(drained, time.Second)
+		}),
+		test_utils.NewTestCase("New provider", "new providers should take the requests during the drain", func() bool {
+			e := newTestRelayExecutor()
+			e.addTestConnection("a", &testProviderConnection{address: "a:1"})
+			e.DrainProvider("a")
+			if _, _, err := e.doRequest(newTestServiceRequest()); err != errAllConnectionsDraining {
+				t.Log("request to draining provider should fail with ", errAllConnectionsDraining)
+				return false
+			}
+			e.addTestConnection("b", &testProviderConnection{address: "b:1"})
+			for i := 0; i < 4; i++ {
+				msg, _, err := e.doRequest(newTestServiceRequest())
+				if err != nil || string(msg.Payload()) != "b:1" {
+					t.Log("request is not routed to the new provider: ", msg, err)
+					return false
+				}
+			}
+			return e.IsProviderDraining("a") && !e.IsProviderDraining("b")
+		}),
+		test_utils.NewTestCase("Remove", "removing the provider should clear its drain state", func() bool {
+			e := newTestRelayExecutor()
+			e.addTestConnection("a", &testProviderConnection{address: "a:1"})
+			e.DrainProvider("a")
+			e.RemoveProviderConnections("a")
+			return !e.IsProviderDraining("a") && !e.HasProviderConnections("a")
+		}),
+		test_utils.NewTestCase("Re-register", "connections added by the provider during its drain should take requests and stay", func() bool {
+			e := newTestRelayExecutor()
+			conn := &testProviderConnection{address: "a:1", release: make(chan struct{})}
+			e.addTestConnection("a", conn)
+			go e.doRequest(newTestServiceRequest())
+			if !waitInFlight(e, "a", 1) {
+				return false
+			}
+			drained := e.DrainProvider("a")
+			e.addTestConnection("a", &testProviderConnection{address: "a:2"})
+			for i := 0; i < 4; i++ {
+				msg, _, err := e.doRequest(newTestServiceRequest())
+				if err != nil || string(msg.Payload()) != "a:2" {
+					t.Log("request is not routed to the new connection: ", msg, err)
+					return false
+				}
+			}
+			if e.IsProviderDraining("a") || isClosed(drained) {
+				t.Log("new connection should neither drain nor hold the drain")
+				return false
+			}
+			close(conn.release)
+			if !WaitDrained(drained, time.Second) || e.RemoveDrainedConnections("a") != 1 {
+				return false
+			}
+			msg, _, err := e.doRequest(newTestServiceRequest())
+			return err == nil && string(msg.Payload()) == "a:2" && e.connectionByAddress("a:1") == nil
+		}),
+		test_utils.NewTestCase("Close draining connection", "closed connections should no longer hold the drain", func() bool {
+			e := newTestRelayExecutor()
+			conn := &testProviderConnection{address: "a:1", release: make(chan struct{})}
+			defer close(conn.release)
+			e.addTestConnection("a", conn)
+			go e.doRequest(newTestServiceRequest())
+			if !waitInFlight(e, "a", 1) {
+				return false
+			}
+			drained := e.DrainProvider("a")
+			e.removeConnections(func(c connection.IConnection) bool {
+				return c.Address() == "a:1"
+			})
+			return isClosed(drained)
+		}),
+	}).Do(t)
+}
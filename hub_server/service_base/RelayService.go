@@ -10,9 +10,36 @@ import (
 	"whub/hub_server/request"
 )
 
+const (
+	ProviderStatusAlive    = "alive"
+	ProviderStatusDead     = "dead"
+	ProviderStatusDraining = "draining" // no new request is routed to the provider
+)
+
+// relayServiceProvider is a client providing the relay service with the descriptor it registered or updated with
+type relayServiceProvider struct {
+	provider   IServiceProvider
+	descriptor service.ServiceDescriptor
+	dead       bool
+}
+
+// ProviderStatus describes a provider client of a relay service
+type ProviderStatus struct {
+	Id string `json:"id"`
+	// the primary provider owns the routes and settings of the service
+	Primary     bool   `json:"primary"`
+	Status      string `json:"status"`
+	Weight      int    `json:"weight"`
+	Connections int    `json:"connections"`
+	InFlight    int    `json:"inFlight"`
+}
+
+// RelayService relays requests to connections of its providers. Distinct clients can provide the same service, the
+// first one is the primary provider and the next one is promoted once the primary is removed.
 type RelayService struct {
 	*Service
-	executor *request.RelayServiceRequestExecutor
+	executor  *request.RelayServiceRequestExecutor
+	providers []*relayServiceProvider
 }
 
 type IRelayService interface {
@@ -22,8 +49,31 @@ type IRelayService interface {
 		executor *request.RelayServiceRequestExecutor)
 	RestoreExternally(reconnectedOwner *client.Client) error
 	Update(descriptor service.ServiceDescriptor) error
-	UpdateProviderConnection(connAddr string) error
+	HasProvider(providerId string) bool
+	// AllowsProvider tells if the primary provider allows the client to provide the service along with it
+	AllowsProvider(providerId string) bool
+	// AddProvider adds another client providing the service
+	AddProvider(provider IServiceProvider, descriptor service.ServiceDescriptor) error
+	// RemoveProvider removes the provider with its connections, returns the descriptor of the promoted provider if the
+	// primary provider is removed and the number of remaining providers
+	RemoveProvider(providerId string) (promoted *service.ServiceDescriptor, remaining int, err error)
+	// UpdateProvider updates the descriptor of a non-primary provider, only its weight applies
+	UpdateProvider(descriptor service.ServiceDescriptor) error
+	// DrainProvider stops routing new requests to the provider, the returned channel is closed once the provider has no
+	// in-flight request
+	DrainProvider(providerId string) (<-chan struct{}, error)
+	// RemoveDrainedConnections removes the drained connections of the provider, returns the number of its connections
+	// added during the drain
+	RemoveDrainedConnections(providerId string) (remaining int)
+	// KillProvider marks the provider as dead, returns true if all providers are dead
+	KillProvider(providerId string) (allDead bool)
+	RestoreProvider(providerId string)
+	GetProviderStatuses() []ProviderStatus
+	// UpdateProviderConnection adds the connection of the provider with its load balancing weight
+	UpdateProviderConnection(providerId string, connAddr string, weight int) error
 	GetProviderConnections() []connection.IConnection
+	// GetProviderConnectionStatuses returns provider connections with their weights and circuit breaker states
+	GetProviderConnectionStatuses() []request.ProviderConnectionStatus
 }
 
 func (s *RelayService) Init(
@@ -32,6 +82,166 @@ func (s *RelayService) Init(
 	executor *request.RelayServiceRequestExecutor) {
 	s.Service = NewService(descriptor.Id, descriptor.Description, provider, executor, descriptor.ServiceUris, descriptor.ServiceType, descriptor.AccessType, descriptor.ExecutionType)
 	s.executor = executor
+	s.providers = []*relayServiceProvider{{provider: provider, descriptor: descriptor}}
+}
+
+func (s *RelayService) findProvider(providerId string) (int, *relayServiceProvider) {
+	for i, p := range s.providers {
+		if p.provider.Id() == providerId {
+			return i, p
+		}
+	}
+	return -1, nil
+}
+
+func (s *RelayService) HasProvider(providerId string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, p := s.findProvider(providerId)
+	return p != nil
+}
+
+func (s *RelayService) allowsProvider(providerId string) bool {
+	if len(s.providers) == 0 {
+		return false
+	}
+	for _, id := range s.providers[0].descriptor.CoProviders {
+		if id == providerId {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RelayService) AllowsProvider(providerId string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.allowsProvider(providerId)
+}
+
+func (s *RelayService) AddProvider(provider IServiceProvider, descriptor service.ServiceDescriptor) (err error) {
+	if s.Status() >= service.ServiceStatusStopping {
+		return errors.New(fmt.Sprintf("invalid service status for adding provider(%d)", s.Status()))
+	}
+	s.withWrite(func() {
+		if _, p := s.findProvider(provider.Id()); p != nil {
+			err = errors.New(fmt.Sprintf("client %s is already a provider of service %s", provider.Id(), s.Id()))
+			return
+		}
+		if !s.allowsProvider(provider.Id()) {
+			err = errors.New(fmt.Sprintf("client %s is not allowed to provide service %s", provider.Id(), s.Id()))
+			return
+		}
+		s.providers = append(s.providers, &relayServiceProvider{provider: provider, descriptor: descriptor})
+	})
+	if err == nil {
+		s.Logger().Printf("provider %s added", provider.Id())
+	}
+	return
+}
+
+func (s *RelayService) RemoveProvider(providerId string) (promoted *service.ServiceDescriptor, remaining int, err error) {
+	s.withWrite(func() {
+		i, p := s.findProvider(providerId)
+		if p == nil {
+			err = errors.New(fmt.Sprintf("client %s is not a provider of service %s", providerId, s.Id()))
+			return
+		}
+		s.providers = append(s.providers[:i], s.providers[i+1:]...)
+		remaining = len(s.providers)
+		if i == 0 && remaining > 0 {
+			s.provider = s.providers[0].provider
+			descriptor := s.providers[0].descriptor
+			promoted = &descriptor
+		}
+	})
+	if err != nil {
+		return
+	}
+	s.executor.RemoveProviderConnections(providerId)
+	s.Logger().Printf("provider %s removed, %d providers remaining", providerId, remaining)
+	if promoted != nil {
+		s.Logger().Printf("provider %s is promoted to primary", promoted.Provider.Id)
+	}
+	return
+}
+
+func (s *RelayService) UpdateProvider(descriptor service.ServiceDescriptor) (err error) {
+	s.withWrite(func() {
+		_, p := s.findProvider(descriptor.Provider.Id)
+		if p == nil {
+			err = errors.New(fmt.Sprintf("client %s is not a provider of service %s", descriptor.Provider.Id, s.Id()))
+			return
+		}
+		p.descriptor = descriptor
+	})
+	if err == nil {
+		s.executor.SetProviderWeight(descriptor.Provider.Id, descriptor.LoadBalancing.Weight)
+	}
+	return
+}
+
+func (s *RelayService) DrainProvider(providerId string) (<-chan struct{}, error) {
+	if !s.HasProvider(providerId) {
+		return nil, errors.New(fmt.Sprintf("client %s is not a provider of service %s", providerId, s.Id()))
+	}
+	s.Logger().Printf("draining provider %s", providerId)
+	return s.executor.DrainProvider(providerId), nil
+}
+
+func (s *RelayService) RemoveDrainedConnections(providerId string) int {
+	return s.executor.RemoveDrainedConnections(providerId)
+}
+
+func (s *RelayService) KillProvider(providerId string) (allDead bool) {
+	s.withWrite(func() {
+		allDead = true
+		for _, p := range s.providers {
+			if p.provider.Id() == providerId {
+				p.dead = true
+			}
+			allDead = allDead && p.dead
+		}
+	})
+	s.Logger().Printf("provider %s is dead", providerId)
+	return
+}
+
+func (s *RelayService) RestoreProvider(providerId string) {
+	s.withWrite(func() {
+		if _, p := s.findProvider(providerId); p != nil {
+			p.dead = false
+		}
+	})
+}
+
+func (s *RelayService) GetProviderStatuses() []ProviderStatus {
+	connections := make(map[string]int)
+	for _, conn := range s.executor.GetProviderConnectionStatuses() {
+		connections[conn.Provider]++
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	statuses := make([]ProviderStatus, len(s.providers))
+	for i, p := range s.providers {
+		statuses[i] = ProviderStatus{
+			Id:          p.provider.Id(),
+			Primary:     i == 0,
+			Status:      ProviderStatusAlive,
+			Weight:      p.descriptor.LoadBalancing.Weight,
+			Connections: connections[p.provider.Id()],
+			InFlight:    s.executor.ProviderInFlight(p.provider.Id()),
+		}
+		if s.executor.IsProviderDraining(p.provider.Id()) {
+			statuses[i].Status = ProviderStatusDraining
+		} else if p.dead {
+			statuses[i].Status = ProviderStatusDead
+		}
+		if statuses[i].Weight <= 0 {
+			statuses[i].Weight = 1
+		}
+	}
+	return statuses
 }
 
 func (s *RelayService) RestoreExternally(reconnectedOwner *client.Client) (err error) {
@@ -46,38 +256,81 @@ func (s *RelayService) RestoreExternally(reconnectedOwner *client.Client) (err e
 	}
 	oldOwner := s.provider
 	oldPool := s.serviceQueue
+	oldExecutor := s.executor
+	oldProviders := s.providers
 	s.withWrite(func() {
 		s.provider = reconnectedOwner
-		s.serviceQueue = service.NewServiceTaskQueue(s.HostInfo().Id, request.NewRelayServiceRequestExecutor(s.Id(), s.Provider().Id()), s.ctx.ServiceTaskPool())
+		s.executor = request.NewRelayServiceRequestExecutor(s.Id(), oldExecutor.LoadBalancing(), oldExecutor.Retry())
+		s.serviceQueue = service.NewServiceTaskQueue(s.HostInfo().Id, s.executor, s.ctx.ServiceTaskPool())
+		// the reconnected provider becomes the primary one
+		restored := &relayServiceProvider{provider: reconnectedOwner, descriptor: oldProviders[0].descriptor}
+		s.providers = []*relayServiceProvider{restored}
+		for _, p := range oldProviders {
+			if p.provider.Id() == reconnectedOwner.Id() {
+				restored.descriptor = p.descriptor
+			} else {
+				s.providers = append(s.providers, p)
+			}
+		}
 	})
 	err = s.Start()
 	if err != nil {
 		// fallback to previous status
 		s.withWrite(func() {
 			s.provider = oldOwner
+			s.executor = oldExecutor
 			s.serviceQueue = oldPool
+			s.providers = oldProviders
 			s.status = service.ServiceStatusDead
 		})
 	}
 	return err
 }
 
-func (s *RelayService) UpdateProviderConnection(connAddr string) error {
+func (s *RelayService) UpdateProviderConnection(providerId string, connAddr string, weight int) error {
 	if s.Status() >= service.ServiceStatusStopping {
 		return errors.New(fmt.Sprintf("invalid service status for update provider connection(%d)", s.Status()))
 	}
-	return s.executor.UpdateProviderConnection(connAddr)
+	if !s.HasProvider(providerId) {
+		return errors.New(fmt.Sprintf("client %s is not a provider of service %s", providerId, s.Id()))
+	}
+	// a new connection means the provider is alive again
+	s.RestoreProvider(providerId)
+	return s.executor.UpdateProviderConnection(providerId, connAddr, weight)
 }
 
 func (s *RelayService) GetProviderConnections() []connection.IConnection {
 	return s.executor.GetProviderConnections()
 }
 
+func (s *RelayService) GetProviderConnectionStatuses() []request.ProviderConnectionStatus {
+	return s.executor.GetProviderConnectionStatuses()
+}
+
+func (s *RelayService) Describe() service.ServiceDescriptor {
+	descriptor := s.Service.Describe()
+	descriptor.LoadBalancing = s.executor.LoadBalancing()
+	descriptor.Retry = s.executor.Retry()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.providers) > 0 {
+		descriptor.CoProviders = s.providers[0].descriptor.CoProviders
+	}
+	return descriptor
+}
+
 func (s *RelayService) Update(descriptor service.ServiceDescriptor) (err error) {
 	defer s.Logger().Println("update result: ", utils.ConditionalPick(err != nil, err, "success"))
 	s.Logger().Println("update with descriptor: ", descriptor.Description)
 	oldDescriptor := s.Describe()
+	if err = s.executor.SetLoadBalancing(descriptor.LoadBalancing); err != nil {
+		return err
+	}
+	if err = s.executor.SetRetry(descriptor.Retry); err != nil {
+		return err
+	}
 	s.update(descriptor)
+	s.executor.SetProviderWeight(s.Provider().Id(), descriptor.LoadBalancing.Weight)
 	if descriptor.Status == service.ServiceStatusStarting {
 		err = s.Start()
 		if err != nil {
@@ -95,5 +348,8 @@ func (s *RelayService) update(descriptor service.ServiceDescriptor) {
 		s.executionType = descriptor.ExecutionType
 		s.cTime = descriptor.CTime
 		s.serviceUris = descriptor.ServiceUris
+		if len(s.providers) > 0 {
+			s.providers[0].descriptor = descriptor
+		}
 	})
 }
@@ -0,0 +1,132 @@
+package service_base
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"whub/common/test_utils"
+	"whub/hub_common/roles"
+	"whub/hub_common/service"
+	"whub/hub_server/context"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/connection_manager"
+	"whub/hub_server/modules/metering"
+	"whub/hub_server/modules/retry_budget"
+	"whub/hub_server/request"
+)
+
+var initTestContextOnce sync.Once
+
+// initTestContext starts the server context with the modules relay services depend on
+func initTestContext(t *testing.T) {
+	initTestContextOnce.Do(func() {
+		context.Ctx.Start(roles.NewServer("server", "test server", "localhost", 0))
+		if err := module_base.Manager.RegisterModules([]module_base.IModule{
+			new(connection_manager.ConnectionManagerModule),
+			new(metering.MeteringModule),
+			new(retry_budget.RetryBudgetModule),
+		}); err != nil {
+			t.Fatal("unable to register modules due to ", err)
+		}
+	})
+}
+
+func newTestDescriptor(providerId string, weight int, coProviders ...string) service.ServiceDescriptor {
+	return service.ServiceDescriptor{
+		Id:            "test",
+		Description:   "relay service of " + providerId,
+		Provider:      roles.RoleDescriptor{Id: providerId},
+		ServiceUris:   []string{"/x"},
+		LoadBalancing: service.LoadBalancingDescriptor{Weight: weight},
+		CoProviders:   coProviders,
+	}
+}
+
+func newTestRelayService(t *testing.T, coProviders ...string) *RelayService {
+	initTestContext(t)
+	descriptor := newTestDescriptor("a", 1, coProviders...)
+	s := new(RelayService)
+	s.Init(descriptor, roles.NewClient("a", "", roles.ClientTypeAnonymous, "", 0), request.NewRelayServiceRequestExecutor(descriptor.Id, descriptor.LoadBalancing, descriptor.Retry))
+	return s
+}
+
+func addTestProvider(s *RelayService, providerId string, weight int) error {
+	return s.AddProvider(roles.NewClient(providerId, "", roles.ClientTypeAnonymous, "", 0), newTestDescriptor(providerId, weight))
+}
+
+func providerStatus(s *RelayService, providerId string) (status ProviderStatus) {
+	for _, status = range s.GetProviderStatuses() {
+		if status.Id == providerId {
+			return
+		}
+	}
+	return ProviderStatus{}
+}
+
+func TestRelayServiceProviders(t *testing.T) {
+	tg := test_utils.NewTestGroup("RelayService providers", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Co-providers", "only co-providers allowed by the primary provider should be added", func() bool {
+			s := newTestRelayService(t, "b")
+			return addTestProvider(s, "b", 1) == nil && addTestProvider(s, "b", 1) != nil && addTestProvider(s, "c", 1) != nil &&
+				s.HasProvider("b") && !s.HasProvider("c")
+		}),
+		test_utils.NewTestCase("Promotion", "the next provider should be promoted once the primary provider is removed", func() bool {
+			s := newTestRelayService(t, "b", "c")
+			if addTestProvider(s, "b", 3) != nil || addTestProvider(s, "c", 1) != nil {
+				return false
+			}
+			promoted, remaining, err := s.RemoveProvider("a")
+			if err != nil || promoted == nil || remaining != 2 {
+				t.Log("unexpected removal result: ", promoted, remaining, err)
+				return false
+			}
+			statuses := s.GetProviderStatuses()
+			return promoted.Provider.Id == "b" && promoted.LoadBalancing.Weight == 3 && s.Provider().Id() == "b" &&
+				statuses[0].Id == "b" && statuses[0].Primary && !statuses[1].Primary && !s.HasProvider("a")
+		}),
+		test_utils.NewTestCase("Remove non-primary", "removing other providers should not promote any provider", func() bool {
+			s := newTestRelayService(t, "b")
+			if addTestProvider(s, "b", 1) != nil {
+				return false
+			}
+			promoted, remaining, err := s.RemoveProvider("b")
+			_, _, errMissing := s.RemoveProvider("b")
+			return err == nil && promoted == nil && remaining == 1 && s.Provider().Id() == "a" && errMissing != nil
+		}),
+		test_utils.NewTestCase("Remove last", "no provider should be promoted once the last provider is removed", func() bool {
+			s := newTestRelayService(t)
+			promoted, remaining, err := s.RemoveProvider("a")
+			return err == nil && promoted == nil && remaining == 0
+		}),
+		test_utils.NewTestCase("Kill provider", "the service should stay alive while other providers are alive", func() bool {
+			s := newTestRelayService(t, "b")
+			if addTestProvider(s, "b", 1) != nil {
+				return false
+			}
+			if s.KillProvider("a") {
+				t.Log("service is dead while provider b is alive")
+				return false
+			}
+			if providerStatus(s, "a").Status != ProviderStatusDead || providerStatus(s, "b").Status != ProviderStatusAlive {
+				return false
+			}
+			if !s.KillProvider("b") {
+				t.Log("service is alive while all providers are dead")
+				return false
+			}
+			s.RestoreProvider("a")
+			return providerStatus(s, "a").Status == ProviderStatusAlive && !s.KillProvider("b")
+		}),
+		test_utils.NewTestCase("Drain provider", "only providers of the service can be drained", func() bool {
+			s := newTestRelayService(t, "b")
+			if addTestProvider(s, "b", 1) != nil {
+				return false
+			}
+			drained, err := s.DrainProvider("b")
+			_, errMissing := s.DrainProvider("c")
+			return err == nil && request.WaitDrained(drained, time.Second) && s.RemoveDrainedConnections("b") == 0 &&
+				providerStatus(s, "a").Status == ProviderStatusAlive && errMissing != nil
+		}),
+	}).Do(t)
+}
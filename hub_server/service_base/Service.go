@@ -11,6 +11,7 @@ import (
 	"whub/hub_common/service"
 	"whub/hub_server/context"
 	"whub/hub_server/module_base"
+	"whub/hub_server/modules/connection_manager"
 	"whub/hub_server/modules/metering"
 )
 
@@ -47,6 +48,9 @@ type IService interface {
 	service.IBaseService
 	Provider() IServiceProvider
 	Kill() error
+	// CancelFrom cancels the request only if it's from the requester, anonymous requests are only cancellable from the
+	// connection they are sent by
+	CancelFrom(messageId string, requester string, addr string) error
 	UriPrefix() string
 	Logger() *logger.SimpleLogger
 }
@@ -192,6 +196,13 @@ func (s *Service) Cancel(messageId string) error {
 	return s.serviceQueue.Cancel(messageId)
 }
 
+func (s *Service) CancelFrom(messageId string, requester string, addr string) error {
+	s.logger.Printf("cancel request %s from %s(%s)", messageId, requester, addr)
+	return s.serviceQueue.CancelIf(messageId, func(request service.IServiceRequest) bool {
+		return request.From() == requester && (requester != "" || request.GetContext(connection_manager.AddrContextKey) == addr)
+	})
+}
+
 func (s *Service) KillAllProcessingJobs() error {
 	s.logger.Println("kill all processing jobs")
 	return s.serviceQueue.KillAll()
@@ -11,6 +11,9 @@ import (
 	"whub/hub_server/services/auth_service"
 	"whub/hub_server/services/client_management"
 	"whub/hub_server/services/messaging"
+	"whub/hub_server/services/presence"
+	"whub/hub_server/services/pubsub"
+	"whub/hub_server/services/response_cache"
 	"whub/hub_server/services/service_management"
 	"whub/hub_server/services/status"
 )
@@ -32,6 +35,9 @@ func instantiateInstances() {
 	serviceInstances[status.ID] = new(status.StatusService)
 	serviceInstances[client_management.ID] = new(client_management.ClientManagementService)
 	serviceInstances[auth_service.ID] = new(auth_service.AuthService)
+	serviceInstances[pubsub.ID] = new(pubsub.PubSubService)
+	serviceInstances[presence.ID] = new(presence.PresenceService)
+	serviceInstances[response_cache.ID] = new(response_cache.ResponseCacheService)
 	cleanUpServiceInstances()
 }
 
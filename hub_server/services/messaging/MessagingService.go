@@ -1,28 +1,50 @@
 package messaging
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"strconv"
+	"time"
+	"whub/common/logger"
+	"whub/hub_common/mailbox"
 	"whub/hub_common/messages"
+	"whub/hub_common/roles"
 	"whub/hub_common/service"
 	"whub/hub_server/client"
+	"whub/hub_server/config"
+	"whub/hub_server/events"
 	"whub/hub_server/module_base"
 	client_manager "whub/hub_server/modules/client_manager"
 	"whub/hub_server/modules/connection_manager"
 	"whub/hub_server/service_base"
 )
 
+// Messages are delivered to recipients as MessageTypeClientNotification with the payload and headers of the request.
+// /send delivers to the client of the X-To header and /multicast to the comma separated clients of it(at most
+// MaxMulticastRecipients ones), only members of a group can send to the group. Responses report the
+// messages.DeliveryResult of each recipient. If mailbox is enabled, messages to offline clients are queued in their
+// mailboxes and flushed in order MailboxFlushDelay after they connect, which gives clients time to set up the
+// connection, and only managers can broadcast as broadcasts would fill every mailbox.
 const (
-	ID             = "message"
-	RouteSend      = "/send"
-	RouteBroadcast = "/broadcast"
+	ID              = "message"
+	RouteSend       = "/send"
+	RouteMulticast  = "/multicast"
+	RouteBroadcast  = "/broadcast"
+	RouteJoinGroup  = "/groups/:group/join"
+	RouteLeaveGroup = "/groups/:group/leave"
+	RouteSendGroup  = "/groups/:group/send"
+	RouteSendType   = "/types/:type/send"
+
+	MailboxFlushDelay      = time.Second
+	MaxMulticastRecipients = 64
 )
 
 type MessagingService struct {
 	*service_base.NativeService
 	client_manager.IClientManagerModule `module:""`
 	connManager                         connection_manager.IConnectionManagerModule `module:""`
+	mailbox                             *mailbox.Mailbox
 	// logger *logger.SimpleLogger
 }
 
@@ -35,13 +57,29 @@ func (s *MessagingService) Init() (err error) {
 	if s.IClientManagerModule == nil {
 		return errors.New("can not get clientManager from container")
 	}
+	if config.Config.Mailbox.Enabled {
+		s.mailbox = mailbox.NewMailbox(createMailboxStore(s.Logger()), mailbox.DefaultPurgeInterval)
+		events.OnEvent(events.EventClientConnectionEstablished, func(message messages.IMessage) {
+			clientId := string(message.Payload())
+			time.AfterFunc(MailboxFlushDelay, func() {
+				s.flushMailbox(clientId)
+			})
+		})
+	}
 	return s.RegisterRoutes(service.NewRequestHandlerMapBuilder().
 		Post(RouteSend, s.Send).
+		Post(RouteMulticast, s.Multicast).
 		Post(RouteBroadcast, s.Broadcast).
+		Post(RouteJoinGroup, s.JoinGroup).
+		Post(RouteLeaveGroup, s.LeaveGroup).
+		Post(RouteSendGroup, s.SendToGroup).
+		Post(RouteSendType, s.SendToType).
 		Build())
 }
 
-func (s *MessagingService) sendToClient(id string, message messages.IMessage, onSendErr func(err error)) error {
+// sendToClient delivers a copy of the message to every connection of the client, the client gets the message if any
+// of its connections accepts it
+func (s *MessagingService) sendToClient(id string, message messages.IMessage) (err error) {
 	conns, err := s.connManager.GetConnectionsByClientId(id)
 	if err != nil {
 		return err
@@ -49,46 +87,221 @@ func (s *MessagingService) sendToClient(id string, message messages.IMessage, on
 	if len(conns) == 0 {
 		return errors.New(fmt.Sprintf("unable to send message to %s because the client is not online", id))
 	}
+	delivered := false
 	for _, conn := range conns {
-		err = conn.Send(message)
-		if err != nil && onSendErr != nil {
-			onSendErr(err)
+		// connections dispose messages once sent
+		if sendErr := conn.Send(message.Copy().SetTo(id).SetMessageType(messages.MessageTypeClientNotification)); sendErr != nil {
+			err = sendErr
+		} else {
+			delivered = true
 		}
 	}
-	return nil
+	if delivered {
+		return nil
+	}
+	return err
 }
 
-func (s *MessagingService) Send(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
-	if _, err = s.GetClientWithErrOnNotFound(request.From()); err != nil {
-		return err
+func createMailboxStore(logger *logger.SimpleLogger) mailbox.IMailboxStore {
+	mailboxConfig := config.Config.Mailbox
+	if mailboxConfig.Dir != "" {
+		logger.Printf("create file mailbox store under %s", mailboxConfig.Dir)
+		store, err := mailbox.NewFileMailboxStore(mailboxConfig.Dir)
+		if err == nil {
+			return store
+		}
+		logger.Printf("create file mailbox store failed due to %s", err.Error())
+	}
+	logger.Println("create in memory mailbox store")
+	return mailbox.NewMemoryMailboxStore()
+}
+
+// mailboxLimit is the limit of the client type of the client, unknown clients do not have mailboxes
+func (s *MessagingService) mailboxLimit(id string) mailbox.Limit {
+	c, err := s.GetClient(id)
+	if err != nil || c == nil {
+		return mailbox.Limit{}
 	}
-	err = s.sendToClient(request.From(), request.Message(), func(cerr error) {
-		err = cerr
+	limit := mailbox.DefaultLimit
+	limitConfig := config.Config.Mailbox.Limits[c.CType()]
+	if limitConfig.MaxDepth != 0 {
+		limit.MaxDepth = limitConfig.MaxDepth
+	}
+	if limitConfig.MaxAge > 0 {
+		limit.MaxAge = time.Duration(limitConfig.MaxAge) * time.Second
+	} else if limitConfig.MaxAge < 0 {
+		limit.MaxAge = 0
+	}
+	return limit
+}
+
+func (s *MessagingService) flushMailbox(clientId string) {
+	sent, err := s.mailbox.Flush(clientId, func(message messages.IMessage) error {
+		return s.sendToClient(clientId, message)
 	})
 	if err != nil {
+		s.Logger().Printf("flushed %d letters to %s, the rest are kept due to %s", sent, clientId, err.Error())
+	} else if sent > 0 {
+		s.Logger().Printf("flushed %d letters to %s", sent, clientId)
+	}
+}
+
+func (s *MessagingService) deliverTo(id string, message messages.IMessage) (result messages.DeliveryResult) {
+	result.ClientId = id
+	var err error
+	if s.mailbox == nil {
+		err = s.sendToClient(id, message)
+	} else {
+		result.Queued, err = s.mailbox.Send(id, message, s.mailboxLimit(id), func(message messages.IMessage) error {
+			return s.sendToClient(id, message)
+		})
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Delivered = !result.Queued
+	}
+	return
+}
+
+// notificationOf drafts the message delivered to recipients from the request, it has its own id so that the copy
+// delivered to the sender is not taken as the response of the request
+func notificationOf(request messages.IMessage) messages.IMessage {
+	notification := messages.DraftMessage(request.From(), request.To(), request.Uri(), messages.MessageTypeClientNotification, request.Payload())
+	for k, v := range request.Headers() {
+		notification.SetHeader(k, v)
+	}
+	return notification
+}
+
+func (s *MessagingService) deliver(request service.IServiceRequest, recipients []string) error {
+	notification := notificationOf(request.Message())
+	results := make([]messages.DeliveryResult, len(recipients), len(recipients))
+	for i, id := range recipients {
+		results[i] = s.deliverTo(id, notification)
+	}
+	s.Logger().Printf("%s delivery results: %v", request.Message().String(), results)
+	marshalled, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
+}
+
+func (s *MessagingService) sender(request service.IServiceRequest) (*client.Client, error) {
+	c, err := s.GetClientWithErrOnNotFound(request.From())
+	if err != nil {
+		return nil, service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	return c, nil
+}
+
+func (s *MessagingService) Send(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if _, err = s.sender(request); err != nil {
 		return err
 	}
-	s.ResolveByAck(request)
-	return nil
+	to := request.Message().GetHeader(messages.MessageHeaderTo)
+	if to == "" {
+		return service.NewBadRequestError(fmt.Sprintf("missing recipient in header %s", messages.MessageHeaderTo))
+	}
+	return s.deliver(request, []string{to})
+}
+
+func (s *MessagingService) Multicast(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if _, err = s.sender(request); err != nil {
+		return err
+	}
+	recipients := messages.ParseRecipients(request.Message().GetHeader(messages.MessageHeaderTo))
+	if len(recipients) == 0 {
+		return service.NewBadRequestError(fmt.Sprintf("missing recipients in header %s", messages.MessageHeaderTo))
+	}
+	if len(recipients) > MaxMulticastRecipients {
+		return service.NewBadRequestError(fmt.Sprintf("too many recipients, at most %d are allowed", MaxMulticastRecipients))
+	}
+	return s.deliver(request, recipients)
 }
 
 func (s *MessagingService) Broadcast(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
-	defer s.Logger().Printf("%s broadcast result: %v", request.Message().String(), err)
-	errMsg := strings.Builder{}
-	s.WithAllClients(func(clients []*client.Client) {
-		for _, c := range clients {
-			err = s.sendToClient(c.Id(), request.Message(), func(cerr error) {
-				err = cerr
-			})
-			if err != nil {
-				errMsg.WriteString(err.Error())
-				errMsg.WriteByte('\n')
-			}
+	if s.mailbox != nil {
+		me, err := s.sender(request)
+		if err != nil {
+			return err
+		}
+		if me.CType() < roles.ClientTypeManager {
+			return service.NewRequestError(messages.MessageTypeSvcForbiddenError, "insufficient privilege")
+		}
+	}
+	var recipients []string
+	err = s.WithAllClients(func(clients []*client.Client) {
+		recipients = make([]string, len(clients), len(clients))
+		for i, c := range clients {
+			recipients[i] = c.Id()
 		}
 	})
-	if errMsg.Len() > 0 {
-		return errors.New(errMsg.String())
+	if err != nil {
+		return err
+	}
+	return s.deliver(request, recipients)
+}
+
+func (s *MessagingService) JoinGroup(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if _, err = s.sender(request); err != nil {
+		return err
+	}
+	if err = s.connManager.JoinGroup(request.From(), pathParams["group"]); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *MessagingService) LeaveGroup(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if _, err = s.sender(request); err != nil {
+		return err
+	}
+	if err = s.connManager.LeaveGroup(request.From(), pathParams["group"]); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *MessagingService) SendToGroup(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if _, err = s.sender(request); err != nil {
+		return err
+	}
+	members, err := s.connManager.GetGroupMembers(pathParams["group"])
+	if err != nil {
+		return err
+	}
+	isMember := false
+	for _, m := range members {
+		isMember = isMember || m == request.From()
+	}
+	if !isMember {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, fmt.Sprintf("%s is not a member of group %s", request.From(), pathParams["group"]))
+	}
+	return s.deliver(request, members)
+}
+
+// SendToType delivers the message to all clients of a roles.ClientType*, only managers can do so
+func (s *MessagingService) SendToType(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	me, err := s.sender(request)
+	if err != nil {
+		return err
+	}
+	if me.CType() < roles.ClientTypeManager {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, "insufficient privilege")
+	}
+	cType, err := strconv.Atoi(pathParams["type"])
+	if err != nil || cType < roles.ClientTypeAnonymous || cType > roles.ClientTypeRoot {
+		return service.NewBadRequestError(fmt.Sprintf("invalid client type %s", pathParams["type"]))
+	}
+	clients, err := s.GetClientsByType(cType)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, len(clients), len(clients))
+	for i, c := range clients {
+		recipients[i] = c.Id()
 	}
-	s.ResolveByAck(request)
-	return nil
+	return s.deliver(request, recipients)
 }
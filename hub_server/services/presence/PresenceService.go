@@ -0,0 +1,217 @@
+package presence
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	base_conn "whub/common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/presence"
+	"whub/hub_common/service"
+	"whub/hub_server/events"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/connection_manager"
+	"whub/hub_server/service_base"
+)
+
+// Presence changes of watched clients are pushed to subscribers as MessageTypeServerNotification messages on
+// ChangeUri with presence.Change payloads. Clients are online once their first connection is established and offline
+// once all their connections are gone, subscriptions of a client are dropped once it goes offline.
+const (
+	ID               = "presence"
+	RouteStatus      = "/status"      // GET with query param ids=a,b,c
+	RouteConnections = "/connections" // GET connections of the requesting client
+	RouteSubscribe   = "/subscribe"   // payload = {"ids": [...]}, responds with the current status of the ids
+	RouteUnsubscribe = "/unsubscribe" // payload = {"ids": [...]}, all subscriptions are dropped if ids is empty
+
+	ChangeUri = service.ServicePrefix + "/" + ID + "/changes"
+)
+
+type PresenceService struct {
+	*service_base.NativeService
+	connManager connection_manager.IConnectionManagerModule `module:""`
+	watchers    *presence.Watchers
+	online      map[string]bool
+	lock        *sync.Mutex
+}
+
+type SubscribePayload struct {
+	Ids []string `json:"ids"`
+}
+
+func (p *SubscribePayload) Validate() error {
+	if len(p.Ids) == 0 {
+		return errors.New("no client id to subscribe")
+	}
+	return nil
+}
+
+type UnsubscribePayload struct {
+	Ids []string `json:"ids"`
+}
+
+func (s *PresenceService) Init() (err error) {
+	s.NativeService = service_base.NewNativeService(ID, "client presence service", service.ServiceTypeInternal, service.ServiceAccessTypeBoth, service.ServiceExecutionSync)
+	err = module_base.Manager.AutoFill(s)
+	if err != nil {
+		return err
+	}
+	if s.connManager == nil {
+		return errors.New("can not get connectionManager from container")
+	}
+	s.watchers = presence.NewWatchers()
+	s.online = make(map[string]bool)
+	s.lock = new(sync.Mutex)
+	events.OnEvent(events.EventClientConnectionEstablished, func(message messages.IMessage) {
+		s.onPresenceChanged(string(message.Payload()), true)
+	})
+	events.OnEvent(events.EventClientConnectionGone, func(message messages.IMessage) {
+		clientId := string(message.Payload())
+		s.onPresenceChanged(clientId, false)
+		s.watchers.UnwatchAll(clientId)
+	})
+	routes := service.NewRequestHandlerMapBuilder().
+		Get(RouteStatus, s.GetStatus).
+		Get(RouteConnections, s.GetConnections).
+		PostTyped(RouteSubscribe, s.Subscribe).
+		PostTyped(RouteUnsubscribe, s.Unsubscribe)
+	if err = routes.Err(); err != nil {
+		return err
+	}
+	return s.RegisterRoutes(routes.Build())
+}
+
+func (s *PresenceService) status(clientId string) presence.Status {
+	conns, err := s.connManager.GetConnectionsByClientId(clientId)
+	if err != nil {
+		conns = nil
+	}
+	return presence.Status{ClientId: clientId, Online: len(conns) > 0, Connections: len(conns)}
+}
+
+// onPresenceChanged notifies subscribers when the client goes online or offline, connections established or closed
+// in between are not changes
+func (s *PresenceService) onPresenceChanged(clientId string, online bool) {
+	changed := false
+	s.lock.Lock()
+	if s.online[clientId] != online {
+		changed = true
+		if online {
+			s.online[clientId] = true
+		} else {
+			delete(s.online, clientId)
+		}
+	}
+	s.lock.Unlock()
+	if !changed {
+		return
+	}
+	change := presence.Change{Status: s.status(clientId), Time: time.Now()}
+	change.Online = online
+	payload, err := json.Marshal(change)
+	if err != nil {
+		s.Logger().Printf("unable to marshal presence change of %s due to %s", clientId, err.Error())
+		return
+	}
+	for _, subscriber := range s.watchers.Subscribers(clientId) {
+		if err = s.notify(subscriber, payload); err != nil {
+			s.Logger().Printf("unable to notify %s of presence change of %s due to %s", subscriber, clientId, err.Error())
+		}
+	}
+}
+
+// notify sends the change to the first connection of the subscriber that accepts it
+func (s *PresenceService) notify(subscriber string, payload []byte) (err error) {
+	conns, err := s.connManager.GetConnectionsByClientId(subscriber)
+	if err != nil {
+		return err
+	}
+	err = errors.New("subscriber is not online")
+	for _, conn := range conns {
+		if err = conn.Send(messages.DraftMessage(s.HostInfo().Id, subscriber, ChangeUri, messages.MessageTypeServerNotification, payload)); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *PresenceService) statuses(clientIds []string) []presence.Status {
+	statuses := make([]presence.Status, len(clientIds), len(clientIds))
+	for i, id := range clientIds {
+		statuses[i] = s.status(id)
+	}
+	return statuses
+}
+
+func (s *PresenceService) resolveByJson(request service.IServiceRequest, data interface{}) error {
+	marshalled, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
+}
+
+func (s *PresenceService) GetStatus(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	ids := make([]string, 0)
+	for _, id := range strings.Split(queryParams["ids"], ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return service.NewBadRequestError("missing query param ids")
+	}
+	return s.resolveByJson(request, s.statuses(ids))
+}
+
+func (s *PresenceService) GetConnections(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	conns, err := s.connManager.GetConnectionsByClientId(request.From())
+	if err != nil {
+		return err
+	}
+	current, _ := request.GetContext(connection_manager.AddrContextKey).(string)
+	infos := make([]presence.ConnectionInfo, 0, len(conns))
+	for _, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		protocol := conn.Protocol()
+		infos = append(infos, presence.ConnectionInfo{
+			Address:     conn.Address(),
+			Type:        base_conn.TypeString(conn.ConnectionType()),
+			Codec:       protocol.Codec,
+			Compression: protocol.Compression,
+			Current:     conn.Address() == current,
+		})
+	}
+	return s.resolveByJson(request, infos)
+}
+
+func (s *PresenceService) Subscribe(ctx gocontext.Context, request service.IServiceRequest, payload *SubscribePayload) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	s.watchers.Watch(request.From(), payload.Ids)
+	return s.resolveByJson(request, s.statuses(payload.Ids))
+}
+
+func (s *PresenceService) Unsubscribe(ctx gocontext.Context, request service.IServiceRequest, payload *UnsubscribePayload) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	if len(payload.Ids) == 0 {
+		s.watchers.UnwatchAll(request.From())
+	} else {
+		s.watchers.Unwatch(request.From(), payload.Ids)
+	}
+	return s.ResolveByAck(request)
+}
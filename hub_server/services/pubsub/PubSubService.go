@@ -0,0 +1,642 @@
+package pubsub
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+	"whub/common/logger"
+	"whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_common/notification"
+	"whub/hub_common/pubsub_v2"
+	"whub/hub_common/pubsub_v2/model"
+	"whub/hub_common/roles"
+	"whub/hub_common/service"
+	"whub/hub_server/config"
+	"whub/hub_server/context"
+	"whub/hub_server/events"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/client_manager"
+	"whub/hub_server/modules/connection_manager"
+	"whub/hub_server/service_base"
+)
+
+// Hierarchical topic ids(e.g. sensors/1/temperature) are escaped to fit in :topic, :topic of subscribe and unsubscribe
+// can be a topic filter like sensors/+/temperature or sensors/#.
+const (
+	ID               = "pubsub"
+	RouteTopics      = "/topics"                // POST to create a topic, GET to list topics
+	RouteTopic       = "/topics/:topic"         // GET to describe a topic, DELETE to delete a topic(creator only)
+	RouteMetrics     = "/topics/:topic/metrics" // GET the backlog of each priority level
+	RoutePublish     = "/topics/:topic/publish" // payload = message payload, query param priority(0-255, higher first)
+	RouteSubscribe   = "/topics/:topic/subscribe"
+	RouteUnsubscribe = "/topics/:topic/unsubscribe"
+	RoutePull        = "/topics/:topic/pull"
+	RouteGroup       = "/topics/:topic/groups/:group"      // DELETE to delete a subscriber group(creator or manager only)
+	RouteSeekGroup   = "/topics/:topic/groups/:group/seek" // payload = {"index": n}, creator or manager only
+	// RouteGroupMembers allows a client to join the group, payload = {"clientId": id}, group owner, creator or manager only
+	RouteGroupMembers = "/topics/:topic/groups/:group/members"
+	// RouteAck acknowledges a message pulled with manualAck, payload = {"group": g, "index": n, "reject": false}
+	RouteAck = "/topics/:topic/ack"
+	// RouteDeadLetters lists dead letters from query param from(1 by default), at most query param max ones, managers
+	// get all dead letters while other clients only get the ones of topics they created
+	RouteDeadLetters = "/dead-letters"
+	// RouteReplay redelivers the message of the dead letter to the group that failed it, creator of the topic or manager only
+	RouteReplay = "/dead-letters/:id/replay"
+
+	DefaultPullSize = 16
+)
+
+type PubSubService struct {
+	*service_base.NativeService
+	clientManager client_manager.IClientManagerModule         `module:""`
+	connManager   connection_manager.IConnectionManagerModule `module:""`
+	controller    pubsub_v2.IPubSubController
+	delivery      pubsub_v2.DeliveryPolicy
+}
+
+type CreateTopicPayload struct {
+	Id string `json:"id"`
+}
+
+func (p *CreateTopicPayload) Validate() error {
+	if p.Id == "" {
+		return errors.New("invalid topic id")
+	}
+	return nil
+}
+
+type SubscribePayload struct {
+	// Group is the subscriber group to join, the subscriber has its own group if not given. Groups are owned by their
+	// creators, other clients can only join once the owner allows them(see RouteGroupMembers). Ids of other clients
+	// are reserved for their own groups.
+	Group string `json:"group"`
+	// Mode is either push or pull, push by default
+	Mode string `json:"mode"`
+}
+
+func (p *SubscribePayload) Validate() error {
+	if p.Mode == "" {
+		p.Mode = model.SubscribeMode(model.SubModePush).String()
+	}
+	_, err := model.ParseSubscribeMode(p.Mode)
+	return err
+}
+
+type UnsubscribePayload struct {
+	Group string `json:"group"`
+}
+
+type PullPayload struct {
+	Group string `json:"group"`
+	Max   int    `json:"max"`
+	// ManualAck keeps pulled messages in flight until they are acknowledged by RouteAck, they are redelivered if not
+	// acknowledged in time(at least once), otherwise they are acknowledged once pulled(at most once)
+	ManualAck bool `json:"manualAck"`
+}
+
+func (p *PullPayload) Validate() error {
+	if p.Max < 0 {
+		return errors.New("invalid max")
+	}
+	if p.Max == 0 {
+		p.Max = DefaultPullSize
+	}
+	return nil
+}
+
+type GroupMemberPayload struct {
+	ClientId string `json:"clientId"`
+}
+
+func (p *GroupMemberPayload) Validate() error {
+	if p.ClientId == "" {
+		return errors.New("invalid client id")
+	}
+	return nil
+}
+
+type AckPayload struct {
+	Group string `json:"group"`
+	Index uint32 `json:"index"`
+	// Reject rejects the message so that it's redelivered after a backoff
+	Reject bool `json:"reject"`
+}
+
+func (p *AckPayload) Validate() error {
+	if p.Index == 0 {
+		return errors.New("invalid index")
+	}
+	return nil
+}
+
+type SeekGroupPayload struct {
+	Index uint32 `json:"index"`
+}
+
+func (p *SeekGroupPayload) Validate() error {
+	if p.Index == 0 {
+		return errors.New("invalid index")
+	}
+	return nil
+}
+
+// createStore fails if the configured persistent store is unavailable, falling back to memory would silently lose
+// messages on restart
+func createStore(logger *logger.SimpleLogger) (pubsub_v2.IPubSubMessageStore, error) {
+	pubSubConfig := config.Config.PubSub
+	mySqlConfig := config.Config.DomainConfigs["pubsub"].Persistent
+	if mySqlConfig.Server != "" {
+		logger.Printf("create mysql pubsub store with mySqlServer %s", mySqlConfig.Server)
+		store := pubsub_v2.NewMySqlPubSubMessageStore()
+		if err := store.Init(mySqlConfig.Server, mySqlConfig.Username, mySqlConfig.Password, mySqlConfig.Db); err != nil {
+			return nil, errors.New(fmt.Sprintf("create mysql pubsub store failed due to %s", err.Error()))
+		}
+		return store, nil
+	}
+	if pubSubConfig.Dir != "" {
+		logger.Printf("create file log pubsub store under %s", pubSubConfig.Dir)
+		store, err := pubsub_v2.NewFileLogPubSubMessageStore(pubSubConfig.Dir, pubSubConfig.SegmentSize)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("create file log pubsub store failed due to %s", err.Error()))
+		}
+		return store, nil
+	}
+	logger.Println("create in memory pubsub store")
+	return pubsub_v2.NewMemoryPubSubMessageStore(), nil
+}
+
+func retentionPolicy() pubsub_v2.RetentionPolicy {
+	pubSubConfig := config.Config.PubSub
+	policy := pubsub_v2.DefaultRetentionPolicy
+	if pubSubConfig.RetentionAge != 0 {
+		policy.MaxAge = time.Duration(pubSubConfig.RetentionAge) * time.Second
+	}
+	if pubSubConfig.RetentionSize != 0 {
+		policy.MaxBytes = pubSubConfig.RetentionSize
+	}
+	// negative values mean no limit, which is what the policy takes them as
+	return policy
+}
+
+func deliveryPolicy() pubsub_v2.DeliveryPolicy {
+	pubSubConfig := config.Config.PubSub
+	policy := pubsub_v2.DefaultDeliveryPolicy
+	if pubSubConfig.AckTimeout > 0 {
+		policy.AckTimeout = time.Duration(pubSubConfig.AckTimeout) * time.Second
+	}
+	if pubSubConfig.MaxDeliveries != 0 {
+		policy.MaxDeliveries = pubSubConfig.MaxDeliveries
+	}
+	if pubSubConfig.DeadLetterTopic != "" {
+		policy.DeadLetterTopic = pubSubConfig.DeadLetterTopic
+	}
+	return policy
+}
+
+func (s *PubSubService) Init() (err error) {
+	s.NativeService = service_base.NewNativeService(ID, "topic based publish/subscribe service", service.ServiceTypeInternal, service.ServiceAccessTypeBoth, service.ServiceExecutionSync)
+	if err = module_base.Manager.AutoFill(s); err != nil {
+		return err
+	}
+	s.delivery = deliveryPolicy()
+	store, err := createStore(s.Logger())
+	if err != nil {
+		return err
+	}
+	if s.controller, err = pubsub_v2.NewPubSubController(store, retentionPolicy(), s.delivery); err != nil {
+		return err
+	}
+	events.OnEvent(events.EventClientConnectionGone, func(message messages.IMessage) {
+		// groups keep their cursors, so the client resumes from where it was once it subscribes again
+		s.controller.UnsubscribeAll(string(message.Payload()))
+	})
+	routes := service.NewRequestHandlerMapBuilder().
+		PostTyped(RouteTopics, s.CreateTopic).
+		Get(RouteTopics, s.GetTopics).
+		Get(RouteTopic, s.GetTopic).
+		Delete(RouteTopic, s.DeleteTopic).
+		Get(RouteMetrics, s.GetMetrics).
+		Post(RoutePublish, s.Publish).
+		PostTyped(RouteSubscribe, s.Subscribe).
+		PostTyped(RouteUnsubscribe, s.Unsubscribe).
+		PostTyped(RoutePull, s.Pull).
+		PostTyped(RouteAck, s.Ack).
+		Delete(RouteGroup, s.DeleteGroup).
+		PostTyped(RouteSeekGroup, s.SeekGroup).
+		PostTyped(RouteGroupMembers, s.AddGroupMember).
+		Get(RouteDeadLetters, s.GetDeadLetters).
+		Post(RouteReplay, s.ReplayDeadLetter)
+	if err = routes.Err(); err != nil {
+		return err
+	}
+	return s.RegisterRoutes(routes.Build())
+}
+
+// unescapeTopic restores hierarchical topic ids that are escaped to fit in one level of the uri
+func unescapeTopic(topic string) string {
+	if unescaped, err := url.PathUnescape(topic); err == nil {
+		return unescaped
+	}
+	return topic
+}
+
+func topicParam(request service.IServiceRequest) string {
+	pathParams, _ := request.GetContext(service.ServiceRequestContextPathParams).(map[string]string)
+	return unescapeTopic(pathParams["topic"])
+}
+
+func (s *PubSubService) resolveByJson(request service.IServiceRequest, data interface{}) error {
+	marshalled, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
+}
+
+func (s *PubSubService) CreateTopic(ctx gocontext.Context, request service.IServiceRequest, payload *CreateTopicPayload) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	topic, err := s.controller.CreateTopic(payload.Id, request.From())
+	if err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.resolveByJson(request, topic.Describe())
+}
+
+func (s *PubSubService) GetTopics(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	topics := s.controller.Topics()
+	descriptors := make([]model.TopicDescriptor, 0, len(topics))
+	for _, topic := range topics {
+		if desc, err := s.controller.DescribeTopic(topic.Id()); err == nil {
+			descriptors = append(descriptors, desc)
+		}
+	}
+	return s.resolveByJson(request, descriptors)
+}
+
+func (s *PubSubService) GetTopic(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	desc, err := s.controller.DescribeTopic(unescapeTopic(pathParams["topic"]))
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	return s.resolveByJson(request, desc)
+}
+
+func (s *PubSubService) GetMetrics(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	metrics, err := s.controller.TopicMetrics(unescapeTopic(pathParams["topic"]))
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	return s.resolveByJson(request, metrics)
+}
+
+func (s *PubSubService) DeleteTopic(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	topic, err := s.controller.GetTopic(unescapeTopic(pathParams["topic"]))
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	if request.From() == "" || request.From() != topic.Creator() {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, "only the creator can delete the topic")
+	}
+	if err = s.controller.DeleteTopic(topic.Id()); err != nil {
+		return err
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *PubSubService) Publish(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	priority := 0
+	if queryParams["priority"] != "" {
+		var err error
+		if priority, err = strconv.Atoi(queryParams["priority"]); err != nil || priority < 0 || priority > 255 {
+			return service.NewBadRequestError(fmt.Sprintf("invalid priority %s", queryParams["priority"]))
+		}
+	}
+	// request message is disposed once the request is handled
+	payload := make([]byte, len(request.Payload()))
+	copy(payload, request.Payload())
+	message, err := s.controller.Publish(unescapeTopic(pathParams["topic"]), request.From(), uint8(priority), payload)
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	return s.ResolveByResponse(request, ([]byte)(strconv.FormatUint(uint64(message.Index()), 10)))
+}
+
+func (s *PubSubService) Subscribe(ctx gocontext.Context, request service.IServiceRequest, payload *SubscribePayload) error {
+	if err := s.CheckCredential(request); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	mode, _ := model.ParseSubscribeMode(payload.Mode)
+	group := payload.Group
+	if group == "" {
+		group = request.From()
+	}
+	if group != request.From() {
+		// ids of clients are reserved for their default groups
+		if c, _ := s.clientManager.GetClient(group); c != nil {
+			return service.NewRequestError(messages.MessageTypeSvcForbiddenError, fmt.Sprintf("group %s is reserved for client %s", group, group))
+		}
+	}
+	topic := topicParam(request)
+	if g := s.getGroup(topic, group); g != nil && !g.Allows(request.From()) {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, fmt.Sprintf("%s is not allowed to join subscriber group %s", request.From(), group))
+	}
+	if err := s.controller.Subscribe(topic, group, s.newConsumer(request, topic, group, mode)); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+// getGroup returns the subscriber group of the topic, nil if topic is a topic filter or the group does not exist
+func (s *PubSubService) getGroup(topicId string, group string) model.ISubscriberGroup {
+	topic, err := s.controller.GetTopic(topicId)
+	if err != nil {
+		return nil
+	}
+	return topic.GetGroup(group)
+}
+
+func (s *PubSubService) Unsubscribe(ctx gocontext.Context, request service.IServiceRequest, payload *UnsubscribePayload) error {
+	if err := s.controller.Unsubscribe(topicParam(request), payload.Group, request.From()); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *PubSubService) Pull(ctx gocontext.Context, request service.IServiceRequest, payload *PullPayload) error {
+	msgs, err := s.controller.Pull(topicParam(request), payload.Group, request.From(), payload.Max, payload.ManualAck)
+	if err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	descriptors := make([]model.PubSubMessageDescriptor, len(msgs))
+	for i, m := range msgs {
+		descriptors[i] = m.Describe()
+	}
+	return s.resolveByJson(request, descriptors)
+}
+
+func (s *PubSubService) Ack(ctx gocontext.Context, request service.IServiceRequest, payload *AckPayload) error {
+	topic, group := topicParam(request), payload.Group
+	if group == "" {
+		group = request.From()
+	}
+	if g := s.getGroup(topic, group); g == nil || !g.HasConsumer(request.From()) {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, fmt.Sprintf("%s is not a consumer of subscriber group %s", request.From(), group))
+	}
+	var err error
+	if payload.Reject {
+		err = s.controller.Nack(topic, group, payload.Index)
+	} else {
+		err = s.controller.Ack(topic, group, payload.Index)
+	}
+	if err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+// checkOperator tells if the requester can manage subscriber groups of the topic
+func (s *PubSubService) checkOperator(request service.IServiceRequest, topicId string) error {
+	topic, err := s.controller.GetTopic(topicId)
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	if request.From() != "" && request.From() == topic.Creator() {
+		return nil
+	}
+	return s.checkManager(request)
+}
+
+func (s *PubSubService) checkManager(request service.IServiceRequest) error {
+	me, err := s.clientManager.GetClient(request.From())
+	if err != nil || me == nil || me.CType() < roles.ClientTypeManager {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, "insufficient privilege")
+	}
+	return nil
+}
+
+func (s *PubSubService) DeleteGroup(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	topic := unescapeTopic(pathParams["topic"])
+	if err := s.checkOperator(request, topic); err != nil {
+		return err
+	}
+	if err := s.controller.DeleteGroup(topic, pathParams["group"]); err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *PubSubService) AddGroupMember(ctx gocontext.Context, request service.IServiceRequest, payload *GroupMemberPayload) error {
+	pathParams, _ := request.GetContext(service.ServiceRequestContextPathParams).(map[string]string)
+	topic := unescapeTopic(pathParams["topic"])
+	g := s.getGroup(topic, pathParams["group"])
+	if g == nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, fmt.Sprintf("subscriber group %s does not exist in topic %s", pathParams["group"], topic))
+	}
+	if request.From() == "" || request.From() != g.Owner() {
+		if err := s.checkOperator(request, topic); err != nil {
+			return err
+		}
+	}
+	if err := s.controller.AddGroupMember(topic, g.Name(), payload.ClientId); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *PubSubService) SeekGroup(ctx gocontext.Context, request service.IServiceRequest, payload *SeekGroupPayload) error {
+	pathParams, _ := request.GetContext(service.ServiceRequestContextPathParams).(map[string]string)
+	topic := unescapeTopic(pathParams["topic"])
+	if err := s.checkOperator(request, topic); err != nil {
+		return err
+	}
+	if err := s.controller.SeekGroup(topic, pathParams["group"], payload.Index); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+// deadLetterFilter returns nil for managers, or the filter of dead letters from topics created by the requester
+func (s *PubSubService) deadLetterFilter(request service.IServiceRequest) (func(*model.DeadLetter) bool, error) {
+	if s.checkManager(request) == nil {
+		return nil, nil
+	}
+	if err := s.CheckCredential(request); err != nil {
+		return nil, service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	return func(deadLetter *model.DeadLetter) bool {
+		topic, err := s.controller.GetTopic(deadLetter.Topic)
+		return err == nil && topic.Creator() == request.From()
+	}, nil
+}
+
+func (s *PubSubService) GetDeadLetters(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	filter, err := s.deadLetterFilter(request)
+	if err != nil {
+		return err
+	}
+	from, max := 1, DefaultPullSize
+	if queryParams["from"] != "" {
+		if from, err = strconv.Atoi(queryParams["from"]); err != nil || from < 0 {
+			return service.NewBadRequestError(fmt.Sprintf("invalid from %s", queryParams["from"]))
+		}
+	}
+	if queryParams["max"] != "" {
+		if max, err = strconv.Atoi(queryParams["max"]); err != nil || max <= 0 {
+			return service.NewBadRequestError(fmt.Sprintf("invalid max %s", queryParams["max"]))
+		}
+	}
+	deadLetters, err := s.controller.DeadLetters(uint32(from), max, filter)
+	if err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.resolveByJson(request, deadLetters)
+}
+
+func (s *PubSubService) ReplayDeadLetter(request service.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	id, err := strconv.ParseUint(pathParams["id"], 10, 32)
+	if err != nil {
+		return service.NewBadRequestError(fmt.Sprintf("invalid dead letter id %s", pathParams["id"]))
+	}
+	deadLetter, err := s.controller.GetDeadLetter(uint32(id))
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcNotFoundError, err.Error())
+	}
+	if err = s.checkOperator(request, deadLetter.Topic); err != nil {
+		return err
+	}
+	if err = s.controller.ReplayDeadLetter(uint32(id)); err != nil {
+		return service.NewBadRequestError(err.Error())
+	}
+	return s.ResolveByAck(request)
+}
+
+func (s *PubSubService) newConsumer(request service.IServiceRequest, topic string, group string, mode model.SubscribeMode) model.IPubSubConsumer {
+	address := ""
+	if isSync, _ := request.GetContext(connection_manager.IsSyncConnContextKey).(bool); !isSync {
+		address, _ = request.GetContext(connection_manager.AddrContextKey).(string)
+	}
+	filter := ""
+	if pubsub_v2.IsTopicFilter(topic) {
+		filter = topic
+	}
+	return &pubSubConsumer{
+		clientId:    request.From(),
+		address:     address,
+		filter:      filter,
+		group:       group,
+		mode:        mode,
+		hostId:      context.Ctx.Server().Id(),
+		uriPrefix:   s.UriPrefix(),
+		ackTimeout:  s.delivery.MaxAckTimeout(),
+		connManager: s.connManager,
+		controller:  s.controller,
+	}
+}
+
+// pubSubConsumer pushes messages to a connection of the client, pull consumers never consume by themselves. Clients
+// acknowledge pushed messages by MessageTypeACK with the id of the pushed message, or reject them by an error message.
+type pubSubConsumer struct {
+	clientId string
+	// address of the connection that subscribed, it is preferred as the client is surely listening on it
+	address string
+	// filter is the topic filter subscribed, empty if a topic is subscribed
+	filter      string
+	group       string
+	mode        model.SubscribeMode
+	hostId      string
+	uriPrefix   string
+	ackTimeout  time.Duration // how long pushed messages wait for acknowledgements, they are redelivered by then
+	connManager connection_manager.IConnectionManagerModule
+	controller  pubsub_v2.IPubSubController
+}
+
+func (c *pubSubConsumer) Id() string {
+	return c.clientId
+}
+
+func (c *pubSubConsumer) Mode() model.SubscribeMode {
+	return c.mode
+}
+
+func (c *pubSubConsumer) ManualAck() bool {
+	return c.mode == model.SubModePush
+}
+
+// onAck listens to the acknowledgement of the pushed message, the listener is removed once the message is
+// acknowledged or it can not be acknowledged anymore
+func (c *pubSubConsumer) onAck(conn connection.IConnection, id string, topic string, index uint32) (notification.Disposable, error) {
+	var (
+		lock  sync.Mutex
+		timer *time.Timer
+	)
+	stopTimer := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	dispose, err := conn.OnceMessage(id, func(resp messages.IMessage) {
+		stopTimer()
+		if resp.MessageType() == messages.MessageTypeACK {
+			c.controller.Ack(topic, c.group, index)
+		} else if resp.IsErrorMessage() {
+			c.controller.Nack(topic, c.group, index)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	timer = time.AfterFunc(c.ackTimeout, dispose)
+	lock.Unlock()
+	return func() {
+		stopTimer()
+		dispose()
+	}, nil
+}
+
+func (c *pubSubConsumer) Consume(message model.IPubSubMessage) error {
+	if c.mode != model.SubModePush {
+		return errors.New(fmt.Sprintf("pull consumer %s can not consume pushed messages", c.clientId))
+	}
+	conns, err := c.connManager.GetConnectionsByClientId(c.clientId)
+	if err != nil {
+		return err
+	}
+	if conn, err := c.connManager.GetConnectionByAddress(c.address); c.address != "" && err == nil {
+		conns = append([]connection.IConnection{conn}, conns...)
+	}
+	for _, conn := range conns {
+		if !conn.IsLive() {
+			continue
+		}
+		msg := messages.DraftMessage(c.hostId, c.clientId, fmt.Sprintf("%s/topics/%s", c.uriPrefix, message.TopicId()), messages.MessageTypeServerServiceNotification, message.Payload())
+		msg.SetHeader(pubsub_v2.MessageHeaderTopic, message.TopicId())
+		msg.SetHeader(pubsub_v2.MessageHeaderTopicIndex, strconv.FormatUint(uint64(message.Index()), 10))
+		msg.SetHeader(pubsub_v2.MessageHeaderSubscriberGroup, c.group)
+		if c.filter != "" {
+			msg.SetHeader(pubsub_v2.MessageHeaderTopicFilter, c.filter)
+		}
+		dispose, err := c.onAck(conn, msg.Id(), message.TopicId(), message.Index())
+		if err != nil {
+			return err
+		}
+		if err = conn.Send(msg); err == nil {
+			return nil
+		}
+		dispose()
+	}
+	return errors.New(fmt.Sprintf("unable to push message to %s because the client is not online", c.clientId))
+}
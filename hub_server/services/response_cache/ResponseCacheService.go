@@ -0,0 +1,68 @@
+package response_cache
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"whub/hub_common/messages"
+	"whub/hub_common/roles"
+	"whub/hub_common/service"
+	"whub/hub_server/module_base"
+	"whub/hub_server/modules/client_manager"
+	"whub/hub_server/modules/response_cache"
+	"whub/hub_server/service_base"
+)
+
+const (
+	ID         = "cache"
+	RoutePurge = "/purge" // payload = {"prefix": "/files"}, all cached responses are purged if prefix is empty
+)
+
+// ResponseCacheService lets managers purge cached service responses
+type ResponseCacheService struct {
+	*service_base.NativeService
+	clientManager client_manager.IClientManagerModule `module:""`
+	responseCache response_cache.IResponseCacheModule `module:""`
+}
+
+type PurgePayload struct {
+	Prefix string `json:"prefix"`
+}
+
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+func (s *ResponseCacheService) Init() (err error) {
+	s.NativeService = service_base.NewNativeService(ID, "response cache administration", service.ServiceTypeInternal, service.ServiceAccessTypeBoth, service.ServiceExecutionSync)
+	err = module_base.Manager.AutoFill(s)
+	if err != nil {
+		return err
+	}
+	if s.clientManager == nil {
+		return errors.New("can not get clientManager from container")
+	}
+	if s.responseCache == nil {
+		return errors.New("can not get responseCache from container")
+	}
+	routes := service.NewRequestHandlerMapBuilder().PostTyped(RoutePurge, s.Purge)
+	if err = routes.Err(); err != nil {
+		return err
+	}
+	return s.RegisterRoutes(routes.Build())
+}
+
+func (s *ResponseCacheService) Purge(ctx gocontext.Context, request service.IServiceRequest, payload *PurgePayload) error {
+	c, err := s.clientManager.GetClientWithErrOnNotFound(request.From())
+	if err != nil {
+		return service.NewRequestError(messages.MessageTypeSvcUnauthorizedError, err.Error())
+	}
+	if c.CType() < roles.ClientTypeManager {
+		return service.NewRequestError(messages.MessageTypeSvcForbiddenError, "insufficient privilege")
+	}
+	marshalled, err := json.Marshal(PurgeResult{s.responseCache.Purge(payload.Prefix)})
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
+}
@@ -4,14 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 	"whub/common/utils"
 	"whub/hub_common/connection"
 	"whub/hub_common/messages"
 	"whub/hub_common/roles"
 	service_common "whub/hub_common/service"
 	"whub/hub_server/client"
+	"whub/hub_server/config"
 	servererror "whub/hub_server/errors"
 	"whub/hub_server/events"
 	"whub/hub_server/module_base"
@@ -27,12 +28,18 @@ const (
 	ID                                 = "services"
 	RouteRegisterService               = "/register"   // payload = service descriptor
 	RouteUnregisterService             = "/unregister" // payload = service descriptor
+	RouteDrainServiceProvider          = "/drain"      // payload = service descriptor, respond once the provider is removed
 	RouteUpdateService                 = "/update"     // payload = service descriptor
 	RouteGetAllServices                = "/services"   // need privilege, respond with all relayed services
 	RouteGetServicesByClientId         = "/clients/:clientId"
 	RouteUpdateProviderConnection      = "/providers" // need privilege, need to check if client has service
 	RouteGetServiceProviderConnections = "/:id/providers"
+	RouteGetServiceProviders           = "/:id/clients"
 	RouteGetServiceById                = "/:id"
+
+	DefaultDrainTimeout = time.Second * 20
+	// MaxDrainTimeout keeps drain requests answered before they time out on clients
+	MaxDrainTimeout = connection.DefaultTimeout - time.Second*5
 )
 
 type ServiceManagementService struct {
@@ -70,6 +77,10 @@ func (s *ServiceManagementService) initNotificationHandlers() {
 		clientId := string(message.Payload()[:])
 		s.serviceManager.WithServicesFromClientId(clientId, func(services []service_base.IService) {
 			for _, svc := range services {
+				// the service is still served by other providers alive
+				if relayService, ok := svc.(service_base.IRelayService); ok && !relayService.KillProvider(clientId) {
+					continue
+				}
 				svc.Kill()
 			}
 		})
@@ -85,11 +96,13 @@ func (s *ServiceManagementService) initRoutes() error {
 		Get(RouteGetServiceById, s.GetServiceById).
 		Post(RouteRegisterService, s.RegisterService).
 		Delete(RouteUnregisterService, s.UnregisterService).
+		Post(RouteDrainServiceProvider, s.DrainServiceProvider).
 		Put(RouteUpdateService, s.UpdateService).
 		Get(RouteGetAllServices, s.GetAllRelayServices).
 		Get(RouteGetServicesByClientId, s.GetServiceByClientId).
 		Patch(RouteUpdateProviderConnection, s.UpdateServiceProviderConnection).
-		Get(RouteGetServiceProviderConnections, s.GetServiceProviderConnections).Build())
+		Get(RouteGetServiceProviderConnections, s.GetServiceProviderConnections).
+		Get(RouteGetServiceProviders, s.GetServiceProviders).Build())
 }
 
 func (s *ServiceManagementService) validateClientConnection(request service_common.IServiceRequest) error {
@@ -134,15 +147,21 @@ func (s *ServiceManagementService) RegisterService(request service_common.IServi
 	if err != nil {
 		return err
 	}
+	if err = descriptor.LoadBalancing.Validate(); err != nil {
+		return service_common.NewBadRequestError(err.Error())
+	}
+	if err = descriptor.Retry.Validate(); err != nil {
+		return service_common.NewBadRequestError(err.Error())
+	}
+	if descriptor.Provider.Id != request.From() {
+		return s.ResolveByError(request, messages.MessageTypeSvcForbiddenError, fmt.Sprintf("descriptor provider id(%s) does not match client id(%s)", descriptor.Provider.Id, request.From()))
+	}
 	client, err := s.clientManager.GetClientWithErrOnNotFound(descriptor.Provider.Id)
 	if err != nil {
 		return err
 	}
-	if s.serviceManager.HasService(descriptor.Id) {
-		// service already running, notify service executor to add extra connection
-		events.EmitEvent(events.EventServiceNewProvider, descriptor.Id)
-		s.ResolveByAck(request)
-		return nil
+	if existing := s.serviceManager.GetService(descriptor.Id); existing != nil {
+		return s.addServiceProvider(request, existing, client, descriptor)
 	}
 	service := s.createRelayService(client, descriptor)
 	err = s.serviceManager.RegisterService(descriptor.Id, service)
@@ -154,9 +173,32 @@ func (s *ServiceManagementService) RegisterService(request service_common.IServi
 	return nil
 }
 
+// addServiceProvider adds the client as another provider of the existing service
+func (s *ServiceManagementService) addServiceProvider(request service_common.IServiceRequest, existing service_base.IService, client *client.Client, descriptor service_common.ServiceDescriptor) error {
+	relayService, ok := existing.(service_base.IRelayService)
+	if !ok {
+		return s.ResolveByError(request, messages.MessageTypeSvcConflictError, fmt.Sprintf("service id %s is reserved", descriptor.Id))
+	}
+	if relayService.HasProvider(client.Id()) {
+		// service already running, notify service executor to add extra connection
+		events.EmitEvent(events.EventServiceNewProvider, client.Id())
+		return s.ResolveByAck(request)
+	}
+	if !relayService.AllowsProvider(client.Id()) {
+		return s.ResolveByError(request, messages.MessageTypeSvcConflictError, fmt.Sprintf("service id %s is taken by another client", descriptor.Id))
+	}
+	if len(s.serviceManager.GetServicesByClientId(client.Id())) >= service_base.MaxServicePerClient {
+		return servererror.NewClientExceededMaxServiceCountError(client.Id(), service_base.MaxServicePerClient)
+	}
+	if err := relayService.AddProvider(client, descriptor); err != nil {
+		return err
+	}
+	return s.ResolveByAck(request)
+}
+
 func (s *ServiceManagementService) createRelayService(provider *client.Client, descriptor service_common.ServiceDescriptor) service_base.IService {
 	service := s.servicePool.Get().(service_base.IRelayService)
-	service.Init(descriptor, provider, request_executor.NewRelayServiceRequestExecutor(descriptor.Id, provider.Id()))
+	service.Init(descriptor, provider, request_executor.NewRelayServiceRequestExecutor(descriptor.Id, descriptor.LoadBalancing, descriptor.Retry))
 	return service
 }
 
@@ -179,19 +221,87 @@ func (s *ServiceManagementService) UnregisterService(request service_common.ISer
 	if service == nil {
 		return servererror.NewNoSuchServiceError(descriptor.Id)
 	}
-	if service.Provider().Id() != request.From() {
-		return errors.New(fmt.Sprintf("actual service provider id(%s) does not match client id(%s)", service.Provider().Id(), request.From()))
+	if relayService, ok := service.(service_base.IRelayService); !ok || !relayService.HasProvider(request.From()) {
+		return errors.New(fmt.Sprintf("client %s is not a provider of service %s", request.From(), descriptor.Id))
 	}
-	err = s.serviceManager.UnregisterService(descriptor.Id)
+	unregistered, err := s.serviceManager.RemoveServiceProvider(descriptor.Id, request.From())
 	if err != nil {
 		return err
 	}
-	// free service
-	s.servicePool.Put(service)
+	if unregistered {
+		// free service
+		s.servicePool.Put(service)
+	}
 	s.ResolveByAck(request)
 	return nil
 }
 
+// DrainServiceProvider stops routing new requests to the provider and removes it once its in-flight requests are done
+// or the drain timeout is reached. Providers registered during the drain take over the service without interruption.
+func (s *ServiceManagementService) DrainServiceProvider(request service_common.IServiceRequest, pathParams map[string]string, queryParams map[string]string) (err error) {
+	if err = s.validateClientConnection(request); err != nil {
+		return err
+	}
+	descriptor, err := server_utils.ParseServiceDescriptor(request.Payload())
+	if err != nil {
+		return err
+	}
+	service := s.serviceManager.GetService(descriptor.Id)
+	if service == nil {
+		return servererror.NewNoSuchServiceError(descriptor.Id)
+	}
+	relayService, ok := service.(service_base.IRelayService)
+	if !ok || !relayService.HasProvider(request.From()) {
+		return s.ResolveByError(request, messages.MessageTypeSvcForbiddenError, fmt.Sprintf("client %s is not a provider of service %s", request.From(), descriptor.Id))
+	}
+	drained, err := relayService.DrainProvider(request.From())
+	if err != nil {
+		return err
+	}
+	timeout := DefaultDrainTimeout
+	if drainTimeout := config.Config.Drain.Timeout; drainTimeout > 0 {
+		timeout = time.Duration(drainTimeout) * time.Second
+	}
+	if timeout > MaxDrainTimeout {
+		s.Logger().Printf("drain timeout %s exceeds the max drain timeout, will use %s", timeout, MaxDrainTimeout)
+		timeout = MaxDrainTimeout
+	}
+	// the request is resolved once the provider is removed
+	go s.removeDrainedProvider(request, relayService, drained, timeout)
+	return nil
+}
+
+func (s *ServiceManagementService) removeDrainedProvider(request service_common.IServiceRequest, service service_base.IRelayService, drained <-chan struct{}, timeout time.Duration) {
+	providerId := request.From()
+	if request_executor.WaitDrained(drained, timeout) {
+		s.Logger().Printf("provider %s of service %s is drained", providerId, service.Id())
+	} else {
+		s.Logger().Printf("drain of provider %s of service %s timed out", providerId, service.Id())
+	}
+	if s.serviceManager.GetService(service.Id()) != service || !service.HasProvider(providerId) {
+		// provider has been unregistered during the drain
+		s.ResolveByAck(request)
+		return
+	}
+	if remaining := service.RemoveDrainedConnections(providerId); remaining > 0 {
+		// provider has re-registered during the drain, only its drained connections are removed
+		s.Logger().Printf("provider %s of service %s keeps %d connections added during the drain", providerId, service.Id(), remaining)
+		s.ResolveByAck(request)
+		return
+	}
+	unregistered, err := s.serviceManager.RemoveServiceProvider(service.Id(), providerId)
+	if err != nil {
+		s.Logger().Printf("remove drained provider %s of service %s failed due to %s", providerId, service.Id(), err.Error())
+		request.Resolve(messages.NewInternalErrorMessage(request.Id(), s.HostInfo().Id, request.From(), request.Uri(), servererror.NewJsonMessageError(err.Error())))
+		return
+	}
+	if unregistered {
+		// free service
+		s.servicePool.Put(service)
+	}
+	s.ResolveByAck(request)
+}
+
 func (s *ServiceManagementService) UpdateService(request service_common.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
 	if err := s.validateClientConnection(request); err != nil {
 		return s.ResolveByInvalidCredential(request)
@@ -200,6 +310,12 @@ func (s *ServiceManagementService) UpdateService(request service_common.IService
 	if err != nil {
 		return err
 	}
+	if err = descriptor.LoadBalancing.Validate(); err != nil {
+		return service_common.NewBadRequestError(err.Error())
+	}
+	if err = descriptor.Retry.Validate(); err != nil {
+		return service_common.NewBadRequestError(err.Error())
+	}
 	if descriptor.Provider.Id != request.From() {
 		return errors.New(fmt.Sprintf("descriptor provider id(%s) does not match client id(%s)", descriptor.Provider.Id, request.From()))
 	}
@@ -261,10 +377,11 @@ func (s *ServiceManagementService) UpdateServiceProviderConnection(request servi
 	if service == nil {
 		return s.ResolveByError(request, messages.MessageTypeSvcNotFoundError, fmt.Sprintf("can not find service by id %s", descriptor.Id))
 	}
-	if service.Provider().Id() != request.From() {
-		return s.ResolveByError(request, messages.MessageTypeSvcForbiddenError, fmt.Sprintf("client %s is not the provider for service %s", request.From(), service.Provider().Id()))
+	relayService, ok := service.(service_base.IRelayService)
+	if !ok || !relayService.HasProvider(request.From()) {
+		return s.ResolveByError(request, messages.MessageTypeSvcForbiddenError, fmt.Sprintf("client %s is not a provider of service %s", request.From(), descriptor.Id))
 	}
-	err = service.(service_base.IRelayService).UpdateProviderConnection(addr.(string))
+	err = relayService.UpdateProviderConnection(request.From(), addr.(string), descriptor.LoadBalancing.Weight)
 	if err != nil {
 		return err
 	}
@@ -281,10 +398,15 @@ func (s *ServiceManagementService) tryToRestoreDeadServicesFromReconnectedClient
 			return
 		}
 		for i := range services {
-			if services[i] != nil {
-				if err = services[i].(service_base.IRelayService).RestoreExternally(client); err != nil {
-					return
-				}
+			if services[i] == nil {
+				continue
+			}
+			relayService := services[i].(service_base.IRelayService)
+			if relayService.Status() != service_common.ServiceStatusDead {
+				// the service is alive with other providers
+				relayService.RestoreProvider(clientId)
+			} else if err = relayService.RestoreExternally(client); err != nil {
+				return
 			}
 		}
 	})
@@ -310,23 +432,41 @@ func (s *ServiceManagementService) GetServiceProviderConnections(request service
 	if err != nil {
 		return err
 	}
-	if svc.Provider().Id() != request.From() && me.CType() < roles.ClientTypeManager {
+	if !svc.(service_base.IRelayService).HasProvider(request.From()) && me.CType() < roles.ClientTypeManager {
 		return s.ResolveByInvalidCredential(request)
 	}
-	conns := svc.(service_base.IRelayService).GetProviderConnections()
-	return s.ResolveByResponse(request, ([]byte)(s.assembleConnJsonArr(conns)))
+	statuses := svc.(service_base.IRelayService).GetProviderConnectionStatuses()
+	marshalled, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
 }
 
-func (s *ServiceManagementService) assembleConnJsonArr(conns []connection.IConnection) string {
-	var builder strings.Builder
-	builder.WriteByte('[')
-	for i, conn := range conns {
-		if i == len(conns)-1 {
-			builder.WriteString(fmt.Sprintf("%s", conn.String()))
-		} else {
-			builder.WriteString(fmt.Sprintf("%s,", conn.String()))
-		}
+// GetServiceProviders lists provider clients of the relay service with their status, weights and connection counts
+func (s *ServiceManagementService) GetServiceProviders(request service_common.IServiceRequest, pathParams map[string]string, queryParams map[string]string) error {
+	if request.From() == "" {
+		return s.ResolveByInvalidCredential(request)
+	}
+	serviceId := pathParams["id"]
+	svc := s.serviceManager.GetService(serviceId)
+	if svc == nil {
+		return s.ResolveByError(request, messages.MessageTypeSvcNotFoundError, fmt.Sprintf("can not find service by id [%s]", serviceId))
 	}
-	builder.WriteByte(']')
-	return builder.String()
+	relayService, ok := svc.(service_base.IRelayService)
+	if !ok {
+		return s.ResolveByError(request, messages.MessageTypeSvcBadRequestError, fmt.Sprintf("service [%s] is not a relay service", serviceId))
+	}
+	me, err := s.clientManager.GetClient(request.From())
+	if err != nil {
+		return err
+	}
+	if !relayService.HasProvider(request.From()) && me.CType() < roles.ClientTypeManager {
+		return s.ResolveByInvalidCredential(request)
+	}
+	marshalled, err := json.Marshal(relayService.GetProviderStatuses())
+	if err != nil {
+		return err
+	}
+	return s.ResolveByResponse(request, marshalled)
 }
@@ -0,0 +1,218 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"whub/common/connection"
+	"whub/common/logger"
+	common_connection "whub/hub_common/connection"
+	"whub/hub_common/messages"
+	"whub/hub_server/config"
+	server_http "whub/hub_server/http"
+	"whub/tcp"
+	"whub/unix"
+	"whub/websocket/wserver"
+)
+
+// StreamAuthTimeout is the max duration for a tcp/unix connection to send its auth token as the first frame
+const StreamAuthTimeout = time.Second * 10
+
+// IListener accepts socket connections of one transport and hands them over with the token they authenticate with
+type IListener interface {
+	Type() string
+	Address() string
+	// Start blocks until the listener is stopped or fails
+	Start() error
+	Stop() error
+	OnConnectionEstablished(func(conn connection.IConnection, token string))
+}
+
+type WSListener struct {
+	*wserver.WServer
+	address   string
+	onConnect func(conn connection.IConnection, token string)
+}
+
+// NewWSListener creates a websocket listener, non-upgradable requests are handled by httpHandler
+func NewWSListener(name string, address string, port int, path string, logger *logger.SimpleLogger, httpHandler func(w http.ResponseWriter, r *http.Request)) *WSListener {
+	if path == "" {
+		path = common_connection.WSConnectionPath
+	}
+	wServer := wserver.NewWServer(wserver.NewServerConfig(name, address, port, path, wserver.DefaultWsConnHandler()))
+	wServer.SetLogger(logger)
+	wServer.SetSubprotocols(messages.MessageParserNames())
+	l := &WSListener{WServer: wServer, address: fmt.Sprintf("ws://%s:%d%s", address, port, path)}
+	wServer.OnClientConnected(l.handleConnection)
+	wServer.OnNonUpgradableRequest(httpHandler)
+	wServer.SetBeforeUpgradeChecker(server_http.NewWebsocketUpgradeChecker().ShouldUpgradeProtocol)
+	return l
+}
+
+func (l *WSListener) handleConnection(conn connection.IConnection, r *http.Request) {
+	if l.onConnect != nil {
+		l.onConnect(conn, server_http.GetTokenFromQueryParameters(r))
+	}
+}
+
+func (l *WSListener) Type() string {
+	return config.ListenerTypeWS
+}
+
+func (l *WSListener) Address() string {
+	return l.address
+}
+
+func (l *WSListener) OnConnectionEstablished(cb func(conn connection.IConnection, token string)) {
+	l.onConnect = cb
+}
+
+type deadlineReader interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// readAuthToken reads the first frame of a stream connection as its auth token
+func readAuthToken(conn connection.IConnection) (string, error) {
+	dr, ok := conn.(deadlineReader)
+	if !ok {
+		return "", errors.New("connection does not support read deadline")
+	}
+	if err := dr.SetReadDeadline(time.Now().Add(StreamAuthTimeout)); err != nil {
+		return "", err
+	}
+	token, err := conn.Read()
+	if err != nil {
+		return "", err
+	}
+	if err = dr.SetReadDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+	return (string)(token), nil
+}
+
+type TCPListener struct {
+	*tcp.TCPServer
+	logger    *logger.SimpleLogger
+	onConnect func(conn connection.IConnection, token string)
+}
+
+func NewTCPListener(name string, address string, port int, codec *tcp.FrameCodec, logger *logger.SimpleLogger) *TCPListener {
+	server := tcp.NewTCPServer(name, address, port)
+	server.SetLogger(logger)
+	server.SetFrameCodec(codec)
+	l := &TCPListener{TCPServer: server, logger: logger}
+	server.OnClientConnected(l.handleConnection)
+	return l
+}
+
+func (l *TCPListener) handleConnection(conn connection.IConnection) {
+	token, err := readAuthToken(conn)
+	if err != nil {
+		l.logger.Printf("unable to read auth token from %s due to %s", conn.Address(), err.Error())
+		conn.Close()
+		return
+	}
+	if l.onConnect != nil {
+		l.onConnect(conn, token)
+	}
+}
+
+func (l *TCPListener) Type() string {
+	return config.ListenerTypeTCP
+}
+
+func (l *TCPListener) Address() string {
+	return "tcp://" + l.TCPServer.Address()
+}
+
+func (l *TCPListener) OnConnectionEstablished(cb func(conn connection.IConnection, token string)) {
+	l.onConnect = cb
+}
+
+type UnixListener struct {
+	*unix.UnixServer
+	logger    *logger.SimpleLogger
+	onConnect func(conn connection.IConnection, token string)
+}
+
+func NewUnixListener(name string, path string, fileMode os.FileMode, codec *tcp.FrameCodec, logger *logger.SimpleLogger) *UnixListener {
+	server := unix.NewUnixServer(name, path, fileMode)
+	server.SetLogger(logger)
+	server.SetFrameCodec(codec)
+	l := &UnixListener{UnixServer: server, logger: logger}
+	server.OnClientConnected(l.handleConnection)
+	return l
+}
+
+func (l *UnixListener) handleConnection(conn connection.IConnection) {
+	token, err := readAuthToken(conn)
+	if err != nil {
+		l.logger.Printf("unable to read auth token from %s due to %s", conn.Address(), err.Error())
+		conn.Close()
+		return
+	}
+	if l.onConnect != nil {
+		l.onConnect(conn, token)
+	}
+}
+
+func (l *UnixListener) Type() string {
+	return config.ListenerTypeUnix
+}
+
+func (l *UnixListener) Address() string {
+	return "unix://" + l.UnixServer.Address()
+}
+
+func (l *UnixListener) OnConnectionEstablished(cb func(conn connection.IConnection, token string)) {
+	l.onConnect = cb
+}
+
+// NewListener creates a listener by listener config, requests to ws listeners that are not upgradable are handled by
+// httpHandler
+func NewListener(name string, listenerConfig config.ListenerConfig, logger *logger.SimpleLogger, httpHandler func(w http.ResponseWriter, r *http.Request)) (IListener, error) {
+	switch listenerConfig.Type {
+	case config.ListenerTypeWS:
+		return NewWSListener(name, listenerConfig.Address, listenerConfig.Port, listenerConfig.Path, logger, httpHandler), nil
+	case config.ListenerTypeTCP:
+		codec, err := newFrameCodec(listenerConfig)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPListener(name, listenerConfig.Address, listenerConfig.Port, codec, logger), nil
+	case config.ListenerTypeUnix:
+		codec, err := newFrameCodec(listenerConfig)
+		if err != nil {
+			return nil, err
+		}
+		fileMode, err := parseFileMode(listenerConfig.Permission)
+		if err != nil {
+			return nil, err
+		}
+		return NewUnixListener(name, listenerConfig.Path, fileMode, codec, logger), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported listener type %s", listenerConfig.Type))
+	}
+}
+
+func newFrameCodec(listenerConfig config.ListenerConfig) (*tcp.FrameCodec, error) {
+	maxFrameSize := listenerConfig.MaxFrameSize
+	if maxFrameSize == 0 {
+		maxFrameSize = tcp.DefaultMaxFrameSize
+	}
+	return tcp.NewFrameCodec(maxFrameSize, listenerConfig.Checksum)
+}
+
+func parseFileMode(permission string) (os.FileMode, error) {
+	if permission == "" {
+		return unix.DefaultSocketFileMode, nil
+	}
+	mode, err := strconv.ParseUint(permission, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New(fmt.Sprintf("invalid socket file permission %s", permission))
+	}
+	return os.FileMode(mode), nil
+}
@@ -2,7 +2,6 @@ package socket
 
 import (
 	"fmt"
-	"net/http"
 	"sync"
 	"whub/common/connection"
 	"whub/common/logger"
@@ -10,10 +9,10 @@ import (
 	"whub/hub_common/dispatcher"
 	"whub/hub_common/messages"
 	"whub/hub_server/context"
-	upgrader_util "whub/hub_server/http"
 	"whub/hub_server/module_base"
 	"whub/hub_server/modules/auth"
 	"whub/hub_server/modules/connection_manager"
+	ws_connection "whub/websocket/connection"
 )
 
 type SocketConnectionHandler struct {
@@ -25,7 +24,7 @@ type SocketConnectionHandler struct {
 }
 
 type ISocketConnectionHandler interface {
-	HandleConnectionEstablished(conn connection.IConnection, r *http.Request)
+	HandleConnectionEstablished(conn connection.IConnection, token string)
 }
 
 func NewSocketConnectionHandler(messageDispatcher dispatcher.IMessageDispatcher) ISocketConnectionHandler {
@@ -43,14 +42,15 @@ func NewSocketConnectionHandler(messageDispatcher dispatcher.IMessageDispatcher)
 	return h
 }
 
-func (h *SocketConnectionHandler) HandleConnectionEstablished(conn connection.IConnection, r *http.Request) {
+// HandleConnectionEstablished handles connections from all listeners, token is what the connection authenticates with
+func (h *SocketConnectionHandler) HandleConnectionEstablished(conn connection.IConnection, token string) {
 	loggerPrefix := fmt.Sprintf("[conn-%s]", conn.Address())
 	wrappedConn := h.connPool.Get().(*common_connection.Connection)
 	wrappedConn.Init(
 		context.Ctx.Logger().WithPrefix(loggerPrefix),
 		conn,
 		common_connection.DefaultTimeout,
-		context.Ctx.MessageParser(),
+		h.selectMessageParser(conn),
 		context.Ctx.NotificationEmitter())
 	h.logger.Printf("new connection %s received", wrappedConn.Address())
 	// any message from any connection needs to go through here
@@ -59,7 +59,7 @@ func (h *SocketConnectionHandler) HandleConnectionEstablished(conn connection.IC
 	})
 	h.connectionManager.AddConnection(wrappedConn)
 	// should authorize the connection(register the connection to active client connection) when authorized
-	clientId, err := h.authController.ValidateToken(upgrader_util.GetTokenFromQueryParameters(r))
+	clientId, err := h.authController.ValidateToken(token)
 	if err != nil {
 		h.logger.Printf("unauthorized connection from %s", conn.Address())
 		conn.Close()
@@ -77,3 +77,17 @@ func (h *SocketConnectionHandler) HandleConnectionEstablished(conn connection.IC
 	h.logger.Printf("connection %s cycle done", conn.Address())
 	h.connPool.Put(wrappedConn)
 }
+
+// selectMessageParser picks the codec negotiated by websocket sub-protocol, falls back to the default parser
+func (h *SocketConnectionHandler) selectMessageParser(conn connection.IConnection) messages.IMessageParser {
+	wsConn, ok := conn.(ws_connection.IWsConnection)
+	if !ok || wsConn.Subprotocol() == "" {
+		return context.Ctx.MessageParser()
+	}
+	parser := messages.GetMessageParser(wsConn.Subprotocol())
+	if parser == nil {
+		h.logger.Printf("unknown sub-protocol %s from %s, will use default message parser", wsConn.Subprotocol(), conn.Address())
+		return context.Ctx.MessageParser()
+	}
+	return parser
+}
@@ -7,6 +7,7 @@ import (
 )
 
 func ServerTest() {
+	hub_server.LoadConfig()
 	role := roles.NewServer("test", "xx", "0.0.0.0", 1234)
 	s := hub_server.NewServer(role, "")
 	e := s.Start()
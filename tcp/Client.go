@@ -2,9 +2,9 @@ package tcp
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"whub/common/connection"
 	"whub/common/logger"
 )
@@ -15,6 +15,7 @@ type TCPClient struct {
 	serverAddr      string
 	serverPort      int
 	retryCount      int
+	codec           *FrameCodec
 	logger          *logger.SimpleLogger
 	onConnected     func(conn connection.IConnection)
 	onMessage       func([]byte)
@@ -29,31 +30,48 @@ func NewTCPClient(serverAddr string, serverPort int, myId string) connection.ICl
 		serverAddr: serverAddr,
 		serverPort: serverPort,
 		retryCount: DefaultRetryCount,
+		codec:      DefaultFrameCodec(),
 		logger:     logger.New(os.Stdout, "[TCPClient]", false),
 	}
 }
 
+// SetFrameCodec sets the frame codec used by connections established afterwards
+func (c *TCPClient) SetFrameCodec(codec *FrameCodec) {
+	c.codec = codec
+}
+
+// Connect connects to the server, if token is not empty, it will be sent as the first frame for the server to
+// authenticate the connection
 func (c *TCPClient) Connect(token string) (connection.IConnection, error) {
-	return c.connectWithRetry(c.retryCount, nil)
+	return c.connectWithRetry(c.retryCount, token, nil)
 }
 
-func (c *TCPClient) connectWithRetry(retry int, lastErr error) (connection.IConnection, error) {
+func (c *TCPClient) connectWithRetry(retry int, token string, lastErr error) (connection.IConnection, error) {
 	if retry == 0 {
 		return nil, lastErr
 	}
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, c.serverPort))
+	conn, err := net.Dial("tcp", net.JoinHostPort(c.serverAddr, strconv.Itoa(c.serverPort)))
 	if err != nil {
-		return c.connectWithRetry(retry-1, err)
+		return c.connectWithRetry(retry-1, token, err)
 	}
-	return c.handleConnection(conn)
+	return c.handleConnection(conn, token)
 }
 
-func (c *TCPClient) handleConnection(rawConn net.Conn) (connection.IConnection, error) {
-	conn := NewTCPConnection(rawConn)
+func (c *TCPClient) handleConnection(rawConn net.Conn, token string) (connection.IConnection, error) {
+	if token != "" {
+		if err := c.codec.WriteFrame(rawConn, ([]byte)(token)); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+	}
+	conn := NewTCPConnectionWithCodec(rawConn, c.codec)
 	conn.OnError(c.onConnectionErr)
 	conn.OnClose(c.onDisconnected)
 	conn.OnMessage(c.onMessage)
-	c.onConnected(conn)
+	c.conn = conn
+	if c.onConnected != nil {
+		c.onConnected(conn)
+	}
 	return conn, nil
 }
 
@@ -1,10 +1,12 @@
 package tcp
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 	"whub/common/connection"
 )
 
@@ -14,27 +16,42 @@ const (
 
 type TCPConnection struct {
 	conn        net.Conn
+	reader      *bufio.Reader
+	codec       *FrameCodec
 	onMessageCb func([]byte)
 	onCloseCb   func(error)
 	onErrorCb   func(error)
 	state       int
 
 	rwLock    *sync.RWMutex
+	writeLock *sync.Mutex
 	closeChan chan bool
 }
 
 func NewTCPConnection(conn net.Conn) connection.IConnection {
+	return NewTCPConnectionWithCodec(conn, DefaultFrameCodec())
+}
+
+func NewTCPConnectionWithCodec(conn net.Conn, codec *FrameCodec) connection.IConnection {
+	return NewFramedConnection(conn, codec)
+}
+
+// NewFramedConnection creates a framed connection over any stream based net.Conn
+func NewFramedConnection(conn net.Conn, codec *FrameCodec) *TCPConnection {
 	return &TCPConnection{
 		conn:      conn,
+		reader:    bufio.NewReaderSize(conn, DefaultReadBufferSize),
+		codec:     codec,
 		state:     connection.StateIdle,
 		rwLock:    new(sync.RWMutex),
+		writeLock: new(sync.Mutex),
 		closeChan: make(chan bool),
 	}
 }
 
 func (c *TCPConnection) withWrite(cb func()) {
-	c.rwLock.RLock()
-	defer c.rwLock.RUnlock()
+	c.rwLock.Lock()
+	defer c.rwLock.Unlock()
 	cb()
 }
 
@@ -57,38 +74,44 @@ func (c *TCPConnection) Close() error {
 	return err
 }
 
+// Read reads one whole frame from the connection
 func (c *TCPConnection) Read() ([]byte, error) {
-	buffer := make([]byte, DefaultReadBufferSize)
-	_, err := c.conn.Read(buffer)
+	frame, err := c.codec.ReadFrame(c.reader)
 	if err != nil {
 		c.handleError(err)
-	} else {
-		c.handleMessage(buffer)
 	}
-	return buffer, err
+	return frame, err
 }
 
 func (c *TCPConnection) OnMessage(cb func([]byte)) {
 	c.onMessageCb = cb
 }
 
-// TODO need a policy to handle packet separation and sticky packet
 func (c *TCPConnection) handleMessage(message []byte) {
 	if c.onMessageCb != nil {
 		c.onMessageCb(message)
 	}
 }
 
+// Write writes data as one frame, concurrent writes will not interleave
 func (c *TCPConnection) Write(data []byte) (err error) {
-	c.withWrite(func() {
-		_, err = c.conn.Write(data)
-	})
+	frame, err := c.codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	c.writeLock.Lock()
+	_, err = c.conn.Write(frame)
+	c.writeLock.Unlock()
 	if err != nil {
 		c.handleError(err)
 	}
 	return err
 }
 
+func (c *TCPConnection) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
 func (c *TCPConnection) Address() string {
 	return c.conn.RemoteAddr().String()
 }
@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+/*
+ * Frame layout(big endian):
+ * | payload length(4 bytes) | flags(1 byte) | [crc32 of payload(4 bytes), only if FrameFlagChecksum is set] | payload |
+ * Stream based connections have no message boundaries, so each message is written as one frame to deal with sticky
+ * and split packets.
+ */
+const (
+	FrameHeaderSize     = 5
+	FrameChecksumSize   = 4
+	FrameFlagChecksum   = 1
+	DefaultMaxFrameSize = 16 * 1024 * 1024
+
+	frameFlagsMask = FrameFlagChecksum
+)
+
+var (
+	ErrFrameTooLarge       = errors.New("frame exceeds max frame size")
+	ErrFrameChecksum       = errors.New("frame checksum mismatch")
+	ErrInvalidFrameHeader  = errors.New("invalid frame header")
+	ErrInvalidMaxFrameSize = errors.New("max frame size should be positive")
+)
+
+type FrameCodec struct {
+	maxFrameSize int
+	checksum     bool
+}
+
+// NewFrameCodec creates a frame codec, frames larger than maxFrameSize are rejected on both read and write, and
+// checksum decides if frames written by this codec carry a crc32 checksum. Frames read are always verified if the
+// sender added a checksum.
+func NewFrameCodec(maxFrameSize int, checksum bool) (*FrameCodec, error) {
+	if maxFrameSize <= 0 {
+		return nil, ErrInvalidMaxFrameSize
+	}
+	return &FrameCodec{maxFrameSize, checksum}, nil
+}
+
+func DefaultFrameCodec() *FrameCodec {
+	return &FrameCodec{DefaultMaxFrameSize, false}
+}
+
+func (f *FrameCodec) MaxFrameSize() int {
+	return f.maxFrameSize
+}
+
+func (f *FrameCodec) Checksum() bool {
+	return f.checksum
+}
+
+// Encode returns the whole frame in one buffer so that it can be written by a single write call
+func (f *FrameCodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) > f.maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	headerSize := FrameHeaderSize
+	var flags byte
+	if f.checksum {
+		headerSize += FrameChecksumSize
+		flags |= FrameFlagChecksum
+	}
+	frame := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame[4] = flags
+	if f.checksum {
+		binary.BigEndian.PutUint32(frame[FrameHeaderSize:], crc32.ChecksumIEEE(payload))
+	}
+	copy(frame[headerSize:], payload)
+	return frame, nil
+}
+
+func (f *FrameCodec) WriteFrame(w io.Writer, payload []byte) error {
+	frame, err := f.Encode(payload)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads exactly one frame from the reader. The frame size is checked before the payload is allocated so
+// that a malicious or broken peer can not make us allocate arbitrary amount of memory.
+func (f *FrameCodec) ReadFrame(r io.Reader) ([]byte, error) {
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	flags := header[4]
+	if flags&^frameFlagsMask != 0 {
+		return nil, ErrInvalidFrameHeader
+	}
+	if uint64(length) > uint64(f.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	var checksum uint32
+	if flags&FrameFlagChecksum != 0 {
+		var checksumBytes [FrameChecksumSize]byte
+		if _, err := io.ReadFull(r, checksumBytes[:]); err != nil {
+			return nil, err
+		}
+		checksum = binary.BigEndian.Uint32(checksumBytes[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if flags&FrameFlagChecksum != 0 && crc32.ChecksumIEEE(payload) != checksum {
+		return nil, ErrFrameChecksum
+	}
+	return payload, nil
+}
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+	"whub/common/test_utils"
+)
+
+func TestFrameCodec(t *testing.T) {
+	codec := DefaultFrameCodec()
+	checksumCodec, _ := NewFrameCodec(16, true)
+	tg := test_utils.NewTestGroup("FrameCodec", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("Sticky frames", "multiple frames in one buffer should be read one by one", func() bool {
+			var buffer bytes.Buffer
+			codec.WriteFrame(&buffer, []byte("hello"))
+			codec.WriteFrame(&buffer, []byte{})
+			checksumCodec.WriteFrame(&buffer, []byte("world"))
+			f0, err0 := codec.ReadFrame(&buffer)
+			f1, err1 := codec.ReadFrame(&buffer)
+			f2, err2 := codec.ReadFrame(&buffer)
+			return err0 == nil && err1 == nil && err2 == nil &&
+				string(f0) == "hello" && len(f1) == 0 && string(f2) == "world" && buffer.Len() == 0
+		}),
+		test_utils.NewTestCase("Split frames", "frames arriving byte by byte should be assembled", func() bool {
+			var buffer bytes.Buffer
+			checksumCodec.WriteFrame(&buffer, []byte("split"))
+			frame, err := checksumCodec.ReadFrame(iotest.OneByteReader(&buffer))
+			return err == nil && string(frame) == "split"
+		}),
+		test_utils.NewTestCase("Max frame size", "", func() bool {
+			var buffer bytes.Buffer
+			writeErr := checksumCodec.WriteFrame(&buffer, make([]byte, 17))
+			codec.WriteFrame(&buffer, make([]byte, 17))
+			_, readErr := checksumCodec.ReadFrame(&buffer)
+			return writeErr == ErrFrameTooLarge && readErr == ErrFrameTooLarge
+		}),
+		test_utils.NewTestCase("Checksum", "corrupted payload should be detected", func() bool {
+			frame, _ := checksumCodec.Encode([]byte("payload"))
+			frame[len(frame)-1] ^= 0xff
+			_, err := checksumCodec.ReadFrame(bytes.NewReader(frame))
+			return err == ErrFrameChecksum
+		}),
+		test_utils.NewTestCase("Invalid header", "", func() bool {
+			_, err := codec.ReadFrame(bytes.NewReader([]byte{0, 0, 0, 1, 0x80, 1}))
+			_, err1 := NewFrameCodec(0, false)
+			return err == ErrInvalidFrameHeader && err1 == ErrInvalidMaxFrameSize
+		}),
+	}).Do(t)
+}
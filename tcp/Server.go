@@ -2,12 +2,18 @@ package tcp
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 	"whub/common/connection"
 	"whub/common/logger"
 )
 
+const maxAcceptRetryDelay = time.Second
+
 type TCPServer struct {
 	name     string
 	address  string
@@ -15,52 +21,144 @@ type TCPServer struct {
 	logger   *logger.SimpleLogger
 	ctx      context.Context
 	stopFunc func()
+	listener net.Listener
+	codec    *FrameCodec
+	wrapper  func(conn net.Conn, codec *FrameCodec) connection.IConnection
+	lock     *sync.Mutex
 
 	onConnected     func(conn connection.IConnection)
 	onDisconnected  func(conn connection.IConnection, err error)
 	onConnectionErr func(conn connection.IConnection, err error)
 }
 
-func (s *TCPServer) Start() error {
+func NewTCPServer(name string, address string, port int) *TCPServer {
+	ctx, stopFunc := context.WithCancel(context.Background())
+	return &TCPServer{
+		name:     name,
+		address:  address,
+		port:     port,
+		logger:   logger.New(os.Stdout, "[TCPServer]", true),
+		ctx:      ctx,
+		stopFunc: stopFunc,
+		codec:    DefaultFrameCodec(),
+		wrapper:  NewTCPConnectionWithCodec,
+		lock:     new(sync.Mutex),
+	}
+}
+
+func (s *TCPServer) withLock(cb func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cb()
+}
+
+// SetFrameCodec sets the frame codec used by connections accepted afterwards
+func (s *TCPServer) SetFrameCodec(codec *FrameCodec) {
+	s.codec = codec
+}
+
+// SetConnectionWrapper sets how accepted raw connections are wrapped, so that the accept loop can be reused for other
+// stream based transports
+func (s *TCPServer) SetConnectionWrapper(wrapper func(conn net.Conn, codec *FrameCodec) connection.IConnection) {
+	s.wrapper = wrapper
+}
+
+func (s *TCPServer) Address() string {
+	return net.JoinHostPort(s.address, strconv.Itoa(s.port))
+}
+
+// Start listens and accepts connections until the server is stopped
+func (s *TCPServer) Start() (err error) {
 	s.logger.Println("starting TCP server...")
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.address, s.port))
+	listener, err := net.Listen("tcp", s.Address())
 	if err != nil {
 		return err
 	}
-	select {
-	case <-s.ctx.Done():
-		s.logger.Println("stopping server ...")
-		break
-	default:
+	return s.Serve(listener)
+}
+
+// Serve accepts connections from the listener until the server is stopped, each connection is handled on its own
+// goroutine
+func (s *TCPServer) Serve(listener net.Listener) error {
+	stopped := false
+	s.withLock(func() {
+		if s.ctx.Err() != nil {
+			stopped = true
+			return
+		}
+		s.listener = listener
+	})
+	if stopped {
+		listener.Close()
+		return errors.New("server has been stopped")
+	}
+	s.logger.Printf("server %s is accepting connections on %s", s.name, listener.Addr().String())
+	var retryDelay time.Duration
+	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				s.logger.Println("server stopped")
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				retryDelay = nextAcceptRetryDelay(retryDelay)
+				s.logger.Printf("accept error: %s, will retry in %s", err.Error(), retryDelay.String())
+				time.Sleep(retryDelay)
+				continue
+			}
 			s.logger.Println("listener err: ", err)
 			return err
 		}
+		retryDelay = 0
 		go s.handleNewConnection(conn)
 	}
-	return listener.Close()
+}
+
+func nextAcceptRetryDelay(lastDelay time.Duration) time.Duration {
+	if lastDelay == 0 {
+		return 5 * time.Millisecond
+	}
+	if lastDelay*2 > maxAcceptRetryDelay {
+		return maxAcceptRetryDelay
+	}
+	return lastDelay * 2
 }
 
 func (s *TCPServer) toTCPConnection(conn net.Conn) connection.IConnection {
-	return NewTCPConnection(conn)
+	return s.wrapper(conn, s.codec)
 }
 
 func (s *TCPServer) handleNewConnection(rawConn net.Conn) {
 	conn := s.toTCPConnection(rawConn)
-	s.logger.Println("new tcp connection ", conn.String())
+	s.logger.Println("new connection ", conn.String())
 	conn.OnError(func(err error) {
-		s.onConnectionErr(conn, err)
+		if s.onConnectionErr != nil {
+			s.onConnectionErr(conn, err)
+		} else {
+			conn.Close()
+		}
 	})
 	conn.OnClose(func(err error) {
-		s.onConnectionErr(conn, err)
+		if s.onDisconnected != nil {
+			s.onDisconnected(conn, err)
+		}
 	})
-	s.onConnected(conn)
+	if s.onConnected != nil {
+		s.onConnected(conn)
+	}
 }
 
-func (s *TCPServer) Stop() error {
-	s.stopFunc()
-	return nil
+func (s *TCPServer) Stop() (err error) {
+	s.withLock(func() {
+		s.stopFunc()
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+	})
+	return
 }
 
 func (s *TCPServer) OnConnectionError(cb func(connection.IConnection, error)) {
@@ -0,0 +1,71 @@
+package unix
+
+import (
+	"net"
+	"os"
+	"whub/common/connection"
+	"whub/common/logger"
+	"whub/tcp"
+)
+
+type UnixClient struct {
+	path            string
+	codec           *tcp.FrameCodec
+	logger          *logger.SimpleLogger
+	onConnected     func(conn connection.IConnection)
+	onMessage       func([]byte)
+	onDisconnected  func(err error)
+	onConnectionErr func(err error)
+}
+
+func NewUnixClient(path string) *UnixClient {
+	return &UnixClient{
+		path:   path,
+		codec:  tcp.DefaultFrameCodec(),
+		logger: logger.New(os.Stdout, "[UnixClient]", false),
+	}
+}
+
+// SetFrameCodec sets the frame codec used by connections established afterwards
+func (c *UnixClient) SetFrameCodec(codec *tcp.FrameCodec) {
+	c.codec = codec
+}
+
+// Connect connects to the server socket, if token is not empty, it will be sent as the first frame for the server to
+// authenticate the connection
+func (c *UnixClient) Connect(token string) (connection.IConnection, error) {
+	rawConn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		if err = c.codec.WriteFrame(rawConn, ([]byte)(token)); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+	}
+	conn := NewUnixConnection(rawConn, c.codec)
+	conn.OnError(c.onConnectionErr)
+	conn.OnClose(c.onDisconnected)
+	conn.OnMessage(c.onMessage)
+	if c.onConnected != nil {
+		c.onConnected(conn)
+	}
+	return conn, nil
+}
+
+func (c *UnixClient) OnConnectionEstablished(cb func(conn connection.IConnection)) {
+	c.onConnected = cb
+}
+
+func (c *UnixClient) OnDisconnect(cb func(error)) {
+	c.onDisconnected = cb
+}
+
+func (c *UnixClient) OnMessage(cb func([]byte)) {
+	c.onMessage = cb
+}
+
+func (c *UnixClient) OnError(cb func(error)) {
+	c.onConnectionErr = cb
+}
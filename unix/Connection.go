@@ -0,0 +1,46 @@
+package unix
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"whub/common/connection"
+	"whub/tcp"
+)
+
+var connCounter uint64
+
+// UnixConnection is a framed connection over a unix domain socket. Peers of unix domain sockets are usually unnamed,
+// so each connection is given a unique address to be distinguishable by address.
+type UnixConnection struct {
+	*tcp.TCPConnection
+	address string
+}
+
+func NewUnixConnection(conn net.Conn, codec *tcp.FrameCodec) connection.IConnection {
+	return &UnixConnection{
+		TCPConnection: tcp.NewFramedConnection(conn, codec),
+		address:       fmt.Sprintf("unix:%s#%d", socketPath(conn), atomic.AddUint64(&connCounter, 1)),
+	}
+}
+
+// socketPath returns the path of the socket file, which is the remote address on client side and the local address
+// on server side
+func socketPath(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil && addr.String() != "" && addr.String() != "@" {
+		return addr.String()
+	}
+	return conn.LocalAddr().String()
+}
+
+func (c *UnixConnection) Address() string {
+	return c.address
+}
+
+func (c *UnixConnection) String() string {
+	return fmt.Sprintf("{\"address\": \"%s\",\"state\": %d }", c.Address(), c.State())
+}
+
+func (c *UnixConnection) ConnectionType() uint8 {
+	return connection.TypeUnix
+}
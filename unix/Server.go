@@ -0,0 +1,82 @@
+package unix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"whub/common/logger"
+	"whub/tcp"
+)
+
+const DefaultSocketFileMode os.FileMode = 0660
+
+// UnixServer accepts framed connections on a unix domain socket file. Only processes with write permission on the
+// socket file are able to connect, so the file mode works as an extra access control on top of token auth.
+type UnixServer struct {
+	*tcp.TCPServer
+	path     string
+	fileMode os.FileMode
+	logger   *logger.SimpleLogger
+}
+
+func NewUnixServer(name string, path string, fileMode os.FileMode) *UnixServer {
+	server := tcp.NewTCPServer(name, "", 0)
+	server.SetConnectionWrapper(NewUnixConnection)
+	s := &UnixServer{
+		TCPServer: server,
+		path:      path,
+		fileMode:  fileMode,
+		logger:    logger.New(os.Stdout, "[UnixServer]", true),
+	}
+	server.SetLogger(s.logger)
+	return s
+}
+
+func (s *UnixServer) Address() string {
+	return s.path
+}
+
+func (s *UnixServer) Start() error {
+	s.logger.Println("starting unix socket server...")
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+	// create the socket file with no permission for anyone else, and then relax it to the configured mode, so that
+	// there is no window for unauthorized processes to connect
+	oldMask := umask(0177)
+	listener, err := net.Listen("unix", s.path)
+	umask(oldMask)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(s.path, s.fileMode); err != nil {
+		listener.Close()
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// removeStaleSocket removes the socket file left by a server that was not shut down properly
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(fmt.Sprintf("%s exists and is not a socket", path))
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf("socket %s is being used by another server", path))
+	}
+	return os.Remove(path)
+}
+
+func (s *UnixServer) SetLogger(logger *logger.SimpleLogger) {
+	s.logger = logger
+	s.TCPServer.SetLogger(logger)
+}
@@ -0,0 +1,68 @@
+package unix
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"whub/common/connection"
+	"whub/common/test_utils"
+)
+
+func TestUnixServer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "whub-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hub.sock")
+	server := NewUnixServer("test", path, 0600)
+	received := make(chan string, 1)
+	server.OnClientConnected(func(conn connection.IConnection) {
+		token, _ := conn.Read()
+		conn.OnMessage(func(msg []byte) {
+			received <- string(token) + ":" + string(msg)
+		})
+		conn.ReadLoop()
+	})
+	go server.Start()
+	defer server.Stop()
+	waitForSocket(path)
+	tg := test_utils.NewTestGroup("UnixServer", "")
+	tg.Cases([]*test_utils.Assertion{
+		test_utils.NewTestCase("File mode", "socket file should have the configured mode", func() bool {
+			info, err := os.Stat(path)
+			return err == nil && info.Mode()&os.ModeSocket != 0 && info.Mode().Perm() == 0600
+		}),
+		test_utils.NewTestCase("Token and message", "", func() bool {
+			conn, err := NewUnixClient(path).Connect("token")
+			if err != nil {
+				t.Log("connect failed due to ", err)
+				return false
+			}
+			defer conn.Close()
+			if conn.ConnectionType() != connection.TypeUnix {
+				return false
+			}
+			conn.Write([]byte("hello"))
+			select {
+			case msg := <-received:
+				return msg == "token:hello"
+			case <-time.After(time.Second * 3):
+				return false
+			}
+		}),
+		test_utils.NewTestCase("Socket in use", "another server should not take over a live socket", func() bool {
+			return removeStaleSocket(path) != nil
+		}),
+	}).Do(t)
+}
+
+func waitForSocket(path string) {
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
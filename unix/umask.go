@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package unix
+
+import "syscall"
+
+// umask is process wide, it is only changed for the short moment the socket file is being created
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+// +build windows
+
+package unix
+
+func umask(mask int) int {
+	return 0
+}
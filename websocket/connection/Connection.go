@@ -40,6 +40,7 @@ func NewWsConnection(conn *websocket.Conn, onMessage func([]byte), onClose func(
 
 type IWsConnection interface {
 	connection.IConnection
+	Subprotocol() string
 }
 
 func (c *WsConnection) withLock(cb func()) {
@@ -141,6 +142,11 @@ func (c *WsConnection) handleError(err error) {
 	}
 }
 
+// Subprotocol returns the negotiated websocket sub-protocol, empty if none was negotiated
+func (c *WsConnection) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
 func (c *WsConnection) ConnectionType() uint8 {
 	return connection.TypeWS
 }
@@ -64,14 +64,20 @@ func NewWClientConfig(serverUrl string, onMessage func([]byte), onConnectionEsta
 }
 
 type WClient struct {
-	serverUrl string
-	handler   *WClientConnectionHandler
-	logger    *logger.SimpleLogger
+	serverUrl    string
+	handler      *WClientConnectionHandler
+	logger       *logger.SimpleLogger
+	subprotocols []string
 	// conn      base_conn.IConnection
 }
 
 func New(config *WClientConfig) base_conn.IClient {
-	return &WClient{config.serverUrl, config.WClientConnectionHandler, logger.New(os.Stdout, "[WebSocketClient]", true)}
+	return &WClient{config.serverUrl, config.WClientConnectionHandler, logger.New(os.Stdout, "[WebSocketClient]", true), nil}
+}
+
+// SetSubprotocols sets the sub-protocols requested on connect in preference order
+func (c *WClient) SetSubprotocols(protocols []string) {
+	c.subprotocols = protocols
 }
 
 func (c *WClient) Connect(token string) (base_conn.IConnection, error) {
@@ -79,7 +85,9 @@ func (c *WClient) Connect(token string) (base_conn.IConnection, error) {
 	// header["Authorization"] = []string{fmt.Sprintf("Bearer %s", token)}
 	requestUri := fmt.Sprintf("%s?token=%s", c.serverUrl, token)
 	// TODO no header needed if token is in request uri
-	conn, _, err := websocket.DefaultDialer.Dial(requestUri, header)
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = c.subprotocols
+	conn, _, err := dialer.Dial(requestUri, header)
 	if err != nil {
 		c.handler.OnConnectionFailed(err)
 		return nil, err
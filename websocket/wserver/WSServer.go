@@ -20,6 +20,7 @@ type WServer struct {
 	handler        *WsConnectionHandler
 	logger         *logger.SimpleLogger
 	upgradeUrlPath string
+	subprotocols   []string
 }
 
 func NewWServer(config WsServerConfig) *WServer {
@@ -50,7 +51,7 @@ func NewWServer(config WsServerConfig) *WServer {
 }
 
 func (ws *WServer) upgradeHTTP(w http.ResponseWriter, r *http.Request) (err error) {
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	conn, err := ws.upgrader.Upgrade(w, r, ws.selectSubprotocol(r))
 	// probably not necessary to do this on a different goroutine
 	/*
 		if ws.asyncPool != nil {
@@ -147,6 +148,27 @@ func (ws *WServer) SetBeforeUpgradeChecker(checker func(r *http.Request) error)
 	ws.handler.beforeUpgradeChecker = checker
 }
 
+// SetSubprotocols sets the sub-protocols supported by the server, the first protocol requested by the client that the
+// server supports will be negotiated
+func (ws *WServer) SetSubprotocols(protocols []string) {
+	ws.subprotocols = protocols
+}
+
+// selectSubprotocol picks the sub-protocol by the preference of the client. The upgrader would pick by the order of
+// the server if its Subprotocols were set, so the selection is passed by the response header instead.
+func (ws *WServer) selectSubprotocol(r *http.Request) http.Header {
+	for _, requested := range websocket.Subprotocols(r) {
+		for _, supported := range ws.subprotocols {
+			if requested == supported {
+				header := make(http.Header)
+				header.Set("Sec-Websocket-Protocol", requested)
+				return header
+			}
+		}
+	}
+	return nil
+}
+
 func (ws *WServer) SetLogger(logger *logger.SimpleLogger) {
 	ws.logger = logger
 }